@@ -0,0 +1,205 @@
+// Package blob provides content-addressed stores for values too large to be
+// kept in the objects table. Blobs are addressed by the hex encoded SHA-256
+// digest of their content, so a blob is never overwritten by different data.
+package blob
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+)
+
+var (
+	// ErrNotFound indicates that a store has no blob with a digest
+	ErrNotFound = fmt.Errorf("blob not found")
+
+	// ErrDigestMismatch indicates that the content of a blob does not match its digest
+	ErrDigestMismatch = fmt.Errorf("blob does not match its digest")
+
+	// ErrInvalidDigest indicates that a digest is not a hex encoded SHA-256 digest
+	ErrInvalidDigest = fmt.Errorf("invalid digest")
+)
+
+// digestRe matches a hex encoded SHA-256 digest
+var digestRe = regexp.MustCompile(`^[a-f0-9]{64}$`)
+
+// Store stores blobs by digest. Object store clients can implement it
+// to replace the local implementations of this package.
+type Store interface {
+
+	// Put stores the content of a blob. Storing a blob that exists is not an error.
+	Put(digest string, data []byte) error
+
+	// Get returns the content of a blob or ErrNotFound if it does not exist
+	Get(digest string) ([]byte, error)
+
+	// Has checks whether a blob exists
+	Has(digest string) (bool, error)
+}
+
+// Digest returns the digest of the content of a blob
+func Digest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Verify checks that the content of a blob matches its digest
+func Verify(digest string, data []byte) error {
+	if Digest(data) != digest {
+		return ErrDigestMismatch
+	}
+	return nil
+}
+
+// Put computes the digest of the content of a blob, stores it and returns the digest
+func Put(s Store, data []byte) (string, error) {
+	digest := Digest(data)
+	if err := s.Put(digest, data); err != nil {
+		return "", err
+	}
+	return digest, nil
+}
+
+// Get returns the content of a blob after verifying it matches its digest
+func Get(s Store, digest string) ([]byte, error) {
+	data, err := s.Get(digest)
+	if err != nil {
+		return nil, err
+	}
+	if err := Verify(digest, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// FSStore stores blobs as files in a directory. Files are spread in
+// sub directories named after the first two bytes of their digest.
+type FSStore struct {
+	dir string
+}
+
+// NewFSStore creates a store in a directory. The directory is created if it does not exist.
+func NewFSStore(dir string) (*FSStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &FSStore{dir: dir}, nil
+}
+
+// path returns the path of the file of a blob
+func (s *FSStore) path(digest string) (string, error) {
+	if !digestRe.MatchString(digest) {
+		return "", ErrInvalidDigest
+	}
+	return filepath.Join(s.dir, digest[:2], digest[2:4], digest), nil
+}
+
+// Put stores the content of a blob. The content is written to a temporary
+// file that is renamed once complete, so readers never see a partial blob.
+func (s *FSStore) Put(digest string, data []byte) error {
+
+	path, err := s.path(digest)
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), path)
+}
+
+// Get returns the content of a blob
+func (s *FSStore) Get(digest string) ([]byte, error) {
+	path, err := s.path(digest)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return data, err
+}
+
+// Has checks whether a blob exists
+func (s *FSStore) Has(digest string) (bool, error) {
+	path, err := s.path(digest)
+	if err != nil {
+		return false, err
+	}
+	_, err = os.Stat(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// MemoryStore stores blobs in memory. It can stand in for a remote store in tests.
+type MemoryStore struct {
+	sync.RWMutex
+	blobs map[string][]byte
+}
+
+// NewMemoryStore creates an empty memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{blobs: make(map[string][]byte)}
+}
+
+// Put stores the content of a blob
+func (s *MemoryStore) Put(digest string, data []byte) error {
+	if !digestRe.MatchString(digest) {
+		return ErrInvalidDigest
+	}
+	s.Lock()
+	defer s.Unlock()
+	s.blobs[digest] = append([]byte{}, data...)
+	return nil
+}
+
+// Get returns the content of a blob
+func (s *MemoryStore) Get(digest string) ([]byte, error) {
+	s.RLock()
+	defer s.RUnlock()
+	data, ok := s.blobs[digest]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return append([]byte{}, data...), nil
+}
+
+// Has checks whether a blob exists
+func (s *MemoryStore) Has(digest string) (bool, error) {
+	s.RLock()
+	defer s.RUnlock()
+	_, ok := s.blobs[digest]
+	return ok, nil
+}
@@ -0,0 +1,70 @@
+package blob
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestBlob(t *testing.T) {
+	Convey("Blob", t, func() {
+		dir, err := ioutil.TempDir("", "blobs")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		fsStore, err := NewFSStore(dir)
+		So(err, ShouldBeNil)
+
+		for name, s := range map[string]Store{"FSStore": fsStore, "MemoryStore": NewMemoryStore()} {
+			Convey(name, func() {
+				data := []byte("some large value")
+
+				Convey("Should store and return a blob by its digest", func() {
+					digest, err := Put(s, data)
+					So(err, ShouldBeNil)
+					So(digest, ShouldEqual, Digest(data))
+					has, err := s.Has(digest)
+					So(err, ShouldBeNil)
+					So(has, ShouldBeTrue)
+					got, err := Get(s, digest)
+					So(err, ShouldBeNil)
+					So(got, ShouldResemble, data)
+
+					Convey("Storing an existing blob is not an error", func() {
+						_, err := Put(s, data)
+						So(err, ShouldBeNil)
+					})
+				})
+
+				Convey("Should return ErrNotFound if the blob does not exist", func() {
+					_, err := Get(s, Digest([]byte("unknown")))
+					So(err, ShouldEqual, ErrNotFound)
+					has, err := s.Has(Digest([]byte("unknown")))
+					So(err, ShouldBeNil)
+					So(has, ShouldBeFalse)
+				})
+
+				Convey("Should return error if the digest is invalid", func() {
+					So(s.Put("../abc", data), ShouldEqual, ErrInvalidDigest)
+				})
+
+				Convey("Should return error if the blob does not match its digest", func() {
+					digest := Digest([]byte("other value"))
+					So(s.Put(digest, data), ShouldBeNil)
+					_, err := Get(s, digest)
+					So(err, ShouldEqual, ErrDigestMismatch)
+				})
+			})
+		}
+
+		Convey("FSStore should store blobs in sub directories of their digest", func() {
+			digest, err := Put(fsStore, []byte("abc"))
+			So(err, ShouldBeNil)
+			_, err = os.Stat(filepath.Join(dir, digest[:2], digest[2:4], digest))
+			So(err, ShouldBeNil)
+		})
+	})
+}
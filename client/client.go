@@ -0,0 +1,403 @@
+// Package client provides a client for the patchain HTTP API (see package httpapi).
+// It offers the same methods as object.Object and verifies the hash of every
+// object returned by the server so that altered objects are rejected.
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ellcrys/patchain"
+	"github.com/ellcrys/patchain/cockroach/tables"
+	"github.com/ellcrys/patchain/httpapi"
+	"github.com/ellcrys/patchain/object"
+	"github.com/ncodes/redo"
+	"github.com/pkg/errors"
+)
+
+// ErrVerificationFailed indicates that an object returned by
+// the server does not match its hash or the object that was sent
+var ErrVerificationFailed = fmt.Errorf("object returned by the server failed verification")
+
+// Error describes an error returned by the server
+type Error struct {
+	StatusCode int
+	Message    string
+	Retryable  bool
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("server returned %d: %s", e.StatusCode, e.Message)
+}
+
+// JSQQuery describes a JSQ query. It can be passed to GetLast and All
+// in place of a tables.Object. See http://github.com/ncodes/jsq
+type JSQQuery struct {
+	Query       string
+	QueryParams patchain.QueryParams
+}
+
+// GetQueryParams returns the query parameters
+func (q *JSQQuery) GetQueryParams() *patchain.QueryParams {
+	return &q.QueryParams
+}
+
+// Option configures a client
+type Option func(c *Client)
+
+// WithTimeout sets the timeout of every request. Default is 30 seconds.
+func WithTimeout(d time.Duration) Option {
+	return func(c *Client) {
+		c.httpClient.Timeout = d
+	}
+}
+
+// WithHTTPClient sets the HTTP client used to send requests
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = hc
+	}
+}
+
+// WithRetry sets the maximum time MustPut and MustCreatePartitions
+// will retry an operation that failed because of contention.
+// Default is 10 minutes.
+func WithRetry(maxElapsedTime time.Duration) Option {
+	return func(c *Client) {
+		c.maxRetryTime = maxElapsedTime
+	}
+}
+
+// Client defines a structure for handling objects through the HTTP API
+type Client struct {
+	baseURL      string
+	httpClient   *http.Client
+	maxRetryTime time.Duration
+}
+
+// NewClient creates a client for the server at baseURL (e.g http://localhost:8080)
+func NewClient(baseURL string, options ...Option) *Client {
+	c := &Client{
+		baseURL:      strings.TrimRight(baseURL, "/"),
+		httpClient:   &http.Client{Timeout: 30 * time.Second},
+		maxRetryTime: 10 * time.Minute,
+	}
+	for _, opt := range options {
+		opt(c)
+	}
+	return c
+}
+
+// checkOptions returns an error if options are passed. Options exist to keep the
+// same signatures as object.Object but database options cannot be used remotely.
+func checkOptions(options []patchain.Option) error {
+	if len(options) > 0 {
+		return fmt.Errorf("options are not supported by the client")
+	}
+	return nil
+}
+
+// do sends a request and decodes the response body into out.
+// Error responses are returned as *Error or patchain.ErrNotFound.
+func (c *Client) do(method, path string, body, out interface{}) error {
+
+	var reqBody *bytes.Reader
+	if body != nil {
+		bs, err := json.Marshal(body)
+		if err != nil {
+			return errors.Wrap(err, "failed to encode request")
+		}
+		reqBody = bytes.NewReader(bs)
+	} else {
+		reqBody = bytes.NewReader(nil)
+	}
+
+	req, err := http.NewRequest(method, c.baseURL+path, reqBody)
+	if err != nil {
+		return errors.Wrap(err, "failed to create request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "request failed")
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return errors.Wrap(err, "failed to read response")
+	}
+
+	if resp.StatusCode >= 300 {
+		if resp.StatusCode == http.StatusNotFound {
+			return patchain.ErrNotFound
+		}
+		var errResp httpapi.ErrorResponse
+		if err := json.Unmarshal(respBody, &errResp); err != nil || errResp.Error == "" {
+			errResp.Error = http.StatusText(resp.StatusCode)
+		}
+		return &Error{StatusCode: resp.StatusCode, Message: errResp.Error, Retryable: errResp.Retryable}
+	}
+
+	if out != nil {
+		if err := json.Unmarshal(respBody, out); err != nil {
+			return errors.Wrap(err, "malformed response")
+		}
+	}
+
+	return nil
+}
+
+// RequiresRetry checks whether an error indicates that the
+// operation failed because of contention and can be retried
+func (c *Client) RequiresRetry(err error) bool {
+	e, ok := errors.Cause(err).(*Error)
+	return ok && e.Retryable
+}
+
+// retry runs an operation until it succeeds or fails with an error that cannot be retried
+func (c *Client) retry(cb func() error) error {
+	var err error
+	cfg := redo.NewDefaultBackoffConfig()
+	cfg.MaxElapsedTime = c.maxRetryTime
+	err = redo.NewRedo().BackOff(cfg, func(stop func()) error {
+		err = cb()
+		if err != nil && c.RequiresRetry(err) {
+			return err
+		}
+		stop()
+		return err
+	})
+	return err
+}
+
+// verifyObjects checks the hash of every object
+func verifyObjects(objs []*tables.Object) error {
+	for _, obj := range objs {
+		if err := object.VerifyObjectHash(obj); err != nil {
+			return errors.Wrapf(ErrVerificationFailed, "object (%s): %s", obj.ID, err)
+		}
+	}
+	return nil
+}
+
+// toQueryRequest converts a query to a query request. A tables.Object is converted
+// to a JSQ query matching its non-empty fields. Query expressions are not supported.
+func toQueryRequest(q patchain.Query) (*httpapi.QueryRequest, error) {
+
+	qp := q.GetQueryParams()
+	req := &httpapi.QueryRequest{OrderBy: qp.OrderBy, Limit: qp.Limit}
+
+	switch q := q.(type) {
+	case *JSQQuery:
+		if qp.KeyStartsWith != "" {
+			return nil, fmt.Errorf("KeyStartsWith is not supported with a JSQ query. Use $sw")
+		}
+		req.Query = json.RawMessage(q.Query)
+		return req, nil
+	case *tables.Object:
+		if qp.Expr.Expr != "" {
+			return nil, fmt.Errorf("query expressions are not supported by the client. Use a JSQ query")
+		}
+		bs, err := json.Marshal(q)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to encode query")
+		}
+		// decode numbers as json.Number so timestamps are not rounded
+		var fields map[string]interface{}
+		dec := json.NewDecoder(bytes.NewReader(bs))
+		dec.UseNumber()
+		dec.Decode(&fields)
+		if !q.Protected {
+			delete(fields, "protected")
+		}
+		if qp.KeyStartsWith != "" {
+			startsWith := map[string]interface{}{"$sw": qp.KeyStartsWith}
+			if key, ok := fields["key"]; ok {
+				delete(fields, "key")
+				fields["$and"] = []interface{}{
+					map[string]interface{}{"key": key},
+					map[string]interface{}{"key": startsWith},
+				}
+			} else {
+				fields["key"] = startsWith
+			}
+		}
+		req.Query, _ = json.Marshal(fields)
+		return req, nil
+	default:
+		return nil, fmt.Errorf("unsupported query type")
+	}
+}
+
+// GetLast gets the most recent object matching the query
+func (c *Client) GetLast(q patchain.Query, options ...patchain.Option) (*tables.Object, error) {
+	if err := checkOptions(options); err != nil {
+		return nil, err
+	}
+	req, err := toQueryRequest(q)
+	if err != nil {
+		return nil, err
+	}
+	var obj tables.Object
+	if err := c.do("POST", "/v1/objects/last", req, &obj); err != nil {
+		return nil, err
+	}
+	if err := verifyObjects([]*tables.Object{&obj}); err != nil {
+		return nil, err
+	}
+	return &obj, nil
+}
+
+// All fetches all the objects matching a query
+func (c *Client) All(q patchain.Query, options ...patchain.Option) ([]*tables.Object, error) {
+	if err := checkOptions(options); err != nil {
+		return nil, err
+	}
+	req, err := toQueryRequest(q)
+	if err != nil {
+		return nil, err
+	}
+	var objs []*tables.Object
+	if err := c.do("POST", "/v1/objects/query", req, &objs); err != nil {
+		return nil, err
+	}
+	if err := verifyObjects(objs); err != nil {
+		return nil, err
+	}
+	return objs, nil
+}
+
+// CreatePartitions creates n partitions for an owner
+func (c *Client) CreatePartitions(n int64, ownerID, creatorID string, options ...patchain.Option) ([]*tables.Object, error) {
+	if err := checkOptions(options); err != nil {
+		return nil, err
+	}
+	var partitions []*tables.Object
+	req := httpapi.CreatePartitionsRequest{OwnerID: ownerID, CreatorID: creatorID, N: n}
+	if err := c.do("POST", "/v1/partitions", req, &partitions); err != nil {
+		return nil, err
+	}
+	if err := verifyObjects(partitions); err != nil {
+		return nil, err
+	}
+	for _, p := range partitions {
+		if p.OwnerID != ownerID || p.CreatorID != creatorID {
+			return nil, errors.Wrapf(ErrVerificationFailed, "partition (%s): owner or creator does not match", p.ID)
+		}
+	}
+	return partitions, nil
+}
+
+// MustCreatePartitions is the same as CreatePartitions but it
+// will retry the operation if it fails because of contention
+func (c *Client) MustCreatePartitions(n int64, ownerID, creatorID string, options ...patchain.Option) ([]*tables.Object, error) {
+	var partitions []*tables.Object
+	var err error
+	err = c.retry(func() error {
+		partitions, err = c.CreatePartitions(n, ownerID, creatorID, options...)
+		return err
+	})
+	return partitions, err
+}
+
+// Put adds one or more objects of the same owner into a partition of the owner.
+// The objects are updated with the fields set by the server.
+func (c *Client) Put(objs interface{}, options ...patchain.Option) error {
+
+	if err := checkOptions(options); err != nil {
+		return err
+	}
+
+	var objects []*tables.Object
+	switch o := objs.(type) {
+	case []*tables.Object:
+		objects = o
+	case *tables.Object:
+		objects = append(objects, o)
+	default:
+		return fmt.Errorf("unsupported object type")
+	}
+
+	var created []*tables.Object
+	if err := c.do("POST", "/v1/objects", objects, &created); err != nil {
+		return errors.Wrap(err, "failed to put object(s)")
+	}
+
+	if len(created) != len(objects) {
+		return errors.Wrap(ErrVerificationFailed, "unexpected number of objects")
+	}
+
+	if err := object.VerifyChain(created); err != nil {
+		return errors.Wrap(ErrVerificationFailed, err.Error())
+	}
+
+	for i, obj := range created {
+		if !sameContent(objects[i], obj) {
+			return errors.Wrapf(ErrVerificationFailed, "object %d (%s): does not match the object sent", i, obj.ID)
+		}
+	}
+
+	for i, obj := range created {
+		*objects[i] = *obj
+	}
+
+	return nil
+}
+
+// sameContent checks whether the server stored the fields set by the
+// caller unchanged. Fields the server may set are only compared if set.
+func sameContent(sent, got *tables.Object) bool {
+	expected := *sent
+	for _, f := range []struct{ s, g *string }{
+		{&expected.ID, &got.ID},
+		{&expected.CreatorID, &got.CreatorID},
+		{&expected.PartitionID, &got.PartitionID},
+		{&expected.PrevHash, &got.PrevHash},
+		{&expected.SchemaVersion, &got.SchemaVersion},
+	} {
+		if *f.s == "" {
+			*f.s = *f.g
+		}
+	}
+	if expected.Timestamp == 0 {
+		expected.Timestamp = got.Timestamp
+	}
+	// the hash covers every field but the peer hash
+	return expected.ComputeHash().Hash == got.Hash
+}
+
+// MustPut is the same as Put but it will retry
+// the operation if it fails because of contention
+func (c *Client) MustPut(objs interface{}, options ...patchain.Option) error {
+	return c.retry(func() error {
+		return c.Put(objs, options...)
+	})
+}
+
+// History returns every version of a key from the oldest to the most recent.
+// If ownerID is set, only the versions of the owner are returned.
+func (c *Client) History(key, ownerID string, limit int) ([]*tables.Object, error) {
+	params := url.Values{"key": {key}}
+	if ownerID != "" {
+		params.Set("owner_id", ownerID)
+	}
+	if limit > 0 {
+		params.Set("limit", fmt.Sprint(limit))
+	}
+	var objs []*tables.Object
+	if err := c.do("GET", "/v1/objects/history?"+params.Encode(), nil, &objs); err != nil {
+		return nil, err
+	}
+	if err := verifyObjects(objs); err != nil {
+		return nil, err
+	}
+	return objs, nil
+}
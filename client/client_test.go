@@ -0,0 +1,180 @@
+package client
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ellcrys/patchain"
+	"github.com/ellcrys/patchain/cockroach/tables"
+	"github.com/ellcrys/patchain/httpapi"
+	"github.com/pkg/errors"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// newTestServer creates a server that responds to every request with the given status and body
+func newTestServer(status int, body interface{}) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(body)
+	}))
+}
+
+// makeObject creates a hashed object
+func makeObject(key, value string) *tables.Object {
+	return (&tables.Object{OwnerID: "owner_id", CreatorID: "owner_id", Key: key, Value: value}).Init().ComputeHash()
+}
+
+func TestToQueryRequest(t *testing.T) {
+	Convey("toQueryRequest", t, func() {
+
+		Convey("Should convert an object to a JSQ query of its non-empty fields", func() {
+			req, err := toQueryRequest(&tables.Object{OwnerID: "owner_id", Key: "key", Timestamp: 1501234567891234567, QueryParams: patchain.QueryParams{OrderBy: "timestamp desc", Limit: 2}})
+			So(err, ShouldBeNil)
+			So(string(req.Query), ShouldEqual, `{"key":"key","owner_id":"owner_id","timestamp":1501234567891234567}`)
+			So(req.OrderBy, ShouldEqual, "timestamp desc")
+			So(req.Limit, ShouldEqual, 2)
+		})
+
+		Convey("Should convert KeyStartsWith to $sw", func() {
+			req, err := toQueryRequest(&tables.Object{QueryParams: patchain.KeyStartsWith("$partition")})
+			So(err, ShouldBeNil)
+			So(string(req.Query), ShouldEqual, `{"key":{"$sw":"$partition"}}`)
+		})
+
+		Convey("Should pass a JSQ query as is", func() {
+			req, err := toQueryRequest(&JSQQuery{Query: `{"key":{"$ne":"a"}}`})
+			So(err, ShouldBeNil)
+			So(string(req.Query), ShouldEqual, `{"key":{"$ne":"a"}}`)
+		})
+
+		Convey("Should return error if object has a query expression", func() {
+			_, err := toQueryRequest(&tables.Object{QueryParams: patchain.QueryParams{Expr: patchain.Expr{Expr: "key = ?"}}})
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestClient(t *testing.T) {
+	Convey("Client", t, func() {
+
+		Convey(".GetLast", func() {
+			Convey("Should return a verified object", func() {
+				obj := makeObject("key", "value")
+				s := newTestServer(200, obj)
+				defer s.Close()
+				found, err := NewClient(s.URL).GetLast(&tables.Object{Key: "key"})
+				So(err, ShouldBeNil)
+				So(found.Hash, ShouldEqual, obj.Hash)
+			})
+
+			Convey("Should reject an altered object", func() {
+				obj := makeObject("key", "value")
+				obj.Value = "altered"
+				s := newTestServer(200, obj)
+				defer s.Close()
+				_, err := NewClient(s.URL).GetLast(&tables.Object{Key: "key"})
+				So(errors.Cause(err), ShouldEqual, ErrVerificationFailed)
+			})
+
+			Convey("Should return patchain.ErrNotFound if server returns 404", func() {
+				s := newTestServer(404, httpapi.ErrorResponse{Error: "not found"})
+				defer s.Close()
+				_, err := NewClient(s.URL).GetLast(&tables.Object{Key: "key"})
+				So(err, ShouldEqual, patchain.ErrNotFound)
+			})
+
+			Convey("Should return error if options are passed", func() {
+				_, err := NewClient("http://localhost").GetLast(&tables.Object{}, &patchain.UseDBOption{})
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey(".All", func() {
+			Convey("Should reject a response with an altered object", func() {
+				objs := []*tables.Object{makeObject("a", "1"), makeObject("b", "2")}
+				objs[1].OwnerID = "another_owner"
+				s := newTestServer(200, objs)
+				defer s.Close()
+				_, err := NewClient(s.URL).All(&tables.Object{})
+				So(errors.Cause(err), ShouldEqual, ErrVerificationFailed)
+			})
+		})
+
+		Convey(".Put", func() {
+			Convey("Should update the objects with the fields set by the server", func() {
+				created := makeObject("key", "value")
+				s := newTestServer(201, []*tables.Object{created})
+				defer s.Close()
+				obj := &tables.Object{OwnerID: "owner_id", CreatorID: "owner_id", Key: "key", Value: "value"}
+				So(NewClient(s.URL).Put(obj), ShouldBeNil)
+				So(obj.ID, ShouldEqual, created.ID)
+				So(obj.Hash, ShouldEqual, created.Hash)
+			})
+
+			Convey("Should reject an object that does not match the object sent", func() {
+				s := newTestServer(201, []*tables.Object{makeObject("key", "another value")})
+				defer s.Close()
+				obj := &tables.Object{OwnerID: "owner_id", CreatorID: "owner_id", Key: "key", Value: "value"}
+				err := NewClient(s.URL).Put(obj)
+				So(errors.Cause(err), ShouldEqual, ErrVerificationFailed)
+				So(obj.ID, ShouldBeEmpty)
+			})
+
+			Convey("Should return a retryable error on contention", func() {
+				s := newTestServer(409, httpapi.ErrorResponse{Error: "conflict", Retryable: true})
+				defer s.Close()
+				c := NewClient(s.URL)
+				err := c.Put(&tables.Object{OwnerID: "owner_id"})
+				So(c.RequiresRetry(err), ShouldBeTrue)
+				So(errors.Cause(err).(*Error).StatusCode, ShouldEqual, 409)
+			})
+		})
+
+		Convey(".MustPut", func() {
+			Convey("Should retry until the server accepts the objects", func() {
+				var calls int32
+				created := makeObject("key", "value")
+				s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					if atomic.AddInt32(&calls, 1) < 3 {
+						w.WriteHeader(503)
+						json.NewEncoder(w).Encode(httpapi.ErrorResponse{Error: "restart transaction", Retryable: true})
+						return
+					}
+					w.WriteHeader(201)
+					json.NewEncoder(w).Encode([]*tables.Object{created})
+				}))
+				defer s.Close()
+				obj := &tables.Object{OwnerID: "owner_id", CreatorID: "owner_id", Key: "key", Value: "value"}
+				So(NewClient(s.URL).MustPut(obj), ShouldBeNil)
+				So(atomic.LoadInt32(&calls), ShouldEqual, 3)
+				So(obj.ID, ShouldEqual, created.ID)
+			})
+
+			Convey("Should not retry errors that are not retryable", func() {
+				var calls int32
+				s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					atomic.AddInt32(&calls, 1)
+					w.WriteHeader(422)
+					json.NewEncoder(w).Encode(httpapi.ErrorResponse{Error: "owner has no partition"})
+				}))
+				defer s.Close()
+				err := NewClient(s.URL).MustPut(&tables.Object{OwnerID: "owner_id"})
+				So(err, ShouldNotBeNil)
+				So(atomic.LoadInt32(&calls), ShouldEqual, 1)
+			})
+		})
+
+		Convey("Should time out slow requests", func() {
+			s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				time.Sleep(200 * time.Millisecond)
+			}))
+			defer s.Close()
+			_, err := NewClient(s.URL, WithTimeout(50*time.Millisecond)).All(&tables.Object{})
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,94 @@
+// Command patchain-server serves the patchain HTTP/JSON API and, if
+// a gRPC address is set, the patchain gRPC service.
+//
+// Usage:
+//
+//	patchain-server [-addr :8080] [-grpc-addr :9090] [-db <connection string>] [-blob-dir <dir>] [-state-table]
+//
+// The addresses, connection string and blob directory default to the values of the
+// PATCHAIN_ADDR, PATCHAIN_GRPC_ADDR, PATCHAIN_DB and PATCHAIN_BLOB_DIR environment variables.
+// If a blob directory is set, large values are offloaded to it. If -state-table is
+// set (or PATCHAIN_STATE_TABLE is true), writes maintain the object_state table.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+
+	"github.com/ellcrys/patchain/blob"
+	"github.com/ellcrys/patchain/cockroach"
+	"github.com/ellcrys/patchain/grpcapi"
+	"github.com/ellcrys/patchain/httpapi"
+	"github.com/ellcrys/patchain/object"
+	"github.com/ellcrys/util"
+	"google.golang.org/grpc"
+)
+
+func main() {
+
+	addr := flag.String("addr", util.Env("PATCHAIN_ADDR", ":8080"), "address to listen on")
+	grpcAddr := flag.String("grpc-addr", util.Env("PATCHAIN_GRPC_ADDR", ""), "address to serve the gRPC service on (disabled if empty)")
+	connStr := flag.String("db", util.Env("PATCHAIN_DB", ""), "database connection string")
+	maxOpenConn := flag.Int("max-open-conn", 10, "maximum number of open database connections")
+	maxIdleConn := flag.Int("max-idle-conn", 5, "maximum number of idle database connections")
+	blobDir := flag.String("blob-dir", util.Env("PATCHAIN_BLOB_DIR", ""), "directory to offload large values to (disabled if empty)")
+	stateTable := flag.Bool("state-table", util.Env("PATCHAIN_STATE_TABLE", "") == "true", "maintain the latest object of every key in the object_state table")
+	flag.Parse()
+
+	if *connStr == "" {
+		fmt.Fprintln(os.Stderr, "error: no connection string. Use -db or set PATCHAIN_DB")
+		os.Exit(2)
+	}
+
+	db := cockroach.NewDB()
+	db.ConnectionString = *connStr
+	db.NoLogging()
+	if err := db.Connect(*maxOpenConn, *maxIdleConn); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		os.Exit(1)
+	}
+	defer db.Close()
+
+	var blobStore blob.Store
+	if *blobDir != "" {
+		store, err := blob.NewFSStore(*blobDir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s\n", err)
+			os.Exit(1)
+		}
+		blobStore = store
+	}
+
+	if *grpcAddr != "" {
+		lis, err := net.Listen("tcp", *grpcAddr)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s\n", err)
+			os.Exit(1)
+		}
+		gs := grpc.NewServer()
+		grpcServer := grpcapi.NewServer(db)
+		grpcServer.Object().SetBlobStore(blobStore, 0)
+		grpcServer.Object().SetStateTable(*stateTable)
+		grpcServer.Register(gs)
+		fmt.Fprintf(os.Stderr, "serving gRPC on %s\n", *grpcAddr)
+		go func() {
+			if err := gs.Serve(lis); err != nil {
+				fmt.Fprintf(os.Stderr, "error: %s\n", err)
+				os.Exit(1)
+			}
+		}()
+	}
+
+	obj := object.NewObject(db)
+	obj.SetBlobStore(blobStore, 0)
+	obj.SetStateTable(*stateTable)
+	server := httpapi.NewServer(db, obj)
+	fmt.Fprintf(os.Stderr, "listening on %s\n", *addr)
+	if err := http.ListenAndServe(*addr, server); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,100 @@
+// Command patchain-verify verifies a bundle of objects exported from a patchain store.
+// It rebuilds every partition chain and the chain of partitions from the bundle and
+// prints a report for every partition. No database connection is required.
+//
+// Usage:
+//
+//	patchain-verify [-q] <bundle.jsonl | ->
+//
+// It exits with status 1 if the bundle fails verification and 2 if it cannot be read.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/ellcrys/patchain/object"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run verifies the bundle named in args and writes the report to stdout.
+// It returns the exit status.
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+
+	flags := flag.NewFlagSet("patchain-verify", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	quiet := flags.Bool("q", false, "only print failures and the summary")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	if flags.NArg() != 1 {
+		fmt.Fprintln(stderr, "usage: patchain-verify [-q] <bundle.jsonl | ->")
+		return 2
+	}
+
+	var r = stdin
+	if name := flags.Arg(0); name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			fmt.Fprintf(stderr, "failed to open bundle: %s\n", err)
+			return 2
+		}
+		defer f.Close()
+		r = f
+	}
+
+	header, objs, _, err := object.DecodeExport(r)
+	if err != nil {
+		fmt.Fprintf(stderr, "failed to read bundle: %s\n", err)
+		return 2
+	}
+
+	report := object.VerifyBundle(objs)
+	printReport(stdout, header, report, *quiet)
+
+	if !report.Passed() {
+		return 1
+	}
+	return 0
+}
+
+// printReport writes a verification report
+func printReport(w io.Writer, header *object.ExportHeader, report *object.BundleReport, quiet bool) {
+
+	fmt.Fprintf(w, "bundle: scope=%s schema_version=%s hash_algorithm=%s\n\n", header.Scope, header.SchemaVersion, header.HashAlgorithm)
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "PARTITION\tOWNER\tOBJECTS\tSTATE\tRESULT")
+	for _, p := range report.Partitions {
+		if quiet && p.Err == nil {
+			continue
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\n", p.PartitionID, p.OwnerID, p.NumObjects, p.State, result(p.Err))
+	}
+	tw.Flush()
+
+	fmt.Fprintf(w, "\npartition chain: %s (%d partition(s) chained to partitions outside the bundle)\n", result(report.PartitionChainErr), report.ExternalLinks)
+	fmt.Fprintf(w, "objects without partition: %d %s\n", report.NumOtherObjects, result(report.OtherObjectsErr))
+	fmt.Fprintf(w, "object flags: %s\n", result(report.FlagsErr))
+
+	if report.Passed() {
+		fmt.Fprintln(w, "\nPASS")
+	} else {
+		fmt.Fprintln(w, "\nFAIL")
+	}
+}
+
+// result describes the result of a check
+func result(err error) string {
+	if err != nil {
+		return "FAIL: " + err.Error()
+	}
+	return "PASS"
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/ellcrys/patchain/cockroach/tables"
+	"github.com/ellcrys/patchain/object"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// makeBundle creates a bundle holding a partition and its genesis pair
+func makeBundle() []*tables.Object {
+	partition := object.MakePartitionObject("partition_a", "owner_id", "creator_id")
+	object.MakeChain(partition)
+	return append([]*tables.Object{partition}, object.MakeGenesisPair("owner_id", "creator_id", partition.ID, partition.Hash)...)
+}
+
+// encodeBundle encodes objects as an export file
+func encodeBundle(objs []*tables.Object) string {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.Encode(object.ExportHeader{SchemaVersion: tables.SchemaVersion, HashAlgorithm: object.HashAlgorithm, Scope: object.ExportScopeOwner})
+	for _, obj := range objs {
+		enc.Encode(obj)
+	}
+	return buf.String()
+}
+
+func TestRun(t *testing.T) {
+	Convey("patchain-verify", t, func() {
+		var stdout, stderr bytes.Buffer
+
+		Convey("Should exit with status 2 if no bundle is given", func() {
+			So(run(nil, nil, &stdout, &stderr), ShouldEqual, 2)
+			So(stderr.String(), ShouldContainSubstring, "usage:")
+		})
+
+		Convey("Should exit with status 2 if bundle cannot be read", func() {
+			So(run([]string{"-"}, strings.NewReader("not json"), &stdout, &stderr), ShouldEqual, 2)
+			So(stderr.String(), ShouldContainSubstring, "failed to read bundle: line 1: malformed export header")
+		})
+
+		Convey("Should exit with status 0 if bundle is valid", func() {
+			bundle := makeBundle()
+			So(run([]string{"-"}, strings.NewReader(encodeBundle(bundle)), &stdout, &stderr), ShouldEqual, 0)
+			So(stdout.String(), ShouldContainSubstring, bundle[0].ID)
+			So(stdout.String(), ShouldEndWith, "PASS\n")
+		})
+
+		Convey("Should exit with status 1 if bundle fails verification", func() {
+			bundle := makeBundle()
+			bundle[2].Value = "altered"
+			So(run([]string{"-"}, strings.NewReader(encodeBundle(bundle)), &stdout, &stderr), ShouldEqual, 1)
+			So(stdout.String(), ShouldContainSubstring, "FAIL: object 1 ("+bundle[2].ID+"): hash mismatch")
+			So(stdout.String(), ShouldEndWith, "FAIL\n")
+		})
+	})
+}
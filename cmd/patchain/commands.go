@@ -0,0 +1,559 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ellcrys/patchain"
+	"github.com/ellcrys/patchain/cockroach/tables"
+	"github.com/ellcrys/patchain/object"
+	"github.com/pkg/errors"
+)
+
+// commands holds all the subcommands
+var commands = []command{
+	{name: "init", usage: "create the tables required by patchain", handler: initCmd},
+	{name: "partitions", usage: "create or list partitions (partitions create|list)", handler: partitionsCmd},
+	{name: "put", usage: "add an object to one of its owner's partitions", handler: putCmd},
+	{name: "get", usage: "get the most recent object matching a query", handler: getCmd},
+	{name: "history", usage: "list every version of a key", handler: historyCmd},
+	{name: "list", usage: "list the keys and common prefixes under a prefix", handler: listCmd},
+	{name: "verify", usage: "verify the chains of a partition or an owner", handler: verifyCmd},
+	{name: "export", usage: "export objects to JSON Lines", handler: exportCmd},
+	{name: "import", usage: "verify and import a JSON Lines export", handler: importCmd},
+	{name: "stats", usage: "show object and partition counts", handler: statsCmd},
+	{name: "state", usage: "check the state table of an owner for drift or rebuild it (state check|rebuild)", handler: stateCmd},
+}
+
+func init() {
+	// help refers to commands so it cannot be part of its initializer
+	commands = append(commands, command{name: "help", usage: "show this help", noDB: true, handler: helpCmd})
+}
+
+// queryFlags holds the flags used to select objects
+type queryFlags struct {
+	jsq         *string
+	ownerID     *string
+	key         *string
+	partitionID *string
+}
+
+// addQueryFlags adds the flags used to select objects to a flag set
+func addQueryFlags(flags *flag.FlagSet) *queryFlags {
+	return &queryFlags{
+		jsq:         flags.String("q", "", "JSQ query (cannot be combined with other query flags)"),
+		ownerID:     flags.String("owner", "", "owner id"),
+		key:         flags.String("key", "", "object key"),
+		partitionID: flags.String("partition", "", "partition id"),
+	}
+}
+
+// query builds a query object from the query flags
+func (c *cli) query(qf *queryFlags) (*tables.Object, error) {
+	if *qf.jsq != "" {
+		if *qf.ownerID != "" || *qf.key != "" || *qf.partitionID != "" {
+			return nil, fmt.Errorf("-q cannot be combined with -owner, -key or -partition")
+		}
+		return c.obj.ParseQuery(*qf.jsq)
+	}
+	if *qf.ownerID == "" && *qf.key == "" && *qf.partitionID == "" {
+		return nil, fmt.Errorf("a query is required. Use -q, -owner, -key or -partition")
+	}
+	return &tables.Object{OwnerID: *qf.ownerID, Key: *qf.key, PartitionID: *qf.partitionID}, nil
+}
+
+// initCmd creates the tables
+func initCmd(c *cli, args []string) error {
+	if err := c.newFlagSet("init").Parse(args); err != nil {
+		return err
+	}
+	if err := c.db.CreateTables(); err != nil {
+		return errors.Wrap(err, "failed to create tables")
+	}
+	fmt.Fprintln(c.stderr, "tables created")
+	return nil
+}
+
+// partitionInfo describes a partition and its state
+type partitionInfo struct {
+	*tables.Object
+	State string `json:"state"`
+}
+
+// partitionsCmd creates or lists partitions
+func partitionsCmd(c *cli, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: patchain partitions create|list [flags]")
+	}
+
+	switch args[0] {
+	case "create":
+		flags := c.newFlagSet("partitions create")
+		ownerID := flags.String("owner", "", "owner id (required)")
+		creatorID := flags.String("creator", "", "creator id (defaults to the owner id)")
+		n := flags.Int64("n", 1, "number of partitions to create")
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+		if *ownerID == "" {
+			return fmt.Errorf("-owner is required")
+		}
+		if *creatorID == "" {
+			*creatorID = *ownerID
+		}
+		partitions, err := c.obj.MustCreatePartitions(*n, *ownerID, *creatorID)
+		if err != nil {
+			return err
+		}
+		return c.printObjects(partitions)
+
+	case "list":
+		flags := c.newFlagSet("partitions list")
+		ownerID := flags.String("owner", "", "owner id (required)")
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+		if *ownerID == "" {
+			return fmt.Errorf("-owner is required")
+		}
+		partitions, err := c.obj.All(&tables.Object{OwnerID: *ownerID, QueryParams: patchain.QueryParams{
+			KeyStartsWith: object.PartitionPrefix,
+			OrderBy:       "timestamp asc",
+		}})
+		if err != nil {
+			return err
+		}
+		var infos = []*partitionInfo{}
+		for _, p := range partitions {
+			state, err := c.obj.GetPartitionState(p.ID)
+			if err != nil {
+				state = "error: " + err.Error()
+			}
+			infos = append(infos, &partitionInfo{Object: p, State: state})
+		}
+		if c.format == formatJSON {
+			return c.printJSON(infos)
+		}
+		tw := tabwriter.NewWriter(c.stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tOWNER\tCREATOR\tSTATE\tHASH")
+		for _, p := range infos {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", p.ID, p.OwnerID, p.CreatorID, p.State, p.Hash)
+		}
+		return tw.Flush()
+
+	default:
+		return fmt.Errorf("unknown partitions command: %s", args[0])
+	}
+}
+
+// putCmd adds an object
+func putCmd(c *cli, args []string) error {
+	flags := c.newFlagSet("put")
+	ownerID := flags.String("owner", "", "owner id (required)")
+	creatorID := flags.String("creator", "", "creator id (defaults to the owner id)")
+	key := flags.String("key", "", "object key (required)")
+	value := flags.String("value", "", "object value")
+	valueFile := flags.String("value-file", "", "read the object value from a file (- for stdin)")
+	protected := flags.Bool("protected", false, "mark the object as protected")
+	refOnly := flags.Bool("ref-only", false, "mark the object as a reference only object")
+	var refs [10]*string
+	for i := range refs {
+		refs[i] = flags.String(fmt.Sprintf("ref%d", i+1), "", fmt.Sprintf("value of ref%d", i+1))
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *ownerID == "" || *key == "" {
+		return fmt.Errorf("-owner and -key are required")
+	}
+
+	if *creatorID == "" {
+		*creatorID = *ownerID
+	}
+
+	if *valueFile != "" {
+		var r io.Reader = c.stdin
+		if *valueFile != "-" {
+			f, err := os.Open(*valueFile)
+			if err != nil {
+				return errors.Wrap(err, "failed to open value file")
+			}
+			defer f.Close()
+			r = f
+		}
+		bs, err := ioutil.ReadAll(r)
+		if err != nil {
+			return errors.Wrap(err, "failed to read value")
+		}
+		*value = string(bs)
+	}
+
+	obj := &tables.Object{
+		OwnerID:   *ownerID,
+		CreatorID: *creatorID,
+		Key:       *key,
+		Value:     *value,
+		Protected: *protected,
+		RefOnly:   *refOnly,
+		Ref1:      *refs[0], Ref2: *refs[1], Ref3: *refs[2], Ref4: *refs[3], Ref5: *refs[4],
+		Ref6: *refs[5], Ref7: *refs[6], Ref8: *refs[7], Ref9: *refs[8], Ref10: *refs[9],
+	}
+	if err := c.obj.MustPut(obj); err != nil {
+		return err
+	}
+
+	return c.printObjects([]*tables.Object{obj})
+}
+
+// getCmd gets the most recent object matching a query
+func getCmd(c *cli, args []string) error {
+	flags := c.newFlagSet("get")
+	qf := addQueryFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	q, err := c.query(qf)
+	if err != nil {
+		return err
+	}
+	obj, err := c.obj.GetLast(q)
+	if err != nil {
+		return err
+	}
+	return c.printObjects([]*tables.Object{obj})
+}
+
+// historyCmd lists every version of a key from the oldest to the most recent
+func historyCmd(c *cli, args []string) error {
+	flags := c.newFlagSet("history")
+	key := flags.String("key", "", "object key (required)")
+	ownerID := flags.String("owner", "", "owner id")
+	limit := flags.Int("limit", 0, "maximum number of versions to list")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *key == "" {
+		return fmt.Errorf("-key is required")
+	}
+	objs, err := c.obj.All(&tables.Object{OwnerID: *ownerID, Key: *key, QueryParams: patchain.QueryParams{
+		OrderBy: "timestamp asc",
+		Limit:   *limit,
+	}})
+	if err != nil {
+		return err
+	}
+	return c.printObjects(objs)
+}
+
+// listCmd lists the keys and common prefixes under a prefix
+func listCmd(c *cli, args []string) error {
+	flags := c.newFlagSet("list")
+	ownerID := flags.String("owner", "", "owner id")
+	prefix := flags.String("prefix", "", "only list keys starting with this prefix")
+	delimiter := flags.String("delimiter", "/", "roll up keys containing this delimiter after the prefix (empty to list every key)")
+	cursor := flags.String("cursor", "", "cursor returned by a previous listing")
+	limit := flags.Int("limit", 0, "maximum number of keys and common prefixes to list")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	listing, err := c.obj.List(*ownerID, *prefix, *delimiter, *cursor, *limit)
+	if err != nil {
+		return err
+	}
+	return c.printListing(listing)
+}
+
+// printListing writes the keys and common prefixes of a listing in order
+func (c *cli) printListing(listing *object.Listing) error {
+	if c.format == formatJSON {
+		return c.printJSON(listing)
+	}
+
+	tw := tabwriter.NewWriter(c.stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEY\tID\tVALUE\tTIMESTAMP")
+	objs, prefixes := listing.Objects, listing.CommonPrefixes
+	for len(objs) > 0 || len(prefixes) > 0 {
+		if len(objs) == 0 || (len(prefixes) > 0 && prefixes[0] < objs[0].Key) {
+			fmt.Fprintf(tw, "%s\t-\t-\t-\n", prefixes[0])
+			prefixes = prefixes[1:]
+			continue
+		}
+		o := objs[0]
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", o.Key, o.ID, truncate(o.Value, 40), time.Unix(0, o.Timestamp).UTC().Format(time.RFC3339Nano))
+		objs = objs[1:]
+	}
+	tw.Flush()
+
+	if listing.NextCursor != "" {
+		fmt.Fprintf(c.stdout, "\nnext cursor: %s\n", listing.NextCursor)
+	}
+	return nil
+}
+
+// verifyCmd verifies the chains of a partition or all partitions of an owner
+func verifyCmd(c *cli, args []string) error {
+	flags := c.newFlagSet("verify")
+	ownerID := flags.String("owner", "", "verify all partitions of an owner")
+	partitionID := flags.String("partition", "", "verify a partition")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	var objs []*tables.Object
+	var err error
+	switch {
+	case *partitionID != "":
+		var partition *tables.Object
+		partition, err = c.obj.GetLast(&tables.Object{ID: *partitionID, QueryParams: patchain.KeyStartsWith(object.PartitionPrefix)})
+		if err != nil {
+			return errors.Wrap(err, "failed to get partition")
+		}
+		objs, err = c.obj.All(&tables.Object{PartitionID: partition.ID, QueryParams: patchain.QueryParams{OrderBy: "timestamp asc"}}, object.IncludeRefOnly())
+		objs = append([]*tables.Object{partition}, objs...)
+	case *ownerID != "":
+		objs, err = c.obj.All(&tables.Object{OwnerID: *ownerID, QueryParams: patchain.QueryParams{OrderBy: "timestamp asc"}}, object.IncludeRefOnly())
+	default:
+		return fmt.Errorf("-owner or -partition is required")
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to get objects")
+	}
+
+	report := object.VerifyBundle(objs)
+
+	if c.format == formatJSON {
+		if err := c.printJSON(report); err != nil {
+			return err
+		}
+	} else {
+		tw := tabwriter.NewWriter(c.stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "PARTITION\tOWNER\tOBJECTS\tSTATE\tRESULT")
+		for _, p := range report.Partitions {
+			fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\n", p.PartitionID, p.OwnerID, p.NumObjects, p.State, result(p.Err))
+		}
+		tw.Flush()
+		fmt.Fprintf(c.stdout, "\npartition chain: %s\n", result(report.PartitionChainErr))
+		fmt.Fprintf(c.stdout, "objects without partition: %d %s\n", report.NumOtherObjects, result(report.OtherObjectsErr))
+		fmt.Fprintf(c.stdout, "object flags: %s\n", result(report.FlagsErr))
+	}
+
+	if !report.Passed() {
+		return errVerificationFailed
+	}
+	return nil
+}
+
+// result describes the result of a check
+func result(err error) string {
+	if err != nil {
+		return "FAIL: " + err.Error()
+	}
+	return "PASS"
+}
+
+// exportCmd exports objects to JSON Lines
+func exportCmd(c *cli, args []string) error {
+	flags := c.newFlagSet("export")
+	qf := addQueryFlags(flags)
+	out := flags.String("o", "-", "file to write the export to (- for stdout)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	var w = c.stdout
+	if *out != "-" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return errors.Wrap(err, "failed to create export file")
+		}
+		defer f.Close()
+		w = f
+	}
+
+	var n int
+	var err error
+	switch {
+	case *qf.jsq == "" && *qf.key == "" && *qf.partitionID != "" && *qf.ownerID == "":
+		n, err = c.obj.ExportPartition(*qf.partitionID, w)
+	case *qf.jsq == "" && *qf.key == "" && *qf.partitionID == "" && *qf.ownerID != "":
+		n, err = c.obj.ExportOwner(*qf.ownerID, w)
+	default:
+		var q *tables.Object
+		if q, err = c.query(qf); err != nil {
+			return err
+		}
+		n, err = c.obj.Export(q, w)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(c.stderr, "exported %d object(s)\n", n)
+	return nil
+}
+
+// importCmd verifies and imports a JSON Lines export
+func importCmd(c *cli, args []string) error {
+	flags := c.newFlagSet("import")
+	in := flags.String("i", "-", "file to read the export from (- for stdin)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	var r = c.stdin
+	if *in != "-" {
+		f, err := os.Open(*in)
+		if err != nil {
+			return errors.Wrap(err, "failed to open export file")
+		}
+		defer f.Close()
+		r = f
+	}
+
+	n, err := c.obj.Import(r)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(c.stderr, "imported %d object(s)\n", n)
+	return nil
+}
+
+// stats holds object and partition counts
+type stats struct {
+	Objects    int64            `json:"objects"`
+	Partitions int64            `json:"partitions"`
+	States     map[string]int64 `json:"partition_states"`
+}
+
+// statsCmd shows object and partition counts
+func statsCmd(c *cli, args []string) error {
+	flags := c.newFlagSet("stats")
+	ownerID := flags.String("owner", "", "only count the objects of an owner")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	var s = stats{States: map[string]int64{
+		object.PartitionActive:   0,
+		object.PartitionSealing:  0,
+		object.PartitionSealed:   0,
+		object.PartitionArchived: 0,
+	}}
+
+	if err := c.db.Count(&tables.Object{OwnerID: *ownerID}, &s.Objects); err != nil {
+		return errors.Wrap(err, "failed to count objects")
+	}
+
+	partitions, err := c.obj.All(&tables.Object{OwnerID: *ownerID, QueryParams: patchain.KeyStartsWith(object.PartitionPrefix)})
+	if err != nil {
+		return errors.Wrap(err, "failed to get partitions")
+	}
+	s.Partitions = int64(len(partitions))
+	for _, p := range partitions {
+		state, err := c.obj.GetPartitionState(p.ID)
+		if err != nil {
+			return errors.Wrapf(err, "failed to get state of partition %s", p.ID)
+		}
+		s.States[state]++
+	}
+
+	if c.format == formatJSON {
+		return c.printJSON(s)
+	}
+
+	tw := tabwriter.NewWriter(c.stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "objects\t%d\n", s.Objects)
+	fmt.Fprintf(tw, "partitions\t%d\n", s.Partitions)
+	for _, state := range []string{object.PartitionActive, object.PartitionSealing, object.PartitionSealed, object.PartitionArchived} {
+		fmt.Fprintf(tw, "  %s\t%d\n", state, s.States[state])
+	}
+	return tw.Flush()
+}
+
+// stateCmd checks the state table of an owner against the states rebuilt from
+// its objects or repairs the states that drifted
+func stateCmd(c *cli, args []string) error {
+	if len(args) == 0 || (args[0] != "check" && args[0] != "rebuild") {
+		return fmt.Errorf("usage: patchain state check|rebuild -owner <owner id>")
+	}
+
+	flags := c.newFlagSet("state " + args[0])
+	ownerID := flags.String("owner", "", "owner id (required)")
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+	if *ownerID == "" {
+		return fmt.Errorf("-owner is required")
+	}
+
+	var drifts []object.StateDrift
+	var err error
+	if args[0] == "rebuild" {
+		drifts, err = c.obj.RebuildState(*ownerID)
+	} else {
+		drifts, err = c.obj.CheckState(*ownerID)
+	}
+	if err != nil {
+		return err
+	}
+
+	if c.format == formatJSON {
+		if drifts == nil {
+			drifts = []object.StateDrift{}
+		}
+		if err := c.printJSON(drifts); err != nil {
+			return err
+		}
+	} else {
+		tw := tabwriter.NewWriter(c.stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "KEY\tEXPECTED\tACTUAL")
+		for _, d := range drifts {
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", d.Key, describeState(d.Expected), describeState(d.Actual))
+		}
+		tw.Flush()
+		if args[0] == "rebuild" {
+			fmt.Fprintf(c.stdout, "\nrepaired %d state(s)\n", len(drifts))
+		} else {
+			fmt.Fprintf(c.stdout, "\ndrifted states: %d\n", len(drifts))
+		}
+	}
+
+	if args[0] == "check" && len(drifts) > 0 {
+		return errVerificationFailed
+	}
+	return nil
+}
+
+// describeState describes the object a state refers to
+func describeState(state *tables.ObjectState) string {
+	switch {
+	case state == nil:
+		return "-"
+	case state.Tombstone:
+		return state.ObjectID + " (deleted)"
+	default:
+		return state.ObjectID
+	}
+}
+
+// helpCmd prints the list of commands
+func helpCmd(c *cli, args []string) error {
+	if len(args) > 0 {
+		cmd := findCommand(args[0])
+		if cmd == nil {
+			return fmt.Errorf("unknown command: %s", args[0])
+		}
+		fmt.Fprintf(c.stderr, "%s: %s\nRun `patchain %s -h` for its flags\n", cmd.name, cmd.usage, cmd.name)
+		return nil
+	}
+	c.printUsage()
+	return nil
+}
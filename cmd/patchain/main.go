@@ -0,0 +1,202 @@
+// Command patchain operates a patchain store backed by CockroachDB.
+//
+// Usage:
+//
+//	patchain [-db <connection string>] [-blob-dir <dir>] [-state-table] [-format table|json] <command> [flags]
+//
+// The connection string and blob directory default to the values of the PATCHAIN_DB
+// and PATCHAIN_BLOB_DIR environment variables. If a blob directory is set, large values
+// are offloaded to it and offloaded values are read from it. If -state-table is set
+// (or PATCHAIN_STATE_TABLE is true), writes maintain the object_state table.
+// Run `patchain help` for the list of commands.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ellcrys/patchain/blob"
+	"github.com/ellcrys/patchain/cockroach"
+	"github.com/ellcrys/patchain/cockroach/tables"
+	"github.com/ellcrys/patchain/object"
+	"github.com/ellcrys/util"
+	"github.com/pkg/errors"
+)
+
+// Output formats
+const (
+	formatTable = "table"
+	formatJSON  = "json"
+)
+
+// errVerificationFailed is returned by commands whose verification checks failed.
+// The report has already been written so no other message is printed.
+var errVerificationFailed = fmt.Errorf("verification failed")
+
+// command describes a subcommand
+type command struct {
+	name    string
+	usage   string
+	noDB    bool
+	handler func(c *cli, args []string) error
+}
+
+// cli holds the state shared by all commands
+type cli struct {
+	stdin      io.Reader
+	stdout     io.Writer
+	stderr     io.Writer
+	connStr    string
+	blobDir    string
+	stateTable bool
+	format     string
+	db         *cockroach.DB
+	obj        *object.Object
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run parses the global flags and runs the command. It returns the exit status.
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+
+	c := &cli{stdin: stdin, stdout: stdout, stderr: stderr}
+
+	flags := flag.NewFlagSet("patchain", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.StringVar(&c.connStr, "db", util.Env("PATCHAIN_DB", ""), "database connection string")
+	flags.StringVar(&c.blobDir, "blob-dir", util.Env("PATCHAIN_BLOB_DIR", ""), "directory to offload large values to (disabled if empty)")
+	flags.BoolVar(&c.stateTable, "state-table", util.Env("PATCHAIN_STATE_TABLE", "") == "true", "maintain the latest object of every key in the object_state table")
+	flags.StringVar(&c.format, "format", formatTable, "output format (table or json)")
+	flags.Usage = func() { c.printUsage() }
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	if flags.NArg() == 0 {
+		c.printUsage()
+		return 2
+	}
+
+	if c.format != formatTable && c.format != formatJSON {
+		fmt.Fprintf(stderr, "error: unknown output format: %s\n", c.format)
+		return 2
+	}
+
+	cmd := findCommand(flags.Arg(0))
+	if cmd == nil {
+		fmt.Fprintf(stderr, "error: unknown command: %s\n", flags.Arg(0))
+		c.printUsage()
+		return 2
+	}
+
+	if !cmd.noDB {
+		if err := c.connect(); err != nil {
+			fmt.Fprintf(stderr, "error: %s\n", err)
+			return 1
+		}
+		defer c.db.Close()
+	}
+
+	if err := cmd.handler(c, flags.Args()[1:]); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		} else if err != errVerificationFailed {
+			fmt.Fprintf(stderr, "error: %s\n", err)
+		}
+		return 1
+	}
+
+	return 0
+}
+
+// findCommand returns the command with the given name
+func findCommand(name string) *command {
+	for i := range commands {
+		if commands[i].name == name {
+			return &commands[i]
+		}
+	}
+	return nil
+}
+
+// printUsage writes the list of commands
+func (c *cli) printUsage() {
+	fmt.Fprintln(c.stderr, "usage: patchain [-db <connection string>] [-blob-dir <dir>] [-state-table] [-format table|json] <command> [flags]")
+	fmt.Fprintln(c.stderr, "\ncommands:")
+	tw := tabwriter.NewWriter(c.stderr, 0, 4, 2, ' ', 0)
+	for _, cmd := range commands {
+		fmt.Fprintf(tw, "  %s\t%s\n", cmd.name, cmd.usage)
+	}
+	tw.Flush()
+}
+
+// connect connects to the database
+func (c *cli) connect() error {
+	if c.connStr == "" {
+		return fmt.Errorf("no connection string. Use -db or set PATCHAIN_DB")
+	}
+	var blobStore blob.Store
+	if c.blobDir != "" {
+		store, err := blob.NewFSStore(c.blobDir)
+		if err != nil {
+			return err
+		}
+		blobStore = store
+	}
+	c.db = cockroach.NewDB()
+	c.db.ConnectionString = c.connStr
+	c.db.NoLogging()
+	if err := c.db.Connect(10, 5); err != nil {
+		return err
+	}
+	c.obj = object.NewObject(c.db)
+	c.obj.SetBlobStore(blobStore, 0)
+	c.obj.SetStateTable(c.stateTable)
+	return nil
+}
+
+// newFlagSet creates a flag set for a command
+func (c *cli) newFlagSet(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet("patchain "+name, flag.ContinueOnError)
+	flags.SetOutput(c.stderr)
+	return flags
+}
+
+// printJSON writes v as indented JSON
+func (c *cli) printJSON(v interface{}) error {
+	enc := json.NewEncoder(c.stdout)
+	enc.SetIndent("", "  ")
+	return errors.Wrap(enc.Encode(v), "failed to write output")
+}
+
+// printObjects writes objects in the selected output format
+func (c *cli) printObjects(objs []*tables.Object) error {
+	if c.format == formatJSON {
+		if objs == nil {
+			objs = []*tables.Object{}
+		}
+		return c.printJSON(objs)
+	}
+	tw := tabwriter.NewWriter(c.stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tKEY\tVALUE\tOWNER\tPARTITION\tTIMESTAMP\tHASH")
+	for _, o := range objs {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", o.ID, o.Key, truncate(o.Value, 40), o.OwnerID, o.PartitionID,
+			time.Unix(0, o.Timestamp).UTC().Format(time.RFC3339Nano), o.Hash)
+	}
+	return tw.Flush()
+}
+
+// truncate shortens a string to at most n characters
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n-3] + "..."
+}
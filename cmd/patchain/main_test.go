@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ellcrys/patchain/cockroach/tables"
+	"github.com/ellcrys/patchain/object"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRun(t *testing.T) {
+	Convey("patchain", t, func() {
+		var stdout, stderr bytes.Buffer
+
+		Convey("Should exit with status 2 and print usage if no command is given", func() {
+			So(run(nil, nil, &stdout, &stderr), ShouldEqual, 2)
+			So(stderr.String(), ShouldContainSubstring, "usage: patchain")
+			So(stderr.String(), ShouldContainSubstring, "partitions")
+		})
+
+		Convey("Should exit with status 2 if command is unknown", func() {
+			So(run([]string{"unknown"}, nil, &stdout, &stderr), ShouldEqual, 2)
+			So(stderr.String(), ShouldContainSubstring, "error: unknown command: unknown")
+		})
+
+		Convey("Should exit with status 2 if output format is unknown", func() {
+			So(run([]string{"-format", "xml", "get"}, nil, &stdout, &stderr), ShouldEqual, 2)
+			So(stderr.String(), ShouldContainSubstring, "error: unknown output format: xml")
+		})
+
+		Convey("Should exit with status 1 if no connection string is set", func() {
+			So(run([]string{"-db", "", "stats"}, nil, &stdout, &stderr), ShouldEqual, 1)
+			So(stderr.String(), ShouldContainSubstring, "error: no connection string")
+		})
+
+		Convey("Should exit with status 1 if the blob directory cannot be created", func() {
+			f, err := ioutil.TempFile("", "patchain")
+			So(err, ShouldBeNil)
+			defer os.Remove(f.Name())
+			f.Close()
+			So(run([]string{"-db", "postgresql://root@localhost:26257", "-blob-dir", filepath.Join(f.Name(), "blobs"), "stats"}, nil, &stdout, &stderr), ShouldEqual, 1)
+			So(stderr.String(), ShouldContainSubstring, "not a directory")
+		})
+
+		Convey("Should run help without a database connection", func() {
+			So(run([]string{"-db", "", "help", "put"}, nil, &stdout, &stderr), ShouldEqual, 0)
+			So(stderr.String(), ShouldContainSubstring, "put: add an object")
+		})
+	})
+}
+
+func TestPrintObjects(t *testing.T) {
+	Convey(".printObjects", t, func() {
+		var stdout bytes.Buffer
+		c := &cli{stdout: &stdout, format: formatTable}
+		objs := []*tables.Object{{ID: "id_1", Key: "key_1", Value: "value_1", OwnerID: "owner_1"}}
+
+		Convey("Should write a table with a row per object", func() {
+			So(c.printObjects(objs), ShouldBeNil)
+			So(stdout.String(), ShouldStartWith, "ID")
+			So(stdout.String(), ShouldContainSubstring, "id_1")
+			So(stdout.String(), ShouldContainSubstring, "owner_1")
+		})
+
+		Convey("Should write a JSON array", func() {
+			c.format = formatJSON
+			So(c.printObjects(objs), ShouldBeNil)
+			var out []*tables.Object
+			So(json.Unmarshal(stdout.Bytes(), &out), ShouldBeNil)
+			So(out, ShouldHaveLength, 1)
+			So(out[0].ID, ShouldEqual, "id_1")
+		})
+
+		Convey("Should write an empty JSON array if there are no objects", func() {
+			c.format = formatJSON
+			So(c.printObjects(nil), ShouldBeNil)
+			So(stdout.String(), ShouldEqual, "[]\n")
+		})
+	})
+}
+
+func TestPrintListing(t *testing.T) {
+	Convey(".printListing", t, func() {
+		var stdout bytes.Buffer
+		c := &cli{stdout: &stdout, format: formatTable}
+		listing := &object.Listing{
+			Objects:        []*tables.Object{{ID: "id_1", Key: "a"}, {ID: "id_2", Key: "c"}},
+			CommonPrefixes: []string{"b/", "d/"},
+			NextCursor:     "d/",
+		}
+
+		Convey("Should write the keys and common prefixes in order", func() {
+			So(c.printListing(listing), ShouldBeNil)
+			out := stdout.String()
+			So(out, ShouldStartWith, "KEY")
+			So(bytes.Index(stdout.Bytes(), []byte("id_1")), ShouldBeLessThan, bytes.Index(stdout.Bytes(), []byte("b/")))
+			So(bytes.Index(stdout.Bytes(), []byte("b/")), ShouldBeLessThan, bytes.Index(stdout.Bytes(), []byte("id_2")))
+			So(out, ShouldEndWith, "next cursor: d/\n")
+		})
+	})
+}
+
+func TestTruncate(t *testing.T) {
+	Convey(".truncate", t, func() {
+		So(truncate("abc", 5), ShouldEqual, "abc")
+		So(truncate("abcdefgh", 6), ShouldEqual, "abc...")
+	})
+}
+
+func TestStateCmd(t *testing.T) {
+	Convey("state", t, func() {
+		var stdout, stderr bytes.Buffer
+		c := &cli{stdout: &stdout, stderr: &stderr, format: formatTable}
+
+		Convey("Should return error if the subcommand is unknown", func() {
+			err := stateCmd(c, []string{"fix"})
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldStartWith, "usage: patchain state")
+		})
+
+		Convey("Should return error if no owner is set", func() {
+			err := stateCmd(c, []string{"check"})
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "-owner is required")
+		})
+	})
+
+	Convey(".describeState", t, func() {
+		So(describeState(nil), ShouldEqual, "-")
+		So(describeState(&tables.ObjectState{ObjectID: "id_1"}), ShouldEqual, "id_1")
+		So(describeState(&tables.ObjectState{ObjectID: "id_1", Tombstone: true}), ShouldEqual, "id_1 (deleted)")
+	})
+}
@@ -1,6 +1,9 @@
 package cockroach
 
 import (
+	"context"
+	"database/sql"
+	"encoding/json"
 	"fmt"
 
 	"strings"
@@ -21,6 +24,9 @@ import (
 // blacklistedFields cannot be included in JSQ query
 var blacklistedFields = []string{"partition_id", "JSQ_params"}
 
+// ErrNoCondition indicates a query that would update or delete all the rows of a table
+var ErrNoCondition = fmt.Errorf("query has no condition")
+
 // DB defines a structure that implements the DB interface
 // to provide database access
 type DB struct {
@@ -108,7 +114,7 @@ func (c *DB) SetConn(conn interface{}) error {
 // CreateTables creates the tables required if they do not exists.
 // Returns nil if table already exists
 func (c *DB) CreateTables() error {
-	c.db.AutoMigrate(&tables.Object{})
+	c.db.AutoMigrate(&tables.Object{}, &tables.OnceKey{}, &tables.FeedCursor{}, &tables.Webhook{}, &tables.WebhookDelivery{}, &tables.OutboxMessage{}, &tables.OwnerUsage{}, &tables.ObjectState{})
 	return nil
 }
 
@@ -233,6 +239,33 @@ func (c *DB) GetAll(q patchain.Query, out interface{}, options ...patchain.Optio
 	return nil
 }
 
+// Update sets the given column values on all documents that match the query.
+// It returns ErrNoCondition if the query has no expression and no non-zero field.
+func (c *DB) Update(q patchain.Query, values map[string]interface{}, options ...patchain.Option) error {
+	if !hasCondition(q) {
+		return ErrNoCondition
+	}
+	dbTx, _ := c.getDBTxFromOption(options, &DB{db: c.db})
+	return dbTx.GetConn().(*gorm.DB).
+		LogMode(!c.noLogging).
+		Model(q).
+		Scopes(c.getQueryModifiers(q)...).
+		Updates(values).Error
+}
+
+// Delete deletes all documents that match the query. It returns ErrNoCondition
+// if the query has no expression and no non-zero field.
+func (c *DB) Delete(q patchain.Query, options ...patchain.Option) error {
+	if !hasCondition(q) {
+		return ErrNoCondition
+	}
+	dbTx, _ := c.getDBTxFromOption(options, &DB{db: c.db})
+	return dbTx.GetConn().(*gorm.DB).
+		LogMode(!c.noLogging).
+		Scopes(c.getQueryModifiers(q)...).
+		Delete(q).Error
+}
+
 // Count returns a count of the number of documents that matches the query
 func (c *DB) Count(q patchain.Query, out interface{}, options ...patchain.Option) error {
 	dbTx, _ := c.getDBTxFromOption(options, &DB{db: c.db})
@@ -243,6 +276,82 @@ func (c *DB) Count(q patchain.Query, out interface{}, options ...patchain.Option
 		Count(out).Error
 }
 
+// Aggregate selects aggregate expressions over the documents that match
+// the query and scans the resulting row into out
+func (c *DB) Aggregate(q patchain.Query, sel patchain.Expr, out interface{}, options ...patchain.Option) error {
+	dbTx, _ := c.getDBTxFromOption(options, &DB{db: c.db})
+	return dbTx.GetConn().(*gorm.DB).
+		LogMode(!c.noLogging).
+		Scopes(c.getQueryModifiers(q)...).
+		Model(q).
+		Select(sel.Expr, sel.Args...).
+		Scan(out).Error
+}
+
+// WatchObjects streams a core changefeed on the objects table and calls cb with the
+// partition id of every object created or updated until stop is closed. It requires
+// rangefeeds to be enabled on the cluster (kv.rangefeed.enabled).
+func (c *DB) WatchObjects(stop <-chan struct{}, cb func(partitionID string)) error {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	rows, err := c.db.DB().QueryContext(ctx, "EXPERIMENTAL CHANGEFEED FOR objects")
+	if err != nil {
+		return errors.Wrap(err, "failed to start changefeed")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var table sql.NullString
+		var key, value []byte
+		if err := rows.Scan(&table, &key, &value); err != nil {
+			return errors.Wrap(err, "failed to read changefeed")
+		}
+		var row struct {
+			After *struct {
+				PartitionID string `json:"partition_id"`
+			} `json:"after"`
+		}
+		if err := json.Unmarshal(value, &row); err != nil || row.After == nil {
+			continue
+		}
+		cb(row.After.PartitionID)
+	}
+
+	select {
+	case <-stop:
+		return nil
+	default:
+	}
+	if err := rows.Err(); err != nil {
+		return errors.Wrap(err, "changefeed failed")
+	}
+	return fmt.Errorf("changefeed ended")
+}
+
+// hasCondition checks whether a query restricts the rows it matches with an
+// expression, a filter, a key prefix or a non-zero field
+func hasCondition(q patchain.Query) bool {
+	qp := q.GetQueryParams()
+	if qp != nil && (qp.Expr.Expr != "" || qp.Filter.Expr != "" || qp.KeyStartsWith != "") {
+		return true
+	}
+	for _, f := range structs.Fields(q) {
+		if f.Name() != "QueryParams" && f.IsExported() && !f.IsZero() {
+			return true
+		}
+	}
+	return false
+}
+
 // getQueryModifiers applies the query parameters
 // associated with query object to the db connection.
 func (c *DB) getQueryModifiers(q patchain.Query) []func(*gorm.DB) *gorm.DB {
@@ -266,6 +375,12 @@ func (c *DB) getQueryModifiers(q patchain.Query) []func(*gorm.DB) *gorm.DB {
 		return modifiers
 	}
 
+	if qp.Filter.Expr != "" {
+		modifiers = append(modifiers, func(conn *gorm.DB) *gorm.DB {
+			return conn.Where(qp.Filter.Expr, qp.Filter.Args...)
+		})
+	}
+
 	if len(qp.KeyStartsWith) > 0 {
 		modifiers = append(modifiers, func(conn *gorm.DB) *gorm.DB {
 			return conn.Where("key LIKE ?", qp.KeyStartsWith+"%")
@@ -103,6 +103,7 @@ func TestCockroach(t *testing.T) {
 				So(err, ShouldBeNil)
 				db, _ := gorm.Open("postgres", conStrWithDB)
 				So(db.HasTable("objects"), ShouldEqual, true)
+				So(db.HasTable("once_keys"), ShouldEqual, true)
 				db.Close()
 			})
 		})
@@ -366,6 +367,75 @@ func TestCockroach(t *testing.T) {
 			})
 		})
 
+		Convey(".Update", func() {
+			Convey("Should only update objects that match a query", func() {
+				key := util.RandString(5)
+				objs := []*tables.Object{
+					{ID: util.UUID4(), Key: key, PrevHash: util.RandString(5)},
+					{ID: util.UUID4(), Key: util.RandString(5), PrevHash: util.RandString(5)},
+				}
+				objsI, _ := util.ToSliceInterface(objs)
+				err := cdb.CreateBulk(objsI)
+				So(err, ShouldBeNil)
+
+				err = cdb.Update(&tables.Object{Key: key}, map[string]interface{}{"ref1": "ref_abc"})
+				So(err, ShouldBeNil)
+
+				var count int64
+				err = cdb.Count(&tables.Object{Ref1: "ref_abc"}, &count)
+				So(err, ShouldBeNil)
+				So(count, ShouldEqual, 1)
+			})
+
+			Convey("Should return error if the query has no condition", func() {
+				So(cdb.Create(&tables.Object{ID: util.UUID4()}), ShouldBeNil)
+				So(cdb.Update(&tables.Object{}, map[string]interface{}{"ref1": "ref_abc"}), ShouldEqual, ErrNoCondition)
+
+				var count int64
+				So(cdb.Count(&tables.Object{Ref1: "ref_abc"}, &count), ShouldBeNil)
+				So(count, ShouldEqual, 0)
+			})
+
+			Reset(func() {
+				clearTable(cdb.GetConn().(*gorm.DB), "objects")
+			})
+		})
+
+		Convey(".Delete", func() {
+			Convey("Should successfully delete objects that match a query", func() {
+				key := util.RandString(5)
+				objs := []*tables.Object{
+					{ID: util.UUID4(), Key: key, PeerHash: util.RandString(5), PrevHash: util.RandString(5)},
+					{ID: util.UUID4(), Key: key, PeerHash: util.RandString(5), PrevHash: util.RandString(5)},
+					{ID: util.UUID4(), Key: util.RandString(5), PeerHash: util.RandString(5), PrevHash: util.RandString(5)},
+				}
+				objsI, _ := util.ToSliceInterface(objs)
+				err := cdb.CreateBulk(objsI)
+				So(err, ShouldBeNil)
+
+				err = cdb.Delete(&tables.Object{Key: key})
+				So(err, ShouldBeNil)
+
+				var count int64
+				err = cdb.Count(&tables.Object{}, &count)
+				So(err, ShouldBeNil)
+				So(count, ShouldEqual, 1)
+			})
+
+			Convey("Should return error if the query has no condition", func() {
+				So(cdb.Create(&tables.Object{ID: util.UUID4()}), ShouldBeNil)
+				So(cdb.Delete(&tables.Object{}), ShouldEqual, ErrNoCondition)
+
+				var count int64
+				So(cdb.Count(&tables.Object{}, &count), ShouldBeNil)
+				So(count, ShouldEqual, 1)
+			})
+
+			Reset(func() {
+				clearTable(cdb.GetConn().(*gorm.DB), "objects")
+			})
+		})
+
 		Convey(".getQueryModifiers - Tests query parameters", func() {
 			Convey("KeyStartsWith", func() {
 				Convey("Should return the last object with the matching start key", func() {
@@ -443,6 +513,29 @@ func TestCockroach(t *testing.T) {
 					So(obj, ShouldResemble, res[0])
 				})
 
+				Convey("Should apply QueryParam.Filter in addition to the query object", func() {
+					key := util.RandString(5)
+					objs := []*tables.Object{
+						{ID: util.UUID4(), Key: key, Protected: true, PeerHash: util.RandString(5), PrevHash: util.RandString(5)},
+						{ID: util.UUID4(), Key: key, PeerHash: util.RandString(5), PrevHash: util.RandString(5)},
+					}
+					objsI, _ := util.ToSliceInterface(objs)
+					err := cdb.CreateBulk(objsI)
+					So(err, ShouldBeNil)
+					conn := cdb.GetConn().(*gorm.DB)
+					res := []*tables.Object{}
+					modifiers := cdb.getQueryModifiers(&tables.Object{
+						Key: key,
+						QueryParams: patchain.QueryParams{
+							Filter: patchain.Expr{Expr: "protected = ?", Args: []interface{}{false}},
+						},
+					})
+					err = conn.NewScope(nil).DB().Scopes(modifiers...).Find(&res).Error
+					So(err, ShouldBeNil)
+					So(len(res), ShouldEqual, 1)
+					So(res[0].ID, ShouldEqual, objs[1].ID)
+				})
+
 				Convey("Should limit objects returned if Limit is set", func() {
 					objs := []*tables.Object{
 						{ID: util.UUID4(), Key: "1", PeerHash: util.RandString(5), PrevHash: util.RandString(5)},
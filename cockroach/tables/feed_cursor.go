@@ -0,0 +1,17 @@
+package tables
+
+import "github.com/ellcrys/patchain"
+
+// FeedCursor stores the encoded cursor of a named change feed
+// so that the feed can be resumed after a restart
+type FeedCursor struct {
+	Name        string               `json:"name,omitempty" structs:"name,omitempty" mapstructure:"name,omitempty" gorm:"type:varchar(64);primary_key"`
+	Cursor      string               `json:"cursor,omitempty" structs:"cursor,omitempty" mapstructure:"cursor,omitempty" gorm:"type:text"`
+	Timestamp   int64                `json:"timestamp,omitempty" structs:"timestamp,omitempty" mapstructure:"timestamp,omitempty"`
+	QueryParams patchain.QueryParams `json:"-" structs:"-" mapstructure:"-" gorm:"-"`
+}
+
+// GetQueryParams returns the query parameters attached to the feed cursor
+func (c *FeedCursor) GetQueryParams() *patchain.QueryParams {
+	return &c.QueryParams
+}
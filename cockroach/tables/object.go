@@ -1,6 +1,7 @@
 package tables
 
 import (
+	"encoding/base64"
 	"fmt"
 	"time"
 
@@ -9,7 +10,26 @@ import (
 )
 
 // SchemaVersion describes the version the object's schema/field make up.
-var SchemaVersion = "1"
+// Version 2 hashes the value of an object with a codec over the codec id and
+// the encoded bytes. Version 1 hashes the value as stored.
+var SchemaVersion = "2"
+
+// SchemaVersions lists the schema versions whose hashes can be computed
+var SchemaVersions = []string{"1", "2"}
+
+// IsSchemaVersion checks whether the hashes of a schema version can be computed
+func IsSchemaVersion(version string) bool {
+	for _, v := range SchemaVersions {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
+
+// CodecJSON is the id of the JSON value codec. Values encoded by it are
+// stored as is while values encoded by other codecs are stored in base64.
+const CodecJSON = "json"
 
 // Object represents a transaction created by an identity
 type Object struct {
@@ -21,6 +41,10 @@ type Object struct {
 	Value         string               `json:"value,omitempty" structs:"value,omitempty" mapstructure:"value,omitempty" gorm:"type:varchar(64000);index:idx_value"`
 	Protected     bool                 `json:"protected" structs:"protected" mapstructure:"protected" gorm:"index:idx_protected"`
 	RefOnly       bool                 `json:"ref_only,omitempty" structs:"ref_only,omitempty" mapstructure:"ref_only,omitempty" gorm:"index:idx_ref_only"`
+	Codec         string               `json:"codec,omitempty" structs:"codec,omitempty" mapstructure:"codec,omitempty" gorm:"type:varchar(32)"`
+	Compression   string               `json:"compression,omitempty" structs:"compression,omitempty" mapstructure:"compression,omitempty" gorm:"type:varchar(16)"`
+	BlobDigest    string               `json:"blob_digest,omitempty" structs:"blob_digest,omitempty" mapstructure:"blob_digest,omitempty" gorm:"type:varchar(64)"`
+	BlobSize      int64                `json:"blob_size,omitempty" structs:"blob_size,omitempty" mapstructure:"blob_size,omitempty"`
 	Timestamp     int64                `json:"timestamp,omitempty" structs:"timestamp,omitempty" mapstructure:"timestamp,omitempty" gorm:"index:idx_timestamp"`
 	PrevHash      string               `json:"prev_hash,omitempty" structs:"prev_hash,omitempty" mapstructure:"prev_hash,omitempty" gorm:"type:varchar(64);unique_index:idx_prev_hash"`
 	PeerHash      string               `json:"peer_hash,omitempty" structs:"peer_hash,omitempty" mapstructure:"peer_hash,omitempty" gorm:"type:varchar(64)"`
@@ -63,12 +87,40 @@ func (o *Object) Init() *Object {
 	return o
 }
 
-// ComputeHash computes a SHA256 has of the object
+// EncodedValue returns the bytes the value was encoded to by its codec.
+// The value of an object without a codec is returned as is.
+func (o *Object) EncodedValue() ([]byte, error) {
+	if o.Codec == "" || o.Codec == CodecJSON {
+		return []byte(o.Value), nil
+	}
+	return base64.StdEncoding.DecodeString(o.Value)
+}
+
+// valueHash returns the hash of the value. From schema version 2, the hash
+// of a value encoded by a codec is taken over the codec id and the encoded bytes.
+func (o *Object) valueHash() (string, error) {
+	if o.Codec == "" || o.SchemaVersion == "1" {
+		return util.Sha256(o.Value), nil
+	}
+	bs, err := o.EncodedValue()
+	if err != nil {
+		return "", err
+	}
+	return util.Sha256(o.Codec + "/" + string(bs)), nil
+}
+
+// ComputeHash computes a SHA256 has of the object. The hash is left
+// empty if the value is not valid for its codec (see EncodedValue).
 func (o *Object) ComputeHash() *Object {
-	if o.SchemaVersion == "1" {
+	if IsSchemaVersion(o.SchemaVersion) {
+		valueHash, err := o.valueHash()
+		if err != nil {
+			o.Hash = ""
+			return o
+		}
 		o.Hash = util.Sha256(fmt.Sprintf("%s/%s/%s/%s/%s/%s/%v/%v/%d/%s/%s/%s/%s/%s/%s/%s/%s/%s/%s/%s/%s",
 			o.ID, o.OwnerID, o.CreatorID, o.PartitionID,
-			util.Sha256(o.Key), util.Sha256(o.Value),
+			util.Sha256(o.Key), valueHash,
 			o.Protected,
 			o.RefOnly,
 			o.Timestamp,
@@ -84,7 +136,7 @@ func (o *Object) ComputeHash() *Object {
 // ComputePeerHash computes the peer hash. A peer hash binds the current object
 // to the object next object.
 func (o *Object) ComputePeerHash(nextObjHash string) *Object {
-	if o.SchemaVersion == "1" {
+	if IsSchemaVersion(o.SchemaVersion) {
 		o.PeerHash = util.Sha256(fmt.Sprintf("%s/%s", o.Hash, nextObjHash))
 	}
 	return o
@@ -0,0 +1,33 @@
+package tables
+
+import "github.com/ellcrys/patchain"
+
+// ObjectState holds the latest object of a key of an owner. It is a
+// materialized view of the objects table maintained in the transaction of
+// every write, so the current value of a key can be read without scanning the
+// versions of the key. A deleted key keeps a state with Tombstone set.
+type ObjectState struct {
+	OwnerID  string `json:"owner_id,omitempty" structs:"owner_id,omitempty" mapstructure:"owner_id,omitempty" gorm:"type:varchar(36);primary_key"`
+	Key      string `json:"key,omitempty" structs:"key,omitempty" mapstructure:"key,omitempty" gorm:"type:varchar(64);primary_key"`
+	ObjectID string `json:"object_id,omitempty" structs:"object_id,omitempty" mapstructure:"object_id,omitempty" gorm:"type:varchar(36)"`
+	Hash     string `json:"hash,omitempty" structs:"hash,omitempty" mapstructure:"hash,omitempty" gorm:"type:varchar(64)"`
+	Value    string `json:"value,omitempty" structs:"value,omitempty" mapstructure:"value,omitempty" gorm:"type:varchar(64000)"`
+
+	// External is set if the value was too large to be copied (it was compressed
+	// or offloaded to the blob store) and must be read from the object
+	External    bool                 `json:"external,omitempty" structs:"external,omitempty" mapstructure:"external,omitempty"`
+	Protected   bool                 `json:"protected" structs:"protected" mapstructure:"protected"`
+	Tombstone   bool                 `json:"tombstone,omitempty" structs:"tombstone,omitempty" mapstructure:"tombstone,omitempty"`
+	Timestamp   int64                `json:"timestamp,omitempty" structs:"timestamp,omitempty" mapstructure:"timestamp,omitempty"`
+	QueryParams patchain.QueryParams `json:"-" structs:"-" mapstructure:"-" gorm:"-"`
+}
+
+// TableName returns the name of the table of the states
+func (s *ObjectState) TableName() string {
+	return "object_state"
+}
+
+// GetQueryParams returns the query parameters attached to the state
+func (s *ObjectState) GetQueryParams() *patchain.QueryParams {
+	return &s.QueryParams
+}
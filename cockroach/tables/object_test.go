@@ -46,6 +46,52 @@ func TestObject(t *testing.T) {
 				obj.ComputeHash()
 				So(obj.Hash, ShouldEqual, hash)
 			})
+
+			Convey("Should take the hash of a value with a codec over the codec and the encoded bytes", func() {
+				obj := Object{OwnerID: "owner_1", Value: "AQID", Codec: "gob"}
+				obj.Init().ComputeHash()
+				hash := obj.Hash
+
+				bs, err := obj.EncodedValue()
+				So(err, ShouldBeNil)
+				So(bs, ShouldResemble, []byte{1, 2, 3})
+
+				obj.Codec = "msgpack"
+				So(obj.ComputeHash().Hash, ShouldNotEqual, hash)
+			})
+
+			Convey("Should hash the value as stored in schema version 1", func() {
+				obj := Object{OwnerID: "owner_1", Value: "AQID", SchemaVersion: "1"}
+				hash := obj.Init().ComputeHash().Hash
+				obj.Codec = "gob"
+				So(obj.ComputeHash().Hash, ShouldEqual, hash)
+			})
+
+			Convey("Should leave the hash empty if the value is not valid for its codec", func() {
+				obj := Object{OwnerID: "owner_1", Value: "not base64!", Codec: "gob"}
+				So(obj.Init().ComputeHash().Hash, ShouldBeEmpty)
+			})
+
+			Convey("Should not include how the value is stored", func() {
+				obj := Object{OwnerID: "owner_1", Value: "abc"}
+				hash := obj.Init().ComputeHash().Hash
+				obj.Compression = "gzip"
+				obj.BlobDigest, obj.BlobSize = "digest", 10
+				So(obj.ComputeHash().Hash, ShouldEqual, hash)
+			})
+		})
+
+		Convey(".EncodedValue", func() {
+			Convey("Should return the value of objects without a codec or with the JSON codec as is", func() {
+				obj := Object{Value: `{"a":1}`}
+				bs, err := obj.EncodedValue()
+				So(err, ShouldBeNil)
+				So(string(bs), ShouldEqual, `{"a":1}`)
+				obj.Codec = CodecJSON
+				bs, err = obj.EncodedValue()
+				So(err, ShouldBeNil)
+				So(string(bs), ShouldEqual, `{"a":1}`)
+			})
 		})
 
 		Convey(".Compute", func() {
@@ -0,0 +1,18 @@
+package tables
+
+import "github.com/ellcrys/patchain"
+
+// OnceKey reserves an object key that must only ever be created once.
+// The unique index on the key column is what guarantees that two
+// concurrent callers of CreateOnce cannot both create the same key.
+type OnceKey struct {
+	ObjectID    string               `json:"object_id,omitempty" structs:"object_id,omitempty" mapstructure:"object_id,omitempty" gorm:"type:varchar(36);primary_key"`
+	Key         string               `json:"key,omitempty" structs:"key,omitempty" mapstructure:"key,omitempty" gorm:"type:varchar(64);unique_index:idx_once_key"`
+	Timestamp   int64                `json:"timestamp,omitempty" structs:"timestamp,omitempty" mapstructure:"timestamp,omitempty"`
+	QueryParams patchain.QueryParams `json:"-" structs:"-" mapstructure:"-" gorm:"-"`
+}
+
+// GetQueryParams returns the query parameters attached to the reservation
+func (k *OnceKey) GetQueryParams() *patchain.QueryParams {
+	return &k.QueryParams
+}
@@ -0,0 +1,49 @@
+package tables
+
+import (
+	"time"
+
+	"github.com/ellcrys/patchain"
+	"github.com/ellcrys/util"
+)
+
+// OutboxMessage is a message written in the transaction of a database
+// operation and relayed to an external system once the transaction commits
+type OutboxMessage struct {
+	ID      string `json:"id,omitempty" structs:"id,omitempty" mapstructure:"id,omitempty" gorm:"type:varchar(36);primary_key"`
+	Topic   string `json:"topic,omitempty" structs:"topic,omitempty" mapstructure:"topic,omitempty" gorm:"type:varchar(128)"`
+	Payload string `json:"payload,omitempty" structs:"payload,omitempty" mapstructure:"payload,omitempty" gorm:"type:text"`
+
+	// ObjectID is the id of the first object put in the same transaction
+	ObjectID  string `json:"object_id,omitempty" structs:"object_id,omitempty" mapstructure:"object_id,omitempty" gorm:"type:varchar(36)"`
+	Attempts  int    `json:"attempts,omitempty" structs:"attempts,omitempty" mapstructure:"attempts,omitempty"`
+	LastError string `json:"last_error,omitempty" structs:"last_error,omitempty" mapstructure:"last_error,omitempty" gorm:"type:text"`
+
+	// NextAttemptAt is the time (in nanoseconds) after which the next attempt can be made
+	NextAttemptAt int64 `json:"next_attempt_at,omitempty" structs:"next_attempt_at,omitempty" mapstructure:"next_attempt_at,omitempty" gorm:"index:idx_outbox_next_attempt"`
+
+	// SentAt is the time (in nanoseconds) the message was accepted by the sink
+	SentAt      int64                `json:"sent_at,omitempty" structs:"sent_at,omitempty" mapstructure:"sent_at,omitempty"`
+	Timestamp   int64                `json:"timestamp,omitempty" structs:"timestamp,omitempty" mapstructure:"timestamp,omitempty"`
+	QueryParams patchain.QueryParams `json:"-" structs:"-" mapstructure:"-" gorm:"-"`
+}
+
+// Init sets defaults values for specific fields
+// if they haven't been set.
+func (m *OutboxMessage) Init() *OutboxMessage {
+	if m.ID == "" {
+		m.ID = util.UUID4()
+	}
+	if m.Timestamp == 0 {
+		m.Timestamp = time.Now().UnixNano()
+	}
+	if m.NextAttemptAt == 0 {
+		m.NextAttemptAt = m.Timestamp
+	}
+	return m
+}
+
+// GetQueryParams returns the query parameters attached to the message
+func (m *OutboxMessage) GetQueryParams() *patchain.QueryParams {
+	return &m.QueryParams
+}
@@ -0,0 +1,23 @@
+package tables
+
+import "github.com/ellcrys/patchain"
+
+// OwnerUsage holds the resources consumed by an owner. It is
+// updated in the transaction of every write checked against a quota.
+type OwnerUsage struct {
+	OwnerID    string `json:"owner_id,omitempty" structs:"owner_id,omitempty" mapstructure:"owner_id,omitempty" gorm:"type:varchar(36);primary_key"`
+	Objects    int64  `json:"objects" structs:"objects" mapstructure:"objects"`
+	ValueBytes int64  `json:"value_bytes" structs:"value_bytes" mapstructure:"value_bytes"`
+	Partitions int64  `json:"partitions" structs:"partitions" mapstructure:"partitions"`
+
+	// WindowStart is the second (unix time) in which the writes counted by WindowWrites occurred
+	WindowStart  int64                `json:"window_start,omitempty" structs:"window_start,omitempty" mapstructure:"window_start,omitempty"`
+	WindowWrites int64                `json:"window_writes,omitempty" structs:"window_writes,omitempty" mapstructure:"window_writes,omitempty"`
+	Timestamp    int64                `json:"timestamp,omitempty" structs:"timestamp,omitempty" mapstructure:"timestamp,omitempty"`
+	QueryParams  patchain.QueryParams `json:"-" structs:"-" mapstructure:"-" gorm:"-"`
+}
+
+// GetQueryParams returns the query parameters attached to the usage
+func (u *OwnerUsage) GetQueryParams() *patchain.QueryParams {
+	return &u.QueryParams
+}
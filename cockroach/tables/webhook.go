@@ -0,0 +1,51 @@
+package tables
+
+import "github.com/ellcrys/patchain"
+
+// Webhook is an endpoint registered to receive chain events
+type Webhook struct {
+	ID      string `json:"id,omitempty" structs:"id,omitempty" mapstructure:"id,omitempty" gorm:"type:varchar(36);primary_key"`
+	OwnerID string `json:"owner_id,omitempty" structs:"owner_id,omitempty" mapstructure:"owner_id,omitempty" gorm:"type:varchar(36);index:idx_webhook_owner_id"`
+	URL     string `json:"url,omitempty" structs:"url,omitempty" mapstructure:"url,omitempty" gorm:"type:varchar(2048)"`
+
+	// Secret is the key used to sign the payloads sent to the endpoint
+	Secret string `json:"secret,omitempty" structs:"secret,omitempty" mapstructure:"secret,omitempty" gorm:"type:varchar(64)"`
+
+	// Events is a comma separated list of the event types sent to the endpoint.
+	// All event types are sent if it is empty.
+	Events string `json:"events,omitempty" structs:"events,omitempty" mapstructure:"events,omitempty" gorm:"type:varchar(256)"`
+
+	// KeyPrefix restricts object events to objects whose key starts with a prefix
+	KeyPrefix   string               `json:"key_prefix,omitempty" structs:"key_prefix,omitempty" mapstructure:"key_prefix,omitempty" gorm:"type:varchar(64)"`
+	Timestamp   int64                `json:"timestamp,omitempty" structs:"timestamp,omitempty" mapstructure:"timestamp,omitempty"`
+	QueryParams patchain.QueryParams `json:"-" structs:"-" mapstructure:"-" gorm:"-"`
+}
+
+// GetQueryParams returns the query parameters attached to the webhook
+func (w *Webhook) GetQueryParams() *patchain.QueryParams {
+	return &w.QueryParams
+}
+
+// WebhookDelivery is an event queued for delivery to a webhook. Deliveries
+// are kept after they are delivered or have failed too many times.
+type WebhookDelivery struct {
+	ID        string `json:"id,omitempty" structs:"id,omitempty" mapstructure:"id,omitempty" gorm:"type:varchar(36);primary_key"`
+	WebhookID string `json:"webhook_id,omitempty" structs:"webhook_id,omitempty" mapstructure:"webhook_id,omitempty" gorm:"type:varchar(36);index:idx_delivery_webhook_id"`
+	Event     string `json:"event,omitempty" structs:"event,omitempty" mapstructure:"event,omitempty" gorm:"type:varchar(32)"`
+	Payload   string `json:"payload,omitempty" structs:"payload,omitempty" mapstructure:"payload,omitempty" gorm:"type:text"`
+	Attempts  int    `json:"attempts,omitempty" structs:"attempts,omitempty" mapstructure:"attempts,omitempty"`
+
+	// NextAttemptAt is the time (in nanoseconds) after which the next attempt can be made
+	NextAttemptAt int64 `json:"next_attempt_at,omitempty" structs:"next_attempt_at,omitempty" mapstructure:"next_attempt_at,omitempty" gorm:"index:idx_delivery_next_attempt"`
+
+	// DeliveredAt is the time (in nanoseconds) the endpoint acknowledged the event
+	DeliveredAt int64                `json:"delivered_at,omitempty" structs:"delivered_at,omitempty" mapstructure:"delivered_at,omitempty"`
+	LastError   string               `json:"last_error,omitempty" structs:"last_error,omitempty" mapstructure:"last_error,omitempty" gorm:"type:text"`
+	Timestamp   int64                `json:"timestamp,omitempty" structs:"timestamp,omitempty" mapstructure:"timestamp,omitempty"`
+	QueryParams patchain.QueryParams `json:"-" structs:"-" mapstructure:"-" gorm:"-"`
+}
+
+// GetQueryParams returns the query parameters attached to the delivery
+func (d *WebhookDelivery) GetQueryParams() *patchain.QueryParams {
+	return &d.QueryParams
+}
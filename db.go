@@ -38,9 +38,19 @@ type DB interface {
 	// UpdatePeerHash updates the peer hash of an object
 	UpdatePeerHash(obj interface{}, newPeerHash string, options ...Option) error
 
+	// Update sets the given column values on all the objects that match the query
+	Update(q Query, values map[string]interface{}, options ...Option) error
+
+	// Delete deletes all the objects that match the query
+	Delete(q Query, options ...Option) error
+
 	// Count counts the number of objects in the patchain that matches a query
 	Count(q Query, out interface{}, options ...Option) error
 
+	// Aggregate selects aggregate expressions (e.g COUNT(*)) over the objects
+	// that match a query and scans the resulting row into out
+	Aggregate(q Query, sel Expr, out interface{}, options ...Option) error
+
 	// GetLast gets the last and most recent object that match the query
 	GetLast(q Query, out interface{}, options ...Option) error
 
@@ -78,6 +88,16 @@ type DB interface {
 	Close() error
 }
 
+// ChangeNotifier is implemented by databases that can notify about
+// objects as they are committed without being polled
+type ChangeNotifier interface {
+
+	// WatchObjects calls cb with the partition id of every object created or
+	// updated until stop is closed. It blocks until stop is closed or the
+	// watch fails.
+	WatchObjects(stop <-chan struct{}, cb func(partitionID string)) error
+}
+
 // QueryOption provides fields that can be used to
 // alter a query
 type QueryOption struct {
@@ -103,9 +123,11 @@ type Expr struct {
 	Args []interface{}
 }
 
-// QueryParams represents object query options
+// QueryParams represents object query options. Filter is an
+// expression applied in addition to the query object or Expr.
 type QueryParams struct {
 	Expr          Expr   `json:"-" structs:"-" mapstructure:"-" gorm:"-"`
+	Filter        Expr   `json:"-" structs:"-" mapstructure:"-" gorm:"-"`
 	KeyStartsWith string `json:"-" structs:"-" mapstructure:"-" gorm:"-"`
 	OrderBy       string `json:"-" structs:"-" mapstructure:"-" gorm:"-"`
 	Limit         int    `json:"-" structs:"-" mapstructure:"-" gorm:"-"`
@@ -0,0 +1,1036 @@
+// Code generated by protoc-gen-go.
+// source: patchain.proto
+// DO NOT EDIT!
+
+/*
+Package grpcapi is a generated protocol buffer package.
+
+It is generated from these files:
+
+	patchain.proto
+
+It has these top-level messages:
+
+	Object
+	PutRequest
+	PutResponse
+	PutStreamResponse
+	QueryRequest
+	CountResponse
+	CreatePartitionsRequest
+	PartitionsResponse
+	ListPartitionsRequest
+	PartitionInfo
+	ListPartitionsResponse
+	PartitionRequest
+	ProofRequest
+	Proof
+*/
+package grpcapi
+
+import proto "github.com/golang/protobuf/proto"
+import fmt "fmt"
+import math "math"
+
+import (
+	context "golang.org/x/net/context"
+	grpc "google.golang.org/grpc"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the proto package it is being compiled against.
+// A compilation error at this line likely means your copy of the
+// proto package needs to be updated.
+const _ = proto.ProtoPackageIsVersion2 // please upgrade the proto package
+
+// Object maps tables.Object
+type Object struct {
+	Id            string `protobuf:"bytes,1,opt,name=id" json:"id,omitempty"`
+	OwnerId       string `protobuf:"bytes,2,opt,name=owner_id,json=ownerId" json:"owner_id,omitempty"`
+	CreatorId     string `protobuf:"bytes,3,opt,name=creator_id,json=creatorId" json:"creator_id,omitempty"`
+	PartitionId   string `protobuf:"bytes,4,opt,name=partition_id,json=partitionId" json:"partition_id,omitempty"`
+	Key           string `protobuf:"bytes,5,opt,name=key" json:"key,omitempty"`
+	Value         string `protobuf:"bytes,6,opt,name=value" json:"value,omitempty"`
+	Protected     bool   `protobuf:"varint,7,opt,name=protected" json:"protected,omitempty"`
+	RefOnly       bool   `protobuf:"varint,8,opt,name=ref_only,json=refOnly" json:"ref_only,omitempty"`
+	Timestamp     int64  `protobuf:"varint,9,opt,name=timestamp" json:"timestamp,omitempty"`
+	PrevHash      string `protobuf:"bytes,10,opt,name=prev_hash,json=prevHash" json:"prev_hash,omitempty"`
+	PeerHash      string `protobuf:"bytes,11,opt,name=peer_hash,json=peerHash" json:"peer_hash,omitempty"`
+	Hash          string `protobuf:"bytes,12,opt,name=hash" json:"hash,omitempty"`
+	SchemaVersion string `protobuf:"bytes,13,opt,name=schema_version,json=schemaVersion" json:"schema_version,omitempty"`
+	Ref1          string `protobuf:"bytes,14,opt,name=ref1" json:"ref1,omitempty"`
+	Ref2          string `protobuf:"bytes,15,opt,name=ref2" json:"ref2,omitempty"`
+	Ref3          string `protobuf:"bytes,16,opt,name=ref3" json:"ref3,omitempty"`
+	Ref4          string `protobuf:"bytes,17,opt,name=ref4" json:"ref4,omitempty"`
+	Ref5          string `protobuf:"bytes,18,opt,name=ref5" json:"ref5,omitempty"`
+	Ref6          string `protobuf:"bytes,19,opt,name=ref6" json:"ref6,omitempty"`
+	Ref7          string `protobuf:"bytes,20,opt,name=ref7" json:"ref7,omitempty"`
+	Ref8          string `protobuf:"bytes,21,opt,name=ref8" json:"ref8,omitempty"`
+	Ref9          string `protobuf:"bytes,22,opt,name=ref9" json:"ref9,omitempty"`
+	Ref10         string `protobuf:"bytes,23,opt,name=ref10" json:"ref10,omitempty"`
+	Codec         string `protobuf:"bytes,24,opt,name=codec" json:"codec,omitempty"`
+	Compression   string `protobuf:"bytes,25,opt,name=compression" json:"compression,omitempty"`
+	BlobDigest    string `protobuf:"bytes,26,opt,name=blob_digest,json=blobDigest" json:"blob_digest,omitempty"`
+	BlobSize      int64  `protobuf:"varint,27,opt,name=blob_size,json=blobSize" json:"blob_size,omitempty"`
+}
+
+func (m *Object) Reset()                    { *m = Object{} }
+func (m *Object) String() string            { return proto.CompactTextString(m) }
+func (*Object) ProtoMessage()               {}
+func (*Object) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{0} }
+
+func (m *Object) GetId() string {
+	if m != nil {
+		return m.Id
+	}
+	return ""
+}
+
+func (m *Object) GetOwnerId() string {
+	if m != nil {
+		return m.OwnerId
+	}
+	return ""
+}
+
+func (m *Object) GetCreatorId() string {
+	if m != nil {
+		return m.CreatorId
+	}
+	return ""
+}
+
+func (m *Object) GetPartitionId() string {
+	if m != nil {
+		return m.PartitionId
+	}
+	return ""
+}
+
+func (m *Object) GetKey() string {
+	if m != nil {
+		return m.Key
+	}
+	return ""
+}
+
+func (m *Object) GetValue() string {
+	if m != nil {
+		return m.Value
+	}
+	return ""
+}
+
+func (m *Object) GetProtected() bool {
+	if m != nil {
+		return m.Protected
+	}
+	return false
+}
+
+func (m *Object) GetRefOnly() bool {
+	if m != nil {
+		return m.RefOnly
+	}
+	return false
+}
+
+func (m *Object) GetTimestamp() int64 {
+	if m != nil {
+		return m.Timestamp
+	}
+	return 0
+}
+
+func (m *Object) GetPrevHash() string {
+	if m != nil {
+		return m.PrevHash
+	}
+	return ""
+}
+
+func (m *Object) GetPeerHash() string {
+	if m != nil {
+		return m.PeerHash
+	}
+	return ""
+}
+
+func (m *Object) GetHash() string {
+	if m != nil {
+		return m.Hash
+	}
+	return ""
+}
+
+func (m *Object) GetSchemaVersion() string {
+	if m != nil {
+		return m.SchemaVersion
+	}
+	return ""
+}
+
+func (m *Object) GetRef1() string {
+	if m != nil {
+		return m.Ref1
+	}
+	return ""
+}
+
+func (m *Object) GetRef2() string {
+	if m != nil {
+		return m.Ref2
+	}
+	return ""
+}
+
+func (m *Object) GetRef3() string {
+	if m != nil {
+		return m.Ref3
+	}
+	return ""
+}
+
+func (m *Object) GetRef4() string {
+	if m != nil {
+		return m.Ref4
+	}
+	return ""
+}
+
+func (m *Object) GetRef5() string {
+	if m != nil {
+		return m.Ref5
+	}
+	return ""
+}
+
+func (m *Object) GetRef6() string {
+	if m != nil {
+		return m.Ref6
+	}
+	return ""
+}
+
+func (m *Object) GetRef7() string {
+	if m != nil {
+		return m.Ref7
+	}
+	return ""
+}
+
+func (m *Object) GetRef8() string {
+	if m != nil {
+		return m.Ref8
+	}
+	return ""
+}
+
+func (m *Object) GetRef9() string {
+	if m != nil {
+		return m.Ref9
+	}
+	return ""
+}
+
+func (m *Object) GetRef10() string {
+	if m != nil {
+		return m.Ref10
+	}
+	return ""
+}
+
+func (m *Object) GetCodec() string {
+	if m != nil {
+		return m.Codec
+	}
+	return ""
+}
+
+func (m *Object) GetCompression() string {
+	if m != nil {
+		return m.Compression
+	}
+	return ""
+}
+
+func (m *Object) GetBlobDigest() string {
+	if m != nil {
+		return m.BlobDigest
+	}
+	return ""
+}
+
+func (m *Object) GetBlobSize() int64 {
+	if m != nil {
+		return m.BlobSize
+	}
+	return 0
+}
+
+type PutRequest struct {
+	Objects []*Object `protobuf:"bytes,1,rep,name=objects" json:"objects,omitempty"`
+}
+
+func (m *PutRequest) Reset()                    { *m = PutRequest{} }
+func (m *PutRequest) String() string            { return proto.CompactTextString(m) }
+func (*PutRequest) ProtoMessage()               {}
+func (*PutRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{1} }
+
+func (m *PutRequest) GetObjects() []*Object {
+	if m != nil {
+		return m.Objects
+	}
+	return nil
+}
+
+type PutResponse struct {
+	Objects []*Object `protobuf:"bytes,1,rep,name=objects" json:"objects,omitempty"`
+}
+
+func (m *PutResponse) Reset()                    { *m = PutResponse{} }
+func (m *PutResponse) String() string            { return proto.CompactTextString(m) }
+func (*PutResponse) ProtoMessage()               {}
+func (*PutResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{2} }
+
+func (m *PutResponse) GetObjects() []*Object {
+	if m != nil {
+		return m.Objects
+	}
+	return nil
+}
+
+type PutStreamResponse struct {
+	NumObjects int64 `protobuf:"varint,1,opt,name=num_objects,json=numObjects" json:"num_objects,omitempty"`
+}
+
+func (m *PutStreamResponse) Reset()                    { *m = PutStreamResponse{} }
+func (m *PutStreamResponse) String() string            { return proto.CompactTextString(m) }
+func (*PutStreamResponse) ProtoMessage()               {}
+func (*PutStreamResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{3} }
+
+func (m *PutStreamResponse) GetNumObjects() int64 {
+	if m != nil {
+		return m.NumObjects
+	}
+	return 0
+}
+
+type QueryRequest struct {
+	// JSQ query. See http://github.com/ncodes/jsq
+	Query   string `protobuf:"bytes,1,opt,name=query" json:"query,omitempty"`
+	OrderBy string `protobuf:"bytes,2,opt,name=order_by,json=orderBy" json:"order_by,omitempty"`
+	Limit   int32  `protobuf:"varint,3,opt,name=limit" json:"limit,omitempty"`
+}
+
+func (m *QueryRequest) Reset()                    { *m = QueryRequest{} }
+func (m *QueryRequest) String() string            { return proto.CompactTextString(m) }
+func (*QueryRequest) ProtoMessage()               {}
+func (*QueryRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{4} }
+
+func (m *QueryRequest) GetQuery() string {
+	if m != nil {
+		return m.Query
+	}
+	return ""
+}
+
+func (m *QueryRequest) GetOrderBy() string {
+	if m != nil {
+		return m.OrderBy
+	}
+	return ""
+}
+
+func (m *QueryRequest) GetLimit() int32 {
+	if m != nil {
+		return m.Limit
+	}
+	return 0
+}
+
+type CountResponse struct {
+	Count int64 `protobuf:"varint,1,opt,name=count" json:"count,omitempty"`
+}
+
+func (m *CountResponse) Reset()                    { *m = CountResponse{} }
+func (m *CountResponse) String() string            { return proto.CompactTextString(m) }
+func (*CountResponse) ProtoMessage()               {}
+func (*CountResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{5} }
+
+func (m *CountResponse) GetCount() int64 {
+	if m != nil {
+		return m.Count
+	}
+	return 0
+}
+
+type CreatePartitionsRequest struct {
+	OwnerId   string `protobuf:"bytes,1,opt,name=owner_id,json=ownerId" json:"owner_id,omitempty"`
+	CreatorId string `protobuf:"bytes,2,opt,name=creator_id,json=creatorId" json:"creator_id,omitempty"`
+	N         int64  `protobuf:"varint,3,opt,name=n" json:"n,omitempty"`
+}
+
+func (m *CreatePartitionsRequest) Reset()                    { *m = CreatePartitionsRequest{} }
+func (m *CreatePartitionsRequest) String() string            { return proto.CompactTextString(m) }
+func (*CreatePartitionsRequest) ProtoMessage()               {}
+func (*CreatePartitionsRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{6} }
+
+func (m *CreatePartitionsRequest) GetOwnerId() string {
+	if m != nil {
+		return m.OwnerId
+	}
+	return ""
+}
+
+func (m *CreatePartitionsRequest) GetCreatorId() string {
+	if m != nil {
+		return m.CreatorId
+	}
+	return ""
+}
+
+func (m *CreatePartitionsRequest) GetN() int64 {
+	if m != nil {
+		return m.N
+	}
+	return 0
+}
+
+type PartitionsResponse struct {
+	Partitions []*Object `protobuf:"bytes,1,rep,name=partitions" json:"partitions,omitempty"`
+}
+
+func (m *PartitionsResponse) Reset()                    { *m = PartitionsResponse{} }
+func (m *PartitionsResponse) String() string            { return proto.CompactTextString(m) }
+func (*PartitionsResponse) ProtoMessage()               {}
+func (*PartitionsResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{7} }
+
+func (m *PartitionsResponse) GetPartitions() []*Object {
+	if m != nil {
+		return m.Partitions
+	}
+	return nil
+}
+
+type ListPartitionsRequest struct {
+	OwnerId string `protobuf:"bytes,1,opt,name=owner_id,json=ownerId" json:"owner_id,omitempty"`
+}
+
+func (m *ListPartitionsRequest) Reset()                    { *m = ListPartitionsRequest{} }
+func (m *ListPartitionsRequest) String() string            { return proto.CompactTextString(m) }
+func (*ListPartitionsRequest) ProtoMessage()               {}
+func (*ListPartitionsRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{8} }
+
+func (m *ListPartitionsRequest) GetOwnerId() string {
+	if m != nil {
+		return m.OwnerId
+	}
+	return ""
+}
+
+type PartitionInfo struct {
+	Partition *Object `protobuf:"bytes,1,opt,name=partition" json:"partition,omitempty"`
+	State     string  `protobuf:"bytes,2,opt,name=state" json:"state,omitempty"`
+}
+
+func (m *PartitionInfo) Reset()                    { *m = PartitionInfo{} }
+func (m *PartitionInfo) String() string            { return proto.CompactTextString(m) }
+func (*PartitionInfo) ProtoMessage()               {}
+func (*PartitionInfo) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{9} }
+
+func (m *PartitionInfo) GetPartition() *Object {
+	if m != nil {
+		return m.Partition
+	}
+	return nil
+}
+
+func (m *PartitionInfo) GetState() string {
+	if m != nil {
+		return m.State
+	}
+	return ""
+}
+
+type ListPartitionsResponse struct {
+	Partitions []*PartitionInfo `protobuf:"bytes,1,rep,name=partitions" json:"partitions,omitempty"`
+}
+
+func (m *ListPartitionsResponse) Reset()                    { *m = ListPartitionsResponse{} }
+func (m *ListPartitionsResponse) String() string            { return proto.CompactTextString(m) }
+func (*ListPartitionsResponse) ProtoMessage()               {}
+func (*ListPartitionsResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{10} }
+
+func (m *ListPartitionsResponse) GetPartitions() []*PartitionInfo {
+	if m != nil {
+		return m.Partitions
+	}
+	return nil
+}
+
+type PartitionRequest struct {
+	PartitionId string `protobuf:"bytes,1,opt,name=partition_id,json=partitionId" json:"partition_id,omitempty"`
+}
+
+func (m *PartitionRequest) Reset()                    { *m = PartitionRequest{} }
+func (m *PartitionRequest) String() string            { return proto.CompactTextString(m) }
+func (*PartitionRequest) ProtoMessage()               {}
+func (*PartitionRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{11} }
+
+func (m *PartitionRequest) GetPartitionId() string {
+	if m != nil {
+		return m.PartitionId
+	}
+	return ""
+}
+
+type ProofRequest struct {
+	ObjectId string `protobuf:"bytes,1,opt,name=object_id,json=objectId" json:"object_id,omitempty"`
+}
+
+func (m *ProofRequest) Reset()                    { *m = ProofRequest{} }
+func (m *ProofRequest) String() string            { return proto.CompactTextString(m) }
+func (*ProofRequest) ProtoMessage()               {}
+func (*ProofRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{12} }
+
+func (m *ProofRequest) GetObjectId() string {
+	if m != nil {
+		return m.ObjectId
+	}
+	return ""
+}
+
+// Proof maps object.Proof
+type Proof struct {
+	Partition *Object   `protobuf:"bytes,1,opt,name=partition" json:"partition,omitempty"`
+	Objects   []*Object `protobuf:"bytes,2,rep,name=objects" json:"objects,omitempty"`
+	Index     int32     `protobuf:"varint,3,opt,name=index" json:"index,omitempty"`
+}
+
+func (m *Proof) Reset()                    { *m = Proof{} }
+func (m *Proof) String() string            { return proto.CompactTextString(m) }
+func (*Proof) ProtoMessage()               {}
+func (*Proof) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{13} }
+
+func (m *Proof) GetPartition() *Object {
+	if m != nil {
+		return m.Partition
+	}
+	return nil
+}
+
+func (m *Proof) GetObjects() []*Object {
+	if m != nil {
+		return m.Objects
+	}
+	return nil
+}
+
+func (m *Proof) GetIndex() int32 {
+	if m != nil {
+		return m.Index
+	}
+	return 0
+}
+
+func init() {
+	proto.RegisterType((*Object)(nil), "patchain.Object")
+	proto.RegisterType((*PutRequest)(nil), "patchain.PutRequest")
+	proto.RegisterType((*PutResponse)(nil), "patchain.PutResponse")
+	proto.RegisterType((*PutStreamResponse)(nil), "patchain.PutStreamResponse")
+	proto.RegisterType((*QueryRequest)(nil), "patchain.QueryRequest")
+	proto.RegisterType((*CountResponse)(nil), "patchain.CountResponse")
+	proto.RegisterType((*CreatePartitionsRequest)(nil), "patchain.CreatePartitionsRequest")
+	proto.RegisterType((*PartitionsResponse)(nil), "patchain.PartitionsResponse")
+	proto.RegisterType((*ListPartitionsRequest)(nil), "patchain.ListPartitionsRequest")
+	proto.RegisterType((*PartitionInfo)(nil), "patchain.PartitionInfo")
+	proto.RegisterType((*ListPartitionsResponse)(nil), "patchain.ListPartitionsResponse")
+	proto.RegisterType((*PartitionRequest)(nil), "patchain.PartitionRequest")
+	proto.RegisterType((*ProofRequest)(nil), "patchain.ProofRequest")
+	proto.RegisterType((*Proof)(nil), "patchain.Proof")
+}
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ context.Context
+var _ grpc.ClientConn
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+const _ = grpc.SupportPackageIsVersion4
+
+// Client API for Patchain service
+
+type PatchainClient interface {
+	// Put adds objects of the same owner to a partition of the owner
+	Put(ctx context.Context, in *PutRequest, opts ...grpc.CallOption) (*PutResponse, error)
+	// PutStream adds the objects of every message received. Every message
+	// is added in its own transaction and may belong to a different owner.
+	PutStream(ctx context.Context, opts ...grpc.CallOption) (Patchain_PutStreamClient, error)
+	// Query streams the objects matching a JSQ query
+	Query(ctx context.Context, in *QueryRequest, opts ...grpc.CallOption) (Patchain_QueryClient, error)
+	// GetLast returns the most recent object matching a JSQ query
+	GetLast(ctx context.Context, in *QueryRequest, opts ...grpc.CallOption) (*Object, error)
+	// Count counts the objects matching a JSQ query
+	Count(ctx context.Context, in *QueryRequest, opts ...grpc.CallOption) (*CountResponse, error)
+	// CreatePartitions creates partitions for an owner
+	CreatePartitions(ctx context.Context, in *CreatePartitionsRequest, opts ...grpc.CallOption) (*PartitionsResponse, error)
+	// ListPartitions lists the partitions of an owner and their state
+	ListPartitions(ctx context.Context, in *ListPartitionsRequest, opts ...grpc.CallOption) (*ListPartitionsResponse, error)
+	// SealPartition seals a partition and returns its seal object
+	SealPartition(ctx context.Context, in *PartitionRequest, opts ...grpc.CallOption) (*Object, error)
+	// GetProof returns a proof that an object is part of its partition
+	GetProof(ctx context.Context, in *ProofRequest, opts ...grpc.CallOption) (*Proof, error)
+}
+
+type patchainClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewPatchainClient(cc *grpc.ClientConn) PatchainClient {
+	return &patchainClient{cc}
+}
+
+func (c *patchainClient) Put(ctx context.Context, in *PutRequest, opts ...grpc.CallOption) (*PutResponse, error) {
+	out := new(PutResponse)
+	err := grpc.Invoke(ctx, "/patchain.Patchain/Put", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *patchainClient) PutStream(ctx context.Context, opts ...grpc.CallOption) (Patchain_PutStreamClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_Patchain_serviceDesc.Streams[0], c.cc, "/patchain.Patchain/PutStream", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &patchainPutStreamClient{stream}
+	return x, nil
+}
+
+type Patchain_PutStreamClient interface {
+	Send(*PutRequest) error
+	CloseAndRecv() (*PutStreamResponse, error)
+	grpc.ClientStream
+}
+
+type patchainPutStreamClient struct {
+	grpc.ClientStream
+}
+
+func (x *patchainPutStreamClient) Send(m *PutRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *patchainPutStreamClient) CloseAndRecv() (*PutStreamResponse, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(PutStreamResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *patchainClient) Query(ctx context.Context, in *QueryRequest, opts ...grpc.CallOption) (Patchain_QueryClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_Patchain_serviceDesc.Streams[1], c.cc, "/patchain.Patchain/Query", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &patchainQueryClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Patchain_QueryClient interface {
+	Recv() (*Object, error)
+	grpc.ClientStream
+}
+
+type patchainQueryClient struct {
+	grpc.ClientStream
+}
+
+func (x *patchainQueryClient) Recv() (*Object, error) {
+	m := new(Object)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *patchainClient) GetLast(ctx context.Context, in *QueryRequest, opts ...grpc.CallOption) (*Object, error) {
+	out := new(Object)
+	err := grpc.Invoke(ctx, "/patchain.Patchain/GetLast", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *patchainClient) Count(ctx context.Context, in *QueryRequest, opts ...grpc.CallOption) (*CountResponse, error) {
+	out := new(CountResponse)
+	err := grpc.Invoke(ctx, "/patchain.Patchain/Count", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *patchainClient) CreatePartitions(ctx context.Context, in *CreatePartitionsRequest, opts ...grpc.CallOption) (*PartitionsResponse, error) {
+	out := new(PartitionsResponse)
+	err := grpc.Invoke(ctx, "/patchain.Patchain/CreatePartitions", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *patchainClient) ListPartitions(ctx context.Context, in *ListPartitionsRequest, opts ...grpc.CallOption) (*ListPartitionsResponse, error) {
+	out := new(ListPartitionsResponse)
+	err := grpc.Invoke(ctx, "/patchain.Patchain/ListPartitions", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *patchainClient) SealPartition(ctx context.Context, in *PartitionRequest, opts ...grpc.CallOption) (*Object, error) {
+	out := new(Object)
+	err := grpc.Invoke(ctx, "/patchain.Patchain/SealPartition", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *patchainClient) GetProof(ctx context.Context, in *ProofRequest, opts ...grpc.CallOption) (*Proof, error) {
+	out := new(Proof)
+	err := grpc.Invoke(ctx, "/patchain.Patchain/GetProof", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Server API for Patchain service
+
+type PatchainServer interface {
+	// Put adds objects of the same owner to a partition of the owner
+	Put(context.Context, *PutRequest) (*PutResponse, error)
+	// PutStream adds the objects of every message received. Every message
+	// is added in its own transaction and may belong to a different owner.
+	PutStream(Patchain_PutStreamServer) error
+	// Query streams the objects matching a JSQ query
+	Query(*QueryRequest, Patchain_QueryServer) error
+	// GetLast returns the most recent object matching a JSQ query
+	GetLast(context.Context, *QueryRequest) (*Object, error)
+	// Count counts the objects matching a JSQ query
+	Count(context.Context, *QueryRequest) (*CountResponse, error)
+	// CreatePartitions creates partitions for an owner
+	CreatePartitions(context.Context, *CreatePartitionsRequest) (*PartitionsResponse, error)
+	// ListPartitions lists the partitions of an owner and their state
+	ListPartitions(context.Context, *ListPartitionsRequest) (*ListPartitionsResponse, error)
+	// SealPartition seals a partition and returns its seal object
+	SealPartition(context.Context, *PartitionRequest) (*Object, error)
+	// GetProof returns a proof that an object is part of its partition
+	GetProof(context.Context, *ProofRequest) (*Proof, error)
+}
+
+func RegisterPatchainServer(s *grpc.Server, srv PatchainServer) {
+	s.RegisterService(&_Patchain_serviceDesc, srv)
+}
+
+func _Patchain_Put_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PutRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PatchainServer).Put(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/patchain.Patchain/Put",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PatchainServer).Put(ctx, req.(*PutRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Patchain_PutStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(PatchainServer).PutStream(&patchainPutStreamServer{stream})
+}
+
+type Patchain_PutStreamServer interface {
+	SendAndClose(*PutStreamResponse) error
+	Recv() (*PutRequest, error)
+	grpc.ServerStream
+}
+
+type patchainPutStreamServer struct {
+	grpc.ServerStream
+}
+
+func (x *patchainPutStreamServer) SendAndClose(m *PutStreamResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *patchainPutStreamServer) Recv() (*PutRequest, error) {
+	m := new(PutRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _Patchain_Query_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(QueryRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(PatchainServer).Query(m, &patchainQueryServer{stream})
+}
+
+type Patchain_QueryServer interface {
+	Send(*Object) error
+	grpc.ServerStream
+}
+
+type patchainQueryServer struct {
+	grpc.ServerStream
+}
+
+func (x *patchainQueryServer) Send(m *Object) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Patchain_GetLast_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(QueryRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PatchainServer).GetLast(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/patchain.Patchain/GetLast",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PatchainServer).GetLast(ctx, req.(*QueryRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Patchain_Count_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(QueryRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PatchainServer).Count(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/patchain.Patchain/Count",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PatchainServer).Count(ctx, req.(*QueryRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Patchain_CreatePartitions_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreatePartitionsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PatchainServer).CreatePartitions(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/patchain.Patchain/CreatePartitions",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PatchainServer).CreatePartitions(ctx, req.(*CreatePartitionsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Patchain_ListPartitions_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListPartitionsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PatchainServer).ListPartitions(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/patchain.Patchain/ListPartitions",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PatchainServer).ListPartitions(ctx, req.(*ListPartitionsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Patchain_SealPartition_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PartitionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PatchainServer).SealPartition(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/patchain.Patchain/SealPartition",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PatchainServer).SealPartition(ctx, req.(*PartitionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Patchain_GetProof_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ProofRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PatchainServer).GetProof(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/patchain.Patchain/GetProof",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PatchainServer).GetProof(ctx, req.(*ProofRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+var _Patchain_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "patchain.Patchain",
+	HandlerType: (*PatchainServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Put",
+			Handler:    _Patchain_Put_Handler,
+		},
+		{
+			MethodName: "GetLast",
+			Handler:    _Patchain_GetLast_Handler,
+		},
+		{
+			MethodName: "Count",
+			Handler:    _Patchain_Count_Handler,
+		},
+		{
+			MethodName: "CreatePartitions",
+			Handler:    _Patchain_CreatePartitions_Handler,
+		},
+		{
+			MethodName: "ListPartitions",
+			Handler:    _Patchain_ListPartitions_Handler,
+		},
+		{
+			MethodName: "SealPartition",
+			Handler:    _Patchain_SealPartition_Handler,
+		},
+		{
+			MethodName: "GetProof",
+			Handler:    _Patchain_GetProof_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "PutStream",
+			Handler:       _Patchain_PutStream_Handler,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "Query",
+			Handler:       _Patchain_Query_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "patchain.proto",
+}
+
+func init() { proto.RegisterFile("patchain.proto", fileDescriptor0) }
+
+var fileDescriptor0 = []byte{
+	// 872 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x94, 0x96, 0x6d, 0x6f, 0xe2, 0x46,
+	0x10, 0xc7, 0x63, 0x08, 0xc1, 0x0c, 0x0f, 0xc7, 0x6d, 0xf3, 0xb0, 0x47, 0xae, 0x0a, 0x67, 0xe9,
+	0x24, 0xd4, 0x4a, 0x51, 0x4a, 0xc2, 0x25, 0xe9, 0x9b, 0x4a, 0xb9, 0xaa, 0x57, 0xa4, 0x93, 0xc2,
+	0x11, 0xa5, 0x2f, 0xfa, 0x06, 0x19, 0x7b, 0x38, 0xdc, 0x82, 0xcd, 0xd9, 0xeb, 0xb4, 0xdc, 0x37,
+	0xea, 0xf7, 0xe9, 0x07, 0xaa, 0x76, 0x76, 0x59, 0x1b, 0x8e, 0x5c, 0x95, 0x77, 0x9e, 0xdf, 0xfc,
+	0x67, 0x3d, 0x3b, 0x9e, 0x3f, 0x02, 0x1a, 0x0b, 0x57, 0x78, 0x53, 0x37, 0x08, 0x4f, 0x17, 0x71,
+	0x24, 0x22, 0x66, 0xaf, 0x62, 0xe7, 0x9f, 0x12, 0xec, 0xdd, 0x8e, 0xff, 0x40, 0x4f, 0xb0, 0x06,
+	0x14, 0x02, 0x9f, 0x5b, 0x6d, 0xab, 0x53, 0x19, 0x16, 0x02, 0x9f, 0xbd, 0x00, 0x3b, 0xfa, 0x2b,
+	0xc4, 0x78, 0x14, 0xf8, 0xbc, 0x40, 0xb4, 0x4c, 0x71, 0xdf, 0x67, 0xdf, 0x02, 0x78, 0x31, 0xba,
+	0x22, 0xa2, 0x64, 0x91, 0x92, 0x15, 0x4d, 0xfa, 0x3e, 0x7b, 0x05, 0xb5, 0x85, 0x1b, 0x8b, 0x40,
+	0x04, 0x51, 0x28, 0x05, 0xbb, 0x24, 0xa8, 0x1a, 0xd6, 0xf7, 0x59, 0x13, 0x8a, 0x7f, 0xe2, 0x92,
+	0x97, 0x28, 0x23, 0x1f, 0xd9, 0x3e, 0x94, 0x1e, 0xdc, 0x59, 0x8a, 0x7c, 0x8f, 0x98, 0x0a, 0xd8,
+	0x4b, 0xa8, 0xc8, 0x96, 0xd1, 0x13, 0xe8, 0xf3, 0x72, 0xdb, 0xea, 0xd8, 0xc3, 0x0c, 0xc8, 0x16,
+	0x63, 0x9c, 0x8c, 0xa2, 0x70, 0xb6, 0xe4, 0x36, 0x25, 0xcb, 0x31, 0x4e, 0x6e, 0xc3, 0xd9, 0x52,
+	0x16, 0x8a, 0x60, 0x8e, 0x89, 0x70, 0xe7, 0x0b, 0x5e, 0x69, 0x5b, 0x9d, 0xe2, 0x30, 0x03, 0xec,
+	0x58, 0x1e, 0x8b, 0x0f, 0xa3, 0xa9, 0x9b, 0x4c, 0x39, 0xd0, 0x0b, 0x6d, 0x09, 0x7e, 0x75, 0x93,
+	0x29, 0x25, 0x11, 0x63, 0x95, 0xac, 0xea, 0x24, 0x62, 0x4c, 0x49, 0x06, 0xbb, 0xc4, 0x6b, 0xc4,
+	0xe9, 0x99, 0xbd, 0x86, 0x46, 0xe2, 0x4d, 0x71, 0xee, 0x8e, 0x1e, 0x30, 0x4e, 0x82, 0x28, 0xe4,
+	0x75, 0xca, 0xd6, 0x15, 0xfd, 0x4d, 0x41, 0x59, 0x1a, 0xe3, 0xe4, 0x07, 0xde, 0x50, 0xa5, 0xf2,
+	0x59, 0xb3, 0x2e, 0x7f, 0x66, 0x58, 0x57, 0xb3, 0x73, 0xde, 0x34, 0xec, 0x5c, 0xb3, 0x0b, 0xfe,
+	0xdc, 0xb0, 0x0b, 0xcd, 0x7a, 0x9c, 0x19, 0xd6, 0xd3, 0xec, 0x0d, 0xff, 0xc6, 0xb0, 0x37, 0x9a,
+	0x5d, 0xf2, 0x7d, 0xc3, 0x2e, 0x35, 0xbb, 0xe2, 0x07, 0x86, 0x5d, 0x69, 0x76, 0xcd, 0x0f, 0x0d,
+	0xbb, 0x96, 0x5f, 0x45, 0xf6, 0x79, 0xc6, 0x8f, 0xd4, 0x57, 0xa1, 0x40, 0x52, 0x2f, 0xf2, 0xd1,
+	0xe3, 0x5c, 0x51, 0x0a, 0x58, 0x1b, 0xaa, 0x5e, 0x34, 0x5f, 0xc4, 0x98, 0xd0, 0x0c, 0x5e, 0xa8,
+	0xaf, 0x9e, 0x43, 0xec, 0x04, 0xaa, 0xe3, 0x59, 0x34, 0x1e, 0xf9, 0xc1, 0x47, 0x4c, 0x04, 0x6f,
+	0x91, 0x02, 0x24, 0xfa, 0x99, 0x88, 0x1c, 0x3d, 0x09, 0x92, 0xe0, 0x33, 0xf2, 0x63, 0xfa, 0x6a,
+	0xb6, 0x04, 0x77, 0xc1, 0x67, 0x74, 0xae, 0x00, 0x06, 0xa9, 0x18, 0xe2, 0xa7, 0x54, 0x4a, 0xbf,
+	0x83, 0x72, 0x44, 0x8b, 0x9b, 0x70, 0xab, 0x5d, 0xec, 0x54, 0xbb, 0xcd, 0x53, 0xb3, 0xe5, 0x6a,
+	0xa3, 0x87, 0x2b, 0x81, 0x73, 0x0d, 0x55, 0xaa, 0x4c, 0x16, 0x51, 0x98, 0xe0, 0x93, 0x4a, 0x2f,
+	0xe0, 0xf9, 0x20, 0x15, 0x77, 0x22, 0x46, 0x77, 0x6e, 0x0e, 0x38, 0x81, 0x6a, 0x98, 0xce, 0x47,
+	0xd9, 0x21, 0xb2, 0x51, 0x08, 0xd3, 0xf9, 0xad, 0xae, 0xba, 0x87, 0xda, 0x87, 0x14, 0xe3, 0xe5,
+	0xaa, 0xd9, 0x7d, 0x28, 0x7d, 0x92, 0xb1, 0xb6, 0x97, 0x0a, 0xc8, 0x61, 0xb1, 0x8f, 0xf1, 0x68,
+	0xbc, 0x34, 0x0e, 0x93, 0xf1, 0x0d, 0xb9, 0x61, 0x16, 0xcc, 0x03, 0x41, 0xe6, 0x2a, 0x0d, 0x55,
+	0xe0, 0xbc, 0x86, 0xfa, 0xdb, 0x28, 0x0d, 0xb3, 0x9b, 0xd0, 0x87, 0x48, 0x43, 0xa1, 0x5b, 0x50,
+	0x81, 0xe3, 0xc2, 0xd1, 0x5b, 0x69, 0x46, 0x1c, 0xac, 0x1c, 0x97, 0xac, 0x1a, 0xc9, 0x9b, 0xda,
+	0xfa, 0x9a, 0xa9, 0x0b, 0x9b, 0xa6, 0xae, 0x81, 0x15, 0x52, 0x37, 0xc5, 0xa1, 0x15, 0x3a, 0xbf,
+	0x00, 0xcb, 0x1f, 0xae, 0xdb, 0x39, 0x03, 0x30, 0x26, 0x7f, 0x7c, 0xb6, 0x39, 0x8d, 0xd3, 0x85,
+	0x83, 0xf7, 0x41, 0x22, 0x9e, 0xd2, 0xa8, 0x73, 0x0f, 0x75, 0xa3, 0xef, 0x87, 0x93, 0x88, 0x9d,
+	0x42, 0xc5, 0x1c, 0x49, 0xe2, 0x6d, 0x6f, 0xcd, 0x24, 0x72, 0x6a, 0x89, 0x70, 0x05, 0xea, 0x4b,
+	0xaa, 0xc0, 0xf9, 0x00, 0x87, 0x9b, 0xad, 0xe8, 0x6b, 0x5d, 0x6e, 0xb9, 0xd6, 0x51, 0xf6, 0x82,
+	0xb5, 0x66, 0xd6, 0x6e, 0xd7, 0x83, 0xa6, 0x49, 0xae, 0x2e, 0xb6, 0xf9, 0xe3, 0x68, 0x7d, 0xf1,
+	0xe3, 0xe8, 0x7c, 0x0f, 0xb5, 0x41, 0x1c, 0x45, 0x93, 0x55, 0xc9, 0x31, 0x54, 0xd4, 0xaa, 0x65,
+	0x7a, 0x5b, 0x81, 0xbe, 0xef, 0x2c, 0xa1, 0x44, 0xe2, 0x27, 0x4f, 0x21, 0xe7, 0x82, 0xc2, 0xff,
+	0xb8, 0x40, 0x4e, 0x2c, 0x08, 0x7d, 0xfc, 0x7b, 0xb5, 0x8e, 0x14, 0x74, 0xff, 0xdd, 0x05, 0x7b,
+	0xa0, 0x4b, 0xd8, 0x05, 0x14, 0x07, 0xa9, 0x60, 0xfb, 0xb9, 0xb9, 0x18, 0xb3, 0xb6, 0x0e, 0x36,
+	0xa8, 0x1a, 0xac, 0xb3, 0xc3, 0x6e, 0xa0, 0x62, 0xec, 0xf5, 0x48, 0xed, 0xf1, 0x1a, 0x5d, 0x77,
+	0xa2, 0xb3, 0xd3, 0xb1, 0x58, 0x0f, 0x4a, 0x64, 0x36, 0x76, 0x98, 0x29, 0xf3, 0xee, 0x6b, 0x7d,
+	0x71, 0x31, 0x67, 0xe7, 0x4c, 0x96, 0x95, 0xdf, 0xa1, 0x78, 0xef, 0x26, 0xe2, 0x29, 0x85, 0xec,
+	0x47, 0x28, 0x91, 0x07, 0x1f, 0x2d, 0xca, 0x6d, 0xc6, 0x9a, 0x59, 0x9d, 0x1d, 0x76, 0x0f, 0xcd,
+	0x4d, 0x63, 0xb2, 0x57, 0x39, 0xf9, 0x76, 0xd3, 0xb6, 0x5e, 0x6e, 0xd9, 0xb5, 0x64, 0xed, 0xd8,
+	0xc6, 0xfa, 0xe6, 0xb2, 0x93, 0xac, 0x62, 0xab, 0xbd, 0x5a, 0xed, 0xc7, 0x05, 0xe6, 0xd8, 0x9f,
+	0xa0, 0x7e, 0x87, 0xee, 0xcc, 0xe4, 0x58, 0x6b, 0x4b, 0x1f, 0x5f, 0x1b, 0x55, 0x0f, 0xec, 0x77,
+	0x28, 0xd4, 0x76, 0xe6, 0xa6, 0x95, 0xdf, 0xed, 0xd6, 0xb3, 0x0d, 0xee, 0xec, 0xdc, 0x54, 0x7e,
+	0x2f, 0x7f, 0x8c, 0x17, 0x9e, 0xbb, 0x08, 0xc6, 0x7b, 0xf4, 0x7f, 0xe5, 0xfc, 0xbf, 0x01, 0x00,
+	0x63, 0xc5, 0xd0, 0x4b, 0xc1, 0x08, 0x00, 0x00,
+}
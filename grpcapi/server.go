@@ -0,0 +1,333 @@
+package grpcapi
+
+//go:generate protoc --go_out=plugins=grpc:. patchain.proto
+
+import (
+	"io"
+
+	"github.com/ellcrys/patchain"
+	"github.com/ellcrys/patchain/cockroach/tables"
+	"github.com/ellcrys/patchain/object"
+	"github.com/op/go-logging"
+	"github.com/pkg/errors"
+	context "golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// Server implements PatchainServer
+type Server struct {
+	db  patchain.DB
+	obj *object.Object
+	log *logging.Logger
+}
+
+// NewServer creates a server backed by a database
+func NewServer(db patchain.DB) *Server {
+	s := &Server{db: db, obj: object.NewObject(db)}
+	s.log, _ = logging.GetLogger("patchain/grpcapi")
+	return s
+}
+
+// Object returns the object handler used by the server
+func (s *Server) Object() *object.Object {
+	return s.obj
+}
+
+// Register registers the service with a gRPC server
+func (s *Server) Register(gs *grpc.Server) {
+	RegisterPatchainServer(gs, s)
+}
+
+// ToObject converts a tables.Object to an Object
+func ToObject(o *tables.Object) *Object {
+	return &Object{
+		Id:            o.ID,
+		OwnerId:       o.OwnerID,
+		CreatorId:     o.CreatorID,
+		PartitionId:   o.PartitionID,
+		Key:           o.Key,
+		Value:         o.Value,
+		Protected:     o.Protected,
+		RefOnly:       o.RefOnly,
+		Timestamp:     o.Timestamp,
+		PrevHash:      o.PrevHash,
+		PeerHash:      o.PeerHash,
+		Hash:          o.Hash,
+		SchemaVersion: o.SchemaVersion,
+		Ref1:          o.Ref1,
+		Ref2:          o.Ref2,
+		Ref3:          o.Ref3,
+		Ref4:          o.Ref4,
+		Ref5:          o.Ref5,
+		Ref6:          o.Ref6,
+		Ref7:          o.Ref7,
+		Ref8:          o.Ref8,
+		Ref9:          o.Ref9,
+		Ref10:         o.Ref10,
+		Codec:         o.Codec,
+		Compression:   o.Compression,
+		BlobDigest:    o.BlobDigest,
+		BlobSize:      o.BlobSize,
+	}
+}
+
+// FromObject converts an Object to a tables.Object
+func FromObject(o *Object) *tables.Object {
+	return &tables.Object{
+		ID:            o.Id,
+		OwnerID:       o.OwnerId,
+		CreatorID:     o.CreatorId,
+		PartitionID:   o.PartitionId,
+		Key:           o.Key,
+		Value:         o.Value,
+		Protected:     o.Protected,
+		RefOnly:       o.RefOnly,
+		Timestamp:     o.Timestamp,
+		PrevHash:      o.PrevHash,
+		PeerHash:      o.PeerHash,
+		Hash:          o.Hash,
+		SchemaVersion: o.SchemaVersion,
+		Ref1:          o.Ref1,
+		Ref2:          o.Ref2,
+		Ref3:          o.Ref3,
+		Ref4:          o.Ref4,
+		Ref5:          o.Ref5,
+		Ref6:          o.Ref6,
+		Ref7:          o.Ref7,
+		Ref8:          o.Ref8,
+		Ref9:          o.Ref9,
+		Ref10:         o.Ref10,
+		Codec:         o.Codec,
+		Compression:   o.Compression,
+		BlobDigest:    o.BlobDigest,
+		BlobSize:      o.BlobSize,
+	}
+}
+
+// toObjects converts tables.Objects to Objects
+func toObjects(objs []*tables.Object) []*Object {
+	var out = make([]*Object, len(objs))
+	for i, o := range objs {
+		out[i] = ToObject(o)
+	}
+	return out
+}
+
+// fromObjects converts Objects to tables.Objects
+func fromObjects(objs []*Object) []*tables.Object {
+	var out = make([]*tables.Object, len(objs))
+	for i, o := range objs {
+		out[i] = FromObject(o)
+	}
+	return out
+}
+
+// toStatus converts an error to a gRPC status error. Contention errors are
+// returned with codes.Aborted and should be retried by the caller. Exceeded
+// quotas are returned with codes.ResourceExhausted and invalid objects with
+// codes.InvalidArgument.
+func (s *Server) toStatus(err error) error {
+	switch cause := errors.Cause(err); {
+	case cause == patchain.ErrNotFound:
+		return grpc.Errorf(codes.NotFound, "%s", err)
+	case cause == object.ErrPartitionSealed, cause == object.ErrProtected:
+		return grpc.Errorf(codes.FailedPrecondition, "%s", err)
+	case cause == object.ErrNoPartition, cause == object.ErrNoActivePartition:
+		return grpc.Errorf(codes.FailedPrecondition, "%s", err)
+	case cause == object.ErrPermissionDenied, cause == object.ErrCreatorMismatch:
+		return grpc.Errorf(codes.PermissionDenied, "%s", err)
+	case object.IsInvalidObject(err):
+		return grpc.Errorf(codes.InvalidArgument, "%s", err)
+	case object.IsQuotaError(err):
+		return grpc.Errorf(codes.ResourceExhausted, "%s", err)
+	case s.obj.RequiresRetry(err):
+		return grpc.Errorf(codes.Aborted, "%s", err)
+	}
+	s.log.Errorf("%s", err)
+	return grpc.Errorf(codes.Internal, "%s", err)
+}
+
+// validatePut checks that objects can be put together
+func validatePut(objs []*Object) error {
+	if len(objs) == 0 {
+		return grpc.Errorf(codes.InvalidArgument, "no object to put")
+	}
+	for i, o := range objs {
+		if o.OwnerId == "" {
+			return grpc.Errorf(codes.InvalidArgument, "object %d: object does not have an owner", i)
+		} else if o.OwnerId != objs[0].OwnerId {
+			return grpc.Errorf(codes.InvalidArgument, "object %d: has a different owner", i)
+		}
+	}
+	return nil
+}
+
+// Put adds objects of the same owner to a partition of the owner
+func (s *Server) Put(ctx context.Context, req *PutRequest) (*PutResponse, error) {
+	if err := validatePut(req.Objects); err != nil {
+		return nil, err
+	}
+	objs := fromObjects(req.Objects)
+	if err := s.obj.Put(objs); err != nil {
+		return nil, s.toStatus(err)
+	}
+	return &PutResponse{Objects: toObjects(objs)}, nil
+}
+
+// PutStream adds the objects of every message received. Every message is added in
+// its own transaction and retried on contention. If a message fails, the stream is
+// aborted and the objects of the messages received before it remain stored.
+func (s *Server) PutStream(stream Patchain_PutStreamServer) error {
+	var numObjects int64
+	for n := 0; ; n++ {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return stream.SendAndClose(&PutStreamResponse{NumObjects: numObjects})
+		} else if err != nil {
+			return err
+		}
+		if err := validatePut(req.Objects); err != nil {
+			return grpc.Errorf(codes.InvalidArgument, "message %d: %s", n, grpc.ErrorDesc(err))
+		}
+		if err := s.obj.MustPut(fromObjects(req.Objects)); err != nil {
+			return s.toStatus(errors.Wrapf(err, "message %d (%d objects stored before it)", n, numObjects))
+		}
+		numObjects += int64(len(req.Objects))
+	}
+}
+
+// parseQuery converts a query request to a query object
+func (s *Server) parseQuery(req *QueryRequest) (*tables.Object, error) {
+
+	if req.Query == "" {
+		return nil, grpc.Errorf(codes.InvalidArgument, "query is required")
+	}
+
+	q, err := s.obj.ParseQuery(req.Query)
+	if err != nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "%s", err)
+	}
+
+	if req.OrderBy != "" {
+		if q.QueryParams.OrderBy, err = s.obj.ParseOrderBy(req.OrderBy); err != nil {
+			return nil, grpc.Errorf(codes.InvalidArgument, "%s", err)
+		}
+	}
+
+	if req.Limit < 0 {
+		return nil, grpc.Errorf(codes.InvalidArgument, "limit cannot be negative")
+	}
+	q.QueryParams.Limit = int(req.Limit)
+
+	return q, nil
+}
+
+// Query streams the objects matching a JSQ query
+func (s *Server) Query(req *QueryRequest, stream Patchain_QueryServer) error {
+	q, err := s.parseQuery(req)
+	if err != nil {
+		return err
+	}
+	objs, err := s.obj.All(q)
+	if err != nil {
+		return s.toStatus(err)
+	}
+	for _, o := range objs {
+		if err := stream.Send(ToObject(o)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetLast returns the most recent object matching a JSQ query
+func (s *Server) GetLast(ctx context.Context, req *QueryRequest) (*Object, error) {
+	q, err := s.parseQuery(req)
+	if err != nil {
+		return nil, err
+	}
+	obj, err := s.obj.GetLast(q)
+	if err != nil {
+		return nil, s.toStatus(err)
+	}
+	return ToObject(obj), nil
+}
+
+// Count counts the objects matching a JSQ query
+func (s *Server) Count(ctx context.Context, req *QueryRequest) (*CountResponse, error) {
+	q, err := s.parseQuery(req)
+	if err != nil {
+		return nil, err
+	}
+	var resp CountResponse
+	if err := s.obj.Count(q, &resp.Count); err != nil {
+		return nil, s.toStatus(errors.Wrap(err, "failed to count objects"))
+	}
+	return &resp, nil
+}
+
+// CreatePartitions creates partitions for an owner
+func (s *Server) CreatePartitions(ctx context.Context, req *CreatePartitionsRequest) (*PartitionsResponse, error) {
+	if req.OwnerId == "" {
+		return nil, grpc.Errorf(codes.InvalidArgument, "owner id is required")
+	} else if req.N <= 0 {
+		return nil, grpc.Errorf(codes.InvalidArgument, "n must be greater than zero")
+	}
+	creatorID := req.CreatorId
+	if creatorID == "" {
+		creatorID = req.OwnerId
+	}
+	partitions, err := s.obj.CreatePartitions(req.N, req.OwnerId, creatorID)
+	if err != nil {
+		return nil, s.toStatus(err)
+	}
+	return &PartitionsResponse{Partitions: toObjects(partitions)}, nil
+}
+
+// ListPartitions lists the partitions of an owner and their state
+func (s *Server) ListPartitions(ctx context.Context, req *ListPartitionsRequest) (*ListPartitionsResponse, error) {
+	if req.OwnerId == "" {
+		return nil, grpc.Errorf(codes.InvalidArgument, "owner id is required")
+	}
+	partitions, err := s.obj.All(&tables.Object{OwnerID: req.OwnerId, QueryParams: patchain.QueryParams{
+		KeyStartsWith: object.PartitionPrefix,
+		OrderBy:       "timestamp asc",
+	}})
+	if err != nil {
+		return nil, s.toStatus(err)
+	}
+	var resp ListPartitionsResponse
+	for _, p := range partitions {
+		state, err := s.obj.GetPartitionState(p.ID)
+		if err != nil {
+			return nil, s.toStatus(errors.Wrapf(err, "failed to get state of partition %s", p.ID))
+		}
+		resp.Partitions = append(resp.Partitions, &PartitionInfo{Partition: ToObject(p), State: state})
+	}
+	return &resp, nil
+}
+
+// SealPartition seals a partition and returns its seal object
+func (s *Server) SealPartition(ctx context.Context, req *PartitionRequest) (*Object, error) {
+	if req.PartitionId == "" {
+		return nil, grpc.Errorf(codes.InvalidArgument, "partition id is required")
+	}
+	seal, err := s.obj.SealPartition(req.PartitionId)
+	if err != nil {
+		return nil, s.toStatus(err)
+	}
+	return ToObject(seal), nil
+}
+
+// GetProof returns a proof that an object is part of its partition
+func (s *Server) GetProof(ctx context.Context, req *ProofRequest) (*Proof, error) {
+	if req.ObjectId == "" {
+		return nil, grpc.Errorf(codes.InvalidArgument, "object id is required")
+	}
+	proof, err := s.obj.GetProof(req.ObjectId)
+	if err != nil {
+		return nil, s.toStatus(err)
+	}
+	return &Proof{Partition: ToObject(proof.Partition), Objects: toObjects(proof.Objects), Index: int32(proof.Index)}, nil
+}
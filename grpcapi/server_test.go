@@ -0,0 +1,239 @@
+package grpcapi
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/ellcrys/patchain"
+	"github.com/ellcrys/patchain/cockroach"
+	"github.com/ellcrys/patchain/cockroach/tables"
+	"github.com/ellcrys/patchain/jsonschema"
+	"github.com/ellcrys/patchain/object"
+	"github.com/ellcrys/util"
+	"github.com/golang/protobuf/proto"
+	"github.com/pkg/errors"
+	. "github.com/smartystreets/goconvey/convey"
+	context "golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+var testDB *sql.DB
+
+var dbName = "test_" + strings.ToLower(util.RandString(5))
+var conStr = "postgresql://root@localhost:26257?sslmode=disable"
+var conStrWithDB = "postgresql://root@localhost:26257/" + dbName + "?sslmode=disable"
+
+func init() {
+	var err error
+	testDB, err = sql.Open("postgres", conStr)
+	if err != nil {
+		panic(fmt.Errorf("failed to connect to database: %s", err))
+	}
+}
+
+func createDb(t *testing.T) error {
+	_, err := testDB.Query(fmt.Sprintf("CREATE DATABASE %s;", dbName))
+	return err
+}
+
+func dropDB(t *testing.T) error {
+	_, err := testDB.Query(fmt.Sprintf("DROP DATABASE %s;", dbName))
+	return err
+}
+
+// startServer serves a server on a random local port and returns a connected client
+func startServer(s *Server) (PatchainClient, func(), error) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, nil, err
+	}
+	gs := grpc.NewServer()
+	s.Register(gs)
+	go gs.Serve(lis)
+	conn, err := grpc.Dial(lis.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		gs.Stop()
+		return nil, nil, err
+	}
+	return NewPatchainClient(conn), func() { conn.Close(); gs.Stop() }, nil
+}
+
+func TestConversion(t *testing.T) {
+	Convey("Conversion", t, func() {
+		o := (&tables.Object{OwnerID: "owner_id", CreatorID: "creator_id", PartitionID: "partition_id", Key: "key", Value: "value",
+			Protected: true, RefOnly: true, Codec: "json", PeerHash: "peer_hash", Ref1: "ref1", Ref5: "ref5", Ref10: "ref10"}).Init().ComputeHash()
+
+		Convey("Should map every field of tables.Object", func() {
+			So(FromObject(ToObject(o)), ShouldResemble, o)
+		})
+
+		Convey("Should preserve the hash through protobuf encoding", func() {
+			bs, err := proto.Marshal(ToObject(o))
+			So(err, ShouldBeNil)
+			var decoded Object
+			So(proto.Unmarshal(bs, &decoded), ShouldBeNil)
+			So(object.VerifyObjectHash(FromObject(&decoded)), ShouldBeNil)
+			So(decoded.PeerHash, ShouldEqual, "peer_hash")
+		})
+	})
+}
+
+func TestRequests(t *testing.T) {
+	Convey("Server", t, func() {
+
+		// not connected. Requests must be rejected before the database is used.
+		s := NewServer(cockroach.NewDB())
+		c, stop, err := startServer(s)
+		So(err, ShouldBeNil)
+		defer stop()
+		ctx := context.Background()
+
+		Convey("Should reject a query request without a query", func() {
+			_, err := c.GetLast(ctx, &QueryRequest{})
+			So(grpc.Code(err), ShouldEqual, codes.InvalidArgument)
+			So(grpc.ErrorDesc(err), ShouldEqual, "query is required")
+		})
+
+		Convey("Should reject an invalid order by clause", func() {
+			_, err := c.Count(ctx, &QueryRequest{Query: `{ "key": "a" }`, OrderBy: "key; DROP TABLE objects"})
+			So(grpc.Code(err), ShouldEqual, codes.InvalidArgument)
+		})
+
+		Convey("Should reject objects without an owner", func() {
+			_, err := c.Put(ctx, &PutRequest{Objects: []*Object{{Key: "a"}}})
+			So(grpc.Code(err), ShouldEqual, codes.InvalidArgument)
+			So(grpc.ErrorDesc(err), ShouldEqual, "object 0: object does not have an owner")
+		})
+
+		Convey("Should reject a streamed message with objects of different owners", func() {
+			stream, err := c.PutStream(ctx)
+			So(err, ShouldBeNil)
+			So(stream.Send(&PutRequest{Objects: []*Object{{OwnerId: "a"}, {OwnerId: "b"}}}), ShouldBeNil)
+			_, err = stream.CloseAndRecv()
+			So(grpc.Code(err), ShouldEqual, codes.InvalidArgument)
+			So(grpc.ErrorDesc(err), ShouldEqual, "message 0: object 1: has a different owner")
+		})
+
+		Convey(".toStatus", func() {
+			So(grpc.Code(s.toStatus(patchain.ErrNotFound)), ShouldEqual, codes.NotFound)
+			So(grpc.Code(s.toStatus(errors.Wrap(object.ErrPartitionSealed, "failed to put object(s)"))), ShouldEqual, codes.FailedPrecondition)
+			So(grpc.Code(s.toStatus(errors.Wrap(object.ErrNoPartition, "failed to put object(s)"))), ShouldEqual, codes.FailedPrecondition)
+			So(grpc.Code(s.toStatus(fmt.Errorf(`violates unique constraint "idx_prev_hash"`))), ShouldEqual, codes.Aborted)
+			So(grpc.Code(s.toStatus(fmt.Errorf("restart transaction"))), ShouldEqual, codes.Aborted)
+			So(grpc.Code(s.toStatus(errors.Wrap(object.ErrPermissionDenied, "failed to put object(s)"))), ShouldEqual, codes.PermissionDenied)
+			So(grpc.Code(s.toStatus(errors.Wrap(&object.QuotaError{Limit: object.LimitObjects}, "failed to put object(s)"))), ShouldEqual, codes.ResourceExhausted)
+			So(grpc.Code(s.toStatus(errors.Wrap(object.ErrProtected, "failed to put object(s)"))), ShouldEqual, codes.FailedPrecondition)
+			So(grpc.Code(s.toStatus(errors.Wrap(object.ErrReservedKey, "failed to put object(s)"))), ShouldEqual, codes.InvalidArgument)
+			So(grpc.Code(s.toStatus(errors.Wrap(object.ErrRefOnlyValue, "failed to put object(s)"))), ShouldEqual, codes.InvalidArgument)
+			So(grpc.Code(s.toStatus(errors.Wrap(object.ErrUnknownCodec, "failed to put object(s)"))), ShouldEqual, codes.InvalidArgument)
+			So(grpc.Code(s.toStatus(errors.Wrap(object.ErrUnknownCompression, "failed to put object(s)"))), ShouldEqual, codes.InvalidArgument)
+			So(grpc.Code(s.toStatus(errors.Wrap(&jsonschema.ValidationError{}, "failed to put object(s)"))), ShouldEqual, codes.InvalidArgument)
+			So(grpc.Code(s.toStatus(errors.Wrap(&object.MappingError{Err: fmt.Errorf("id: is required")}, "failed to put object(s)"))), ShouldEqual, codes.InvalidArgument)
+			So(grpc.Code(s.toStatus(fmt.Errorf("something else"))), ShouldEqual, codes.Internal)
+		})
+	})
+}
+
+func TestServer(t *testing.T) {
+
+	if err := createDb(t); err != nil {
+		t.Fatalf("failed to create test database. %s", err)
+	}
+	defer dropDB(t)
+
+	cdb := cockroach.NewDB()
+	cdb.ConnectionString = conStrWithDB
+	cdb.NoLogging()
+	if err := cdb.Connect(10, 5); err != nil {
+		t.Fatalf("failed to connect to database. %s", err)
+	}
+
+	if err := cdb.CreateTables(); err != nil {
+		t.Fatalf("failed to create tables. %s", err)
+	}
+
+	c, stop, err := startServer(NewServer(cdb))
+	if err != nil {
+		t.Fatalf("failed to start server. %s", err)
+	}
+	defer stop()
+
+	Convey("Server", t, func() {
+		ctx := context.Background()
+		ownerID := util.RandString(10)
+
+		resp, err := c.CreatePartitions(ctx, &CreatePartitionsRequest{OwnerId: ownerID, N: 1})
+		So(err, ShouldBeNil)
+		So(resp.Partitions, ShouldHaveLength, 1)
+		partition := resp.Partitions[0]
+
+		Convey("Should put objects", func() {
+			resp, err := c.Put(ctx, &PutRequest{Objects: []*Object{{OwnerId: ownerID, Key: "key_a"}, {OwnerId: ownerID, Key: "key_b"}}})
+			So(err, ShouldBeNil)
+			So(resp.Objects, ShouldHaveLength, 2)
+			So(resp.Objects[0].PartitionId, ShouldEqual, partition.Id)
+			So(resp.Objects[1].PrevHash, ShouldEqual, resp.Objects[0].Hash)
+
+			Convey("Should stream the objects matching a query", func() {
+				stream, err := c.Query(ctx, &QueryRequest{Query: `{ "owner_id": "` + ownerID + `", "key": { "$in": ["key_a", "key_b"] } }`, OrderBy: "timestamp asc"})
+				So(err, ShouldBeNil)
+				var found []*Object
+				for {
+					obj, err := stream.Recv()
+					if err == io.EOF {
+						break
+					}
+					So(err, ShouldBeNil)
+					found = append(found, obj)
+				}
+				So(found, ShouldHaveLength, 2)
+				So(found[0].Id, ShouldEqual, resp.Objects[0].Id)
+			})
+
+			Convey("Should get a verifiable proof of an object", func() {
+				proof, err := c.GetProof(ctx, &ProofRequest{ObjectId: resp.Objects[0].Id})
+				So(err, ShouldBeNil)
+				So(object.VerifyProof(&object.Proof{Partition: FromObject(proof.Partition), Objects: fromObjects(proof.Objects), Index: int(proof.Index)}), ShouldBeNil)
+			})
+
+			Convey("Should return NotFound if no object matches", func() {
+				_, err := c.GetLast(ctx, &QueryRequest{Query: `{ "key": "unknown_key" }`})
+				So(grpc.Code(err), ShouldEqual, codes.NotFound)
+			})
+		})
+
+		Convey("Should put streamed objects", func() {
+			stream, err := c.PutStream(ctx)
+			So(err, ShouldBeNil)
+			for i := 0; i < 3; i++ {
+				So(stream.Send(&PutRequest{Objects: []*Object{{OwnerId: ownerID, Key: "streamed"}}}), ShouldBeNil)
+			}
+			resp, err := stream.CloseAndRecv()
+			So(err, ShouldBeNil)
+			So(resp.NumObjects, ShouldEqual, 3)
+
+			count, err := c.Count(ctx, &QueryRequest{Query: `{ "owner_id": "` + ownerID + `", "key": "streamed" }`})
+			So(err, ShouldBeNil)
+			So(count.Count, ShouldEqual, 3)
+		})
+
+		Convey("Should seal a partition and list its state", func() {
+			seal, err := c.SealPartition(ctx, &PartitionRequest{PartitionId: partition.Id})
+			So(err, ShouldBeNil)
+			So(seal.Key, ShouldEqual, object.SealKey)
+
+			list, err := c.ListPartitions(ctx, &ListPartitionsRequest{OwnerId: ownerID})
+			So(err, ShouldBeNil)
+			So(list.Partitions, ShouldHaveLength, 1)
+			So(list.Partitions[0].State, ShouldEqual, object.PartitionSealed)
+
+			_, err = c.Put(ctx, &PutRequest{Objects: []*Object{{OwnerId: ownerID, Key: "key_c"}}})
+			So(grpc.Code(err), ShouldEqual, codes.FailedPrecondition)
+		})
+	})
+}
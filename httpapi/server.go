@@ -0,0 +1,415 @@
+// Package httpapi provides an HTTP/JSON API for operating a patchain store.
+// Objects are encoded using the JSON representation of tables.Object.
+package httpapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ellcrys/patchain"
+	"github.com/ellcrys/patchain/cockroach/tables"
+	"github.com/ellcrys/patchain/object"
+	"github.com/op/go-logging"
+	"github.com/pkg/errors"
+)
+
+// maxBodySize is the maximum size of a request body
+var maxBodySize int64 = 10 * 1024 * 1024
+
+// QueryRequest is the body of requests that query objects
+type QueryRequest struct {
+
+	// Query is a JSQ query. See http://github.com/ncodes/jsq
+	Query json.RawMessage `json:"query"`
+
+	// OrderBy is an order by clause (e.g "timestamp desc")
+	OrderBy string `json:"order_by,omitempty"`
+
+	// Limit is the maximum number of objects to return
+	Limit int `json:"limit,omitempty"`
+}
+
+// CreatePartitionsRequest is the body of a request to create partitions
+type CreatePartitionsRequest struct {
+	OwnerID   string `json:"owner_id"`
+	CreatorID string `json:"creator_id"`
+	N         int64  `json:"n"`
+}
+
+// CountResponse is the response body of a count request
+type CountResponse struct {
+	Count int64 `json:"count"`
+}
+
+// ErrorResponse is the response body of a failed request
+type ErrorResponse struct {
+	Error string `json:"error"`
+
+	// Retryable indicates that the request failed because of
+	// contention and can be safely retried
+	Retryable bool `json:"retryable,omitempty"`
+}
+
+// badRequestError describes an invalid request
+type badRequestError struct {
+	msg string
+}
+
+func (e *badRequestError) Error() string {
+	return e.msg
+}
+
+// badRequest creates an error describing an invalid request
+func badRequest(format string, args ...interface{}) error {
+	return &badRequestError{msg: fmt.Sprintf(format, args...)}
+}
+
+// Server serves the HTTP API
+type Server struct {
+	db  patchain.DB
+	obj *object.Object
+	mux *http.ServeMux
+	log *logging.Logger
+}
+
+// NewServer creates a server. The object handler must use the same database.
+func NewServer(db patchain.DB, obj *object.Object) *Server {
+	s := &Server{db: db, obj: obj, mux: http.NewServeMux()}
+	s.log, _ = logging.GetLogger("patchain/httpapi")
+	s.mux.HandleFunc("/v1/objects", s.handle("POST", s.put))
+	s.mux.HandleFunc("/v1/objects/last", s.handle("POST", s.getLast))
+	s.mux.HandleFunc("/v1/objects/query", s.handle("POST", s.all))
+	s.mux.HandleFunc("/v1/objects/count", s.handle("POST", s.count))
+	s.mux.HandleFunc("/v1/objects/history", s.handle("GET", s.history))
+	s.mux.HandleFunc("/v1/objects/list", s.handle("GET", s.list))
+	s.mux.HandleFunc("/v1/partitions", s.handle("POST", s.createPartitions))
+	s.mux.HandleFunc("/v1/verify", s.handle("GET", s.verify))
+	s.mux.HandleFunc("/v1/usage", s.handle("GET", s.usage))
+	return s
+}
+
+// ServeHTTP implements http.Handler
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// handle creates a handler that only accepts the given method, writes the value
+// returned by the handler function as JSON and maps errors to status codes.
+func (s *Server) handle(method string, f func(r *http.Request) (int, interface{}, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		if r.Method != method {
+			w.Header().Set("Allow", method)
+			writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "method not allowed"})
+			return
+		}
+
+		if r.Body != nil {
+			r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
+		}
+
+		status, v, err := f(r)
+		if err != nil {
+			s.writeError(w, err)
+			return
+		}
+
+		writeJSON(w, status, v)
+	}
+}
+
+// writeError writes an error response. Contention errors are reported as
+// retryable: prev hash conflicts with 409 and transaction restarts with 503.
+// Quota errors are reported with 429 and are retryable if the rate limit was exceeded.
+// Invalid objects are reported with 400, denied writes with 403 and attempts to
+// supersede protected objects with 409.
+func (s *Server) writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	resp := ErrorResponse{Error: err.Error()}
+
+	switch cause := errors.Cause(err); {
+	case cause == patchain.ErrNotFound:
+		status = http.StatusNotFound
+	case cause == object.ErrPartitionSealed, cause == object.ErrProtected:
+		status = http.StatusConflict
+	case cause == object.ErrNoPartition, cause == object.ErrNoActivePartition:
+		status = http.StatusUnprocessableEntity
+	case cause == object.ErrPermissionDenied, cause == object.ErrCreatorMismatch:
+		status = http.StatusForbidden
+	case object.IsInvalidObject(err):
+		status = http.StatusBadRequest
+	case object.IsQuotaError(err):
+		status = http.StatusTooManyRequests
+		resp.Retryable = cause.(*object.QuotaError).Limit == object.LimitWritesPerSecond
+	case s.obj.IsPrevHashConflict(err):
+		status = http.StatusConflict
+		resp.Retryable = true
+	case s.obj.RequiresRetry(err):
+		status = http.StatusServiceUnavailable
+		resp.Retryable = true
+	default:
+		if _, ok := cause.(*badRequestError); ok {
+			status = http.StatusBadRequest
+		}
+	}
+
+	if resp.Retryable {
+		w.Header().Set("Retry-After", "1")
+	}
+
+	if status == http.StatusInternalServerError {
+		s.log.Errorf("%s", err)
+	}
+
+	writeJSON(w, status, resp)
+}
+
+// writeJSON writes v as the JSON response body
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// decodeBody decodes a JSON request body
+func decodeBody(r *http.Request, v interface{}) error {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		return badRequest("malformed request body: %s", err)
+	}
+	return nil
+}
+
+// put adds one or more objects of the same owner. The body can be an object or an array of objects.
+func (s *Server) put(r *http.Request) (int, interface{}, error) {
+
+	var raw json.RawMessage
+	if err := decodeBody(r, &raw); err != nil {
+		return 0, nil, err
+	}
+
+	var objs []*tables.Object
+	if trimmed := strings.TrimSpace(string(raw)); strings.HasPrefix(trimmed, "[") {
+		if err := json.Unmarshal(raw, &objs); err != nil {
+			return 0, nil, badRequest("malformed objects: %s", err)
+		}
+	} else {
+		var obj tables.Object
+		if err := json.Unmarshal(raw, &obj); err != nil {
+			return 0, nil, badRequest("malformed object: %s", err)
+		}
+		objs = append(objs, &obj)
+	}
+
+	if len(objs) == 0 {
+		return 0, nil, badRequest("no object to put")
+	}
+	for i, obj := range objs {
+		if obj.OwnerID == "" {
+			return 0, nil, badRequest("object %d: object does not have an owner", i)
+		} else if obj.OwnerID != objs[0].OwnerID {
+			return 0, nil, badRequest("object %d: has a different owner", i)
+		}
+	}
+
+	if err := s.obj.Put(objs); err != nil {
+		return 0, nil, err
+	}
+
+	return http.StatusCreated, objs, nil
+}
+
+// parseQuery parses the body of a query request
+func (s *Server) parseQuery(r *http.Request) (*tables.Object, error) {
+
+	var req QueryRequest
+	if err := decodeBody(r, &req); err != nil {
+		return nil, err
+	}
+
+	if len(req.Query) == 0 {
+		return nil, badRequest("query is required")
+	}
+
+	q, err := s.obj.ParseQuery(string(req.Query))
+	if err != nil {
+		return nil, badRequest("%s", err)
+	}
+
+	if req.OrderBy != "" {
+		if q.QueryParams.OrderBy, err = s.obj.ParseOrderBy(req.OrderBy); err != nil {
+			return nil, badRequest("%s", err)
+		}
+	}
+
+	if req.Limit < 0 {
+		return nil, badRequest("limit cannot be negative")
+	}
+	q.QueryParams.Limit = req.Limit
+
+	return q, nil
+}
+
+// getLast returns the most recent object matching a query
+func (s *Server) getLast(r *http.Request) (int, interface{}, error) {
+	q, err := s.parseQuery(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	obj, err := s.obj.GetLast(q)
+	if err != nil {
+		return 0, nil, err
+	}
+	return http.StatusOK, obj, nil
+}
+
+// all returns all the objects matching a query
+func (s *Server) all(r *http.Request) (int, interface{}, error) {
+	q, err := s.parseQuery(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	objs, err := s.obj.All(q)
+	if err != nil {
+		return 0, nil, err
+	}
+	if objs == nil {
+		objs = []*tables.Object{}
+	}
+	return http.StatusOK, objs, nil
+}
+
+// count counts the objects matching a query
+func (s *Server) count(r *http.Request) (int, interface{}, error) {
+	q, err := s.parseQuery(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	var resp CountResponse
+	if err := s.obj.Count(q, &resp.Count); err != nil {
+		return 0, nil, errors.Wrap(err, "failed to count objects")
+	}
+	return http.StatusOK, resp, nil
+}
+
+// history returns every version of a key from the oldest to the most recent.
+// Query parameters: key (required), owner_id and limit.
+func (s *Server) history(r *http.Request) (int, interface{}, error) {
+
+	params := r.URL.Query()
+	if params.Get("key") == "" {
+		return 0, nil, badRequest("key is required")
+	}
+
+	var limit int
+	if l := params.Get("limit"); l != "" {
+		var err error
+		if limit, err = strconv.Atoi(l); err != nil || limit < 0 {
+			return 0, nil, badRequest("invalid limit")
+		}
+	}
+
+	objs, err := s.obj.All(&tables.Object{OwnerID: params.Get("owner_id"), Key: params.Get("key"), QueryParams: patchain.QueryParams{
+		OrderBy: "timestamp asc",
+		Limit:   limit,
+	}})
+	if err != nil {
+		return 0, nil, err
+	}
+	if objs == nil {
+		objs = []*tables.Object{}
+	}
+	return http.StatusOK, objs, nil
+}
+
+// list lists the keys under a prefix. Query parameters: owner_id,
+// prefix, delimiter, cursor and limit.
+func (s *Server) list(r *http.Request) (int, interface{}, error) {
+
+	params := r.URL.Query()
+	var limit int
+	if l := params.Get("limit"); l != "" {
+		var err error
+		if limit, err = strconv.Atoi(l); err != nil || limit < 0 {
+			return 0, nil, badRequest("invalid limit")
+		}
+	}
+
+	listing, err := s.obj.List(params.Get("owner_id"), params.Get("prefix"), params.Get("delimiter"), params.Get("cursor"), limit)
+	if err != nil {
+		return 0, nil, err
+	}
+	return http.StatusOK, listing, nil
+}
+
+// createPartitions creates partitions for an owner
+func (s *Server) createPartitions(r *http.Request) (int, interface{}, error) {
+
+	var req CreatePartitionsRequest
+	if err := decodeBody(r, &req); err != nil {
+		return 0, nil, err
+	}
+
+	if req.OwnerID == "" {
+		return 0, nil, badRequest("owner_id is required")
+	} else if req.N <= 0 {
+		return 0, nil, badRequest("n must be greater than zero")
+	}
+	if req.CreatorID == "" {
+		req.CreatorID = req.OwnerID
+	}
+
+	partitions, err := s.obj.CreatePartitions(req.N, req.OwnerID, req.CreatorID)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return http.StatusCreated, partitions, nil
+}
+
+// usage returns the resources consumed by an owner.
+// Query parameters: owner_id (required).
+func (s *Server) usage(r *http.Request) (int, interface{}, error) {
+
+	ownerID := r.URL.Query().Get("owner_id")
+	if ownerID == "" {
+		return 0, nil, badRequest("owner_id is required")
+	}
+
+	usage, err := s.obj.GetUsage(ownerID)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return http.StatusOK, usage, nil
+}
+
+// verify verifies the chains of a partition or all the partitions of an owner.
+// Query parameters: partition_id or owner_id.
+func (s *Server) verify(r *http.Request) (int, interface{}, error) {
+
+	var objs []*tables.Object
+	var err error
+	params := r.URL.Query()
+	switch {
+	case params.Get("partition_id") != "":
+		var partition *tables.Object
+		partition, err = s.obj.GetLast(&tables.Object{ID: params.Get("partition_id"), QueryParams: patchain.KeyStartsWith(object.PartitionPrefix)})
+		if err != nil {
+			return 0, nil, err
+		}
+		objs, err = s.obj.All(&tables.Object{PartitionID: partition.ID, QueryParams: patchain.QueryParams{OrderBy: "timestamp asc"}}, object.IncludeRefOnly())
+		objs = append([]*tables.Object{partition}, objs...)
+	case params.Get("owner_id") != "":
+		objs, err = s.obj.All(&tables.Object{OwnerID: params.Get("owner_id"), QueryParams: patchain.QueryParams{OrderBy: "timestamp asc"}}, object.IncludeRefOnly())
+	default:
+		return 0, nil, badRequest("owner_id or partition_id is required")
+	}
+	if err != nil {
+		return 0, nil, errors.Wrap(err, "failed to get objects")
+	}
+
+	return http.StatusOK, object.VerifyBundle(objs), nil
+}
@@ -0,0 +1,274 @@
+package httpapi
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ellcrys/patchain"
+	"github.com/ellcrys/patchain/cockroach"
+	"github.com/ellcrys/patchain/cockroach/tables"
+	"github.com/ellcrys/patchain/jsonschema"
+	"github.com/ellcrys/patchain/object"
+	"github.com/ellcrys/util"
+	"github.com/pkg/errors"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+var testDB *sql.DB
+
+var dbName = "test_" + strings.ToLower(util.RandString(5))
+var conStr = "postgresql://root@localhost:26257?sslmode=disable"
+var conStrWithDB = "postgresql://root@localhost:26257/" + dbName + "?sslmode=disable"
+
+func init() {
+	var err error
+	testDB, err = sql.Open("postgres", conStr)
+	if err != nil {
+		panic(fmt.Errorf("failed to connect to database: %s", err))
+	}
+}
+
+func createDb(t *testing.T) error {
+	_, err := testDB.Query(fmt.Sprintf("CREATE DATABASE %s;", dbName))
+	return err
+}
+
+func dropDB(t *testing.T) error {
+	_, err := testDB.Query(fmt.Sprintf("DROP DATABASE %s;", dbName))
+	return err
+}
+
+// do sends a request to the server and decodes the response body into out
+func do(s *Server, method, path, body string, out interface{}) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+	if out != nil {
+		json.Unmarshal(w.Body.Bytes(), out)
+	}
+	return w
+}
+
+func TestRequests(t *testing.T) {
+	Convey("Server", t, func() {
+
+		// not connected. Requests must be rejected before the database is used.
+		cdb := cockroach.NewDB()
+		s := NewServer(cdb, object.NewObject(cdb))
+		var resp ErrorResponse
+
+		Convey("Should reject unsupported methods", func() {
+			w := do(s, "GET", "/v1/objects", "", &resp)
+			So(w.Code, ShouldEqual, http.StatusMethodNotAllowed)
+			So(w.Header().Get("Allow"), ShouldEqual, "POST")
+		})
+
+		Convey("Should reject a malformed body", func() {
+			w := do(s, "POST", "/v1/objects/query", "{", &resp)
+			So(w.Code, ShouldEqual, http.StatusBadRequest)
+			So(resp.Error, ShouldStartWith, "malformed request body")
+		})
+
+		Convey("Should reject a query request without a query", func() {
+			w := do(s, "POST", "/v1/objects/last", `{}`, &resp)
+			So(w.Code, ShouldEqual, http.StatusBadRequest)
+			So(resp.Error, ShouldEqual, "query is required")
+		})
+
+		Convey("Should reject an invalid JSQ query", func() {
+			w := do(s, "POST", "/v1/objects/query", `{ "query": { "unknown_field": "a" } }`, &resp)
+			So(w.Code, ShouldEqual, http.StatusBadRequest)
+			So(resp.Error, ShouldContainSubstring, "unknown query field: unknown_field")
+		})
+
+		Convey("Should reject an invalid order by clause", func() {
+			for _, orderBy := range []string{"unknown_field", "key; DROP TABLE objects", "key sideways"} {
+				body, _ := json.Marshal(QueryRequest{Query: json.RawMessage(`{ "key": "a" }`), OrderBy: orderBy})
+				w := do(s, "POST", "/v1/objects/count", string(body), &resp)
+				So(w.Code, ShouldEqual, http.StatusBadRequest)
+				So(resp.Error, ShouldEqual, "invalid order by clause")
+			}
+		})
+
+		Convey("Should reject objects without an owner", func() {
+			w := do(s, "POST", "/v1/objects", `[{ "key": "a" }]`, &resp)
+			So(w.Code, ShouldEqual, http.StatusBadRequest)
+			So(resp.Error, ShouldEqual, "object 0: object does not have an owner")
+		})
+
+		Convey("Should require a key to get the history", func() {
+			w := do(s, "GET", "/v1/objects/history", "", &resp)
+			So(w.Code, ShouldEqual, http.StatusBadRequest)
+			So(resp.Error, ShouldEqual, "key is required")
+		})
+
+		Convey("Should reject an invalid list limit", func() {
+			w := do(s, "GET", "/v1/objects/list?limit=-1", "", &resp)
+			So(w.Code, ShouldEqual, http.StatusBadRequest)
+			So(resp.Error, ShouldEqual, "invalid limit")
+		})
+
+		Convey("Should require an owner or partition to verify", func() {
+			w := do(s, "GET", "/v1/verify", "", &resp)
+			So(w.Code, ShouldEqual, http.StatusBadRequest)
+		})
+
+		Convey("Should require an owner to get the usage", func() {
+			w := do(s, "GET", "/v1/usage", "", &resp)
+			So(w.Code, ShouldEqual, http.StatusBadRequest)
+			So(resp.Error, ShouldEqual, "owner_id is required")
+		})
+
+		Convey(".writeError", func() {
+			var cases = []struct {
+				err       error
+				status    int
+				retryable bool
+			}{
+				{patchain.ErrNotFound, http.StatusNotFound, false},
+				{errors.Wrap(object.ErrPartitionSealed, "failed to put object(s)"), http.StatusConflict, false},
+				{errors.Wrap(object.ErrNoPartition, "failed to put object(s)"), http.StatusUnprocessableEntity, false},
+				{fmt.Errorf(`pq: duplicate key value (prev_hash)=('abc') violates unique constraint "idx_prev_hash"`), http.StatusConflict, true},
+				{fmt.Errorf("pq: restart transaction: HandledRetryableTxnError"), http.StatusServiceUnavailable, true},
+				{errors.Wrap(&object.QuotaError{Limit: object.LimitWritesPerSecond}, "failed to put object(s)"), http.StatusTooManyRequests, true},
+				{errors.Wrap(&object.QuotaError{Limit: object.LimitObjects}, "failed to put object(s)"), http.StatusTooManyRequests, false},
+				{errors.Wrap(object.ErrPermissionDenied, "failed to put object(s)"), http.StatusForbidden, false},
+				{errors.Wrap(object.ErrCreatorMismatch, "failed to put object(s)"), http.StatusForbidden, false},
+				{errors.Wrap(object.ErrProtected, "failed to put object(s)"), http.StatusConflict, false},
+				{errors.Wrap(object.ErrReservedKey, "failed to put object(s)"), http.StatusBadRequest, false},
+				{errors.Wrap(object.ErrRefOnlyValue, "failed to put object(s)"), http.StatusBadRequest, false},
+				{errors.Wrap(object.ErrUnknownCodec, "failed to put object(s)"), http.StatusBadRequest, false},
+				{errors.Wrap(object.ErrUnknownCompression, "failed to put object(s)"), http.StatusBadRequest, false},
+				{errors.Wrap(&jsonschema.ValidationError{}, "failed to put object(s)"), http.StatusBadRequest, false},
+				{errors.Wrap(&object.MappingError{Err: fmt.Errorf("id: is required")}, "failed to put object(s)"), http.StatusBadRequest, false},
+				{badRequest("bad"), http.StatusBadRequest, false},
+				{fmt.Errorf("something else"), http.StatusInternalServerError, false},
+			}
+			for _, c := range cases {
+				var resp ErrorResponse
+				w := httptest.NewRecorder()
+				s.writeError(w, c.err)
+				json.Unmarshal(w.Body.Bytes(), &resp)
+				So(w.Code, ShouldEqual, c.status)
+				So(resp.Retryable, ShouldEqual, c.retryable)
+				So(w.Header().Get("Retry-After") != "", ShouldEqual, c.retryable)
+			}
+		})
+	})
+}
+
+func TestServer(t *testing.T) {
+
+	if err := createDb(t); err != nil {
+		t.Fatalf("failed to create test database. %s", err)
+	}
+	defer dropDB(t)
+
+	cdb := cockroach.NewDB()
+	cdb.ConnectionString = conStrWithDB
+	cdb.NoLogging()
+	if err := cdb.Connect(10, 5); err != nil {
+		t.Fatalf("failed to connect to database. %s", err)
+	}
+
+	if err := cdb.CreateTables(); err != nil {
+		t.Fatalf("failed to create tables. %s", err)
+	}
+
+	s := NewServer(cdb, object.NewObject(cdb))
+
+	Convey("Server", t, func() {
+		ownerID := util.RandString(10)
+
+		Convey("Should return 422 when putting an object of an owner without partitions", func() {
+			var resp ErrorResponse
+			w := do(s, "POST", "/v1/objects", `{ "owner_id": "`+ownerID+`", "key": "a" }`, &resp)
+			So(w.Code, ShouldEqual, http.StatusUnprocessableEntity)
+		})
+
+		Convey("Should create partitions", func() {
+			var partitions []*tables.Object
+			w := do(s, "POST", "/v1/partitions", `{ "owner_id": "`+ownerID+`", "n": 2 }`, &partitions)
+			So(w.Code, ShouldEqual, http.StatusCreated)
+			So(partitions, ShouldHaveLength, 2)
+			So(partitions[0].CreatorID, ShouldEqual, ownerID)
+
+			Convey("Should put objects", func() {
+				var objs []*tables.Object
+				body := `[{ "owner_id": "` + ownerID + `", "key": "key_a", "value": "1" }, { "owner_id": "` + ownerID + `", "key": "key_a", "value": "2" }]`
+				w := do(s, "POST", "/v1/objects", body, &objs)
+				So(w.Code, ShouldEqual, http.StatusCreated)
+				So(objs, ShouldHaveLength, 2)
+				So(objs[0].Hash, ShouldNotBeEmpty)
+				So(objs[1].PrevHash, ShouldEqual, objs[0].Hash)
+
+				query := `{ "query": { "owner_id": "` + ownerID + `", "key": "key_a" } }`
+
+				Convey("Should get the last object", func() {
+					var obj tables.Object
+					w := do(s, "POST", "/v1/objects/last", query, &obj)
+					So(w.Code, ShouldEqual, http.StatusOK)
+					So(obj.ID, ShouldEqual, objs[1].ID)
+				})
+
+				Convey("Should return 404 if no object matches", func() {
+					w := do(s, "POST", "/v1/objects/last", `{ "query": { "key": "unknown" } }`, nil)
+					So(w.Code, ShouldEqual, http.StatusNotFound)
+				})
+
+				Convey("Should get all objects", func() {
+					var found []*tables.Object
+					w := do(s, "POST", "/v1/objects/query", `{ "query": { "owner_id": "`+ownerID+`", "key": "key_a" }, "order_by": "timestamp asc", "limit": 1 }`, &found)
+					So(w.Code, ShouldEqual, http.StatusOK)
+					So(found, ShouldHaveLength, 1)
+					So(found[0].ID, ShouldEqual, objs[0].ID)
+				})
+
+				Convey("Should count objects", func() {
+					var resp CountResponse
+					w := do(s, "POST", "/v1/objects/count", query, &resp)
+					So(w.Code, ShouldEqual, http.StatusOK)
+					So(resp.Count, ShouldEqual, 2)
+				})
+
+				Convey("Should not count ref-only objects", func() {
+					w := do(s, "POST", "/v1/objects", `{ "owner_id": "`+ownerID+`", "key": "key_a", "value": "3", "ref_only": true }`, nil)
+					So(w.Code, ShouldEqual, http.StatusCreated)
+					var resp CountResponse
+					w = do(s, "POST", "/v1/objects/count", query, &resp)
+					So(w.Code, ShouldEqual, http.StatusOK)
+					So(resp.Count, ShouldEqual, 2)
+				})
+
+				Convey("Should get the history of a key", func() {
+					var found []*tables.Object
+					w := do(s, "GET", "/v1/objects/history?key=key_a&owner_id="+ownerID, "", &found)
+					So(w.Code, ShouldEqual, http.StatusOK)
+					So(found, ShouldHaveLength, 2)
+					So(found[0].Value, ShouldEqual, "1")
+					So(found[1].Value, ShouldEqual, "2")
+				})
+
+				Convey("Should list the keys of the owner", func() {
+					var listing object.Listing
+					w := do(s, "GET", "/v1/objects/list?prefix=key_&owner_id="+ownerID, "", &listing)
+					So(w.Code, ShouldEqual, http.StatusOK)
+					So(listing.Objects, ShouldHaveLength, 1)
+					So(listing.Objects[0].Value, ShouldEqual, "2")
+				})
+
+				Convey("Should verify the partitions of the owner", func() {
+					var report map[string]interface{}
+					w := do(s, "GET", "/v1/verify?owner_id="+ownerID, "", &report)
+					So(w.Code, ShouldEqual, http.StatusOK)
+					So(report["passed"], ShouldEqual, true)
+					So(report["partitions"], ShouldHaveLength, 2)
+				})
+			})
+		})
+	})
+}
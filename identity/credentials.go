@@ -0,0 +1,210 @@
+package identity
+
+import (
+	"fmt"
+
+	"github.com/ellcrys/patchain"
+	"github.com/ellcrys/patchain/cockroach/tables"
+	"github.com/ellcrys/patchain/object"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrNoCredentials indicates that an identity has no client credentials
+	ErrNoCredentials = fmt.Errorf("identity has no client credentials")
+
+	// ErrCredentialsRevoked indicates that the client credentials of an identity have been revoked
+	ErrCredentialsRevoked = fmt.Errorf("client credentials have been revoked")
+)
+
+// Credentials are the client credentials of a developer identity. The
+// secret is only available when the credentials are issued or rotated.
+type Credentials struct {
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+}
+
+// RegisterDeveloper is like Register but the identity is issued client credentials
+func (i *Identity) RegisterDeveloper(ownerID, creatorID, email, password string, protected bool) (*tables.Object, *Credentials, error) {
+
+	email, err := validate(email, password)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	identity, secret, err := object.MakeDeveloperIdentityObject(ownerID, creatorID, email, password, protected)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := i.register(identity); err != nil {
+		return nil, nil, err
+	}
+
+	return identity, &Credentials{ClientID: identity.Ref1, ClientSecret: secret}, nil
+}
+
+// setCredentials appends a new version of an identity holding new client
+// credentials. A new client id is generated if clientID is empty.
+func (i *Identity) setCredentials(email, clientID string) (*tables.Object, *Credentials, error) {
+
+	current, err := i.Get(email)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	identity := NewVersion(current)
+	secret, err := object.SetClientCredentials(identity, clientID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := i.o.MustPut(identity); err != nil {
+		return nil, nil, errors.Wrap(err, "failed to add identity version")
+	}
+
+	return identity, &Credentials{ClientID: identity.Ref1, ClientSecret: secret}, nil
+}
+
+// IssueCredentials issues new client credentials to an identity. Credentials
+// previously issued to the identity stop being valid.
+func (i *Identity) IssueCredentials(email string) (*tables.Object, *Credentials, error) {
+	return i.setCredentials(email, "")
+}
+
+// RotateCredentials replaces the client secret of an identity. The client id
+// does not change and the previous secret stops being valid.
+func (i *Identity) RotateCredentials(email string) (*tables.Object, *Credentials, error) {
+
+	current, err := i.Get(email)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	info, err := object.GetIdentityInfo(current)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if info.CredentialsRevoked {
+		return nil, nil, ErrCredentialsRevoked
+	}
+
+	if current.Ref1 == "" || info.ClientSecretHash == "" {
+		return nil, nil, ErrNoCredentials
+	}
+
+	return i.setCredentials(email, current.Ref1)
+}
+
+// RevokeCredentials revokes the client credentials of an identity by
+// appending a new version of the identity that records the revocation
+func (i *Identity) RevokeCredentials(email string) (*tables.Object, error) {
+
+	current, err := i.Get(email)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := object.GetIdentityInfo(current)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.CredentialsRevoked {
+		return nil, ErrCredentialsRevoked
+	}
+
+	if current.Ref1 == "" || info.ClientSecretHash == "" {
+		return nil, ErrNoCredentials
+	}
+
+	identity := NewVersion(current)
+	if err := object.RevokeClientCredentials(identity); err != nil {
+		return nil, err
+	}
+
+	if err := i.o.MustPut(identity); err != nil {
+		return nil, errors.Wrap(err, "failed to add identity version")
+	}
+
+	return identity, nil
+}
+
+// getIssuedIdentity returns the latest version of the identity a client id was
+// issued to. Identity objects that are not of the owner their email was
+// registered for are ignored. ErrInvalidCredentials is returned if the client
+// id was issued to more than one identity.
+func (i *Identity) getIssuedIdentity(clientID string) (*tables.Object, error) {
+
+	issued, err := i.o.All(&tables.Object{Ref1: clientID, QueryParams: patchain.KeyStartsWith(object.IdentityPrefix)})
+	if err != nil {
+		return nil, err
+	}
+
+	var key, ownerID string
+	owners := make(map[string]string)
+	for _, obj := range issued {
+		owner, ok := owners[obj.Key]
+		if !ok {
+			if owner, err = i.getOwner(obj.Key); err != nil && err != patchain.ErrNotFound {
+				return nil, err
+			}
+			owners[obj.Key] = owner
+		}
+		if owner == "" || owner != obj.OwnerID {
+			continue
+		}
+		if key != "" && key != obj.Key {
+			return nil, ErrInvalidCredentials
+		}
+		key, ownerID = obj.Key, owner
+	}
+
+	if key == "" {
+		return nil, patchain.ErrNotFound
+	}
+
+	// credentials are only valid if they belong to the latest version of the identity
+	return i.o.GetLast(&tables.Object{OwnerID: ownerID, Key: key})
+}
+
+// VerifyCredentials checks client credentials and returns the latest version
+// of the identity they were issued to. ErrInvalidCredentials is returned if the
+// credentials do not match the latest version, have been revoked or the client
+// id was issued to more than one identity.
+func (i *Identity) VerifyCredentials(clientID, clientSecret string) (*tables.Object, error) {
+
+	if clientID == "" {
+		checkDummyPassword(clientSecret)
+		return nil, ErrInvalidCredentials
+	}
+
+	identity, err := i.getIssuedIdentity(clientID)
+	if err != nil {
+		if err == patchain.ErrNotFound || err == ErrInvalidCredentials {
+			checkDummyPassword(clientSecret)
+			return nil, ErrInvalidCredentials
+		}
+		return nil, errors.Wrap(err, "failed to get identity")
+	}
+
+	info, err := object.GetIdentityInfo(identity)
+	if err != nil {
+		return nil, err
+	}
+
+	if identity.Ref1 != clientID || info.CredentialsRevoked || info.ClientSecretHash == "" {
+		checkDummyPassword(clientSecret)
+		return nil, ErrInvalidCredentials
+	}
+
+	ok, err := object.CheckPassword(info.ClientSecretHash, clientSecret)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrInvalidCredentials
+	}
+
+	return identity, nil
+}
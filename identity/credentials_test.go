@@ -0,0 +1,148 @@
+package identity
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ellcrys/patchain/cockroach"
+	"github.com/ellcrys/patchain/object"
+	"github.com/ellcrys/util"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCredentials(t *testing.T) {
+
+	if err := createDb(t); err != nil {
+		t.Fatalf("failed to create test database. %s", err)
+	}
+	defer dropDB(t)
+
+	cdb := cockroach.NewDB()
+	cdb.ConnectionString = conStrWithDB
+	cdb.NoLogging()
+	if err := cdb.Connect(10, 5); err != nil {
+		t.Fatalf("failed to connect to database. %s", err)
+	}
+
+	if err := cdb.CreateTables(); err != nil {
+		t.Fatalf("failed to create tables. %s", err)
+	}
+
+	obj := object.NewObject(cdb)
+	id := NewIdentity(cdb, obj)
+
+	Convey("Credentials", t, func() {
+		ownerID := util.RandString(10)
+		email := strings.ToLower(util.RandString(10)) + "@example.com"
+		_, err := obj.CreatePartitions(1, ownerID, ownerID)
+		So(err, ShouldBeNil)
+
+		identity, creds, err := id.RegisterDeveloper(ownerID, ownerID, email, "pass", false)
+		So(err, ShouldBeNil)
+		So(creds.ClientID, ShouldEqual, identity.Ref1)
+		So(creds.ClientSecret, ShouldNotBeEmpty)
+
+		Convey("Should not store the client secret", func() {
+			So(identity.Value, ShouldNotContainSubstring, creds.ClientSecret)
+			So(identity.Ref2, ShouldBeEmpty)
+		})
+
+		Convey("Should verify issued credentials", func() {
+			verified, err := id.VerifyCredentials(creds.ClientID, creds.ClientSecret)
+			So(err, ShouldBeNil)
+			So(verified.ID, ShouldEqual, identity.ID)
+
+			_, err = id.VerifyCredentials(creds.ClientID, "wrong_secret")
+			So(err, ShouldEqual, ErrInvalidCredentials)
+			_, err = id.VerifyCredentials("unknown_client", creds.ClientSecret)
+			So(err, ShouldEqual, ErrInvalidCredentials)
+		})
+
+		Convey("Should ignore identity objects of other owners with the same client id", func() {
+			otherID := util.RandString(10)
+			_, err := obj.CreatePartitions(1, otherID, otherID)
+			So(err, ShouldBeNil)
+			shadow, err := object.MakeIdentityObject(otherID, otherID, email, "pass", false)
+			So(err, ShouldBeNil)
+			secret, err := object.SetClientCredentials(shadow, creds.ClientID)
+			So(err, ShouldBeNil)
+			So(obj.Put(shadow), ShouldBeNil)
+
+			_, err = id.VerifyCredentials(creds.ClientID, secret)
+			So(err, ShouldEqual, ErrInvalidCredentials)
+			verified, err := id.VerifyCredentials(creds.ClientID, creds.ClientSecret)
+			So(err, ShouldBeNil)
+			So(verified.ID, ShouldEqual, identity.ID)
+		})
+
+		Convey("Should reject a client id issued to more than one identity", func() {
+			email := strings.ToLower(util.RandString(10)) + "@example.com"
+			other, err := id.Register(ownerID, ownerID, email, "pass", false)
+			So(err, ShouldBeNil)
+			other = NewVersion(other)
+			secret, err := object.SetClientCredentials(other, creds.ClientID)
+			So(err, ShouldBeNil)
+			So(obj.MustPut(other), ShouldBeNil)
+
+			_, err = id.VerifyCredentials(creds.ClientID, secret)
+			So(err, ShouldEqual, ErrInvalidCredentials)
+			_, err = id.VerifyCredentials(creds.ClientID, creds.ClientSecret)
+			So(err, ShouldEqual, ErrInvalidCredentials)
+		})
+
+		Convey("Should keep credentials when the password is changed", func() {
+			_, err := id.ChangePassword(email, "pass", "new_pass")
+			So(err, ShouldBeNil)
+			_, err = id.VerifyCredentials(creds.ClientID, creds.ClientSecret)
+			So(err, ShouldBeNil)
+		})
+
+		Convey("Should rotate the client secret", func() {
+			rotated, newCreds, err := id.RotateCredentials(email)
+			So(err, ShouldBeNil)
+			So(newCreds.ClientID, ShouldEqual, creds.ClientID)
+			So(newCreds.ClientSecret, ShouldNotEqual, creds.ClientSecret)
+			So(rotated.PrevHash, ShouldEqual, identity.Hash)
+
+			_, err = id.VerifyCredentials(creds.ClientID, creds.ClientSecret)
+			So(err, ShouldEqual, ErrInvalidCredentials)
+			verified, err := id.VerifyCredentials(newCreds.ClientID, newCreds.ClientSecret)
+			So(err, ShouldBeNil)
+			So(verified.ID, ShouldEqual, rotated.ID)
+		})
+
+		Convey("Should issue a new client id", func() {
+			_, newCreds, err := id.IssueCredentials(email)
+			So(err, ShouldBeNil)
+			So(newCreds.ClientID, ShouldNotEqual, creds.ClientID)
+			_, err = id.VerifyCredentials(creds.ClientID, creds.ClientSecret)
+			So(err, ShouldEqual, ErrInvalidCredentials)
+			_, err = id.VerifyCredentials(newCreds.ClientID, newCreds.ClientSecret)
+			So(err, ShouldBeNil)
+		})
+
+		Convey("Should revoke credentials", func() {
+			revoked, err := id.RevokeCredentials(email)
+			So(err, ShouldBeNil)
+			info, err := object.GetIdentityInfo(revoked)
+			So(err, ShouldBeNil)
+			So(info.CredentialsRevoked, ShouldBeTrue)
+			So(revoked.Ref1, ShouldEqual, creds.ClientID)
+
+			_, err = id.VerifyCredentials(creds.ClientID, creds.ClientSecret)
+			So(err, ShouldEqual, ErrInvalidCredentials)
+			_, _, err = id.RotateCredentials(email)
+			So(err, ShouldEqual, ErrCredentialsRevoked)
+			_, err = id.RevokeCredentials(email)
+			So(err, ShouldEqual, ErrCredentialsRevoked)
+		})
+
+		Convey("Should return error if the identity has no credentials", func() {
+			email := strings.ToLower(util.RandString(10)) + "@example.com"
+			_, err := id.Register(ownerID, ownerID, email, "pass", false)
+			So(err, ShouldBeNil)
+			_, _, err = id.RotateCredentials(email)
+			So(err, ShouldEqual, ErrNoCredentials)
+		})
+	})
+}
@@ -0,0 +1,218 @@
+// Package identity registers and authenticates identities. An identity is an
+// object whose key is derived from an email (see object.MakeIdentityKey) and
+// whose value holds the hash of its password. Every change to an identity is
+// appended as a new version of its key, so the latest version is authoritative.
+package identity
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/asaskevich/govalidator"
+	"github.com/ellcrys/patchain"
+	"github.com/ellcrys/patchain/cockroach/tables"
+	"github.com/ellcrys/patchain/object"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrInvalidEmail indicates that an email is not valid
+	ErrInvalidEmail = fmt.Errorf("invalid email")
+
+	// ErrPasswordRequired indicates that an empty password was given
+	ErrPasswordRequired = fmt.Errorf("password is required")
+
+	// ErrIdentityExists indicates that an identity with the same email exists
+	ErrIdentityExists = fmt.Errorf("identity already exists")
+
+	// ErrInvalidCredentials indicates that an email and password do not match an identity
+	ErrInvalidCredentials = fmt.Errorf("invalid email or password")
+)
+
+var (
+	// dummyHash is checked when authenticating an unknown email so that
+	// the response time does not reveal whether the identity exists
+	dummyHash     string
+	dummyHashOnce sync.Once
+)
+
+// checkDummyPassword spends the time of a password check
+func checkDummyPassword(password string) {
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = object.HashPassword("")
+	})
+	object.CheckPassword(dummyHash, password)
+}
+
+// Identity defines a structure for managing identities
+type Identity struct {
+	db patchain.DB
+	o  *object.Object
+}
+
+// NewIdentity creates an identity manager. The object handler must use the same database.
+func NewIdentity(db patchain.DB, o *object.Object) *Identity {
+	return &Identity{db: db, o: o}
+}
+
+// normalizeEmail trims and lowercases an email
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// validate checks the email and password of a new identity
+// and returns the normalized email
+func validate(email, password string) (string, error) {
+	email = normalizeEmail(email)
+	if !govalidator.IsEmail(email) {
+		return "", ErrInvalidEmail
+	}
+	if password == "" {
+		return "", ErrPasswordRequired
+	}
+	return email, nil
+}
+
+// Register creates an identity and adds it to a partition of its owner. An
+// email can only be registered once. The returned object holds the hash of
+// the password, never the password.
+func (i *Identity) Register(ownerID, creatorID, email, password string, protected bool) (*tables.Object, error) {
+
+	email, err := validate(email, password)
+	if err != nil {
+		return nil, err
+	}
+
+	identity, err := object.MakeIdentityObject(ownerID, creatorID, email, password, protected)
+	if err != nil {
+		return nil, err
+	}
+	if err := i.register(identity); err != nil {
+		return nil, err
+	}
+
+	return identity, nil
+}
+
+// register adds a new identity to a partition of its owner after reserving its key
+func (i *Identity) register(identity *tables.Object) error {
+	err := i.o.Retry(func(stop func()) error {
+		return i.db.Transact(func(dbTx patchain.DB, commit patchain.CommitFunc, rollback patchain.RollbackFunc) error {
+			dbOptions := []patchain.Option{&patchain.UseDBOption{DB: dbTx}}
+
+			// reserve the key so that concurrent registrations of the email fail
+			onceKey := &tables.OnceKey{ObjectID: identity.ID, Key: identity.Key, Timestamp: identity.Timestamp}
+			if err := i.db.Create(onceKey, dbOptions...); err != nil {
+				return err
+			}
+
+			return i.o.Put(identity, dbOptions...)
+		})
+	})
+	if err != nil {
+		if i.o.IsOnceKeyConflict(err) {
+			return ErrIdentityExists
+		}
+		return errors.Wrap(err, "failed to register identity")
+	}
+	return nil
+}
+
+// getOwner returns the owner of the identity of a key. It is the owner of the
+// object the key was reserved for when the identity was registered, so objects
+// other owners put with the same key are not taken for the identity.
+func (i *Identity) getOwner(key string) (string, error) {
+
+	var onceKey tables.OnceKey
+	if err := i.db.GetLast(&tables.OnceKey{Key: key}, &onceKey); err != nil {
+		return "", err
+	}
+
+	reserved, err := i.o.GetLast(&tables.Object{ID: onceKey.ObjectID})
+	if err != nil {
+		return "", err
+	}
+
+	return reserved.OwnerID, nil
+}
+
+// Get returns the latest version of the identity of an email. Only versions
+// of the owner the email was registered for are considered.
+func (i *Identity) Get(email string) (*tables.Object, error) {
+	key := object.MakeIdentityKey(normalizeEmail(email))
+	ownerID, err := i.getOwner(key)
+	if err != nil {
+		return nil, err
+	}
+	return i.o.GetLast(&tables.Object{OwnerID: ownerID, Key: key})
+}
+
+// Authenticate checks the password of an identity and returns
+// its latest version. ErrInvalidCredentials is returned if the
+// identity does not exist or the password does not match.
+func (i *Identity) Authenticate(email, password string) (*tables.Object, error) {
+
+	identity, err := i.Get(email)
+	if err != nil {
+		if err == patchain.ErrNotFound {
+			checkDummyPassword(password)
+			return nil, ErrInvalidCredentials
+		}
+		return nil, errors.Wrap(err, "failed to get identity")
+	}
+
+	info, err := object.GetIdentityInfo(identity)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.PasswordHash == "" {
+		checkDummyPassword(password)
+		return nil, ErrInvalidCredentials
+	}
+
+	ok, err := object.CheckPassword(info.PasswordHash, password)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrInvalidCredentials
+	}
+
+	return identity, nil
+}
+
+// NewVersion creates a copy of an identity that can be put as its new version
+func NewVersion(current *tables.Object) *tables.Object {
+	identity := *current
+	identity.ID, identity.PartitionID, identity.PrevHash, identity.PeerHash, identity.Hash = "", "", "", "", ""
+	identity.Timestamp = 0
+	identity.QueryParams = patchain.QueryParams{}
+	return identity.Init()
+}
+
+// ChangePassword checks the current password of an identity and appends
+// a new version of the identity holding the hash of the new password
+func (i *Identity) ChangePassword(email, password, newPassword string) (*tables.Object, error) {
+
+	if newPassword == "" {
+		return nil, ErrPasswordRequired
+	}
+
+	current, err := i.Authenticate(email, password)
+	if err != nil {
+		return nil, err
+	}
+
+	identity := NewVersion(current)
+	if err := object.SetIdentityPassword(identity, newPassword); err != nil {
+		return nil, err
+	}
+
+	if err := i.o.MustPut(identity); err != nil {
+		return nil, errors.Wrap(err, "failed to change password")
+	}
+
+	return identity, nil
+}
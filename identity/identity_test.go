@@ -0,0 +1,151 @@
+package identity
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/ellcrys/patchain/cockroach"
+	"github.com/ellcrys/patchain/object"
+	"github.com/ellcrys/util"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+var testDB *sql.DB
+
+var dbName = "test_" + strings.ToLower(util.RandString(5))
+var conStr = "postgresql://root@localhost:26257?sslmode=disable"
+var conStrWithDB = "postgresql://root@localhost:26257/" + dbName + "?sslmode=disable"
+
+func init() {
+	var err error
+	testDB, err = sql.Open("postgres", conStr)
+	if err != nil {
+		panic(fmt.Errorf("failed to connect to database: %s", err))
+	}
+}
+
+func createDb(t *testing.T) error {
+	_, err := testDB.Query(fmt.Sprintf("CREATE DATABASE %s;", dbName))
+	return err
+}
+
+func dropDB(t *testing.T) error {
+	_, err := testDB.Query(fmt.Sprintf("DROP DATABASE %s;", dbName))
+	return err
+}
+
+func TestIdentity(t *testing.T) {
+
+	if err := createDb(t); err != nil {
+		t.Fatalf("failed to create test database. %s", err)
+	}
+	defer dropDB(t)
+
+	cdb := cockroach.NewDB()
+	cdb.ConnectionString = conStrWithDB
+	cdb.NoLogging()
+	if err := cdb.Connect(10, 5); err != nil {
+		t.Fatalf("failed to connect to database. %s", err)
+	}
+
+	if err := cdb.CreateTables(); err != nil {
+		t.Fatalf("failed to create tables. %s", err)
+	}
+
+	obj := object.NewObject(cdb)
+	id := NewIdentity(cdb, obj)
+
+	Convey("Identity", t, func() {
+		ownerID := util.RandString(10)
+		email := strings.ToLower(util.RandString(10)) + "@example.com"
+		_, err := obj.CreatePartitions(1, ownerID, ownerID)
+		So(err, ShouldBeNil)
+
+		Convey(".Register", func() {
+			Convey("Should return error if email is invalid", func() {
+				_, err := id.Register(ownerID, ownerID, "not_an_email", "pass", false)
+				So(err, ShouldEqual, ErrInvalidEmail)
+			})
+
+			Convey("Should return error if password is empty", func() {
+				_, err := id.Register(ownerID, ownerID, email, "", false)
+				So(err, ShouldEqual, ErrPasswordRequired)
+			})
+
+			Convey("Should add the identity to a partition of the owner", func() {
+				identity, err := id.Register(ownerID, ownerID, strings.ToUpper(email), "pass", false)
+				So(err, ShouldBeNil)
+				So(identity.Key, ShouldEqual, object.MakeIdentityKey(email))
+				So(identity.PartitionID, ShouldNotBeEmpty)
+				So(identity.Value, ShouldNotContainSubstring, "pass\"")
+
+				Convey("Should not register the same email twice", func() {
+					_, err := id.Register(ownerID, ownerID, email, "pass2", false)
+					So(err, ShouldEqual, ErrIdentityExists)
+				})
+			})
+		})
+
+		Convey(".Authenticate", func() {
+			registered, err := id.Register(ownerID, ownerID, email, "pass", false)
+			So(err, ShouldBeNil)
+
+			Convey("Should return the identity if the password matches", func() {
+				identity, err := id.Authenticate(" "+strings.ToUpper(email), "pass")
+				So(err, ShouldBeNil)
+				So(identity.ID, ShouldEqual, registered.ID)
+			})
+
+			Convey("Should return error if the password does not match", func() {
+				_, err := id.Authenticate(email, "wrong_pass")
+				So(err, ShouldEqual, ErrInvalidCredentials)
+			})
+
+			Convey("Should return error if the identity does not exist", func() {
+				_, err := id.Authenticate("unknown_"+email, "pass")
+				So(err, ShouldEqual, ErrInvalidCredentials)
+			})
+
+			Convey("Should ignore identity objects of other owners", func() {
+				otherID := util.RandString(10)
+				_, err := obj.CreatePartitions(1, otherID, otherID)
+				So(err, ShouldBeNil)
+				shadow, err := object.MakeIdentityObject(otherID, otherID, email, "other_pass", false)
+				So(err, ShouldBeNil)
+				So(obj.Put(shadow), ShouldBeNil)
+
+				_, err = id.Authenticate(email, "other_pass")
+				So(err, ShouldEqual, ErrInvalidCredentials)
+				identity, err := id.Authenticate(email, "pass")
+				So(err, ShouldBeNil)
+				So(identity.ID, ShouldEqual, registered.ID)
+			})
+		})
+
+		Convey(".ChangePassword", func() {
+			registered, err := id.Register(ownerID, ownerID, email, "pass", false)
+			So(err, ShouldBeNil)
+
+			Convey("Should return error if the current password does not match", func() {
+				_, err := id.ChangePassword(email, "wrong_pass", "new_pass")
+				So(err, ShouldEqual, ErrInvalidCredentials)
+			})
+
+			Convey("Should append a new version of the identity", func() {
+				identity, err := id.ChangePassword(email, "pass", "new_pass")
+				So(err, ShouldBeNil)
+				So(identity.ID, ShouldNotEqual, registered.ID)
+				So(identity.Key, ShouldEqual, registered.Key)
+				So(identity.PrevHash, ShouldEqual, registered.Hash)
+
+				_, err = id.Authenticate(email, "pass")
+				So(err, ShouldEqual, ErrInvalidCredentials)
+				latest, err := id.Authenticate(email, "new_pass")
+				So(err, ShouldBeNil)
+				So(latest.ID, ShouldEqual, identity.ID)
+			})
+		})
+	})
+}
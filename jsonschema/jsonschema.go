@@ -0,0 +1,193 @@
+// Package jsonschema validates JSON documents against a JSON Schema. It wraps
+// github.com/santhosh-tekuri/jsonschema and supports drafts 4 to 2020-12;
+// schemas without $schema are read as draft 7. References ($ref) are resolved
+// within the schema. References to other documents are rejected so that
+// compiling a schema never reads files or makes network requests.
+package jsonschema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	lib "github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// schemaURL is the url of the schema being compiled. References to
+// other documents resolve to urls that cannot be loaded.
+const schemaURL = "patchain:///schema.json"
+
+// FieldError describes a value that does not match its schema
+type FieldError struct {
+
+	// Field is the path of the value (e.g address.lines[0]). It is
+	// empty if the error concerns the whole document.
+	Field string `json:"field"`
+
+	// Message describes the error
+	Message string `json:"message"`
+}
+
+func (e FieldError) String() string {
+	if e.Field == "" {
+		return e.Message
+	}
+	return fmt.Sprintf("%s: %s", e.Field, e.Message)
+}
+
+// ValidationError is returned when a document does not match a schema
+type ValidationError struct {
+	Errors []FieldError `json:"errors"`
+}
+
+func (e *ValidationError) Error() string {
+	var msgs []string
+	for _, fe := range e.Errors {
+		msgs = append(msgs, fe.String())
+	}
+	return "value does not match schema: " + strings.Join(msgs, "; ")
+}
+
+// Schema is a compiled JSON Schema
+type Schema struct {
+	schema *lib.Schema
+}
+
+// decode decodes JSON keeping numbers as json.Number
+func decode(data string, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader([]byte(data)))
+	dec.UseNumber()
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if dec.More() {
+		return fmt.Errorf("unexpected data after JSON value")
+	}
+	return nil
+}
+
+// Compile parses a JSON Schema
+func Compile(schemaJSON string) (*Schema, error) {
+
+	var doc interface{}
+	if err := decode(schemaJSON, &doc); err != nil {
+		return nil, fmt.Errorf("malformed schema")
+	}
+
+	c := lib.NewCompiler()
+	c.Draft = lib.Draft7
+	c.LoadURL = func(url string) (io.ReadCloser, error) {
+		return nil, fmt.Errorf("cannot load %s: references to other documents are not supported", url)
+	}
+	if err := c.AddResource(schemaURL, strings.NewReader(schemaJSON)); err != nil {
+		return nil, fmt.Errorf("malformed schema")
+	}
+
+	s, err := c.Compile(schemaURL)
+	if err != nil {
+		return nil, schemaErr(err, doc)
+	}
+
+	return &Schema{schema: s}, nil
+}
+
+// schemaErr describes why a decoded schema failed to compile
+func schemaErr(err error, doc interface{}) error {
+	if se, ok := err.(*lib.SchemaError); ok && se.Err != nil {
+		err = se.Err
+	}
+	if ve, ok := err.(*lib.ValidationError); ok {
+		var msgs []string
+		for _, fe := range leafErrors(ve, doc) {
+			msgs = append(msgs, fe.String())
+		}
+		return fmt.Errorf("invalid schema: %s", strings.Join(msgs, "; "))
+	}
+	return fmt.Errorf("invalid schema: %s", strings.TrimPrefix(err.Error(), "jsonschema: "))
+}
+
+// Validate checks that a JSON document matches the schema. It
+// returns a *ValidationError describing every mismatch.
+func (s *Schema) Validate(document string) error {
+	var doc interface{}
+	if err := decode(document, &doc); err != nil {
+		return &ValidationError{Errors: []FieldError{{Message: "is not valid JSON"}}}
+	}
+	err := s.schema.Validate(doc)
+	if err == nil {
+		return nil
+	}
+	ve, ok := err.(*lib.ValidationError)
+	if !ok {
+		return err
+	}
+	return &ValidationError{Errors: leafErrors(ve, doc)}
+}
+
+// leafErrors returns the errors at the leaves of a validation error sorted
+// by field. Paths are resolved against the document when it is set so that
+// array indexes are written as [i].
+func leafErrors(ve *lib.ValidationError, doc interface{}) []FieldError {
+
+	var errs []FieldError
+	seen := make(map[FieldError]bool)
+	var walk func(ve *lib.ValidationError)
+	walk = func(ve *lib.ValidationError) {
+		if len(ve.Causes) > 0 {
+			for _, cause := range ve.Causes {
+				walk(cause)
+			}
+			return
+		}
+		fe := FieldError{Field: fieldPath(ve.InstanceLocation, doc), Message: ve.Message}
+		if !seen[fe] {
+			seen[fe] = true
+			errs = append(errs, fe)
+		}
+	}
+	walk(ve)
+
+	sort.SliceStable(errs, func(i, j int) bool {
+		return errs[i].Field < errs[j].Field
+	})
+	return errs
+}
+
+// fieldPath converts a JSON pointer to a path like address.lines[0]
+func fieldPath(pointer string, doc interface{}) string {
+
+	if pointer == "" {
+		return ""
+	}
+
+	var path string
+	v := doc
+	for _, token := range strings.Split(strings.TrimPrefix(pointer, "/"), "/") {
+		token = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+		switch val := v.(type) {
+		case []interface{}:
+			i, _ := strconv.Atoi(token)
+			path += fmt.Sprintf("[%d]", i)
+			if i >= 0 && i < len(val) {
+				v = val[i]
+			} else {
+				v = nil
+			}
+			continue
+		case map[string]interface{}:
+			v = val[token]
+		default:
+			v = nil
+		}
+		if path != "" {
+			path += "."
+		}
+		path += token
+	}
+
+	return path
+}
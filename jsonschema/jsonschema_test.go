@@ -0,0 +1,142 @@
+package jsonschema
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// fieldErrors validates a document and returns its field errors
+func fieldErrors(s *Schema, document string) []FieldError {
+	err := s.Validate(document)
+	if err == nil {
+		return nil
+	}
+	return err.(*ValidationError).Errors
+}
+
+func TestJSONSchema(t *testing.T) {
+	Convey("JSONSchema", t, func() {
+
+		Convey(".Compile", func() {
+			Convey("Should return error if schema is invalid", func() {
+				cases := map[string]string{
+					`{`:                               "malformed schema",
+					`1`:                               "invalid schema: expected object or boolean, but got number",
+					`{"$ref":"#/definitions/a"}`:      "invalid schema: patchain:///schema.json#/definitions/a not found",
+					`{"minLength":-1}`:                "invalid schema: minLength: must be >= 0 but found -1",
+					`{"pattern":"("}`:                 "invalid schema: pattern: '(' is not valid 'regex'",
+					`{"items":[{"type":"string"},3]}`: "invalid schema: items: expected object or boolean, but got array; items[1]: expected object or boolean, but got number",
+				}
+				for schema, msg := range cases {
+					_, err := Compile(schema)
+					So(err, ShouldNotBeNil)
+					So(err.Error(), ShouldEqual, msg)
+				}
+			})
+
+			Convey("Should resolve references within the schema", func() {
+				s, err := Compile(`{"definitions": {"id": {"type": "string"}}, "properties": {"id": {"$ref": "#/definitions/id"}}}`)
+				So(err, ShouldBeNil)
+				So(s.Validate(`{"id":"a"}`), ShouldBeNil)
+				So(fieldErrors(s, `{"id":1}`), ShouldResemble, []FieldError{{Field: "id", Message: "expected string, but got number"}})
+			})
+
+			Convey("Should not load other documents", func() {
+				for _, ref := range []string{"http://example.com/schema.json", "other.json"} {
+					_, err := Compile(`{"$ref":"` + ref + `"}`)
+					So(err, ShouldNotBeNil)
+					So(err.Error(), ShouldContainSubstring, "references to other documents are not supported")
+				}
+			})
+		})
+
+		Convey(".Validate", func() {
+			s, err := Compile(`{
+				"type": "object",
+				"required": ["name", "age"],
+				"additionalProperties": false,
+				"properties": {
+					"name": {"type": "string", "minLength": 2},
+					"age": {"type": "integer", "minimum": 0},
+					"email": {"type": "string", "format": "email"},
+					"role": {"enum": ["admin", "user"]},
+					"tags": {"type": "array", "items": {"type": "string"}, "uniqueItems": true}
+				}
+			}`)
+			So(err, ShouldBeNil)
+
+			Convey("Should return nil if document is valid", func() {
+				So(s.Validate(`{"name":"lana","age":30,"email":"lana@example.com","role":"admin","tags":["a","b"]}`), ShouldBeNil)
+			})
+
+			Convey("Should accept integers written as decimals", func() {
+				So(s.Validate(`{"name":"lana","age":30.0}`), ShouldBeNil)
+			})
+
+			Convey("Should return error if document is not valid JSON", func() {
+				So(fieldErrors(s, `{"name"`), ShouldResemble, []FieldError{{Message: "is not valid JSON"}})
+			})
+
+			Convey("Should return field-level errors", func() {
+				errs := fieldErrors(s, `{"name":"l","age":-1.5,"email":"lana","role":"root","tags":["a",1,"a"],"extra":true}`)
+				So(errs, ShouldResemble, []FieldError{
+					{Message: "additionalProperties 'extra' not allowed"},
+					{Field: "age", Message: "expected integer, but got number"},
+					{Field: "email", Message: "'lana' is not valid 'email'"},
+					{Field: "name", Message: "length must be >= 2, but got 1"},
+					{Field: "role", Message: `value must be one of "admin", "user"`},
+					{Field: "tags", Message: "items at index 0 and 2 are equal"},
+					{Field: "tags[1]", Message: "expected string, but got number"},
+				})
+			})
+
+			Convey("Should report missing required properties", func() {
+				err := s.Validate(`{}`)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "value does not match schema: missing properties: 'name', 'age'")
+			})
+		})
+
+		Convey("Should validate numeric constraints", func() {
+			s, err := Compile(`{"exclusiveMinimum": 0, "maximum": 10, "multipleOf": 0.5}`)
+			So(err, ShouldBeNil)
+			So(s.Validate(`2.5`), ShouldBeNil)
+			So(fieldErrors(s, `0`), ShouldResemble, []FieldError{{Message: "must be > 0 but found 0"}})
+			So(fieldErrors(s, `10.5`), ShouldResemble, []FieldError{{Message: "must be <= 10 but found 10.5"}})
+			So(fieldErrors(s, `1.2`), ShouldResemble, []FieldError{{Message: "1.2 not multipleOf 0.5"}})
+		})
+
+		Convey("Should validate combinators", func() {
+			s, err := Compile(`{"anyOf": [{"type": "string"}, {"type": "null"}], "not": {"const": "none"}}`)
+			So(err, ShouldBeNil)
+			So(s.Validate(`null`), ShouldBeNil)
+			So(fieldErrors(s, `1`), ShouldResemble, []FieldError{{Message: "expected string, but got number"}, {Message: "expected null, but got number"}})
+			So(fieldErrors(s, `"none"`), ShouldResemble, []FieldError{{Message: "not failed"}})
+
+			s, err = Compile(`{"oneOf": [{"type": "integer"}, {"minimum": 5}]}`)
+			So(err, ShouldBeNil)
+			So(s.Validate(`3`), ShouldBeNil)
+			So(fieldErrors(s, `7`), ShouldResemble, []FieldError{{Message: "valid against schemas at indexes 0 and 1"}})
+		})
+
+		Convey("Should validate nested paths and pattern properties", func() {
+			s, err := Compile(`{
+				"properties": {"address": {"required": ["city"], "properties": {"lines": {"items": {"maxLength": 3}}}}},
+				"patternProperties": {"^x-": {"type": "boolean"}}
+			}`)
+			So(err, ShouldBeNil)
+			So(fieldErrors(s, `{"address":{"lines":["abcd"]},"x-a":1}`), ShouldResemble, []FieldError{
+				{Field: "address", Message: "missing properties: 'city'"},
+				{Field: "address.lines[0]", Message: "length must be <= 3, but got 4"},
+				{Field: "x-a", Message: "expected boolean, but got number"},
+			})
+		})
+
+		Convey("Should support boolean schemas", func() {
+			s, err := Compile(`false`)
+			So(err, ShouldBeNil)
+			So(fieldErrors(s, `{}`), ShouldResemble, []FieldError{{Message: "not allowed"}})
+		})
+	})
+}
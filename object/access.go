@@ -0,0 +1,344 @@
+package object
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ellcrys/patchain"
+	"github.com/ellcrys/patchain/cockroach/tables"
+	"github.com/pkg/errors"
+)
+
+// Permissions that an owner can grant to another identity
+const (
+	// PermRead allows reading the protected objects of the owner
+	PermRead = "read"
+
+	// PermWrite allows adding objects to the partitions of the owner
+	PermWrite = "write"
+
+	// PermOverride allows superseding the protected objects of the owner
+	PermOverride = "override"
+)
+
+var (
+	// Permissions lists the permissions that can be granted
+	Permissions = []string{PermRead, PermWrite, PermOverride}
+
+	// ErrPermissionDenied indicates that the acting identity is not allowed to perform an operation
+	ErrPermissionDenied = fmt.Errorf("permission denied")
+
+	// ErrCreatorMismatch indicates that an object's creator is not the acting identity
+	ErrCreatorMismatch = fmt.Errorf("creator is not the acting identity")
+
+	// ErrNoGrant indicates that an identity has no active grant from an owner
+	ErrNoGrant = fmt.Errorf("grant not found")
+)
+
+// ActorOptionName is the name of the ActorOption
+var ActorOptionName = "actor"
+
+// ActorOption sets the identity on whose behalf an operation is performed.
+// Operations without it are not subject to access control.
+type ActorOption struct {
+	ID string
+}
+
+// GetName returns the option's name
+func (t *ActorOption) GetName() string {
+	return ActorOptionName
+}
+
+// GetValue returns the id of the acting identity
+func (t *ActorOption) GetValue() interface{} {
+	return t.ID
+}
+
+// ActingAs creates an option that performs an operation on behalf of an identity.
+// Writes are only allowed into the partitions of the identity or of owners that
+// granted it PermWrite. Protected objects are only returned to their owner or
+// to identities granted PermRead.
+func ActingAs(identityID string) *ActorOption {
+	return &ActorOption{ID: identityID}
+}
+
+// getActorID returns the id of the acting identity included in the options
+func getActorID(options []patchain.Option) string {
+	for _, option := range options {
+		if option.GetName() == ActorOptionName {
+			return option.(*ActorOption).ID
+		}
+	}
+	return ""
+}
+
+// withoutActor returns the options without the actor option. Operations
+// performed internally once access has been checked use them.
+func withoutActor(options []patchain.Option) []patchain.Option {
+	var filtered []patchain.Option
+	for _, option := range options {
+		if option.GetName() != ActorOptionName {
+			filtered = append(filtered, option)
+		}
+	}
+	return filtered
+}
+
+// isPermission checks whether a permission can be granted
+func isPermission(permission string) bool {
+	for _, p := range Permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// GetGrant returns the latest grant an owner gave to an identity.
+// ErrNoGrant is returned if there is none or it has been revoked.
+func (o *Object) GetGrant(ownerID, granteeID string, options ...patchain.Option) (*GrantInfo, error) {
+	if ownerID == "" || granteeID == "" {
+		return nil, ErrNoGrant
+	}
+	grantObj, err := o.GetLast(&tables.Object{OwnerID: ownerID, Key: MakeGrantKey(granteeID)}, withoutActor(options)...)
+	if err != nil {
+		if err == patchain.ErrNotFound {
+			return nil, ErrNoGrant
+		}
+		return nil, errors.Wrap(err, "failed to get grant")
+	}
+	grant, err := GetGrantInfo(grantObj)
+	if err != nil {
+		return nil, err
+	}
+	if grant.Revoked {
+		return nil, ErrNoGrant
+	}
+	return grant, nil
+}
+
+// HasPermission checks whether an identity has a permission on the objects of an
+// owner. Owners have all permissions on their objects.
+func (o *Object) HasPermission(ownerID, identityID, permission string, options ...patchain.Option) (bool, error) {
+	if identityID == ownerID {
+		return true, nil
+	}
+	grant, err := o.GetGrant(ownerID, identityID, options...)
+	if err != nil {
+		if err == ErrNoGrant {
+			return false, nil
+		}
+		return false, err
+	}
+	return grant.Has(permission), nil
+}
+
+// putGrant appends a new version of the grant an owner gave to an identity.
+// Each version refers to the previous version in Ref1.
+func (o *Object) putGrant(ownerID, granteeID string, grant *GrantInfo, options []patchain.Option) (*tables.Object, error) {
+
+	if actorID := getActorID(options); actorID != "" && actorID != ownerID {
+		return nil, ErrPermissionDenied
+	}
+
+	grantObj := MakeGrantObject(ownerID, granteeID, grant)
+	dbTx, dbOptions, finish := o.getDBOptions(options)
+	err := o.db.TransactWithDB(dbTx, finish, func(dbTx patchain.DB, commit patchain.CommitFunc, rollback patchain.RollbackFunc) error {
+
+		current, err := o.GetLast(&tables.Object{OwnerID: ownerID, Key: grantObj.Key}, dbOptions...)
+		if err != nil && err != patchain.ErrNotFound {
+			return errors.Wrap(err, "failed to get current grant")
+		}
+
+		if current != nil {
+			grantObj.Ref1 = current.ID
+		} else if grant.Revoked {
+			return ErrNoGrant
+		}
+
+		return o.Put(grantObj, &patchain.UseDBOption{DB: dbTx})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return grantObj, nil
+}
+
+// Grant gives an identity permissions on the objects of an owner. The
+// permissions replace those of any previous grant to the identity.
+func (o *Object) Grant(ownerID, granteeID string, permissions []string, options ...patchain.Option) (*tables.Object, error) {
+
+	if granteeID == "" || granteeID == ownerID {
+		return nil, fmt.Errorf("grantee must be another identity")
+	}
+
+	if len(permissions) == 0 {
+		return nil, fmt.Errorf("no permission to grant")
+	}
+
+	for _, p := range permissions {
+		if !isPermission(p) {
+			return nil, fmt.Errorf("unknown permission: %s", p)
+		}
+	}
+
+	grantObj, err := o.putGrant(ownerID, granteeID, &GrantInfo{Permissions: permissions}, options)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to grant permissions")
+	}
+
+	return grantObj, nil
+}
+
+// RevokeGrant revokes the grant an owner gave to an identity
+func (o *Object) RevokeGrant(ownerID, granteeID string, options ...patchain.Option) (*tables.Object, error) {
+	if _, err := o.GetGrant(ownerID, granteeID, options...); err != nil {
+		return nil, err
+	}
+	grantObj, err := o.putGrant(ownerID, granteeID, &GrantInfo{Revoked: true}, options)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to revoke grant")
+	}
+	return grantObj, nil
+}
+
+// authorizeWrite checks that the acting identity can add objects to the
+// partitions of an owner. Objects without a creator are assigned the
+// acting identity. Keys starting with $ can only be written by the owner,
+// except tombstones which can be written by any identity that can write.
+func (o *Object) authorizeWrite(ownerID, actorID string, objects []*tables.Object, options []patchain.Option) error {
+
+	if ownerID == "" {
+		return ErrPermissionDenied
+	}
+
+	for i, obj := range objects {
+		if obj.CreatorID == "" {
+			obj.CreatorID = actorID
+		} else if obj.CreatorID != actorID {
+			return errors.Wrapf(ErrCreatorMismatch, "object %d", i)
+		}
+		if actorID != ownerID && strings.HasPrefix(obj.Key, "$") && !strings.HasPrefix(obj.Key, TombstonePrefix) {
+			return ErrPermissionDenied
+		}
+	}
+
+	allowed, err := o.HasPermission(ownerID, actorID, PermWrite, options...)
+	if err != nil {
+		return err
+	} else if !allowed {
+		return ErrPermissionDenied
+	}
+
+	return nil
+}
+
+// authorizeOwners checks that the acting identity, if any, can write to the
+// partitions of every owner. It guards the operations that change partitions
+// without Put, such as sealing, archiving, restoring and importing.
+func (o *Object) authorizeOwners(ownerIDs []string, actorID string, options []patchain.Option) error {
+
+	if actorID == "" {
+		return nil
+	}
+
+	checked := make(map[string]bool)
+	for _, ownerID := range ownerIDs {
+		if checked[ownerID] {
+			continue
+		} else if ownerID == "" {
+			return ErrPermissionDenied
+		}
+		allowed, err := o.HasPermission(ownerID, actorID, PermWrite, options...)
+		if err != nil {
+			return err
+		} else if !allowed {
+			return ErrPermissionDenied
+		}
+		checked[ownerID] = true
+	}
+
+	return nil
+}
+
+// authorizeImport checks that the acting identity, if any, can import objects
+// into the partitions of their owners. As in authorizeWrite, keys starting with
+// $ can only be imported by the owner, except tombstones.
+func (o *Object) authorizeImport(objects []*tables.Object, actorID string, options []patchain.Option) error {
+
+	if actorID == "" {
+		return nil
+	}
+
+	var ownerIDs []string
+	for _, obj := range objects {
+		if actorID != obj.OwnerID && strings.HasPrefix(obj.Key, "$") && !strings.HasPrefix(obj.Key, TombstonePrefix) {
+			return ErrPermissionDenied
+		}
+		ownerIDs = append(ownerIDs, obj.OwnerID)
+	}
+
+	return o.authorizeOwners(ownerIDs, actorID, options)
+}
+
+// authorizeRead checks that an identity can read an object. Any
+// object can be read if there is no acting identity.
+func (o *Object) authorizeRead(obj *tables.Object, actorID string, options []patchain.Option) error {
+	if actorID == "" || !obj.Protected {
+		return nil
+	}
+	allowed, err := o.HasPermission(obj.OwnerID, actorID, PermRead, options...)
+	if err != nil {
+		return err
+	} else if !allowed {
+		return ErrPermissionDenied
+	}
+	return nil
+}
+
+// authorizeReadAll checks that an identity can read every object of a
+// list. Permissions are checked once per owner of a protected object.
+func (o *Object) authorizeReadAll(objs []*tables.Object, actorID string, options []patchain.Option) error {
+	checked := make(map[string]bool)
+	for _, obj := range objs {
+		if !obj.Protected || checked[obj.OwnerID] {
+			continue
+		}
+		if err := o.authorizeRead(obj, actorID, options); err != nil {
+			return err
+		}
+		checked[obj.OwnerID] = true
+	}
+	return nil
+}
+
+// readableOwners returns the owners whose protected objects an identity can read
+func (o *Object) readableOwners(identityID string, options []patchain.Option) ([]string, error) {
+
+	grantObjs, err := o.All(&tables.Object{Key: MakeGrantKey(identityID), QueryParams: patchain.QueryParams{
+		OrderBy: "timestamp asc",
+	}}, withoutActor(options)...)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get grants")
+	}
+
+	// keep the latest grant of every owner
+	latest := make(map[string]*tables.Object)
+	for _, grantObj := range grantObjs {
+		latest[grantObj.OwnerID] = grantObj
+	}
+
+	owners := []string{identityID}
+	for ownerID, grantObj := range latest {
+		grant, err := GetGrantInfo(grantObj)
+		if err != nil {
+			return nil, err
+		}
+		if grant.Has(PermRead) {
+			owners = append(owners, ownerID)
+		}
+	}
+
+	return owners, nil
+}
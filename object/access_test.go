@@ -0,0 +1,173 @@
+package object
+
+import (
+	"testing"
+
+	"github.com/ellcrys/patchain/cockroach"
+	"github.com/ellcrys/patchain/cockroach/tables"
+	"github.com/ellcrys/util"
+	"github.com/pkg/errors"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAccessControl(t *testing.T) {
+
+	if err := createDb(t); err != nil {
+		t.Fatalf("failed to create test database. %s", err)
+	}
+	defer dropDB(t)
+
+	cdb := cockroach.NewDB()
+	cdb.ConnectionString = conStrWithDB
+	cdb.NoLogging()
+	if err := cdb.Connect(10, 5); err != nil {
+		t.Fatalf("failed to connect to database. %s", err)
+	}
+
+	if err := cdb.CreateTables(); err != nil {
+		t.Fatalf("failed to create tables. %s", err)
+	}
+
+	obj := NewObject(cdb)
+
+	Convey("Access control", t, func() {
+		ownerID := util.RandString(10)
+		creatorID := util.RandString(10)
+		_, err := obj.CreatePartitions(1, ownerID, ownerID)
+		So(err, ShouldBeNil)
+
+		Convey(".Grant", func() {
+			Convey("Should return error if permission is unknown", func() {
+				_, err := obj.Grant(ownerID, creatorID, []string{"delete"})
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "unknown permission: delete")
+			})
+
+			Convey("Should return error if the acting identity is not the owner", func() {
+				_, err := obj.Grant(ownerID, creatorID, []string{PermWrite}, ActingAs(creatorID))
+				So(errors.Cause(err), ShouldEqual, ErrPermissionDenied)
+			})
+
+			Convey("Should chain grants and revocations", func() {
+				g1, err := obj.Grant(ownerID, creatorID, []string{PermWrite}, ActingAs(ownerID))
+				So(err, ShouldBeNil)
+				g2, err := obj.Grant(ownerID, creatorID, []string{PermRead, PermWrite})
+				So(err, ShouldBeNil)
+				So(g2.Ref1, ShouldEqual, g1.ID)
+
+				grant, err := obj.GetGrant(ownerID, creatorID)
+				So(err, ShouldBeNil)
+				So(grant.Permissions, ShouldResemble, []string{PermRead, PermWrite})
+
+				revocation, err := obj.RevokeGrant(ownerID, creatorID)
+				So(err, ShouldBeNil)
+				So(revocation.Ref1, ShouldEqual, g2.ID)
+				_, err = obj.GetGrant(ownerID, creatorID)
+				So(err, ShouldEqual, ErrNoGrant)
+			})
+		})
+
+		Convey(".RevokeGrant", func() {
+			Convey("Should return ErrNoGrant if there is no active grant", func() {
+				_, err := obj.RevokeGrant(ownerID, creatorID)
+				So(err, ShouldEqual, ErrNoGrant)
+			})
+		})
+
+		Convey(".Put", func() {
+			Convey("Should allow the owner and set the creator", func() {
+				o := &tables.Object{OwnerID: ownerID, Key: "a"}
+				So(obj.Put(o, ActingAs(ownerID)), ShouldBeNil)
+				So(o.CreatorID, ShouldEqual, ownerID)
+			})
+
+			Convey("Should deny an identity without a write grant", func() {
+				err := obj.Put(&tables.Object{OwnerID: ownerID, Key: "a"}, ActingAs(creatorID))
+				So(errors.Cause(err), ShouldEqual, ErrPermissionDenied)
+
+				_, err = obj.Grant(ownerID, creatorID, []string{PermRead})
+				So(err, ShouldBeNil)
+				err = obj.Put(&tables.Object{OwnerID: ownerID, Key: "a"}, ActingAs(creatorID))
+				So(errors.Cause(err), ShouldEqual, ErrPermissionDenied)
+			})
+
+			Convey("Should allow an identity with a write grant until it is revoked", func() {
+				_, err := obj.Grant(ownerID, creatorID, []string{PermWrite})
+				So(err, ShouldBeNil)
+				o := &tables.Object{OwnerID: ownerID, Key: "a"}
+				So(obj.Put(o, ActingAs(creatorID)), ShouldBeNil)
+				So(o.CreatorID, ShouldEqual, creatorID)
+
+				_, err = obj.RevokeGrant(ownerID, creatorID)
+				So(err, ShouldBeNil)
+				err = obj.Put(&tables.Object{OwnerID: ownerID, Key: "a"}, ActingAs(creatorID))
+				So(errors.Cause(err), ShouldEqual, ErrPermissionDenied)
+			})
+
+			Convey("Should deny grantees writing reserved keys", func() {
+				_, err := obj.Grant(ownerID, creatorID, []string{PermWrite})
+				So(err, ShouldBeNil)
+				err = obj.Put(MakeGrantObject(ownerID, creatorID, &GrantInfo{Permissions: []string{PermRead}}), ActingAs(creatorID))
+				So(err, ShouldNotBeNil)
+			})
+
+			Convey("Should return error if the creator is not the acting identity", func() {
+				err := obj.Put(&tables.Object{OwnerID: ownerID, CreatorID: creatorID, Key: "a"}, ActingAs(ownerID))
+				So(errors.Cause(err), ShouldEqual, ErrCreatorMismatch)
+			})
+		})
+
+		Convey(".CreatePartitions", func() {
+			Convey("Should deny an identity without a write grant", func() {
+				_, err := obj.CreatePartitions(1, ownerID, "", ActingAs(creatorID))
+				So(err, ShouldEqual, ErrPermissionDenied)
+			})
+		})
+
+		Convey(".CreateOnce", func() {
+			Convey("Should deny an identity without a write grant", func() {
+				_, err := obj.CreateOnce(&tables.Object{OwnerID: ownerID, Key: util.RandString(10)}, ActingAs(creatorID))
+				So(errors.Cause(err), ShouldEqual, ErrPermissionDenied)
+			})
+		})
+
+		Convey("Reading protected objects", func() {
+			key := util.RandString(10)
+			So(obj.Put([]*tables.Object{
+				{OwnerID: ownerID, Key: key, Value: "public"},
+				{OwnerID: ownerID, Key: key, Value: "secret", Protected: true},
+			}), ShouldBeNil)
+
+			Convey("Should return protected objects to their owner", func() {
+				objs, err := obj.All(&tables.Object{Key: key}, ActingAs(ownerID))
+				So(err, ShouldBeNil)
+				So(objs, ShouldHaveLength, 2)
+			})
+
+			Convey("Should exclude protected objects for identities without a read grant", func() {
+				objs, err := obj.All(&tables.Object{Key: key}, ActingAs(creatorID))
+				So(err, ShouldBeNil)
+				So(objs, ShouldHaveLength, 1)
+				So(objs[0].Value, ShouldEqual, "public")
+
+				last, err := obj.GetLast(&tables.Object{Key: key}, ActingAs(creatorID))
+				So(err, ShouldBeNil)
+				So(last.Value, ShouldEqual, "public")
+			})
+
+			Convey("Should return protected objects to identities with a read grant", func() {
+				_, err := obj.Grant(ownerID, creatorID, []string{PermRead})
+				So(err, ShouldBeNil)
+				objs, err := obj.All(&tables.Object{Key: key}, ActingAs(creatorID))
+				So(err, ShouldBeNil)
+				So(objs, ShouldHaveLength, 2)
+			})
+
+			Convey("Should not restrict reads without an acting identity", func() {
+				objs, err := obj.All(&tables.Object{Key: key})
+				So(err, ShouldBeNil)
+				So(objs, ShouldHaveLength, 2)
+			})
+		})
+	})
+}
@@ -0,0 +1,312 @@
+package object
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/ellcrys/patchain"
+	"github.com/ellcrys/patchain/cockroach/tables"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ArchiveKey is the key of the stub object left in an archived partition
+	ArchiveKey = "$archive"
+
+	// ArchiveVersion is the version of the archive file format
+	ArchiveVersion = "1"
+
+	// HashAlgorithm is the algorithm used to compute object hashes
+	HashAlgorithm = "sha256"
+)
+
+// PartitionArchived describes a partition whose objects have been archived
+const PartitionArchived = "archived"
+
+// ArchiveHeader is the first line of an archive file
+type ArchiveHeader struct {
+	ArchiveVersion string         `json:"archive_version"`
+	HashAlgorithm  string         `json:"hash_algorithm"`
+	Partition      *tables.Object `json:"partition"`
+	Length         int64          `json:"length"`
+	HeadHash       string         `json:"head_hash"`
+	MerkleRoot     string         `json:"merkle_root"`
+}
+
+// ArchiveInfo describes an archive. It is stored in the stub
+// object that replaces the objects of an archived partition.
+type ArchiveInfo struct {
+	Digest     string `json:"digest"`
+	Length     int64  `json:"length"`
+	HeadHash   string `json:"head_hash"`
+	MerkleRoot string `json:"merkle_root"`
+}
+
+// GetArchiveInfo decodes the archive information stored in an archive stub object
+func GetArchiveInfo(stub *tables.Object) (*ArchiveInfo, error) {
+	if stub.Key != ArchiveKey {
+		return nil, fmt.Errorf("not an archive object")
+	}
+	var info ArchiveInfo
+	if err := json.Unmarshal([]byte(stub.Value), &info); err != nil {
+		return nil, errors.Wrap(err, "malformed archive object")
+	}
+	return &info, nil
+}
+
+// getPartitionObjects returns the objects of a partition ordered from the oldest to the most recent
+func (o *Object) getPartitionObjects(partitionID string, options []patchain.Option) ([]*tables.Object, error) {
+	return o.all(&tables.Object{PartitionID: partitionID, QueryParams: patchain.QueryParams{OrderBy: "timestamp asc"}}, options)
+}
+
+// ArchivePartition exports the objects of a sealed partition to w and replaces them with
+// a stub object holding the digest of the archive. The archive is a JSON Lines file whose
+// first line is the archive header and every other line is an object of the partition.
+// The partition is verified before it is archived. If an identity is acting, it must be
+// allowed to write for the owner of the partition. A partition holding protected objects
+// can only be archived if WithOverride is passed and, when an identity is acting, it has
+// been granted PermOverride by the owner.
+func (o *Object) ArchivePartition(partitionID string, w io.Writer, options ...patchain.Option) (*ArchiveInfo, error) {
+	var info *ArchiveInfo
+	dbTx, dbOptions, finish := o.getDBOptions(options)
+	err := o.db.TransactWithDB(dbTx, finish, func(dbTx patchain.DB, commit patchain.CommitFunc, rollback patchain.RollbackFunc) error {
+
+		partition, err := o.GetLast(&tables.Object{ID: partitionID, QueryParams: patchain.KeyStartsWith(PartitionPrefix)}, dbOptions...)
+		if err != nil {
+			return errors.Wrap(err, "failed to get partition")
+		}
+
+		if err := o.authorizeOwners([]string{partition.OwnerID}, getActorID(options), dbOptions); err != nil {
+			return err
+		}
+
+		objs, err := o.getPartitionObjects(partition.ID, dbOptions)
+		if err != nil {
+			return errors.Wrap(err, "failed to get partition objects")
+		}
+
+		if len(objs) == 0 || objs[len(objs)-1].Key != SealKey {
+			return fmt.Errorf("partition is not sealed")
+		}
+
+		if err := VerifyPartition(partition, objs); err != nil {
+			return errors.Wrap(err, "partition failed verification")
+		}
+
+		if err := o.authorizeArchive(partition.OwnerID, objs, options, dbOptions); err != nil {
+			return err
+		}
+
+		archive, header, err := makeArchive(partition, objs)
+		if err != nil {
+			return err
+		}
+
+		digest := sha256.Sum256(archive)
+		info = &ArchiveInfo{
+			Digest:     hex.EncodeToString(digest[:]),
+			Length:     header.Length,
+			HeadHash:   header.HeadHash,
+			MerkleRoot: header.MerkleRoot,
+		}
+
+		if _, err := w.Write(archive); err != nil {
+			return errors.Wrap(err, "failed to write archive")
+		}
+
+		if err := o.db.Delete(&tables.Object{PartitionID: partition.ID}, dbOptions...); err != nil {
+			return errors.Wrap(err, "failed to delete partition objects")
+		}
+
+		infoJSON, _ := json.Marshal(info)
+		stub := &tables.Object{
+			OwnerID:     partition.OwnerID,
+			CreatorID:   partition.CreatorID,
+			PartitionID: partition.ID,
+			Key:         ArchiveKey,
+			Value:       string(infoJSON),
+			PrevHash:    header.HeadHash,
+		}
+		if err := o.db.Create(stub.Init().ComputeHash(), dbOptions...); err != nil {
+			return errors.Wrap(err, "failed to create archive stub")
+		}
+
+		return nil
+	})
+	return info, errors.Wrap(err, "failed to archive partition")
+}
+
+// authorizeArchive checks that the objects of a partition can be removed
+// from the objects table. Protected objects require an override.
+func (o *Object) authorizeArchive(ownerID string, objs []*tables.Object, options []patchain.Option, dbOptions []patchain.Option) error {
+	for _, obj := range objs {
+		if !obj.Protected {
+			continue
+		}
+		if !hasOption(options, OverrideOptionName) {
+			return ErrProtected
+		}
+		if actorID := getActorID(options); actorID != "" {
+			allowed, err := o.HasPermission(ownerID, actorID, PermOverride, dbOptions...)
+			if err != nil {
+				return err
+			} else if !allowed {
+				return ErrPermissionDenied
+			}
+		}
+		return nil
+	}
+	return nil
+}
+
+// makeArchive creates the content of an archive file
+func makeArchive(partition *tables.Object, objs []*tables.Object) ([]byte, *ArchiveHeader, error) {
+
+	var hashes []string
+	for _, obj := range objs {
+		hashes = append(hashes, obj.Hash)
+	}
+
+	header := &ArchiveHeader{
+		ArchiveVersion: ArchiveVersion,
+		HashAlgorithm:  HashAlgorithm,
+		Partition:      partition,
+		Length:         int64(len(objs)),
+		HeadHash:       objs[len(objs)-1].Hash,
+		MerkleRoot:     MerkleRoot(hashes),
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	if err := enc.Encode(header); err != nil {
+		return nil, nil, errors.Wrap(err, "failed to encode archive header")
+	}
+	for _, obj := range objs {
+		if err := enc.Encode(obj); err != nil {
+			return nil, nil, errors.Wrap(err, "failed to encode object")
+		}
+	}
+
+	return buf.Bytes(), header, nil
+}
+
+// readArchive decodes the content of an archive file
+func readArchive(archive []byte) (*ArchiveHeader, []*tables.Object, error) {
+
+	var header ArchiveHeader
+	var objs []*tables.Object
+
+	// the archive is split in memory so that lines have no size limit
+	for i, data := range bytes.Split(bytes.TrimSuffix(archive, []byte("\n")), []byte("\n")) {
+		line := i + 1
+		if line == 1 {
+			if err := json.Unmarshal(data, &header); err != nil {
+				return nil, nil, fmt.Errorf("line 1: malformed archive header")
+			}
+			continue
+		}
+		var obj tables.Object
+		if err := json.Unmarshal(data, &obj); err != nil {
+			return nil, nil, fmt.Errorf("line %d: malformed object", line)
+		}
+		objs = append(objs, &obj)
+	}
+
+	if header.ArchiveVersion != ArchiveVersion {
+		return nil, nil, fmt.Errorf("unsupported archive version")
+	} else if header.HashAlgorithm != HashAlgorithm {
+		return nil, nil, fmt.Errorf("unsupported hash algorithm")
+	} else if header.Partition == nil {
+		return nil, nil, fmt.Errorf("archive has no partition")
+	}
+
+	return &header, objs, nil
+}
+
+// RestorePartition re-imports the objects of an archived partition. The archive is verified
+// against the digest held by the partition's archive stub and the partition and its objects
+// are verified before they are imported. The stub is removed once the objects are restored.
+// If an identity is acting, it must be allowed to write for the owner of the partition.
+// The restored objects are not charged to the quota of the owner as archiving a partition
+// does not release the usage of its objects (see GetUsage).
+func (o *Object) RestorePartition(r io.Reader, options ...patchain.Option) (*tables.Object, error) {
+
+	archive, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read archive")
+	}
+
+	header, objs, err := readArchive(archive)
+	if err != nil {
+		return nil, err
+	}
+
+	var partition *tables.Object
+	dbTx, dbOptions, finish := o.getDBOptions(options)
+	err = o.db.TransactWithDB(dbTx, finish, func(dbTx patchain.DB, commit patchain.CommitFunc, rollback patchain.RollbackFunc) error {
+
+		partition, err = o.GetLast(&tables.Object{ID: header.Partition.ID, QueryParams: patchain.KeyStartsWith(PartitionPrefix)}, dbOptions...)
+		if err != nil {
+			return errors.Wrap(err, "failed to get partition")
+		}
+
+		if err := o.authorizeOwners([]string{partition.OwnerID}, getActorID(options), dbOptions); err != nil {
+			return err
+		}
+
+		if partition.Hash != header.Partition.Hash {
+			return fmt.Errorf("archive partition does not match the stored partition")
+		}
+
+		stub, err := o.getLast(&tables.Object{PartitionID: partition.ID}, dbOptions)
+		if err != nil && err != patchain.ErrNotFound {
+			return errors.Wrap(err, "failed to get archive stub")
+		} else if stub == nil || stub.Key != ArchiveKey {
+			return fmt.Errorf("partition is not archived")
+		}
+
+		info, err := GetArchiveInfo(stub)
+		if err != nil {
+			return err
+		}
+
+		digest := sha256.Sum256(archive)
+		if hex.EncodeToString(digest[:]) != info.Digest {
+			return fmt.Errorf("archive digest does not match")
+		}
+
+		if err := VerifyPartition(partition, objs); err != nil {
+			return errors.Wrap(err, "archive failed verification")
+		}
+
+		var hashes []string
+		for _, obj := range objs {
+			hashes = append(hashes, obj.Hash)
+		}
+		if int64(len(objs)) != info.Length || objs[len(objs)-1].Hash != info.HeadHash || MerkleRoot(hashes) != info.MerkleRoot {
+			return fmt.Errorf("archive objects do not match the archive stub")
+		}
+
+		if err := o.db.Delete(&tables.Object{ID: stub.ID}, dbOptions...); err != nil {
+			return errors.Wrap(err, "failed to delete archive stub")
+		}
+
+		var stored []*tables.Object
+		for _, obj := range objs {
+			storedObj, err := o.store(o.db, obj, dbOptions)
+			if err != nil {
+				return errors.Wrap(err, "failed to restore object")
+			}
+			stored = append(stored, storedObj)
+		}
+
+		return o.updateState(stored, dbOptions)
+	})
+
+	return partition, errors.Wrap(err, "failed to restore partition")
+}
@@ -0,0 +1,144 @@
+package object
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/ellcrys/gorm"
+	"github.com/ellcrys/patchain"
+	"github.com/ellcrys/patchain/cockroach"
+	"github.com/ellcrys/patchain/cockroach/tables"
+	"github.com/ellcrys/util"
+	"github.com/pkg/errors"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestArchive(t *testing.T) {
+
+	if err := createDb(t); err != nil {
+		t.Fatalf("failed to create test database. %s", err)
+	}
+	defer dropDB(t)
+
+	cdb := cockroach.NewDB()
+	cdb.ConnectionString = conStrWithDB
+	cdb.NoLogging()
+	if err := cdb.Connect(10, 5); err != nil {
+		t.Fatalf("failed to connect to database. %s", err)
+	}
+
+	if err := cdb.CreateTables(); err != nil {
+		t.Fatalf("failed to create tables. %s", err)
+	}
+
+	obj := NewObject(cdb)
+
+	Convey("Archive", t, func() {
+
+		ownerID := util.RandString(10)
+		partitions, err := obj.CreatePartitions(1, ownerID, ownerID)
+		So(err, ShouldBeNil)
+		err = obj.Put([]*tables.Object{{Key: "key_1", OwnerID: ownerID}, {Key: "key_2", OwnerID: ownerID}})
+		So(err, ShouldBeNil)
+
+		Convey(".ArchivePartition", func() {
+			Convey("Should return error if partition is not sealed", func() {
+				var buf bytes.Buffer
+				_, err := obj.ArchivePartition(partitions[0].ID, &buf)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "failed to archive partition: partition is not sealed")
+			})
+
+			Convey("Should require an override to archive protected objects", func() {
+				So(obj.Put([]*tables.Object{{Key: "key_3", OwnerID: ownerID, Protected: true}}), ShouldBeNil)
+				_, err := obj.SealPartition(partitions[0].ID)
+				So(err, ShouldBeNil)
+
+				var buf bytes.Buffer
+				_, err = obj.ArchivePartition(partitions[0].ID, &buf)
+				So(errors.Cause(err), ShouldEqual, ErrProtected)
+				_, err = obj.ArchivePartition(partitions[0].ID, &buf, WithOverride(), ActingAs(util.RandString(10)))
+				So(errors.Cause(err), ShouldEqual, ErrPermissionDenied)
+				_, err = obj.ArchivePartition(partitions[0].ID, &buf, WithOverride())
+				So(err, ShouldBeNil)
+			})
+
+			Convey("Should return ErrPermissionDenied if the acting identity cannot write for the owner", func() {
+				_, err := obj.SealPartition(partitions[0].ID)
+				So(err, ShouldBeNil)
+				var buf bytes.Buffer
+				_, err = obj.ArchivePartition(partitions[0].ID, &buf, ActingAs(util.RandString(10)))
+				So(errors.Cause(err), ShouldEqual, ErrPermissionDenied)
+				So(buf.Len(), ShouldEqual, 0)
+			})
+
+			Convey("Should archive a sealed partition", func() {
+				_, err := obj.SealPartition(partitions[0].ID)
+				So(err, ShouldBeNil)
+
+				var buf bytes.Buffer
+				info, err := obj.ArchivePartition(partitions[0].ID, &buf)
+				So(err, ShouldBeNil)
+				So(info.Digest, ShouldEqual, util.Sha256(buf.String()))
+				So(info.Length, ShouldEqual, 6)
+				So(strings.Count(buf.String(), "\n"), ShouldEqual, 7)
+
+				Convey("Should replace the partition's objects with an archive stub", func() {
+					var all []*tables.Object
+					err := cdb.GetAll(&tables.Object{PartitionID: partitions[0].ID}, &all)
+					So(err, ShouldBeNil)
+					So(all, ShouldHaveLength, 1)
+					stubInfo, err := GetArchiveInfo(all[0])
+					So(err, ShouldBeNil)
+					So(stubInfo, ShouldResemble, info)
+
+					state, err := obj.GetPartitionState(partitions[0].ID)
+					So(err, ShouldBeNil)
+					So(state, ShouldEqual, PartitionArchived)
+				})
+
+				Convey(".RestorePartition", func() {
+					Convey("Should return ErrPermissionDenied if the acting identity cannot write for the owner", func() {
+						_, err := obj.RestorePartition(strings.NewReader(buf.String()), ActingAs(util.RandString(10)))
+						So(errors.Cause(err), ShouldEqual, ErrPermissionDenied)
+					})
+
+					Convey("Should reject a tampered archive", func() {
+						tampered := strings.Replace(buf.String(), `"key":"key_1"`, `"key":"key_x"`, 1)
+						_, err := obj.RestorePartition(strings.NewReader(tampered))
+						So(err, ShouldNotBeNil)
+						So(err.Error(), ShouldEqual, "failed to restore partition: archive digest does not match")
+					})
+
+					Convey("Should restore the partition's objects", func() {
+						partition, err := obj.RestorePartition(bytes.NewReader(buf.Bytes()))
+						So(err, ShouldBeNil)
+						So(partition.ID, ShouldEqual, partitions[0].ID)
+
+						var all []*tables.Object
+						err = cdb.GetAll(&tables.Object{PartitionID: partitions[0].ID, QueryParams: patchain.QueryParams{OrderBy: "timestamp asc"}}, &all)
+						So(err, ShouldBeNil)
+						So(all, ShouldHaveLength, 6)
+						So(VerifyPartition(partition, all), ShouldBeNil)
+
+						state, err := obj.GetPartitionState(partitions[0].ID)
+						So(err, ShouldBeNil)
+						So(state, ShouldEqual, PartitionSealed)
+					})
+
+					Convey("Should not charge the restored objects to the quota of the owner", func() {
+						obj.SetOwnerQuota(ownerID, &Quota{MaxObjects: 1})
+						defer obj.SetOwnerQuota(ownerID, nil)
+						_, err := obj.RestorePartition(bytes.NewReader(buf.Bytes()))
+						So(err, ShouldBeNil)
+					})
+				})
+			})
+		})
+
+		Reset(func() {
+			clearTable(cdb.GetConn().(*gorm.DB), "objects")
+		})
+	})
+}
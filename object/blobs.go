@@ -0,0 +1,74 @@
+package object
+
+import (
+	"fmt"
+
+	"github.com/ellcrys/patchain/blob"
+	"github.com/ellcrys/patchain/cockroach/tables"
+	"github.com/pkg/errors"
+)
+
+// DefaultBlobThreshold is the size (in bytes) above which values are offloaded
+// to the blob store when no threshold is set. It is the size of the value column.
+const DefaultBlobThreshold = 64000
+
+// ErrNoBlobStore indicates that an object refers to a blob but no blob store is set
+var ErrNoBlobStore = fmt.Errorf("no blob store")
+
+// SetBlobStore sets the store that values larger than threshold bytes (after
+// compression) are offloaded to. Offloaded values are stored by their SHA-256
+// digest and only the digest and size of the blob are kept in the object.
+// Readers fetch and verify blobs transparently. A threshold lower than 1 uses
+// DefaultBlobThreshold. Pass a nil store to stop offloading values.
+func (o *Object) SetBlobStore(store blob.Store, threshold int) {
+	if threshold < 1 {
+		threshold = DefaultBlobThreshold
+	}
+	o.blobStore = store
+	o.blobThreshold = threshold
+}
+
+// offload moves the value of an object about to be stored to the blob store
+// if it exceeds the blob threshold. Blobs are not removed if the transaction
+// storing the object fails; as they are addressed by content, they are
+// reused if the object is stored again.
+func (o *Object) offload(stored *tables.Object) error {
+
+	stored.BlobDigest, stored.BlobSize = "", 0
+	if o.blobStore == nil || len(stored.Value) <= o.blobThreshold {
+		return nil
+	}
+
+	digest, err := blob.Put(o.blobStore, []byte(stored.Value))
+	if err != nil {
+		return errors.Wrap(err, "failed to store blob")
+	}
+
+	stored.BlobDigest = digest
+	stored.BlobSize = int64(len(stored.Value))
+	stored.Value = ""
+	return nil
+}
+
+// restoreValue restores the value of an object read from the database. The
+// value is fetched from the blob store, verified and decompressed. The blob
+// fields are cleared as the value is no longer offloaded.
+func (o *Object) restoreValue(obj *tables.Object) error {
+
+	if obj.BlobDigest != "" {
+		if o.blobStore == nil {
+			return errors.Wrapf(ErrNoBlobStore, "object (%s)", obj.ID)
+		}
+		data, err := blob.Get(o.blobStore, obj.BlobDigest)
+		if err != nil {
+			return errors.Wrapf(err, "object (%s): failed to get blob", obj.ID)
+		}
+		if int64(len(data)) != obj.BlobSize {
+			return fmt.Errorf("object (%s): blob size does not match", obj.ID)
+		}
+		obj.Value = string(data)
+		obj.BlobDigest, obj.BlobSize = "", 0
+	}
+
+	return decompress(obj)
+}
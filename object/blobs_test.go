@@ -0,0 +1,101 @@
+package object
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ellcrys/patchain/blob"
+	"github.com/ellcrys/patchain/cockroach"
+	"github.com/ellcrys/patchain/cockroach/tables"
+	"github.com/ellcrys/util"
+	"github.com/pkg/errors"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestBlobs(t *testing.T) {
+	Convey("Blobs", t, func() {
+		o := NewObject(nil)
+		store := blob.NewMemoryStore()
+		o.SetBlobStore(store, 10)
+
+		Convey(".offload", func() {
+			Convey("Should move values above the threshold to the blob store", func() {
+				stored := &tables.Object{ID: "1", Value: strings.Repeat("a", 11)}
+				So(o.offload(stored), ShouldBeNil)
+				So(stored.Value, ShouldBeEmpty)
+				So(stored.BlobDigest, ShouldEqual, blob.Digest([]byte(strings.Repeat("a", 11))))
+				So(stored.BlobSize, ShouldEqual, 11)
+
+				Convey("Should restore the value from the blob store", func() {
+					So(o.restoreValue(stored), ShouldBeNil)
+					So(stored.Value, ShouldEqual, strings.Repeat("a", 11))
+				})
+			})
+
+			Convey("Should keep values that do not exceed the threshold", func() {
+				stored := &tables.Object{Value: strings.Repeat("a", 10)}
+				So(o.offload(stored), ShouldBeNil)
+				So(stored.Value, ShouldEqual, strings.Repeat("a", 10))
+				So(stored.BlobDigest, ShouldBeEmpty)
+			})
+		})
+
+		Convey(".restoreValue", func() {
+			Convey("Should return error if the blob does not match its digest", func() {
+				digest := blob.Digest([]byte("value"))
+				So(store.Put(digest, []byte("other")), ShouldBeNil)
+				err := o.restoreValue(&tables.Object{ID: "1", BlobDigest: digest, BlobSize: 5})
+				So(errors.Cause(err), ShouldEqual, blob.ErrDigestMismatch)
+			})
+
+			Convey("Should return error if there is no blob store", func() {
+				err := NewObject(nil).restoreValue(&tables.Object{ID: "1", BlobDigest: "abc"})
+				So(errors.Cause(err), ShouldEqual, ErrNoBlobStore)
+			})
+		})
+	})
+}
+
+func TestObjectBlobs(t *testing.T) {
+
+	if err := createDb(t); err != nil {
+		t.Fatalf("failed to create test database. %s", err)
+	}
+	defer dropDB(t)
+
+	cdb := cockroach.NewDB()
+	cdb.ConnectionString = conStrWithDB
+	cdb.NoLogging()
+	if err := cdb.Connect(10, 5); err != nil {
+		t.Fatalf("failed to connect to database. %s", err)
+	}
+
+	if err := cdb.CreateTables(); err != nil {
+		t.Fatalf("failed to create tables. %s", err)
+	}
+
+	obj := NewObject(cdb)
+
+	Convey("Object blobs", t, func() {
+		ownerID := util.RandString(10)
+		_, err := obj.CreatePartitions(1, ownerID, ownerID)
+		So(err, ShouldBeNil)
+		obj.SetBlobStore(blob.NewMemoryStore(), 0)
+		defer obj.SetBlobStore(nil, 0)
+
+		Convey("Should store values larger than the value column", func() {
+			value := util.RandString(100000)
+			o := &tables.Object{OwnerID: ownerID, Key: "large", Value: value}
+			So(obj.Put(o), ShouldBeNil)
+			So(o.BlobDigest, ShouldBeEmpty)
+			So(o.BlobSize, ShouldEqual, 0)
+
+			stored, err := obj.GetLast(&tables.Object{OwnerID: ownerID, Key: "large"})
+			So(err, ShouldBeNil)
+			So(stored.Value, ShouldEqual, value)
+			So(stored.BlobDigest, ShouldBeEmpty)
+			So(stored.BlobSize, ShouldEqual, 0)
+			So(VerifyObjectHash(stored), ShouldBeNil)
+		})
+	})
+}
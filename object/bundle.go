@@ -0,0 +1,242 @@
+package object
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ellcrys/patchain/cockroach/tables"
+	"github.com/ellcrys/util"
+)
+
+// byTimestamp sorts objects from the oldest to the most recent
+type byTimestamp []*tables.Object
+
+func (s byTimestamp) Len() int           { return len(s) }
+func (s byTimestamp) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byTimestamp) Less(i, j int) bool { return s[i].Timestamp < s[j].Timestamp }
+
+// PartitionReport describes the result of verifying a partition of a bundle
+type PartitionReport struct {
+	PartitionID string
+	OwnerID     string
+	NumObjects  int
+	State       string
+	Err         error
+}
+
+// BundleReport describes the result of verifying a bundle of exported objects
+type BundleReport struct {
+
+	// Partitions holds the report of every partition in the bundle
+	Partitions []*PartitionReport
+
+	// PartitionChainErr is set if the partitions in the bundle are not correctly chained
+	PartitionChainErr error
+
+	// ExternalLinks is the number of partitions that are chained
+	// to a partition that is not included in the bundle
+	ExternalLinks int
+
+	// NumOtherObjects is the number of objects that do not belong to a partition
+	NumOtherObjects int
+
+	// OtherObjectsErr is set if an object that does not belong to a partition failed verification
+	OtherObjectsErr error
+
+	// FlagsErr is set if an object breaks the rules of the Protected or RefOnly flags (see VerifyFlags)
+	FlagsErr error
+}
+
+// Passed checks whether the bundle passed verification
+func (r *BundleReport) Passed() bool {
+	if r.PartitionChainErr != nil || r.OtherObjectsErr != nil || r.FlagsErr != nil {
+		return false
+	}
+	for _, p := range r.Partitions {
+		if p.Err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// errString returns the message of an error or an empty string if it is nil
+func errString(err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	return ""
+}
+
+// MarshalJSON encodes the report as JSON
+func (r *PartitionReport) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"partition_id": r.PartitionID,
+		"owner_id":     r.OwnerID,
+		"num_objects":  r.NumObjects,
+		"state":        r.State,
+		"passed":       r.Err == nil,
+		"error":        errString(r.Err),
+	})
+}
+
+// MarshalJSON encodes the report as JSON
+func (r *BundleReport) MarshalJSON() ([]byte, error) {
+	partitions := r.Partitions
+	if partitions == nil {
+		partitions = []*PartitionReport{}
+	}
+	return json.Marshal(map[string]interface{}{
+		"passed":                r.Passed(),
+		"partitions":            partitions,
+		"partition_chain_error": errString(r.PartitionChainErr),
+		"external_links":        r.ExternalLinks,
+		"num_other_objects":     r.NumOtherObjects,
+		"other_objects_error":   errString(r.OtherObjectsErr),
+		"flags_error":           errString(r.FlagsErr),
+	})
+}
+
+// VerifyBundle rebuilds and verifies every partition chain and the chain of
+// partitions from a bundle of exported objects, and checks the rules of the
+// Protected and RefOnly flags. It requires no database access.
+func VerifyBundle(objs []*tables.Object) *BundleReport {
+
+	var report BundleReport
+	var partitions []*tables.Object
+	var partitionObjs = make(map[string][]*tables.Object)
+
+	for _, obj := range objs {
+		if obj.PartitionID != "" {
+			partitionObjs[obj.PartitionID] = append(partitionObjs[obj.PartitionID], obj)
+		} else if strings.HasPrefix(obj.Key, PartitionPrefix) {
+			partitions = append(partitions, obj)
+		} else {
+			report.NumOtherObjects++
+			if err := VerifyObjectHash(obj); err != nil && report.OtherObjectsErr == nil {
+				report.OtherObjectsErr = fmt.Errorf("object (%s): %s", obj.ID, err)
+			}
+		}
+	}
+
+	sort.Stable(byTimestamp(partitions))
+	for _, partition := range partitions {
+		pObjs := partitionObjs[partition.ID]
+		delete(partitionObjs, partition.ID)
+		sort.Stable(byTimestamp(pObjs))
+		report.Partitions = append(report.Partitions, verifyBundlePartition(partition, pObjs))
+	}
+
+	// objects whose partition is not included in the bundle
+	var missing []string
+	for partitionID := range partitionObjs {
+		missing = append(missing, partitionID)
+	}
+	sort.Strings(missing)
+	for _, partitionID := range missing {
+		pObjs := partitionObjs[partitionID]
+		report.Partitions = append(report.Partitions, &PartitionReport{
+			PartitionID: partitionID,
+			OwnerID:     pObjs[0].OwnerID,
+			NumObjects:  len(pObjs),
+			Err:         fmt.Errorf("partition not found in bundle"),
+		})
+	}
+
+	report.ExternalLinks, report.PartitionChainErr = verifyPartitionChain(partitions)
+
+	sorted := append([]*tables.Object{}, objs...)
+	sort.Stable(byTimestamp(sorted))
+	report.FlagsErr = VerifyFlags(sorted)
+
+	return &report
+}
+
+// verifyBundlePartition verifies a partition of a bundle and its objects
+func verifyBundlePartition(partition *tables.Object, objs []*tables.Object) *PartitionReport {
+
+	report := &PartitionReport{
+		PartitionID: partition.ID,
+		OwnerID:     partition.OwnerID,
+		NumObjects:  len(objs),
+	}
+
+	// an archived partition only holds its archive stub
+	if len(objs) > 0 && objs[0].Key == ArchiveKey {
+		report.State = PartitionArchived
+		if err := VerifyObjectHash(partition); err != nil {
+			report.Err = fmt.Errorf("partition (%s): %s", partition.ID, err)
+		} else if len(objs) > 1 {
+			report.Err = fmt.Errorf("archived partition has objects after the archive stub")
+		} else if err := VerifyObjectHash(objs[0]); err != nil {
+			report.Err = fmt.Errorf("object 0 (%s): %s", objs[0].ID, err)
+		}
+		return report
+	}
+
+	if err := VerifyPartition(partition, objs); err != nil {
+		report.Err = err
+		return report
+	}
+
+	last := objs[len(objs)-1]
+	report.State = getPartitionState(last)
+	if report.State == PartitionSealed {
+		info, err := GetSealInfo(last)
+		if err != nil {
+			report.Err = err
+		} else if info.Length != int64(len(objs)-1) {
+			report.Err = fmt.Errorf("seal length does not match the number of objects in the partition")
+		} else if info.HeadHash != objs[len(objs)-2].Hash {
+			report.Err = fmt.Errorf("seal head hash does not match the hash of the partition's head")
+		}
+	}
+
+	return report
+}
+
+// verifyPartitionChain verifies the chain of partitions. Partitions must be ordered
+// from the oldest to the most recent. Only the first partition ever created can be the
+// root of the chain and no two partitions can be chained to the same partition. It
+// returns the number of partitions chained to a partition outside the bundle.
+func verifyPartitionChain(partitions []*tables.Object) (int, error) {
+
+	var externalLinks int
+	var byHash = make(map[string]*tables.Object)
+	var chainedTo = make(map[string]string)
+	var root *tables.Object
+
+	for _, partition := range partitions {
+		byHash[partition.Hash] = partition
+	}
+
+	for _, partition := range partitions {
+
+		if partition.PrevHash == util.Sha256(partition.ID) {
+			if root != nil {
+				return externalLinks, fmt.Errorf("partition (%s): chain has more than one root", partition.ID)
+			}
+			root = partition
+			continue
+		}
+
+		prev, ok := byHash[partition.PrevHash]
+		if !ok {
+			externalLinks++
+			continue
+		}
+
+		if prev.Timestamp > partition.Timestamp {
+			return externalLinks, fmt.Errorf("partition (%s): chained to a partition created after it", partition.ID)
+		}
+
+		if other, ok := chainedTo[partition.PrevHash]; ok {
+			return externalLinks, fmt.Errorf("partition (%s): chained to the same partition as partition (%s)", partition.ID, other)
+		}
+		chainedTo[partition.PrevHash] = partition.ID
+	}
+
+	return externalLinks, nil
+}
@@ -0,0 +1,115 @@
+package object
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/ellcrys/patchain/cockroach/tables"
+	"github.com/ellcrys/util"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestVerifyBundle(t *testing.T) {
+	Convey("VerifyBundle", t, func() {
+		p1, p1Objs := makeTestPartition(2)
+		p2, p2Objs := makeTestPartition(1)
+		p2.PrevHash = p1.Hash
+		p2.Timestamp = p1.Timestamp + 1
+		MakeChain(p2)
+		p2Objs = MakeGenesisPair("owner_id", "creator_id", p2.ID, p2.Hash)
+		identity, err := MakeIdentityObject("owner_id", "owner_id", "email@email.com", "", false)
+		So(err, ShouldBeNil)
+		identity.ComputeHash()
+
+		bundle := []*tables.Object{p1, p2, identity}
+		bundle = append(bundle, p1Objs...)
+		bundle = append(bundle, p2Objs...)
+
+		Convey("Should pass a valid bundle", func() {
+			report := VerifyBundle(bundle)
+			So(report.Passed(), ShouldBeTrue)
+			So(report.Partitions, ShouldHaveLength, 2)
+			So(report.Partitions[0].PartitionID, ShouldEqual, p1.ID)
+			So(report.Partitions[0].NumObjects, ShouldEqual, 4)
+			So(report.Partitions[0].State, ShouldEqual, PartitionActive)
+			So(report.NumOtherObjects, ShouldEqual, 1)
+			So(report.ExternalLinks, ShouldEqual, 0)
+		})
+
+		Convey("Should encode the report as JSON", func() {
+			p1Objs[2].Value = "altered"
+			var out map[string]interface{}
+			bs, err := json.Marshal(VerifyBundle(bundle))
+			So(err, ShouldBeNil)
+			So(json.Unmarshal(bs, &out), ShouldBeNil)
+			So(out["passed"], ShouldEqual, false)
+			partitions := out["partitions"].([]interface{})
+			So(partitions, ShouldHaveLength, 2)
+			So(partitions[0].(map[string]interface{})["partition_id"], ShouldEqual, p1.ID)
+			So(partitions[0].(map[string]interface{})["error"], ShouldNotBeEmpty)
+			So(partitions[1].(map[string]interface{})["passed"], ShouldEqual, true)
+		})
+
+		Convey("Should fail a partition with an altered object", func() {
+			p1Objs[2].Value = "altered"
+			report := VerifyBundle(bundle)
+			So(report.Passed(), ShouldBeFalse)
+			So(report.Partitions[0].Err, ShouldNotBeNil)
+			So(report.Partitions[1].Err, ShouldBeNil)
+		})
+
+		Convey("Should fail objects whose partition is not in the bundle", func() {
+			report := VerifyBundle(p1Objs)
+			So(report.Passed(), ShouldBeFalse)
+			So(report.Partitions[0].Err.Error(), ShouldEqual, "partition not found in bundle")
+		})
+
+		Convey("Should fail an altered object without a partition", func() {
+			identity.Value = "altered"
+			report := VerifyBundle(bundle)
+			So(report.Passed(), ShouldBeFalse)
+			So(report.OtherObjectsErr, ShouldNotBeNil)
+		})
+
+		Convey("Should count partitions chained to partitions outside the bundle", func() {
+			report := VerifyBundle(append([]*tables.Object{p2}, p2Objs...))
+			So(report.Passed(), ShouldBeTrue)
+			So(report.ExternalLinks, ShouldEqual, 1)
+		})
+
+		Convey("Should fail a partition chain with more than one root", func() {
+			p3, p3Objs := makeTestPartition(0)
+			p3.Timestamp = p2.Timestamp + 1
+			p3.PrevHash = util.Sha256(p3.ID)
+			p3.ComputeHash()
+			p3Objs = MakeGenesisPair("owner_id", "creator_id", p3.ID, p3.Hash)
+			report := VerifyBundle(append(append(bundle, p3), p3Objs...))
+			So(report.Passed(), ShouldBeFalse)
+			So(report.PartitionChainErr.Error(), ShouldEqual, "partition ("+p3.ID+"): chain has more than one root")
+		})
+
+		Convey("Should verify the seal of a sealed partition", func() {
+			sealing := &tables.Object{OwnerID: "owner_id", PartitionID: p2.ID, Key: SealingKey, PrevHash: p2Objs[1].Hash}
+			MakeChain(sealing)
+			p2Objs[1].ComputePeerHash(sealing.Hash)
+			sealInfo, _ := json.Marshal(SealInfo{Length: 3, HeadHash: sealing.Hash})
+			seal := &tables.Object{OwnerID: "owner_id", PartitionID: p2.ID, Key: SealKey, Value: string(sealInfo), PrevHash: sealing.Hash}
+			MakeChain(seal)
+			sealing.ComputePeerHash(seal.Hash)
+
+			report := VerifyBundle(append(bundle, sealing, seal))
+			So(report.Passed(), ShouldBeTrue)
+			So(report.Partitions[1].State, ShouldEqual, PartitionSealed)
+
+			Convey("Should fail if seal does not match the partition", func() {
+				sealInfo, _ := json.Marshal(SealInfo{Length: 10, HeadHash: sealing.Hash})
+				seal.Value = string(sealInfo)
+				seal.ComputeHash()
+				sealing.ComputePeerHash(seal.Hash)
+				report := VerifyBundle(append(bundle, sealing, seal))
+				So(report.Passed(), ShouldBeFalse)
+				So(report.Partitions[1].Err.Error(), ShouldEqual, "seal length does not match the number of objects in the partition")
+			})
+		})
+	})
+}
@@ -0,0 +1,233 @@
+package object
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/ellcrys/patchain"
+	"github.com/ellcrys/patchain/cockroach/tables"
+	"github.com/golang/protobuf/proto"
+	"github.com/pkg/errors"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Codec encodes values stored in objects. The id of the codec is
+// recorded on the objects it encodes so that readers can decode them.
+type Codec interface {
+
+	// ID returns the id of the codec
+	ID() string
+
+	// Encode encodes a value
+	Encode(v interface{}) ([]byte, error)
+
+	// Decode decodes data into the value pointed to by out
+	Decode(data []byte, out interface{}) error
+}
+
+var (
+	// JSONCodec encodes values using encoding/json. Values are stored as is.
+	JSONCodec Codec = &jsonCodec{}
+
+	// GobCodec encodes values using encoding/gob
+	GobCodec Codec = &gobCodec{}
+
+	// ProtobufCodec encodes protocol buffer messages (proto.Message)
+	ProtobufCodec Codec = &protobufCodec{}
+
+	// MsgpackCodec encodes values in the MessagePack format. Struct fields are
+	// named according to their json tags and map keys are sorted.
+	MsgpackCodec Codec = &msgpackCodec{}
+
+	// ErrUnknownCodec indicates that an object was encoded by a codec that is not registered
+	ErrUnknownCodec = fmt.Errorf("unknown codec")
+
+	codecsMtx sync.RWMutex
+	codecs    = map[string]Codec{}
+)
+
+func init() {
+	for _, c := range []Codec{JSONCodec, GobCodec, ProtobufCodec, MsgpackCodec} {
+		RegisterCodec(c)
+	}
+}
+
+// RegisterCodec registers a codec so that the objects it encodes can
+// be decoded. A codec replaces any registered codec with the same id.
+func RegisterCodec(c Codec) {
+	codecsMtx.Lock()
+	defer codecsMtx.Unlock()
+	codecs[c.ID()] = c
+}
+
+// GetCodec returns a registered codec
+func GetCodec(id string) (Codec, error) {
+	codecsMtx.RLock()
+	defer codecsMtx.RUnlock()
+	c, ok := codecs[id]
+	if !ok {
+		return nil, errors.Wrapf(ErrUnknownCodec, "codec (%s)", id)
+	}
+	return c, nil
+}
+
+// jsonCodec encodes values as JSON
+type jsonCodec struct{}
+
+func (c *jsonCodec) ID() string {
+	return tables.CodecJSON
+}
+
+func (c *jsonCodec) Encode(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (c *jsonCodec) Decode(data []byte, out interface{}) error {
+	return json.Unmarshal(data, out)
+}
+
+// gobCodec encodes values using gob
+type gobCodec struct{}
+
+func (c *gobCodec) ID() string {
+	return "gob"
+}
+
+func (c *gobCodec) Encode(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c *gobCodec) Decode(data []byte, out interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(out)
+}
+
+// protobufCodec encodes protocol buffer messages
+type protobufCodec struct{}
+
+func (c *protobufCodec) ID() string {
+	return "protobuf"
+}
+
+func (c *protobufCodec) Encode(v interface{}) ([]byte, error) {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("value is not a protocol buffer message")
+	}
+	return proto.Marshal(msg)
+}
+
+func (c *protobufCodec) Decode(data []byte, out interface{}) error {
+	msg, ok := out.(proto.Message)
+	if !ok {
+		return fmt.Errorf("value is not a protocol buffer message")
+	}
+	return proto.Unmarshal(data, msg)
+}
+
+// msgpackCodec encodes values in the MessagePack format
+type msgpackCodec struct{}
+
+func (c *msgpackCodec) ID() string {
+	return "msgpack"
+}
+
+func (c *msgpackCodec) Encode(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetSortMapKeys(true)
+	enc.SetCustomStructTag("json")
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c *msgpackCodec) Decode(data []byte, out interface{}) error {
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	dec.SetCustomStructTag("json")
+	return dec.Decode(out)
+}
+
+// EncodeValue encodes a value using a codec and sets it as the value of an
+// object. Values encoded by codecs other than JSONCodec are stored in base64.
+func EncodeValue(obj *tables.Object, v interface{}, codec Codec) error {
+	bs, err := codec.Encode(v)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode value")
+	}
+	obj.Codec = codec.ID()
+	if obj.Codec == tables.CodecJSON {
+		obj.Value = string(bs)
+	} else {
+		obj.Value = base64.StdEncoding.EncodeToString(bs)
+	}
+	return nil
+}
+
+// DecodeValue decodes the value of an object into the value pointed to by out
+// using the codec recorded on the object. The value of an object without a
+// codec is decoded as JSON, or stored as is if out is a *string.
+func DecodeValue(obj *tables.Object, out interface{}) error {
+
+	if obj.Codec == "" {
+		if s, ok := out.(*string); ok {
+			*s = obj.Value
+			return nil
+		}
+	}
+
+	id := obj.Codec
+	if id == "" {
+		id = tables.CodecJSON
+	}
+
+	codec, err := GetCodec(id)
+	if err != nil {
+		return err
+	}
+
+	bs, err := obj.EncodedValue()
+	if err != nil {
+		return errors.Wrap(err, "malformed value")
+	}
+
+	if err := codec.Decode(bs, out); err != nil {
+		return errors.Wrap(err, "failed to decode value")
+	}
+
+	return nil
+}
+
+// PutValue encodes a value using a codec and puts it as a new version of a key
+// of an owner. The options are passed to Put.
+func (o *Object) PutValue(ownerID, key string, v interface{}, codec Codec, options ...patchain.Option) (*tables.Object, error) {
+	obj := &tables.Object{OwnerID: ownerID, Key: key}
+	if err := EncodeValue(obj, v, codec); err != nil {
+		return nil, err
+	}
+	if err := o.Put(obj, options...); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+// GetValue decodes the value of the latest version of a key of an owner into
+// the value pointed to by out (see DecodeValue). The options are passed to GetLast.
+func (o *Object) GetValue(ownerID, key string, out interface{}, options ...patchain.Option) (*tables.Object, error) {
+	obj, err := o.GetLast(&tables.Object{OwnerID: ownerID, Key: key}, options...)
+	if err != nil {
+		return nil, err
+	}
+	if err := DecodeValue(obj, out); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
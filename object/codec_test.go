@@ -0,0 +1,121 @@
+package object
+
+import (
+	"testing"
+
+	"github.com/ellcrys/patchain/cockroach"
+	"github.com/ellcrys/patchain/cockroach/tables"
+	"github.com/ellcrys/util"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/any"
+	"github.com/pkg/errors"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type codecValue struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+func TestCodec(t *testing.T) {
+	Convey("Codec", t, func() {
+		Convey(".EncodeValue", func() {
+			Convey("Should encode and decode values using every codec", func() {
+				for _, codec := range []Codec{JSONCodec, GobCodec, MsgpackCodec} {
+					obj := &tables.Object{}
+					So(EncodeValue(obj, codecValue{Name: "a", Count: 2}, codec), ShouldBeNil)
+					So(obj.Codec, ShouldEqual, codec.ID())
+					var out codecValue
+					So(DecodeValue(obj, &out), ShouldBeNil)
+					So(out, ShouldResemble, codecValue{Name: "a", Count: 2})
+				}
+			})
+
+			Convey("Should store JSON values as is", func() {
+				obj := &tables.Object{}
+				So(EncodeValue(obj, codecValue{Name: "a"}, JSONCodec), ShouldBeNil)
+				So(obj.Value, ShouldEqual, `{"name":"a","count":0}`)
+			})
+
+			Convey("Should encode protocol buffer messages", func() {
+				obj := &tables.Object{}
+				So(EncodeValue(obj, &any.Any{TypeUrl: "type", Value: []byte{1, 2}}, ProtobufCodec), ShouldBeNil)
+				var out any.Any
+				So(DecodeValue(obj, &out), ShouldBeNil)
+				So(proto.Equal(&out, &any.Any{TypeUrl: "type", Value: []byte{1, 2}}), ShouldBeTrue)
+
+				err := EncodeValue(obj, codecValue{}, ProtobufCodec)
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey(".DecodeValue", func() {
+			Convey("Should return error if the codec is not registered", func() {
+				var out codecValue
+				err := DecodeValue(&tables.Object{Value: "abc", Codec: "unknown"}, &out)
+				So(errors.Cause(err), ShouldEqual, ErrUnknownCodec)
+			})
+
+			Convey("Should decode values without a codec as JSON or as is into a string", func() {
+				obj := &tables.Object{Value: `{"name":"a"}`}
+				var out codecValue
+				So(DecodeValue(obj, &out), ShouldBeNil)
+				So(out.Name, ShouldEqual, "a")
+				var s string
+				So(DecodeValue(obj, &s), ShouldBeNil)
+				So(s, ShouldEqual, obj.Value)
+			})
+		})
+	})
+}
+
+func TestObjectValue(t *testing.T) {
+
+	if err := createDb(t); err != nil {
+		t.Fatalf("failed to create test database. %s", err)
+	}
+	defer dropDB(t)
+
+	cdb := cockroach.NewDB()
+	cdb.ConnectionString = conStrWithDB
+	cdb.NoLogging()
+	if err := cdb.Connect(10, 5); err != nil {
+		t.Fatalf("failed to connect to database. %s", err)
+	}
+
+	if err := cdb.CreateTables(); err != nil {
+		t.Fatalf("failed to create tables. %s", err)
+	}
+
+	obj := NewObject(cdb)
+
+	Convey("Object values", t, func() {
+		ownerID := util.RandString(10)
+		_, err := obj.CreatePartitions(1, ownerID, ownerID)
+		So(err, ShouldBeNil)
+
+		Convey(".PutValue", func() {
+			Convey("Should put a value that can be read with GetValue", func() {
+				o, err := obj.PutValue(ownerID, "item", codecValue{Name: "a", Count: 1}, MsgpackCodec)
+				So(err, ShouldBeNil)
+				So(VerifyObjectHash(o), ShouldBeNil)
+
+				var out codecValue
+				stored, err := obj.GetValue(ownerID, "item", &out)
+				So(err, ShouldBeNil)
+				So(stored.Codec, ShouldEqual, MsgpackCodec.ID())
+				So(out, ShouldResemble, codecValue{Name: "a", Count: 1})
+				So(VerifyObjectHash(stored), ShouldBeNil)
+			})
+
+			Convey("Should return error if a schema applies to a value that is not JSON", func() {
+				_, err := obj.DefineSchema(ownerID, "item", `{"type": "object"}`)
+				So(err, ShouldBeNil)
+				_, err = obj.PutValue(ownerID, "item", codecValue{Name: "a"}, GobCodec)
+				So(err, ShouldNotBeNil)
+				_, err = obj.PutValue(ownerID, "item", codecValue{Name: "a"}, JSONCodec)
+				So(err, ShouldBeNil)
+			})
+		})
+	})
+}
@@ -0,0 +1,261 @@
+package object
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"sync"
+
+	"github.com/ellcrys/patchain"
+	"github.com/ellcrys/patchain/cockroach/tables"
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+)
+
+// Names of compression algorithms
+const (
+	// CompressionGzip compresses values using gzip
+	CompressionGzip = "gzip"
+
+	// CompressionSnappy compresses values using the Snappy block format
+	CompressionSnappy = "snappy"
+
+	// CompressionZstd compresses values using Zstandard
+	CompressionZstd = "zstd"
+)
+
+// Compressor compresses the values of objects before they are stored
+type Compressor interface {
+
+	// Name returns the name of the compression algorithm
+	Name() string
+
+	// Compress compresses data
+	Compress(data []byte) ([]byte, error)
+
+	// Decompress decompresses data compressed by Compress
+	Decompress(data []byte) ([]byte, error)
+}
+
+var (
+	// ErrUnknownCompression indicates that a compression algorithm has no registered compressor
+	ErrUnknownCompression = fmt.Errorf("unknown compression algorithm")
+
+	compressorsMtx sync.RWMutex
+	compressors    = map[string]Compressor{}
+)
+
+func init() {
+	RegisterCompressor(&gzipCompressor{})
+	RegisterCompressor(&snappyCompressor{})
+	RegisterCompressor(&zstdCompressor{})
+}
+
+// RegisterCompressor registers a compressor. A compressor replaces
+// any registered compressor with the same name.
+func RegisterCompressor(c Compressor) {
+	compressorsMtx.Lock()
+	defer compressorsMtx.Unlock()
+	compressors[c.Name()] = c
+}
+
+// GetCompressor returns a registered compressor
+func GetCompressor(name string) (Compressor, error) {
+	compressorsMtx.RLock()
+	defer compressorsMtx.RUnlock()
+	c, ok := compressors[name]
+	if !ok {
+		return nil, errors.Wrapf(ErrUnknownCompression, "compression (%s)", name)
+	}
+	return c, nil
+}
+
+// gzipCompressor compresses data using gzip
+type gzipCompressor struct{}
+
+func (c *gzipCompressor) Name() string {
+	return CompressionGzip
+}
+
+func (c *gzipCompressor) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c *gzipCompressor) Decompress(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+// snappyCompressor compresses data using the Snappy block format
+type snappyCompressor struct{}
+
+func (c *snappyCompressor) Name() string {
+	return CompressionSnappy
+}
+
+func (c *snappyCompressor) Compress(data []byte) ([]byte, error) {
+	return snappy.Encode(nil, data), nil
+}
+
+func (c *snappyCompressor) Decompress(data []byte) ([]byte, error) {
+	return snappy.Decode(nil, data)
+}
+
+// zstdCompressor compresses data using Zstandard. The encoder and
+// decoder are created on first use and shared by all goroutines.
+type zstdCompressor struct {
+	once    sync.Once
+	encoder *zstd.Encoder
+	decoder *zstd.Decoder
+	err     error
+}
+
+func (c *zstdCompressor) Name() string {
+	return CompressionZstd
+}
+
+func (c *zstdCompressor) init() error {
+	c.once.Do(func() {
+		if c.encoder, c.err = zstd.NewWriter(nil); c.err != nil {
+			return
+		}
+		c.decoder, c.err = zstd.NewReader(nil)
+	})
+	return c.err
+}
+
+func (c *zstdCompressor) Compress(data []byte) ([]byte, error) {
+	if err := c.init(); err != nil {
+		return nil, err
+	}
+	return c.encoder.EncodeAll(data, nil), nil
+}
+
+func (c *zstdCompressor) Decompress(data []byte) ([]byte, error) {
+	if err := c.init(); err != nil {
+		return nil, err
+	}
+	return c.decoder.DecodeAll(data, nil)
+}
+
+// CompressionPolicy defines the values compressed before they are stored
+type CompressionPolicy struct {
+
+	// Algorithm is the name of a registered compressor
+	Algorithm string
+
+	// Threshold is the minimum size (in bytes) of the values to compress
+	Threshold int
+}
+
+// SetCompressionPolicy sets the policy used to compress values before they are
+// stored. The algorithm is recorded in the compression field of the stored objects.
+// Values are decompressed when read and the compression field of the objects returned
+// is cleared. Hashes are computed on uncompressed values.
+// Pass nil to disable compression.
+func (o *Object) SetCompressionPolicy(policy *CompressionPolicy) error {
+	if policy != nil {
+		if _, err := GetCompressor(policy.Algorithm); err != nil {
+			return err
+		}
+	}
+	o.compressionPolicy = policy
+	return nil
+}
+
+// toStored returns the copy of an object to store. The value is compressed
+// if the object sets a compression algorithm or the value reaches the
+// threshold of the compression policy. The compressed value is stored in
+// base64 and only kept if it is smaller than the value.
+func (o *Object) toStored(obj *tables.Object) (*tables.Object, error) {
+
+	stored := *obj
+	algorithm := obj.Compression
+	if algorithm == "" && o.compressionPolicy != nil && len(obj.Value) >= o.compressionPolicy.Threshold {
+		algorithm = o.compressionPolicy.Algorithm
+	}
+
+	stored.Compression = ""
+	if algorithm == "" || obj.Value == "" {
+		return &stored, nil
+	}
+
+	c, err := GetCompressor(algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	bs, err := c.Compress([]byte(obj.Value))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to compress value")
+	}
+
+	if compressed := base64.StdEncoding.EncodeToString(bs); len(compressed) < len(obj.Value) {
+		stored.Value = compressed
+		stored.Compression = algorithm
+	}
+
+	return &stored, nil
+}
+
+// store creates an object. Its value is compressed and offloaded to the blob
+// store according to the policies. The object is left as is and the copy that
+// was stored, which records the compression and blob applied to its value, is
+// returned. Objects whose value is not valid for their codec are rejected.
+func (o *Object) store(db patchain.DB, obj *tables.Object, options []patchain.Option) (*tables.Object, error) {
+	if _, err := obj.EncodedValue(); err != nil {
+		return nil, errors.Wrapf(err, "object (%s): malformed value", obj.ID)
+	}
+	stored, err := o.toStored(obj)
+	if err != nil {
+		return nil, err
+	}
+	if err := o.offload(stored); err != nil {
+		return nil, err
+	}
+	if err := db.Create(stored, options...); err != nil {
+		return nil, err
+	}
+	return stored, nil
+}
+
+// decompress restores the value of an object read from the database. The
+// compression field is cleared as the value is no longer compressed.
+func decompress(obj *tables.Object) error {
+
+	if obj.Compression == "" {
+		return nil
+	}
+
+	c, err := GetCompressor(obj.Compression)
+	if err != nil {
+		return errors.Wrapf(err, "object (%s)", obj.ID)
+	}
+
+	bs, err := base64.StdEncoding.DecodeString(obj.Value)
+	if err == nil {
+		bs, err = c.Decompress(bs)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "object (%s): failed to decompress value", obj.ID)
+	}
+
+	obj.Value = string(bs)
+	obj.Compression = ""
+	return nil
+}
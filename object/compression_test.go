@@ -0,0 +1,123 @@
+package object
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ellcrys/patchain"
+	"github.com/ellcrys/patchain/cockroach"
+	"github.com/ellcrys/patchain/cockroach/tables"
+	"github.com/ellcrys/util"
+	"github.com/pkg/errors"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCompression(t *testing.T) {
+	Convey("Compression", t, func() {
+		o := NewObject(nil)
+		value := strings.Repeat(`{"name": "book", "price": 10}`, 100)
+
+		Convey(".SetCompressionPolicy", func() {
+			Convey("Should return error if the algorithm has no compressor", func() {
+				err := o.SetCompressionPolicy(&CompressionPolicy{Algorithm: "lz4"})
+				So(errors.Cause(err), ShouldEqual, ErrUnknownCompression)
+			})
+		})
+
+		Convey(".toStored", func() {
+			Convey("Should compress values that reach the threshold and restore them", func() {
+				for _, algorithm := range []string{CompressionGzip, CompressionSnappy, CompressionZstd} {
+					So(o.SetCompressionPolicy(&CompressionPolicy{Algorithm: algorithm, Threshold: 1024}), ShouldBeNil)
+					obj := (&tables.Object{OwnerID: "owner_id", Value: value}).Init().ComputeHash()
+					stored, err := o.toStored(obj)
+					So(err, ShouldBeNil)
+					So(stored.Compression, ShouldEqual, algorithm)
+					So(len(stored.Value), ShouldBeLessThan, len(value))
+					So(obj.Value, ShouldEqual, value)
+
+					So(decompress(stored), ShouldBeNil)
+					So(stored.Value, ShouldEqual, value)
+					So(stored.Compression, ShouldBeEmpty)
+					So(VerifyObjectHash(stored), ShouldBeNil)
+				}
+			})
+
+			Convey("Should not compress values below the threshold", func() {
+				So(o.SetCompressionPolicy(&CompressionPolicy{Algorithm: CompressionGzip, Threshold: 1 << 20}), ShouldBeNil)
+				stored, err := o.toStored(&tables.Object{Value: value})
+				So(err, ShouldBeNil)
+				So(stored.Compression, ShouldBeEmpty)
+				So(stored.Value, ShouldEqual, value)
+			})
+
+			Convey("Should compress values of objects that set an algorithm", func() {
+				stored, err := o.toStored(&tables.Object{Value: value, Compression: CompressionSnappy})
+				So(err, ShouldBeNil)
+				So(stored.Compression, ShouldEqual, CompressionSnappy)
+			})
+
+			Convey("Should not keep a compressed value that is not smaller", func() {
+				stored, err := o.toStored(&tables.Object{Value: "abc", Compression: CompressionGzip})
+				So(err, ShouldBeNil)
+				So(stored.Compression, ShouldBeEmpty)
+				So(stored.Value, ShouldEqual, "abc")
+			})
+		})
+
+		Convey(".decompress", func() {
+			Convey("Should return error if the value is not valid", func() {
+				err := decompress(&tables.Object{ID: "1", Value: "abc", Compression: CompressionSnappy})
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+}
+
+func TestObjectCompression(t *testing.T) {
+
+	if err := createDb(t); err != nil {
+		t.Fatalf("failed to create test database. %s", err)
+	}
+	defer dropDB(t)
+
+	cdb := cockroach.NewDB()
+	cdb.ConnectionString = conStrWithDB
+	cdb.NoLogging()
+	if err := cdb.Connect(10, 5); err != nil {
+		t.Fatalf("failed to connect to database. %s", err)
+	}
+
+	if err := cdb.CreateTables(); err != nil {
+		t.Fatalf("failed to create tables. %s", err)
+	}
+
+	obj := NewObject(cdb)
+
+	Convey("Object compression", t, func() {
+		ownerID := util.RandString(10)
+		_, err := obj.CreatePartitions(1, ownerID, ownerID)
+		So(err, ShouldBeNil)
+		So(obj.SetCompressionPolicy(&CompressionPolicy{Algorithm: CompressionGzip, Threshold: 1024}), ShouldBeNil)
+		defer obj.SetCompressionPolicy(nil)
+
+		Convey("Should store compressed values and return them uncompressed", func() {
+			value := strings.Repeat("abcdefgh", 10000)
+			o := &tables.Object{OwnerID: ownerID, Key: "large", Value: value}
+			So(obj.Put(o), ShouldBeNil)
+			So(o.Compression, ShouldBeEmpty)
+			So(o.Value, ShouldEqual, value)
+
+			stored, err := obj.GetLast(&tables.Object{OwnerID: ownerID, Key: "large"})
+			So(err, ShouldBeNil)
+			So(stored.Compression, ShouldBeEmpty)
+			So(stored.Value, ShouldEqual, value)
+			So(VerifyObjectHash(stored), ShouldBeNil)
+
+			partition, err := obj.GetLast(&tables.Object{ID: o.PartitionID})
+			So(err, ShouldBeNil)
+			objs, err := obj.All(&tables.Object{PartitionID: o.PartitionID, QueryParams: patchain.QueryParams{OrderBy: "timestamp asc"}})
+			So(err, ShouldBeNil)
+			So(VerifyPartition(partition, objs), ShouldBeNil)
+		})
+	})
+}
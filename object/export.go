@@ -0,0 +1,333 @@
+package object
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/ellcrys/patchain"
+	"github.com/ellcrys/patchain/cockroach/tables"
+	"github.com/ellcrys/util"
+	"github.com/pkg/errors"
+)
+
+// Export scopes
+const (
+	// ExportScopeQuery describes an export of the objects matching a query.
+	// Objects of a partition in such an export are not expected to be contiguous.
+	ExportScopeQuery = "query"
+
+	// ExportScopePartition describes an export of a partition and all its objects
+	ExportScopePartition = "partition"
+
+	// ExportScopeOwner describes an export of all the objects of an owner
+	ExportScopeOwner = "owner"
+)
+
+// ExportHeader is the first line of an export file
+type ExportHeader struct {
+	SchemaVersion string `json:"schema_version"`
+	HashAlgorithm string `json:"hash_algorithm"`
+	Scope         string `json:"scope"`
+	Timestamp     int64  `json:"timestamp"`
+}
+
+// Export writes the objects matching a query to w as JSON Lines. The first line is the
+// export header and every other line is an object. Objects are ordered from the oldest
+// to the most recent unless the query specifies an order. It returns the number of
+// objects exported.
+func (o *Object) Export(q patchain.Query, w io.Writer, options ...patchain.Option) (int, error) {
+	// order a copy of the query so that the caller's query is not modified
+	if obj, ok := q.(*tables.Object); ok && obj.QueryParams.OrderBy == "" {
+		ordered := *obj
+		ordered.QueryParams.OrderBy = "timestamp asc"
+		q = &ordered
+	}
+	objs, err := o.All(q, options...)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to get objects")
+	}
+	return writeExport(w, ExportScopeQuery, objs)
+}
+
+// ExportPartition writes a partition followed by all its objects to w as JSON Lines.
+// It returns the number of objects exported including the partition. If an identity
+// is acting, ErrPermissionDenied is returned unless it can read every protected object
+// of the partition.
+func (o *Object) ExportPartition(partitionID string, w io.Writer, options ...patchain.Option) (int, error) {
+
+	actorID := getActorID(options)
+
+	partition, err := o.GetLast(&tables.Object{ID: partitionID, QueryParams: patchain.KeyStartsWith(PartitionPrefix)}, options...)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to get partition")
+	}
+
+	objs, err := o.getPartitionObjects(partition.ID, withoutActor(options))
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to get partition objects")
+	}
+
+	if err := o.authorizeReadAll(objs, actorID, options); err != nil {
+		return 0, err
+	}
+
+	return writeExport(w, ExportScopePartition, append([]*tables.Object{partition}, objs...))
+}
+
+// ExportOwner writes all the objects of an owner, including its partitions, to w as
+// JSON Lines. It returns the number of objects exported. If an identity is acting,
+// ErrPermissionDenied is returned unless it can read the protected objects of the owner.
+func (o *Object) ExportOwner(ownerID string, w io.Writer, options ...patchain.Option) (int, error) {
+	objs, err := o.all(&tables.Object{OwnerID: ownerID, QueryParams: patchain.QueryParams{OrderBy: "timestamp asc"}}, withoutActor(options))
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to get objects")
+	}
+	if err := o.authorizeReadAll(objs, getActorID(options), options); err != nil {
+		return 0, err
+	}
+	return writeExport(w, ExportScopeOwner, objs)
+}
+
+// writeExport writes the export header and the objects to w
+func writeExport(w io.Writer, scope string, objs []*tables.Object) (int, error) {
+	enc := json.NewEncoder(w)
+	header := ExportHeader{
+		SchemaVersion: tables.SchemaVersion,
+		HashAlgorithm: HashAlgorithm,
+		Scope:         scope,
+		Timestamp:     time.Now().UnixNano(),
+	}
+	if err := enc.Encode(header); err != nil {
+		return 0, errors.Wrap(err, "failed to write export header")
+	}
+	for i, obj := range objs {
+		if err := enc.Encode(obj); err != nil {
+			return i, errors.Wrap(err, "failed to write object")
+		}
+	}
+	return len(objs), nil
+}
+
+// ReadExport reads and verifies an export. The hash of every object is verified and
+// objects of the same partition that are contiguous in their chain must be correctly
+// linked. For partition and owner exports, every object of a partition must be linked
+// to the object of the partition before it and the first object of a partition must be
+// linked to the partition if it is included. Errors include the offending line number.
+func ReadExport(r io.Reader) (*ExportHeader, []*tables.Object, error) {
+
+	header, objs, lines, err := DecodeExport(r)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := verifyExport(objs, lines, header.Scope != ExportScopeQuery); err != nil {
+		return nil, nil, err
+	}
+
+	return header, objs, nil
+}
+
+// DecodeExport reads an export without verifying its objects. It returns the
+// header, the objects and the line number of every object.
+func DecodeExport(r io.Reader) (*ExportHeader, []*tables.Object, []int, error) {
+
+	var header ExportHeader
+	var objs []*tables.Object
+	var lines []int
+
+	// lines are read without a size limit as values can be large
+	br := bufio.NewReader(r)
+	line, headerLine := 0, 0
+	for {
+		data, err := br.ReadBytes('\n')
+		if err == io.EOF && len(data) == 0 {
+			break
+		} else if err != nil && err != io.EOF {
+			return nil, nil, nil, errors.Wrap(err, "failed to read export")
+		}
+		line++
+		if len(bytes.TrimSpace(data)) == 0 {
+			continue
+		}
+		// the header is the first line that is not blank
+		if headerLine == 0 {
+			headerLine = line
+			if err := json.Unmarshal(data, &header); err != nil {
+				return nil, nil, nil, fmt.Errorf("line %d: malformed export header", line)
+			}
+			continue
+		}
+		var obj tables.Object
+		if err := json.Unmarshal(data, &obj); err != nil {
+			return nil, nil, nil, fmt.Errorf("line %d: malformed object", line)
+		}
+		objs = append(objs, &obj)
+		lines = append(lines, line)
+	}
+
+	if headerLine == 0 {
+		return nil, nil, nil, fmt.Errorf("line 1: export header not found")
+	} else if header.HashAlgorithm != HashAlgorithm {
+		return nil, nil, nil, fmt.Errorf("line %d: unsupported hash algorithm", headerLine)
+	} else if !tables.IsSchemaVersion(header.SchemaVersion) {
+		return nil, nil, nil, fmt.Errorf("line %d: unsupported schema version", headerLine)
+	}
+
+	return &header, objs, lines, nil
+}
+
+// verifyExport verifies the hashes and links of exported objects. If complete
+// is true, every object of a partition must be linked to the one before it.
+func verifyExport(objs []*tables.Object, lines []int, complete bool) error {
+
+	partitions := make(map[string]*tables.Object)
+	lastInPartition := make(map[string]int)
+
+	for i, obj := range objs {
+
+		if err := VerifyObjectHash(obj); err != nil {
+			return fmt.Errorf("line %d: %s", lines[i], err)
+		}
+
+		if obj.PartitionID == "" {
+			if strings.HasPrefix(obj.Key, PartitionPrefix) {
+				partitions[obj.ID] = obj
+			}
+			continue
+		}
+
+		j, seen := lastInPartition[obj.PartitionID]
+		lastInPartition[obj.PartitionID] = i
+
+		if !seen {
+			partition, ok := partitions[obj.PartitionID]
+			if complete && ok && obj.Key != ArchiveKey {
+				if obj.Key != "$genesis/1" || obj.PrevHash != util.Sha256(PartitionPrefix+partition.Hash) {
+					return fmt.Errorf("line %d: first object of the partition is not chained to the partition", lines[i])
+				}
+			}
+			continue
+		}
+
+		prevObj := objs[j]
+		if obj.PrevHash != prevObj.Hash {
+			if complete {
+				return fmt.Errorf("line %d: prev hash does not match the hash of the previous object", lines[i])
+			}
+			continue
+		}
+
+		peer := *prevObj
+		if peer.ComputePeerHash(obj.Hash).PeerHash != prevObj.PeerHash {
+			return fmt.Errorf("line %d: does not match the peer hash of the previous object", lines[i])
+		}
+	}
+
+	return nil
+}
+
+// Import reads a partition or owner export, verifies it and adds its objects to the
+// database in a single transaction. Nothing is added if the export fails verification.
+// Every object of a partition must be linked to the one before it, whatever the scope
+// of the export. The first object of a partition must be linked to the partition if it
+// is included or else to the current head of the partition in the database, whose
+// peer hash is then updated. Query exports are rejected. It returns the number
+// of objects imported. If an identity is acting, it must be allowed to write for the
+// owner of every object and be the owner of every object whose key starts with $,
+// except tombstones. The imported objects and partitions are charged to the quota
+// of their owners and nothing is added if a quota would be exceeded.
+func (o *Object) Import(r io.Reader, options ...patchain.Option) (int, error) {
+
+	header, objs, lines, err := DecodeExport(r)
+	if err != nil {
+		return 0, errors.Wrap(err, "export failed verification")
+	}
+
+	// the scope of the header is not trusted to relax the verification. Every
+	// object of a partition must be linked to the one before it.
+	if header.Scope == ExportScopeQuery {
+		return 0, fmt.Errorf("export failed verification: query exports cannot be imported")
+	} else if err := verifyExport(objs, lines, true); err != nil {
+		return 0, errors.Wrap(err, "export failed verification")
+	}
+
+	dbTx, dbOptions, finish := o.getDBOptions(options)
+	err = o.db.TransactWithDB(dbTx, finish, func(dbTx patchain.DB, commit patchain.CommitFunc, rollback patchain.RollbackFunc) error {
+		if err := o.authorizeImport(objs, getActorID(options), dbOptions); err != nil {
+			return err
+		}
+		if err := o.linkToHeads(objs, lines, dbOptions); err != nil {
+			return errors.Wrap(err, "export failed verification")
+		}
+		ownerIDs, usages, err := o.usageByOwner(objs)
+		if err != nil {
+			return err
+		}
+		for _, ownerID := range ownerIDs {
+			if err := o.chargeQuota(ownerID, usages[ownerID], true, dbOptions); err != nil {
+				return err
+			}
+		}
+		var stored []*tables.Object
+		for _, obj := range objs {
+			storedObj, err := o.store(o.db, obj, dbOptions)
+			if err != nil {
+				return err
+			}
+			stored = append(stored, storedObj)
+		}
+		return o.updateState(stored, dbOptions)
+	})
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to import objects")
+	}
+
+	return len(objs), nil
+}
+
+// linkToHeads checks that the first object of every partition whose partition
+// object is not included in objs is linked to the current head of the partition
+// in the database, and binds the head to it. The partition must be active.
+func (o *Object) linkToHeads(objs []*tables.Object, lines []int, options []patchain.Option) error {
+
+	included := make(map[string]bool)
+	for _, obj := range objs {
+		if obj.PartitionID == "" && strings.HasPrefix(obj.Key, PartitionPrefix) {
+			included[obj.ID] = true
+		}
+	}
+
+	linked := make(map[string]bool)
+	for i, obj := range objs {
+		if obj.PartitionID == "" || included[obj.PartitionID] || linked[obj.PartitionID] {
+			continue
+		}
+		linked[obj.PartitionID] = true
+
+		head, err := o.getLast(&tables.Object{PartitionID: obj.PartitionID}, options)
+		if err != nil {
+			if err == patchain.ErrNotFound {
+				return fmt.Errorf("line %d: partition of the object not found", lines[i])
+			}
+			return errors.Wrap(err, "failed to get partition head")
+		}
+		if getPartitionState(head) != PartitionActive {
+			return fmt.Errorf("line %d: %s", lines[i], ErrPartitionSealed)
+		}
+		if obj.PrevHash != head.Hash {
+			return fmt.Errorf("line %d: first object of the partition is not linked to the head of the partition", lines[i])
+		}
+
+		head.ComputePeerHash(obj.Hash)
+		if err := o.db.UpdatePeerHash(head, head.PeerHash, options...); err != nil {
+			return errors.Wrap(err, "failed to update partition head peer hash")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,280 @@
+package object
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/ellcrys/gorm"
+	"github.com/ellcrys/patchain"
+	"github.com/ellcrys/patchain/cockroach"
+	"github.com/ellcrys/patchain/cockroach/tables"
+	"github.com/ellcrys/util"
+	"github.com/pkg/errors"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// makeTestExport creates the content of an export file
+func makeTestExport(scope string, objs []*tables.Object) string {
+	var buf bytes.Buffer
+	writeExport(&buf, scope, objs)
+	return buf.String()
+}
+
+// replaceLine replaces a line of an export file with the JSON encoding of v
+func replaceLine(export string, line int, v interface{}) string {
+	lines := strings.Split(export, "\n")
+	bs, _ := json.Marshal(v)
+	lines[line-1] = string(bs)
+	return strings.Join(lines, "\n")
+}
+
+func TestReadExport(t *testing.T) {
+	Convey("ReadExport", t, func() {
+		partition, objs := makeTestPartition(3)
+		all := append([]*tables.Object{partition}, objs...)
+
+		Convey("Should successfully read a valid export", func() {
+			header, readObjs, err := ReadExport(strings.NewReader(makeTestExport(ExportScopePartition, all)))
+			So(err, ShouldBeNil)
+			So(header.Scope, ShouldEqual, ExportScopePartition)
+			So(header.SchemaVersion, ShouldEqual, tables.SchemaVersion)
+			So(header.HashAlgorithm, ShouldEqual, HashAlgorithm)
+			So(readObjs, ShouldResemble, all)
+		})
+
+		Convey("Should read the header from the first line that is not blank", func() {
+			header, readObjs, err := ReadExport(strings.NewReader("\n  \n" + makeTestExport(ExportScopePartition, all)))
+			So(err, ShouldBeNil)
+			So(header.Scope, ShouldEqual, ExportScopePartition)
+			So(readObjs, ShouldResemble, all)
+		})
+
+		Convey("Should read objects with values larger than 1MB", func() {
+			large := (&tables.Object{OwnerID: "owner_id", Key: "large", Value: strings.Repeat("a", 2<<20)}).Init().ComputeHash()
+			_, readObjs, err := ReadExport(strings.NewReader(makeTestExport(ExportScopeQuery, []*tables.Object{large})))
+			So(err, ShouldBeNil)
+			So(readObjs, ShouldHaveLength, 1)
+			So(readObjs[0].Value, ShouldEqual, large.Value)
+		})
+
+		Convey("Should return error if header is missing", func() {
+			_, _, err := ReadExport(strings.NewReader(""))
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "line 1: export header not found")
+		})
+
+		Convey("Should return error if hash algorithm is not supported", func() {
+			export := replaceLine(makeTestExport(ExportScopePartition, all), 1, ExportHeader{SchemaVersion: tables.SchemaVersion, HashAlgorithm: "md5"})
+			_, _, err := ReadExport(strings.NewReader(export))
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "line 1: unsupported hash algorithm")
+		})
+
+		Convey("Should return error with the line number of a malformed object", func() {
+			export := replaceLine(makeTestExport(ExportScopePartition, all), 3, "not an object")
+			_, _, err := ReadExport(strings.NewReader(export))
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "line 3: malformed object")
+		})
+
+		Convey("Should return error with the line number of an altered object", func() {
+			altered := *objs[2]
+			altered.Value = "altered"
+			export := replaceLine(makeTestExport(ExportScopePartition, all), 5, altered)
+			_, _, err := ReadExport(strings.NewReader(export))
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "line 5: hash mismatch")
+		})
+
+		Convey("Should return error with the line number of an object whose hash was recomputed after it was altered", func() {
+			altered := *objs[2]
+			altered.Value = "altered"
+			altered.ComputeHash()
+			export := replaceLine(makeTestExport(ExportScopePartition, all), 5, altered)
+			_, _, err := ReadExport(strings.NewReader(export))
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "line 5: does not match the peer hash of the previous object")
+		})
+
+		Convey("Should return error if an object was removed from a partition export", func() {
+			export := makeTestExport(ExportScopePartition, append(all[:3], all[4:]...))
+			_, _, err := ReadExport(strings.NewReader(export))
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "line 5: prev hash does not match the hash of the previous object")
+		})
+
+		Convey("Should allow non-contiguous objects in a query export", func() {
+			export := makeTestExport(ExportScopeQuery, append(all[:3], all[4:]...))
+			_, readObjs, err := ReadExport(strings.NewReader(export))
+			So(err, ShouldBeNil)
+			So(readObjs, ShouldHaveLength, 5)
+		})
+
+		Convey("Should return error if the genesis object is not chained to the partition", func() {
+			otherPartition := *partition
+			otherPartition.Key = MakePartitionKey("other_partition")
+			otherPartition.ComputeHash()
+			export := makeTestExport(ExportScopePartition, append([]*tables.Object{&otherPartition}, objs...))
+			_, _, err := ReadExport(strings.NewReader(export))
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "line 3: first object of the partition is not chained to the partition")
+		})
+	})
+}
+
+func TestExport(t *testing.T) {
+
+	if err := createDb(t); err != nil {
+		t.Fatalf("failed to create test database. %s", err)
+	}
+	defer dropDB(t)
+
+	cdb := cockroach.NewDB()
+	cdb.ConnectionString = conStrWithDB
+	cdb.NoLogging()
+	if err := cdb.Connect(10, 5); err != nil {
+		t.Fatalf("failed to connect to database. %s", err)
+	}
+
+	if err := cdb.CreateTables(); err != nil {
+		t.Fatalf("failed to create tables. %s", err)
+	}
+
+	obj := NewObject(cdb)
+
+	Convey("Export", t, func() {
+
+		ownerID := util.RandString(10)
+		partitions, err := obj.CreatePartitions(2, ownerID, ownerID)
+		So(err, ShouldBeNil)
+		err = obj.Put([]*tables.Object{{Key: "key_1", OwnerID: ownerID}, {Key: "key_2", OwnerID: ownerID}})
+		So(err, ShouldBeNil)
+		err = obj.Put(&tables.Object{Key: "key_1", OwnerID: ownerID})
+		So(err, ShouldBeNil)
+
+		Convey(".Export", func() {
+			Convey("Should export objects matching a query", func() {
+				var buf bytes.Buffer
+				q := &tables.Object{Key: "key_1"}
+				n, err := obj.Export(q, &buf)
+				So(err, ShouldBeNil)
+				So(n, ShouldEqual, 2)
+				So(q.QueryParams.OrderBy, ShouldBeEmpty)
+				header, objs, err := ReadExport(&buf)
+				So(err, ShouldBeNil)
+				So(header.Scope, ShouldEqual, ExportScopeQuery)
+				So(objs, ShouldHaveLength, 2)
+			})
+		})
+
+		Convey(".ExportPartition", func() {
+			Convey("Should export a partition and its objects", func() {
+				var buf bytes.Buffer
+				n, err := obj.ExportPartition(partitions[0].ID, &buf)
+				So(err, ShouldBeNil)
+				header, objs, err := ReadExport(&buf)
+				So(err, ShouldBeNil)
+				So(header.Scope, ShouldEqual, ExportScopePartition)
+				So(objs, ShouldHaveLength, n)
+				So(objs[0].ID, ShouldEqual, partitions[0].ID)
+			})
+
+			Convey("Should return ErrPermissionDenied if the acting identity cannot read a protected object", func() {
+				protected := &tables.Object{Key: "key_3", OwnerID: ownerID, Protected: true}
+				So(obj.Put(protected), ShouldBeNil)
+				var buf bytes.Buffer
+				_, err := obj.ExportPartition(protected.PartitionID, &buf, ActingAs(util.RandString(10)))
+				So(err, ShouldEqual, ErrPermissionDenied)
+				_, err = obj.ExportOwner(ownerID, &buf, ActingAs(util.RandString(10)))
+				So(err, ShouldEqual, ErrPermissionDenied)
+				_, err = obj.ExportOwner(ownerID, &buf, ActingAs(ownerID))
+				So(err, ShouldBeNil)
+			})
+		})
+
+		Convey(".ExportOwner / .Import", func() {
+			var buf bytes.Buffer
+			n, err := obj.ExportOwner(ownerID, &buf)
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 9)
+			export := buf.String()
+
+			Convey("Should not import anything from a tampered export", func() {
+				clearTable(cdb.GetConn().(*gorm.DB), "objects")
+				tampered := strings.Replace(export, `"key":"key_2"`, `"key":"key_x"`, 1)
+				_, err := obj.Import(strings.NewReader(tampered))
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, "export failed verification: line")
+				So(err.Error(), ShouldEndWith, "hash mismatch")
+				var count int64
+				err = cdb.Count(&tables.Object{}, &count)
+				So(err, ShouldBeNil)
+				So(count, ShouldEqual, 0)
+			})
+
+			Convey("Should not import a query export", func() {
+				clearTable(cdb.GetConn().(*gorm.DB), "objects")
+				query := strings.Replace(export, `"scope":"owner"`, `"scope":"query"`, 1)
+				_, err := obj.Import(strings.NewReader(query))
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "export failed verification: query exports cannot be imported")
+			})
+
+			Convey("Should not import objects whose partition is neither included nor stored", func() {
+				clearTable(cdb.GetConn().(*gorm.DB), "objects")
+				var lines []string
+				for _, line := range strings.Split(export, "\n") {
+					if !strings.Contains(line, `"key":"$partition/`) {
+						lines = append(lines, line)
+					}
+				}
+				_, err := obj.Import(strings.NewReader(strings.Join(lines, "\n")))
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "failed to import objects: export failed verification: line 2: partition of the object not found")
+			})
+
+			Convey("Should return ErrPermissionDenied if the acting identity cannot write for the owner", func() {
+				clearTable(cdb.GetConn().(*gorm.DB), "objects")
+				_, err := obj.Import(strings.NewReader(export), ActingAs(util.RandString(10)))
+				So(errors.Cause(err), ShouldEqual, ErrPermissionDenied)
+			})
+
+			Convey("Should return ErrPermissionDenied if an identity that is not the owner imports $ keys", func() {
+				granteeID := util.RandString(10)
+				_, err := obj.Grant(ownerID, granteeID, []string{PermWrite})
+				So(err, ShouldBeNil)
+				_, err = obj.Import(strings.NewReader(export), ActingAs(granteeID))
+				So(errors.Cause(err), ShouldEqual, ErrPermissionDenied)
+			})
+
+			Convey("Should not import anything if the quota of an owner would be exceeded", func() {
+				clearTable(cdb.GetConn().(*gorm.DB), "objects")
+				obj.SetOwnerQuota(ownerID, &Quota{MaxObjects: 2})
+				defer obj.SetOwnerQuota(ownerID, nil)
+				_, err := obj.Import(strings.NewReader(export))
+				So(IsQuotaError(err), ShouldBeTrue)
+				So(errors.Cause(err).(*QuotaError).Requested, ShouldEqual, 3)
+				var count int64
+				err = cdb.Count(&tables.Object{}, &count)
+				So(err, ShouldBeNil)
+				So(count, ShouldEqual, 0)
+			})
+
+			Convey("Should import an export", func() {
+				clearTable(cdb.GetConn().(*gorm.DB), "objects")
+				n, err := obj.Import(strings.NewReader(export))
+				So(err, ShouldBeNil)
+				So(n, ShouldEqual, 9)
+				all, err := obj.All(&tables.Object{OwnerID: ownerID, QueryParams: patchain.QueryParams{OrderBy: "timestamp asc"}})
+				So(err, ShouldBeNil)
+				So(all, ShouldHaveLength, 9)
+			})
+		})
+
+		Reset(func() {
+			clearTable(cdb.GetConn().(*gorm.DB), "objects")
+		})
+	})
+}
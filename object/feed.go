@@ -0,0 +1,546 @@
+package object
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ellcrys/patchain"
+	"github.com/ellcrys/patchain/cockroach/tables"
+	"github.com/ellcrys/util"
+	logging "github.com/op/go-logging"
+	"github.com/pkg/errors"
+)
+
+// FeedConfig configures a change feed
+type FeedConfig struct {
+
+	// OwnerID restricts the feed to the partitions of an owner
+	OwnerID string
+
+	// KeyPrefix restricts the feed to objects whose key starts with a prefix
+	KeyPrefix string
+
+	// Query restricts the feed to objects matching a JSQ query
+	Query string
+
+	// Cursor resumes a feed from a previously saved cursor
+	Cursor *FeedCursor
+
+	// StartAtEnd starts the feed at the head of the partitions that exist when it
+	// starts and are not in the cursor. By default, partitions are read from the start.
+	StartAtEnd bool
+
+	// PollInterval is how often partitions are polled for new objects. When the
+	// database supports change notifications, polling only catches notifications
+	// that were missed. Defaults to 1 second.
+	PollInterval time.Duration
+
+	// DisableNotifications forces polling even if the
+	// database supports change notifications
+	DisableNotifications bool
+}
+
+// FeedEvent describes an object added to a partition
+type FeedEvent struct {
+	Object      *tables.Object
+	PartitionID string
+
+	// Position is the position of the object in its partition.
+	// The first genesis object is at position 0.
+	Position int64
+}
+
+// FeedHandler handles the events of a feed. An error stops the feed.
+type FeedHandler func(ev *FeedEvent) error
+
+// PartitionCursor is the position of a feed in a partition
+type PartitionCursor struct {
+
+	// Hash is the hash of the last object handled. It is empty
+	// if no object of the partition has been handled.
+	Hash string `json:"hash"`
+
+	// Position is the position of the next object
+	Position int64 `json:"position"`
+
+	// Sealed is set once the seal of the partition has been handled
+	Sealed bool `json:"sealed,omitempty"`
+
+	// Timestamp is the most recent timestamp of the objects handled.
+	// Partitions are read in pages of objects more recent than it.
+	Timestamp int64 `json:"timestamp,omitempty"`
+}
+
+// FeedCursor is the position of a feed in every partition it reads
+type FeedCursor struct {
+	Partitions map[string]*PartitionCursor `json:"partitions"`
+}
+
+// feedPageSize is the maximum number of objects of a partition read by a query
+const feedPageSize = 100
+
+// NewFeedCursor creates an empty cursor
+func NewFeedCursor() *FeedCursor {
+	return &FeedCursor{Partitions: make(map[string]*PartitionCursor)}
+}
+
+// Encode encodes the cursor so it can be saved
+func (c *FeedCursor) Encode() string {
+	bs, _ := json.Marshal(c)
+	return string(bs)
+}
+
+// DecodeFeedCursor decodes a cursor encoded with Encode
+func DecodeFeedCursor(s string) (*FeedCursor, error) {
+	c := NewFeedCursor()
+	if err := json.Unmarshal([]byte(s), c); err != nil {
+		return nil, fmt.Errorf("malformed cursor")
+	}
+	if c.Partitions == nil {
+		c.Partitions = make(map[string]*PartitionCursor)
+	}
+	return c, nil
+}
+
+// copy returns a deep copy of the cursor
+func (c *FeedCursor) copy() *FeedCursor {
+	cp := NewFeedCursor()
+	for id, pc := range c.Partitions {
+		pcCopy := *pc
+		cp.Partitions[id] = &pcCopy
+	}
+	return cp
+}
+
+// Feed emits the objects added to partitions in chain order. Every partition is
+// read by following its chain from the object after the cursor, so objects are
+// emitted exactly in the order they were chained. Objects are delivered at least
+// once: a consumer that saves the cursor after handling events and resumes from
+// it will not miss objects but may see objects handled after the cursor was saved.
+type Feed struct {
+	sync.Mutex
+	o          *Object
+	cfg        FeedConfig
+	log        *logging.Logger
+	filter     *tables.Object
+	cursor     *FeedCursor
+	partitions map[string]*tables.Object
+	ignored    map[string]bool
+	dirty      map[string]bool
+	wake       chan struct{}
+	ready      chan struct{}
+	stop       chan struct{}
+	stopOnce   sync.Once
+}
+
+// NewFeed creates a change feed
+func (o *Object) NewFeed(cfg FeedConfig) (*Feed, error) {
+
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = time.Second
+	}
+
+	f := &Feed{
+		o:          o,
+		cfg:        cfg,
+		cursor:     NewFeedCursor(),
+		partitions: make(map[string]*tables.Object),
+		ignored:    make(map[string]bool),
+		dirty:      make(map[string]bool),
+		wake:       make(chan struct{}, 1),
+		ready:      make(chan struct{}),
+		stop:       make(chan struct{}),
+	}
+	f.log, _ = logging.GetLogger("patchain/feed")
+
+	if cfg.Cursor != nil {
+		f.cursor = cfg.Cursor.copy()
+	}
+
+	if cfg.Query != "" {
+		q, err := o.ParseQuery(cfg.Query)
+		if err != nil {
+			return nil, err
+		}
+		f.filter = q
+	}
+
+	return f, nil
+}
+
+// Cursor returns the position of the feed after the last event handled
+func (f *Feed) Cursor() *FeedCursor {
+	f.Lock()
+	defer f.Unlock()
+	return f.cursor.copy()
+}
+
+// CursorAfter returns the position the feed will have once an event has been
+// handled. Handlers can save it together with the effects of the event.
+func (f *Feed) CursorAfter(ev *FeedEvent) *FeedCursor {
+	c := f.Cursor()
+	pc := &PartitionCursor{
+		Hash:      ev.Object.Hash,
+		Position:  ev.Position + 1,
+		Sealed:    ev.Object.Key == SealKey,
+		Timestamp: ev.Object.Timestamp,
+	}
+	if prev := c.Partitions[ev.PartitionID]; prev != nil && prev.Timestamp > pc.Timestamp {
+		pc.Timestamp = prev.Timestamp
+	}
+	c.Partitions[ev.PartitionID] = pc
+	return c
+}
+
+// Ready returns a channel that is closed once the feed has read the
+// partitions that existed when it started
+func (f *Feed) Ready() <-chan struct{} {
+	return f.ready
+}
+
+// Stop stops the feed
+func (f *Feed) Stop() {
+	f.stopOnce.Do(func() {
+		close(f.stop)
+	})
+}
+
+// stopped checks whether the feed has been stopped
+func (f *Feed) stopped() bool {
+	select {
+	case <-f.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// Run reads the partitions and calls the handler for every new object until the feed
+// is stopped or the handler returns an error. Database errors are logged and the
+// operation is retried at the next poll.
+func (f *Feed) Run(handler FeedHandler) error {
+
+	f.watch()
+
+	if err := f.poll(nil, true, handler); err != nil {
+		return err
+	}
+	close(f.ready)
+
+	ticker := time.NewTicker(f.cfg.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-f.stop:
+			return nil
+		case <-ticker.C:
+			if err := f.poll(nil, false, handler); err != nil {
+				return err
+			}
+		case <-f.wake:
+			f.Lock()
+			dirty := f.dirty
+			f.dirty = make(map[string]bool)
+			f.Unlock()
+			if err := f.poll(dirty, false, handler); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// watch subscribes to change notifications if the database supports them.
+// If the subscription fails, the feed continues by polling.
+func (f *Feed) watch() {
+	notifier, ok := f.o.db.(patchain.ChangeNotifier)
+	if !ok || f.cfg.DisableNotifications {
+		return
+	}
+	go func() {
+		err := notifier.WatchObjects(f.stop, func(partitionID string) {
+			if partitionID == "" {
+				return
+			}
+			f.Lock()
+			f.dirty[partitionID] = true
+			f.Unlock()
+			select {
+			case f.wake <- struct{}{}:
+			default:
+			}
+		})
+		if err != nil {
+			f.log.Errorf("change notifications failed. Falling back to polling: %s", err)
+		}
+	}()
+}
+
+// poll reads the new objects of the given partitions or all partitions if
+// partitionIDs is nil. It only returns the errors returned by the handler.
+func (f *Feed) poll(partitionIDs map[string]bool, initial bool, handler FeedHandler) error {
+
+	refresh := partitionIDs == nil
+	for id := range partitionIDs {
+		if f.partitions[id] == nil && !f.ignored[id] {
+			refresh = true
+		}
+	}
+
+	if refresh {
+		if err := f.refreshPartitions(initial); err != nil {
+			f.log.Errorf("failed to get partitions: %s", err)
+			return nil
+		}
+		for id := range partitionIDs {
+			if f.partitions[id] == nil {
+				f.ignored[id] = true
+			}
+		}
+	}
+
+	var partitions []*tables.Object
+	for id, p := range f.partitions {
+		if partitionIDs == nil || partitionIDs[id] {
+			partitions = append(partitions, p)
+		}
+	}
+	sort.Stable(byTimestamp(partitions))
+
+	for _, p := range partitions {
+		if f.stopped() {
+			return nil
+		}
+		if err := f.read(p, handler); err != nil {
+			if he, ok := err.(*handlerError); ok {
+				return he.err
+			}
+			f.log.Errorf("failed to read partition %s: %s", p.ID, err)
+		}
+	}
+
+	return nil
+}
+
+// refreshPartitions fetches the partitions read by the feed and adds a cursor
+// for every new partition
+func (f *Feed) refreshPartitions(initial bool) error {
+
+	partitions, err := f.o.All(&tables.Object{OwnerID: f.cfg.OwnerID, QueryParams: patchain.QueryParams{
+		KeyStartsWith: PartitionPrefix,
+		OrderBy:       "timestamp asc",
+	}})
+	if err != nil {
+		return err
+	}
+
+	for _, p := range partitions {
+		if f.partitions[p.ID] != nil {
+			continue
+		}
+
+		f.Lock()
+		_, hasCursor := f.cursor.Partitions[p.ID]
+		f.Unlock()
+
+		if !hasCursor {
+			pc := &PartitionCursor{}
+			if initial && f.cfg.StartAtEnd {
+				if pc, err = f.headCursor(p); err != nil {
+					return err
+				}
+			}
+			f.Lock()
+			f.cursor.Partitions[p.ID] = pc
+			f.Unlock()
+		}
+
+		f.partitions[p.ID] = p
+		delete(f.ignored, p.ID)
+	}
+
+	return nil
+}
+
+// headCursor returns a cursor positioned after the last object of a partition
+func (f *Feed) headCursor(partition *tables.Object) (*PartitionCursor, error) {
+	head, err := f.o.getLast(&tables.Object{PartitionID: partition.ID}, nil)
+	if err != nil {
+		if err == patchain.ErrNotFound {
+			return &PartitionCursor{}, nil
+		}
+		return nil, errors.Wrap(err, "failed to get partition head")
+	}
+	var count int64
+	if err := f.o.db.Count(&tables.Object{PartitionID: partition.ID}, &count); err != nil {
+		return nil, errors.Wrap(err, "failed to count partition objects")
+	}
+	state := getPartitionState(head)
+	return &PartitionCursor{
+		Hash:      head.Hash,
+		Position:  count,
+		Sealed:    state == PartitionSealed || state == PartitionArchived,
+		Timestamp: head.Timestamp,
+	}, nil
+}
+
+// handlerError wraps an error returned by a feed handler
+type handlerError struct {
+	err error
+}
+
+func (e *handlerError) Error() string {
+	return e.err.Error()
+}
+
+// read follows the chain of a partition from its cursor and
+// calls the handler for every object matching the filters
+func (f *Feed) read(partition *tables.Object, handler FeedHandler) error {
+
+	f.Lock()
+	pc := *f.cursor.Partitions[partition.ID]
+	f.Unlock()
+
+	prevHash := pc.Hash
+	if prevHash == "" {
+		prevHash = util.Sha256(PartitionPrefix + partition.Hash)
+	}
+
+	for !pc.Sealed && !f.stopped() {
+
+		page, err := f.readPage(partition.ID, prevHash, pc.Timestamp)
+		if err != nil {
+			return err
+		}
+
+		// follow the chain through the page
+		byPrevHash := make(map[string]*tables.Object, len(page))
+		for _, obj := range page {
+			byPrevHash[obj.PrevHash] = obj
+		}
+		var chain []*tables.Object
+		for next := byPrevHash[prevHash]; next != nil && len(chain) < len(page); next = byPrevHash[next.Hash] {
+			chain = append(chain, next)
+		}
+
+		// a full page may not include the next object if objects
+		// chained after it have older timestamps
+		if len(chain) == 0 {
+			if len(page) < feedPageSize {
+				return nil
+			}
+			var next tables.Object
+			if err := f.o.db.GetLast(&tables.Object{PartitionID: partition.ID, PrevHash: prevHash}, &next); err != nil {
+				if err == patchain.ErrNotFound {
+					return nil
+				}
+				return err
+			}
+			chain = append(chain, &next)
+		}
+
+		matched, err := f.filterPage(partition.ID, chain)
+		if err != nil {
+			return err
+		}
+
+		for _, next := range chain {
+
+			if f.stopped() {
+				return nil
+			}
+
+			if f.matches(next, matched) {
+				if err := f.o.restoreValue(next); err != nil {
+					return err
+				}
+				if err := handler(&FeedEvent{Object: next, PartitionID: partition.ID, Position: pc.Position}); err != nil {
+					return &handlerError{err: err}
+				}
+			}
+
+			pc.Hash = next.Hash
+			pc.Position++
+			pc.Sealed = next.Key == SealKey
+			if next.Timestamp > pc.Timestamp {
+				pc.Timestamp = next.Timestamp
+			}
+			prevHash = next.Hash
+
+			f.Lock()
+			cp := pc
+			f.cursor.Partitions[partition.ID] = &cp
+			f.Unlock()
+
+			if pc.Sealed {
+				break
+			}
+		}
+	}
+
+	return nil
+}
+
+// readPage returns, in timestamp order, up to feedPageSize objects of a partition
+// that are more recent than timestamp. The object chained to prevHash is
+// included even if it is older.
+func (f *Feed) readPage(partitionID, prevHash string, timestamp int64) ([]*tables.Object, error) {
+	var page []*tables.Object
+	err := f.o.db.GetAll(&tables.Object{QueryParams: patchain.QueryParams{
+		Expr: patchain.Expr{
+			Expr: "partition_id = ? AND (timestamp > ? OR prev_hash = ?)",
+			Args: []interface{}{partitionID, timestamp, prevHash},
+		},
+		OrderBy: "timestamp asc",
+		Limit:   feedPageSize,
+	}}, &page)
+	if err != nil && err != patchain.ErrNotFound {
+		return nil, errors.Wrap(err, "failed to read partition")
+	}
+	return page, nil
+}
+
+// filterPage returns the ids of the objects that match the query of
+// the feed. It returns nil if the feed has no query.
+func (f *Feed) filterPage(partitionID string, objs []*tables.Object) (map[string]bool, error) {
+
+	if f.filter == nil || f.filter.QueryParams.Expr.Expr == "" {
+		return nil, nil
+	}
+
+	var ids []string
+	for _, obj := range objs {
+		ids = append(ids, obj.ID)
+	}
+
+	var matched []*tables.Object
+	err := f.o.db.GetAll(&tables.Object{QueryParams: patchain.QueryParams{
+		Expr:   patchain.Expr{Expr: "partition_id = ? AND id IN (?)", Args: []interface{}{partitionID, ids}},
+		Filter: f.filter.QueryParams.Expr,
+	}}, &matched)
+	if err != nil && err != patchain.ErrNotFound {
+		return nil, errors.Wrap(err, "failed to apply query filter")
+	}
+
+	matchedIDs := make(map[string]bool, len(matched))
+	for _, obj := range matched {
+		matchedIDs[obj.ID] = true
+	}
+
+	return matchedIDs, nil
+}
+
+// matches checks whether an object matches the filters of the feed.
+// matched holds the ids returned by filterPage.
+func (f *Feed) matches(obj *tables.Object, matched map[string]bool) bool {
+
+	if f.cfg.KeyPrefix != "" && !strings.HasPrefix(obj.Key, f.cfg.KeyPrefix) {
+		return false
+	}
+
+	return matched == nil || matched[obj.ID]
+}
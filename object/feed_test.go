@@ -0,0 +1,207 @@
+package object
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ellcrys/patchain/cockroach"
+	"github.com/ellcrys/patchain/cockroach/tables"
+	"github.com/ellcrys/util"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// collect runs a feed until n events have been handled or the timeout expires
+func collect(f *Feed, n int, timeout time.Duration) ([]*FeedEvent, error) {
+	var events []*FeedEvent
+	time.AfterFunc(timeout, f.Stop)
+	err := f.Run(func(ev *FeedEvent) error {
+		events = append(events, ev)
+		if len(events) == n {
+			f.Stop()
+		}
+		return nil
+	})
+	return events, err
+}
+
+func TestFeedCursor(t *testing.T) {
+	Convey("FeedCursor", t, func() {
+		Convey("Should encode and decode a cursor", func() {
+			c := NewFeedCursor()
+			c.Partitions["partition_a"] = &PartitionCursor{Hash: "hash", Position: 3, Timestamp: 10}
+			decoded, err := DecodeFeedCursor(c.Encode())
+			So(err, ShouldBeNil)
+			So(decoded, ShouldResemble, c)
+		})
+
+		Convey("Should return error if cursor is malformed", func() {
+			_, err := DecodeFeedCursor("{")
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "malformed cursor")
+		})
+	})
+}
+
+func TestFeed(t *testing.T) {
+
+	if err := createDb(t); err != nil {
+		t.Fatalf("failed to create test database. %s", err)
+	}
+	defer dropDB(t)
+
+	cdb := cockroach.NewDB()
+	cdb.ConnectionString = conStrWithDB
+	cdb.NoLogging()
+	if err := cdb.Connect(10, 5); err != nil {
+		t.Fatalf("failed to connect to database. %s", err)
+	}
+
+	if err := cdb.CreateTables(); err != nil {
+		t.Fatalf("failed to create tables. %s", err)
+	}
+
+	obj := NewObject(cdb)
+
+	Convey("Feed", t, func() {
+		ownerID := util.RandString(10)
+		partitions, err := obj.CreatePartitions(1, ownerID, ownerID)
+		So(err, ShouldBeNil)
+		objs := []*tables.Object{{Key: "a/1", OwnerID: ownerID}, {Key: "b/1", OwnerID: ownerID}, {Key: "a/2", OwnerID: ownerID}}
+		So(obj.Put(objs), ShouldBeNil)
+		cfg := FeedConfig{OwnerID: ownerID, PollInterval: 50 * time.Millisecond, DisableNotifications: true}
+
+		Convey("Should emit the objects of the owner's partitions in chain order", func() {
+			f, err := obj.NewFeed(cfg)
+			So(err, ShouldBeNil)
+			events, err := collect(f, 5, 5*time.Second)
+			So(err, ShouldBeNil)
+			So(events, ShouldHaveLength, 5)
+			So(events[0].Object.Key, ShouldEqual, "$genesis/1")
+			So(events[2].Object.ID, ShouldEqual, objs[0].ID)
+			for i, ev := range events {
+				So(ev.PartitionID, ShouldEqual, partitions[0].ID)
+				So(ev.Position, ShouldEqual, i)
+				if i > 0 {
+					So(ev.Object.PrevHash, ShouldEqual, events[i-1].Object.Hash)
+				}
+			}
+
+			Convey("Should resume from a cursor", func() {
+				cursor, err := DecodeFeedCursor(f.Cursor().Encode())
+				So(err, ShouldBeNil)
+				So(cursor.Partitions[partitions[0].ID].Position, ShouldEqual, 5)
+
+				newObj := &tables.Object{Key: "a/3", OwnerID: ownerID}
+				So(obj.Put(newObj), ShouldBeNil)
+
+				cfg.Cursor = cursor
+				f, err := obj.NewFeed(cfg)
+				So(err, ShouldBeNil)
+				events, err := collect(f, 1, 5*time.Second)
+				So(err, ShouldBeNil)
+				So(events, ShouldHaveLength, 1)
+				So(events[0].Object.ID, ShouldEqual, newObj.ID)
+				So(events[0].Position, ShouldEqual, 5)
+			})
+		})
+
+		Convey("Should emit objects added after the feed started", func() {
+			cfg.StartAtEnd = true
+			f, err := obj.NewFeed(cfg)
+			So(err, ShouldBeNil)
+			newObj := &tables.Object{Key: "a/3", OwnerID: ownerID}
+			time.AfterFunc(200*time.Millisecond, func() { obj.Put(newObj) })
+			events, err := collect(f, 1, 5*time.Second)
+			So(err, ShouldBeNil)
+			So(events, ShouldHaveLength, 1)
+			So(events[0].Object.Key, ShouldEqual, "a/3")
+			So(events[0].Position, ShouldEqual, 5)
+		})
+
+		Convey("Should emit the objects of a partition that do not fit in a page", func() {
+			var more []*tables.Object
+			for i := 0; i < feedPageSize+10; i++ {
+				more = append(more, &tables.Object{Key: fmt.Sprintf("c/%d", i), OwnerID: ownerID})
+			}
+			So(obj.Put(more), ShouldBeNil)
+			f, err := obj.NewFeed(cfg)
+			So(err, ShouldBeNil)
+			events, err := collect(f, 5+len(more), 5*time.Second)
+			So(err, ShouldBeNil)
+			So(events, ShouldHaveLength, 5+len(more))
+			for i, ev := range events {
+				So(ev.Position, ShouldEqual, i)
+			}
+			So(events[len(events)-1].Object.ID, ShouldEqual, more[len(more)-1].ID)
+		})
+
+		Convey("Should emit an object chained after an object with a more recent timestamp", func() {
+			f, err := obj.NewFeed(cfg)
+			So(err, ShouldBeNil)
+			_, err = collect(f, 5, 5*time.Second)
+			So(err, ShouldBeNil)
+
+			older := &tables.Object{Key: "a/3", OwnerID: ownerID, Timestamp: objs[0].Timestamp - 1}
+			So(obj.Put(older), ShouldBeNil)
+
+			cfg.Cursor = f.Cursor()
+			f, err = obj.NewFeed(cfg)
+			So(err, ShouldBeNil)
+			events, err := collect(f, 1, 5*time.Second)
+			So(err, ShouldBeNil)
+			So(events, ShouldHaveLength, 1)
+			So(events[0].Object.ID, ShouldEqual, older.ID)
+			So(events[0].Position, ShouldEqual, 5)
+		})
+
+		Convey("Should only emit objects with the key prefix", func() {
+			cfg.KeyPrefix = "a/"
+			f, err := obj.NewFeed(cfg)
+			So(err, ShouldBeNil)
+			events, err := collect(f, 2, 5*time.Second)
+			So(err, ShouldBeNil)
+			So(events, ShouldHaveLength, 2)
+			So(events[0].Position, ShouldEqual, 2)
+			So(events[1].Position, ShouldEqual, 4)
+		})
+
+		Convey("Should only emit objects matching the query", func() {
+			cfg.Query = `{ "key": "b/1" }`
+			f, err := obj.NewFeed(cfg)
+			So(err, ShouldBeNil)
+			events, err := collect(f, 1, 5*time.Second)
+			So(err, ShouldBeNil)
+			So(events, ShouldHaveLength, 1)
+			So(events[0].Object.ID, ShouldEqual, objs[1].ID)
+		})
+
+		Convey("Should return error if query is invalid", func() {
+			cfg.Query = `{ "unknown_field": "a" }`
+			_, err := obj.NewFeed(cfg)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Should stop and return the error returned by the handler", func() {
+			f, err := obj.NewFeed(cfg)
+			So(err, ShouldBeNil)
+			err = f.Run(func(ev *FeedEvent) error {
+				return fmt.Errorf("handler failed")
+			})
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "handler failed")
+			So(f.Cursor().Partitions[partitions[0].ID].Position, ShouldEqual, 0)
+		})
+
+		Convey("Should not read objects past the seal of a partition", func() {
+			_, err := obj.SealPartition(partitions[0].ID)
+			So(err, ShouldBeNil)
+			f, err := obj.NewFeed(cfg)
+			So(err, ShouldBeNil)
+			events, err := collect(f, 7, 5*time.Second)
+			So(err, ShouldBeNil)
+			So(events[len(events)-1].Object.Key, ShouldEqual, SealKey)
+			So(f.Cursor().Partitions[partitions[0].ID].Sealed, ShouldBeTrue)
+		})
+	})
+}
@@ -0,0 +1,228 @@
+package object
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ellcrys/patchain"
+	"github.com/ellcrys/patchain/cockroach/tables"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrProtected indicates an attempt to supersede a protected object without an override
+	ErrProtected = fmt.Errorf("object is protected")
+
+	// ErrRefOnlyValue indicates that a ref-only object has a value
+	ErrRefOnlyValue = fmt.Errorf("ref-only object must not have a value")
+)
+
+// OverrideOptionName is the name of the OverrideOption
+var OverrideOptionName = "override"
+
+// OverrideOption allows Put to supersede protected objects
+type OverrideOption struct{}
+
+// GetName returns the option's name
+func (t *OverrideOption) GetName() string {
+	return OverrideOptionName
+}
+
+// GetValue returns true
+func (t *OverrideOption) GetValue() interface{} {
+	return true
+}
+
+// WithOverride creates an option that allows Put to supersede protected objects.
+// If an acting identity is set, it must be the owner or have been granted PermOverride.
+func WithOverride() *OverrideOption {
+	return &OverrideOption{}
+}
+
+// IncludeRefOnlyOptionName is the name of the IncludeRefOnlyOption
+var IncludeRefOnlyOptionName = "include_ref_only"
+
+// IncludeRefOnlyOption causes All and GetLast to return ref-only objects
+type IncludeRefOnlyOption struct{}
+
+// GetName returns the option's name
+func (t *IncludeRefOnlyOption) GetName() string {
+	return IncludeRefOnlyOptionName
+}
+
+// GetValue returns true
+func (t *IncludeRefOnlyOption) GetValue() interface{} {
+	return true
+}
+
+// IncludeRefOnly creates an option that causes All and GetLast to return ref-only objects
+func IncludeRefOnly() *IncludeRefOnlyOption {
+	return &IncludeRefOnlyOption{}
+}
+
+// hasOption checks whether an option is included in the options
+func hasOption(options []patchain.Option, name string) bool {
+	for _, option := range options {
+		if option.GetName() == name {
+			return true
+		}
+	}
+	return false
+}
+
+// addFilter adds an expression to the filter of a query
+func addFilter(qp *patchain.QueryParams, expr string, args ...interface{}) {
+	if qp.Filter.Expr == "" {
+		qp.Filter = patchain.Expr{Expr: expr, Args: args}
+		return
+	}
+	qp.Filter = patchain.Expr{
+		Expr: fmt.Sprintf("(%s) AND %s", qp.Filter.Expr, expr),
+		Args: append(append([]interface{}{}, qp.Filter.Args...), args...),
+	}
+}
+
+// restrictQuery returns a copy of a query that excludes ref-only objects, unless
+// IncludeRefOnly is passed or the query asks for them, and the protected objects
+// the acting identity cannot read.
+func (o *Object) restrictQuery(q patchain.Query, options []patchain.Option) (patchain.Query, error) {
+
+	actorID := getActorID(options)
+	includeRefOnly := hasOption(options, IncludeRefOnlyOptionName)
+	if actorID == "" && includeRefOnly {
+		return q, nil
+	}
+
+	obj, ok := q.(*tables.Object)
+	if !ok {
+		return nil, fmt.Errorf("unsupported query type")
+	}
+	restricted := *obj
+
+	if !includeRefOnly && !obj.RefOnly {
+		addFilter(&restricted.QueryParams, "ref_only = ?", false)
+	}
+
+	if actorID != "" {
+		owners, err := o.readableOwners(actorID, options)
+		if err != nil {
+			return nil, err
+		}
+		addFilter(&restricted.QueryParams, "(protected = ? OR owner_id IN (?))", false, owners)
+	}
+
+	return &restricted, nil
+}
+
+// checkFlags enforces the rules of the Protected and RefOnly flags on objects
+// about to be added for an owner. Ref-only objects must not have a value. An
+// object cannot supersede (have the same key as) the latest version of a
+// protected object unless WithOverride is passed, in which case an override
+// record is returned for every superseded object. A tombstone supersedes the
+// object of the key it deletes. Other keys starting with $ are managed by this
+// package and are exempt.
+func (o *Object) checkFlags(ownerID string, objects []*tables.Object, options []patchain.Option, dbOptions []patchain.Option) ([]*tables.Object, error) {
+
+	var keys []string
+	for i, obj := range objects {
+		if obj.RefOnly && obj.Value != "" {
+			return nil, errors.Wrapf(ErrRefOnlyValue, "object %d", i)
+		}
+		if key, ok := stateKey(obj.Key); ok {
+			keys = append(keys, key, MakeTombstoneKey(key))
+		}
+	}
+
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	existing, err := o.all(&tables.Object{QueryParams: patchain.QueryParams{
+		Expr:    patchain.Expr{Expr: "owner_id = ? AND key IN (?)", Args: []interface{}{ownerID, keys}},
+		OrderBy: "timestamp asc",
+	}}, dbOptions)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get existing objects")
+	}
+
+	latest := make(map[string]*tables.Object)
+	for _, obj := range existing {
+		key, _ := stateKey(obj.Key)
+		latest[key] = obj
+	}
+
+	override := hasOption(options, OverrideOptionName)
+	actorID := getActorID(options)
+	var overrides []*tables.Object
+	var added = make(map[string]bool)
+	for i, obj := range objects {
+
+		key, ok := stateKey(obj.Key)
+		if !ok {
+			continue
+		}
+
+		// a protected object cannot be superseded in the batch that adds it
+		if added[key] && latest[key].Protected {
+			return nil, errors.Wrapf(ErrProtected, "object %d", i)
+		}
+
+		if current := latest[key]; current != nil && current.Protected {
+			if !override {
+				return nil, errors.Wrapf(ErrProtected, "object %d", i)
+			}
+			creatorID := ownerID
+			if actorID != "" {
+				allowed, err := o.HasPermission(ownerID, actorID, PermOverride, dbOptions...)
+				if err != nil {
+					return nil, err
+				} else if !allowed {
+					return nil, ErrPermissionDenied
+				}
+				creatorID = actorID
+			}
+			overrides = append(overrides, MakeOverrideObject(ownerID, creatorID, current))
+		}
+
+		// the object is the latest version for the objects after it
+		latest[key] = obj
+		added[key] = true
+	}
+
+	return overrides, nil
+}
+
+// VerifyFlags checks that the objects of an owner respect the rules of the
+// Protected and RefOnly flags: ref-only objects have no value and every
+// protected object superseded by a newer object or a tombstone of the same key has
+// an override record holding its hash. Objects must be ordered from the oldest to the most recent.
+func VerifyFlags(objs []*tables.Object) error {
+
+	var overrides = make(map[string]string)
+	for _, obj := range objs {
+		if strings.HasPrefix(obj.Key, OverridePrefix) {
+			overrides[strings.TrimPrefix(obj.Key, OverridePrefix)] = obj.Value
+		}
+	}
+
+	var latest = make(map[string]*tables.Object)
+	for _, obj := range objs {
+
+		if obj.RefOnly && obj.Value != "" {
+			return fmt.Errorf("object (%s): %s", obj.ID, ErrRefOnlyValue)
+		}
+
+		key, ok := stateKey(obj.Key)
+		if !ok {
+			continue
+		}
+
+		key = obj.OwnerID + "/" + key
+		if prev := latest[key]; prev != nil && prev.Protected && overrides[prev.ID] != prev.Hash {
+			return fmt.Errorf("object (%s): supersedes protected object (%s) without override", obj.ID, prev.ID)
+		}
+		latest[key] = obj
+	}
+
+	return nil
+}
@@ -0,0 +1,159 @@
+package object
+
+import (
+	"testing"
+
+	"github.com/ellcrys/patchain"
+	"github.com/ellcrys/patchain/cockroach"
+	"github.com/ellcrys/patchain/cockroach/tables"
+	"github.com/ellcrys/util"
+	"github.com/pkg/errors"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestVerifyFlags(t *testing.T) {
+	Convey("VerifyFlags", t, func() {
+		protected := &tables.Object{ID: "1", OwnerID: "owner_id", Key: "a", Hash: "hash_1", Protected: true}
+		newer := &tables.Object{ID: "2", OwnerID: "owner_id", Key: "a"}
+
+		Convey("Should return error if a ref-only object has a value", func() {
+			err := VerifyFlags([]*tables.Object{{ID: "1", RefOnly: true, Value: "abc"}})
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "object (1): ref-only object must not have a value")
+		})
+
+		Convey("Should return error if a protected object is superseded without override", func() {
+			err := VerifyFlags([]*tables.Object{protected, newer})
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "object (2): supersedes protected object (1) without override")
+		})
+
+		Convey("Should return error if the override does not hold the hash of the protected object", func() {
+			override := MakeOverrideObject("owner_id", "owner_id", protected)
+			override.Value = "other_hash"
+			err := VerifyFlags([]*tables.Object{protected, override, newer})
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Should accept a protected object superseded with an override", func() {
+			override := MakeOverrideObject("owner_id", "owner_id", protected)
+			So(VerifyFlags([]*tables.Object{protected, override, newer}), ShouldBeNil)
+		})
+
+		Convey("Should return error if a protected object is deleted without override", func() {
+			tombstone := MakeTombstoneObject("owner_id", "owner_id", "a", protected.ID)
+			So(VerifyFlags([]*tables.Object{protected, tombstone}), ShouldNotBeNil)
+			override := MakeOverrideObject("owner_id", "owner_id", protected)
+			So(VerifyFlags([]*tables.Object{protected, tombstone, override}), ShouldBeNil)
+		})
+
+		Convey("Should accept protected objects of different owners with the same key", func() {
+			other := &tables.Object{ID: "3", OwnerID: "owner_id_2", Key: "a"}
+			So(VerifyFlags([]*tables.Object{protected, other}), ShouldBeNil)
+		})
+	})
+}
+
+func TestFlags(t *testing.T) {
+
+	if err := createDb(t); err != nil {
+		t.Fatalf("failed to create test database. %s", err)
+	}
+	defer dropDB(t)
+
+	cdb := cockroach.NewDB()
+	cdb.ConnectionString = conStrWithDB
+	cdb.NoLogging()
+	if err := cdb.Connect(10, 5); err != nil {
+		t.Fatalf("failed to connect to database. %s", err)
+	}
+
+	if err := cdb.CreateTables(); err != nil {
+		t.Fatalf("failed to create tables. %s", err)
+	}
+
+	obj := NewObject(cdb)
+
+	Convey("Object flags", t, func() {
+		ownerID := util.RandString(10)
+		creatorID := util.RandString(10)
+		_, err := obj.CreatePartitions(1, ownerID, ownerID)
+		So(err, ShouldBeNil)
+
+		Convey("Protected objects", func() {
+			key := util.RandString(10)
+			protected := &tables.Object{OwnerID: ownerID, Key: key, Value: "v1", Protected: true}
+			So(obj.Put(protected), ShouldBeNil)
+
+			Convey("Should not be superseded without override", func() {
+				err := obj.Put(&tables.Object{OwnerID: ownerID, Key: key, Value: "v2"})
+				So(errors.Cause(err), ShouldEqual, ErrProtected)
+			})
+
+			Convey("Should not be superseded in the batch that adds them", func() {
+				key := util.RandString(10)
+				err := obj.Put([]*tables.Object{
+					{OwnerID: ownerID, Key: key, Protected: true},
+					{OwnerID: ownerID, Key: key},
+				}, WithOverride())
+				So(errors.Cause(err), ShouldEqual, ErrProtected)
+			})
+
+			Convey("Should be superseded with override and record it", func() {
+				So(obj.Put(&tables.Object{OwnerID: ownerID, Key: key, Value: "v2"}, WithOverride()), ShouldBeNil)
+				override, err := obj.GetLast(&tables.Object{OwnerID: ownerID, Key: MakeOverrideKey(protected.ID)})
+				So(err, ShouldBeNil)
+				So(override.Value, ShouldEqual, protected.Hash)
+				So(override.CreatorID, ShouldEqual, ownerID)
+
+				objs, err := obj.All(&tables.Object{OwnerID: ownerID, QueryParams: patchain.QueryParams{OrderBy: "timestamp asc"}})
+				So(err, ShouldBeNil)
+				So(VerifyFlags(objs), ShouldBeNil)
+			})
+
+			Convey("Should require the override permission for other identities", func() {
+				_, err := obj.Grant(ownerID, creatorID, []string{PermWrite})
+				So(err, ShouldBeNil)
+				err = obj.Put(&tables.Object{OwnerID: ownerID, Key: key}, ActingAs(creatorID), WithOverride())
+				So(errors.Cause(err), ShouldEqual, ErrPermissionDenied)
+
+				_, err = obj.Grant(ownerID, creatorID, []string{PermWrite, PermOverride})
+				So(err, ShouldBeNil)
+				So(obj.Put(&tables.Object{OwnerID: ownerID, Key: key}, ActingAs(creatorID), WithOverride()), ShouldBeNil)
+				override, err := obj.GetLast(&tables.Object{OwnerID: ownerID, Key: MakeOverrideKey(protected.ID)})
+				So(err, ShouldBeNil)
+				So(override.CreatorID, ShouldEqual, creatorID)
+			})
+		})
+
+		Convey("Ref-only objects", func() {
+			key := util.RandString(10)
+
+			Convey("Should not have a value", func() {
+				err := obj.Put(&tables.Object{OwnerID: ownerID, Key: key, Value: "abc", RefOnly: true})
+				So(errors.Cause(err), ShouldEqual, ErrRefOnlyValue)
+			})
+
+			Convey("Should be excluded from reads unless requested", func() {
+				So(obj.Put([]*tables.Object{
+					{OwnerID: ownerID, Key: key, Value: "abc"},
+					{OwnerID: ownerID, Key: key, Ref1: "some_ref", RefOnly: true},
+				}), ShouldBeNil)
+
+				objs, err := obj.All(&tables.Object{Key: key})
+				So(err, ShouldBeNil)
+				So(objs, ShouldHaveLength, 1)
+				last, err := obj.GetLast(&tables.Object{Key: key})
+				So(err, ShouldBeNil)
+				So(last.RefOnly, ShouldBeFalse)
+
+				objs, err = obj.All(&tables.Object{Key: key}, IncludeRefOnly())
+				So(err, ShouldBeNil)
+				So(objs, ShouldHaveLength, 2)
+				last, err = obj.GetLast(&tables.Object{Key: key, RefOnly: true})
+				So(err, ShouldBeNil)
+				So(last.RefOnly, ShouldBeTrue)
+			})
+		})
+	})
+}
@@ -0,0 +1,275 @@
+package object
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/ellcrys/patchain"
+	"github.com/ellcrys/patchain/cockroach/tables"
+	"github.com/pkg/errors"
+)
+
+// DefaultListLimit is the number of entries returned by List when no limit is set
+const DefaultListLimit = 1000
+
+// Listing is a page of the keys listed by List
+type Listing struct {
+
+	// Objects holds the latest object of the keys that are not rolled up in a common prefix
+	Objects []*tables.Object `json:"objects"`
+
+	// CommonPrefixes holds the distinct prefixes of the keys that contain the
+	// delimiter after the listed prefix, up to and including the delimiter
+	CommonPrefixes []string `json:"common_prefixes"`
+
+	// NextCursor is the cursor of the next page or empty if this is the last page
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// listEntry is a key or a common prefix found by List
+type listEntry struct {
+	key string
+	obj *tables.Object
+}
+
+// commonPrefix returns the common prefix a key is rolled up into: the key up
+// to and including the first delimiter after the prefix. It returns false if
+// there is no delimiter after the prefix.
+func commonPrefix(key, prefix, delimiter string) (string, bool) {
+	if delimiter == "" || !strings.HasPrefix(key, prefix) {
+		return "", false
+	}
+	i := strings.Index(key[len(prefix):], delimiter)
+	if i < 0 {
+		return "", false
+	}
+	return key[:len(prefix)+i+len(delimiter)], true
+}
+
+// prefixEnd returns the smallest string greater than every string that starts
+// with prefix. It returns an empty string if there is none.
+func prefixEnd(prefix string) string {
+	for prefix != "" {
+		r, size := utf8.DecodeLastRuneInString(prefix)
+		prefix = prefix[:len(prefix)-size]
+		switch {
+		case r == utf8.MaxRune || (r == utf8.RuneError && size == 1):
+			continue
+		case r == 0xD7FF:
+			return prefix + string(rune(0xE000))
+		default:
+			return prefix + string(r+1)
+		}
+	}
+	return ""
+}
+
+// List lists the keys of an owner that start with a prefix in ascending order,
+// like the listing of an S3 bucket. Keys that contain the delimiter after the
+// prefix are rolled up into a single common prefix. The latest object of every
+// other key is returned. Deleted keys (see Delete) and keys whose latest object
+// is ref-only are excluded. At most limit objects and common prefixes are returned;
+// a limit lower than 1 uses DefaultListLimit. Pass the NextCursor of a listing to get
+// the next page. If the owner id is empty, the keys of all owners are listed and a
+// key is listed once per owner; a page does not end between the owners of a key
+// unless they do not fit in a page. If an acting identity is set, keys whose latest
+// object is protected and cannot be read by the identity are excluded.
+func (o *Object) List(ownerID, prefix, delimiter, cursor string, limit int, options ...patchain.Option) (*Listing, error) {
+
+	if limit < 1 {
+		limit = DefaultListLimit
+	}
+
+	// the range of keys left to list. Once an object has been listed, the
+	// range starts after its key and owner.
+	lower, inclusive := prefix, true
+	var lowerOwner *string
+	if cursor != "" && cursor >= prefix {
+		lower, inclusive = cursor, false
+		if cp, ok := commonPrefix(cursor, prefix, delimiter); ok {
+			if lower, inclusive = prefixEnd(cp), true; lower == "" {
+				return &Listing{Objects: []*tables.Object{}, CommonPrefixes: []string{}}, nil
+			}
+		}
+	}
+	upper := prefixEnd(prefix)
+
+	visible := o.listVisibility(options)
+
+	var entries []*listEntry
+	for len(entries) <= limit {
+
+		expr, args := "key >= ?", []interface{}{lower}
+		switch {
+		case !inclusive && lowerOwner != nil:
+			expr, args = "(key, owner_id) > (?, ?)", []interface{}{lower, *lowerOwner}
+		case !inclusive:
+			expr = "key > ?"
+		}
+		if upper != "" {
+			expr, args = expr+" AND key < ?", append(args, upper)
+		}
+		if ownerID != "" {
+			expr, args = expr+" AND owner_id = ?", append(args, ownerID)
+		}
+
+		// the latest version of every key is selected before the visibility of
+		// the key is checked so that superseded versions are never listed. Only
+		// the first limit + 1 keys of the range are grouped.
+		var objs []*tables.Object
+		if err := o.db.GetAll(&tables.Object{QueryParams: patchain.QueryParams{
+			Expr: patchain.Expr{
+				Expr: "(owner_id, key, timestamp) IN (SELECT owner_id, key, MAX(timestamp) FROM objects WHERE " + expr +
+					" GROUP BY owner_id, key ORDER BY key asc, owner_id asc LIMIT ?)",
+				Args: append(args, limit+1),
+			},
+			OrderBy: "key asc, owner_id asc",
+			Limit:   limit + 1,
+		}}, &objs, withoutActor(options)...); err != nil {
+			return nil, errors.Wrap(err, "failed to list keys")
+		}
+		if len(objs) == 0 {
+			break
+		}
+
+		// the next query starts after the last object or the common prefix found
+		var found []*listEntry
+		var latest []*tables.Object
+		var rolledUp bool
+		last := objs[len(objs)-1]
+		lower, lowerOwner, inclusive = last.Key, &last.OwnerID, false
+		for i, obj := range objs {
+			if i > 0 && objs[i-1].Key == obj.Key && objs[i-1].OwnerID == obj.OwnerID {
+				continue
+			}
+			ok, err := visible(obj)
+			if err != nil {
+				return nil, err
+			} else if !ok {
+				continue
+			}
+			if cp, ok := commonPrefix(obj.Key, prefix, delimiter); ok {
+				found = append(found, &listEntry{key: cp})
+				lower, lowerOwner, inclusive = prefixEnd(cp), nil, true
+				rolledUp = true
+				break
+			}
+			found = append(found, &listEntry{key: obj.Key, obj: obj})
+			latest = append(latest, obj)
+		}
+
+		deleted, err := o.deletedKeys(latest, options)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range found {
+			if e.obj == nil || !deleted[e.obj.ID] {
+				entries = append(entries, e)
+			}
+		}
+
+		if (rolledUp && lower == "") || (!rolledUp && len(objs) <= limit) {
+			break
+		}
+	}
+
+	listing := &Listing{Objects: []*tables.Object{}, CommonPrefixes: []string{}}
+	if len(entries) > limit {
+		// the cursor is a key, so the owners of the first key that does not
+		// fit are moved to the next page, unless they fill the whole page
+		n := limit
+		for n > 0 && entries[n-1].obj != nil && entries[n-1].key == entries[limit].key {
+			n--
+		}
+		if n == 0 {
+			n = limit
+		}
+		entries = entries[:n]
+		listing.NextCursor = entries[n-1].key
+	}
+	for _, e := range entries {
+		if e.obj == nil {
+			listing.CommonPrefixes = append(listing.CommonPrefixes, e.key)
+			continue
+		}
+		if err := o.restoreValue(e.obj); err != nil {
+			return nil, err
+		}
+		listing.Objects = append(listing.Objects, e.obj)
+	}
+
+	return listing, nil
+}
+
+// listVisibility returns a function that checks whether the latest object of
+// a key can be listed: ref-only objects are excluded unless IncludeRefOnly is
+// passed and protected objects must be readable by the acting identity.
+// Permissions are checked once per owner.
+func (o *Object) listVisibility(options []patchain.Option) func(obj *tables.Object) (bool, error) {
+	includeRefOnly := hasOption(options, IncludeRefOnlyOptionName)
+	actorID := getActorID(options)
+	readable := make(map[string]bool)
+	return func(obj *tables.Object) (bool, error) {
+		if obj.RefOnly && !includeRefOnly {
+			return false, nil
+		}
+		if actorID == "" || !obj.Protected {
+			return true, nil
+		}
+		if allowed, ok := readable[obj.OwnerID]; ok {
+			return allowed, nil
+		}
+		err := o.authorizeRead(obj, actorID, options)
+		if err != nil && err != ErrPermissionDenied {
+			return false, err
+		}
+		readable[obj.OwnerID] = err == nil
+		return err == nil, nil
+	}
+}
+
+// deletedKeys returns the ids of the objects that were superseded by the
+// tombstone of their key. Only the tombstones of the owners and keys of
+// the objects are read.
+func (o *Object) deletedKeys(objs []*tables.Object, options []patchain.Option) (map[string]bool, error) {
+
+	deleted := make(map[string]bool)
+	var pairs []string
+	var args []interface{}
+	for _, obj := range objs {
+		if !strings.HasPrefix(obj.Key, "$") {
+			pairs = append(pairs, "(?, ?)")
+			args = append(args, obj.OwnerID, MakeTombstoneKey(obj.Key))
+		}
+	}
+	if len(pairs) == 0 {
+		return deleted, nil
+	}
+
+	expr := "(owner_id, key) IN (" + strings.Join(pairs, ", ") + ")"
+
+	// only the latest tombstone of every key is needed
+	var tombstones []*tables.Object
+	if err := o.db.GetAll(&tables.Object{QueryParams: patchain.QueryParams{
+		Expr: patchain.Expr{
+			Expr: "(owner_id, key, timestamp) IN (SELECT owner_id, key, MAX(timestamp) FROM objects WHERE " + expr + " GROUP BY owner_id, key)",
+			Args: args,
+		},
+	}}, &tombstones, withoutActor(options)...); err != nil {
+		return nil, errors.Wrap(err, "failed to get tombstones")
+	}
+
+	deletedAt := make(map[string]int64)
+	for _, t := range tombstones {
+		if t.Timestamp > deletedAt[t.OwnerID+"/"+t.Key] {
+			deletedAt[t.OwnerID+"/"+t.Key] = t.Timestamp
+		}
+	}
+	for _, obj := range objs {
+		if ts, ok := deletedAt[obj.OwnerID+"/"+MakeTombstoneKey(obj.Key)]; ok && ts > obj.Timestamp {
+			deleted[obj.ID] = true
+		}
+	}
+
+	return deleted, nil
+}
@@ -0,0 +1,173 @@
+package object
+
+import (
+	"testing"
+
+	"github.com/ellcrys/patchain/cockroach"
+	"github.com/ellcrys/patchain/cockroach/tables"
+	"github.com/ellcrys/util"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestListUtil(t *testing.T) {
+	Convey("List", t, func() {
+
+		Convey(".commonPrefix", func() {
+			Convey("Should return the key up to the first delimiter after the prefix", func() {
+				cp, ok := commonPrefix("a/b/c", "a/", "/")
+				So(ok, ShouldBeTrue)
+				So(cp, ShouldEqual, "a/b/")
+				cp, ok = commonPrefix("a::b::c", "", "::")
+				So(ok, ShouldBeTrue)
+				So(cp, ShouldEqual, "a::")
+			})
+
+			Convey("Should return false if there is no delimiter after the prefix", func() {
+				_, ok := commonPrefix("a/b", "a/", "/")
+				So(ok, ShouldBeFalse)
+				_, ok = commonPrefix("a/b", "", "")
+				So(ok, ShouldBeFalse)
+			})
+		})
+
+		Convey(".prefixEnd", func() {
+			Convey("Should return the smallest string greater than the strings with the prefix", func() {
+				So(prefixEnd("a/"), ShouldEqual, "a0")
+				So(prefixEnd("ab"), ShouldEqual, "ac")
+				So(prefixEnd("a"+string(rune(0x10FFFF))), ShouldEqual, "b")
+				So(prefixEnd(string(rune(0xD7FF))), ShouldEqual, string(rune(0xE000)))
+				So(prefixEnd(""), ShouldEqual, "")
+			})
+		})
+	})
+}
+
+func TestList(t *testing.T) {
+
+	if err := createDb(t); err != nil {
+		t.Fatalf("failed to create test database. %s", err)
+	}
+	defer dropDB(t)
+
+	cdb := cockroach.NewDB()
+	cdb.ConnectionString = conStrWithDB
+	cdb.NoLogging()
+	if err := cdb.Connect(10, 5); err != nil {
+		t.Fatalf("failed to connect to database. %s", err)
+	}
+
+	if err := cdb.CreateTables(); err != nil {
+		t.Fatalf("failed to create tables. %s", err)
+	}
+
+	obj := NewObject(cdb)
+
+	Convey("Object list", t, func() {
+		ownerID := util.RandString(10)
+		_, err := obj.CreatePartitions(1, ownerID, ownerID)
+		So(err, ShouldBeNil)
+		So(obj.Put([]*tables.Object{
+			{OwnerID: ownerID, Key: "docs/a", Value: "v1"},
+			{OwnerID: ownerID, Key: "docs/a", Value: "v2"},
+			{OwnerID: ownerID, Key: "docs/b/1", Value: "v1"},
+			{OwnerID: ownerID, Key: "docs/b/2", Value: "v1"},
+			{OwnerID: ownerID, Key: "docs/c", Value: "v1"},
+			{OwnerID: ownerID, Key: "docs/d/1", Value: "v1"},
+			{OwnerID: ownerID, Key: "other", Value: "v1"},
+		}), ShouldBeNil)
+
+		Convey("Should return the latest objects and the common prefixes", func() {
+			listing, err := obj.List(ownerID, "docs/", "/", "", 0)
+			So(err, ShouldBeNil)
+			So(listing.Objects, ShouldHaveLength, 2)
+			So(listing.Objects[0].Key, ShouldEqual, "docs/a")
+			So(listing.Objects[0].Value, ShouldEqual, "v2")
+			So(listing.Objects[1].Key, ShouldEqual, "docs/c")
+			So(listing.CommonPrefixes, ShouldResemble, []string{"docs/b/", "docs/d/"})
+			So(listing.NextCursor, ShouldBeEmpty)
+		})
+
+		Convey("Should list every key without a delimiter", func() {
+			listing, err := obj.List(ownerID, "docs/", "", "", 0)
+			So(err, ShouldBeNil)
+			So(listing.Objects, ShouldHaveLength, 5)
+			So(listing.CommonPrefixes, ShouldBeEmpty)
+		})
+
+		Convey("Should page through the keys using the cursor", func() {
+			var keys []string
+			var cursor string
+			for {
+				listing, err := obj.List(ownerID, "docs/", "/", cursor, 1)
+				So(err, ShouldBeNil)
+				for _, o := range listing.Objects {
+					keys = append(keys, o.Key)
+				}
+				keys = append(keys, listing.CommonPrefixes...)
+				if cursor = listing.NextCursor; cursor == "" {
+					break
+				}
+			}
+			So(keys, ShouldResemble, []string{"docs/a", "docs/b/", "docs/c", "docs/d/"})
+		})
+
+		Convey("Should exclude deleted keys", func() {
+			_, err := obj.Delete(ownerID, "docs/c")
+			So(err, ShouldBeNil)
+			listing, err := obj.List(ownerID, "docs/", "/", "", 0)
+			So(err, ShouldBeNil)
+			So(listing.Objects, ShouldHaveLength, 1)
+			So(listing.Objects[0].Key, ShouldEqual, "docs/a")
+		})
+
+		Convey("Should exclude keys whose latest object is not visible instead of listing an older version", func() {
+			So(obj.Put(&tables.Object{OwnerID: ownerID, Key: "docs/p", Value: "v1"}), ShouldBeNil)
+			So(obj.Put(&tables.Object{OwnerID: ownerID, Key: "docs/p", Value: "v2", Protected: true}), ShouldBeNil)
+			So(obj.Put(&tables.Object{OwnerID: ownerID, Key: "docs/r", Value: "v1"}), ShouldBeNil)
+			So(obj.Put(&tables.Object{OwnerID: ownerID, Key: "docs/r", RefOnly: true}), ShouldBeNil)
+
+			listing, err := obj.List(ownerID, "docs/", "/", "", 0, ActingAs(util.RandString(10)))
+			So(err, ShouldBeNil)
+			So(listing.Objects, ShouldHaveLength, 2)
+			So(listing.Objects[0].Key, ShouldEqual, "docs/a")
+			So(listing.Objects[1].Key, ShouldEqual, "docs/c")
+
+			listing, err = obj.List(ownerID, "docs/", "/", "", 0, ActingAs(ownerID), IncludeRefOnly())
+			So(err, ShouldBeNil)
+			So(listing.Objects, ShouldHaveLength, 4)
+			So(listing.Objects[2].Key, ShouldEqual, "docs/p")
+			So(listing.Objects[2].Value, ShouldEqual, "v2")
+			So(listing.Objects[3].Key, ShouldEqual, "docs/r")
+			So(listing.Objects[3].RefOnly, ShouldBeTrue)
+		})
+
+		Convey("Should list a key once per owner if the owner id is empty", func() {
+			prefix := util.RandString(10) + "/"
+			otherID := util.RandString(10)
+			_, err := obj.CreatePartitions(1, otherID, otherID)
+			So(err, ShouldBeNil)
+			So(obj.Put(&tables.Object{OwnerID: ownerID, Key: prefix + "a", Value: "v1"}), ShouldBeNil)
+			So(obj.Put(&tables.Object{OwnerID: ownerID, Key: prefix + "a", Value: "v2"}), ShouldBeNil)
+			So(obj.Put(&tables.Object{OwnerID: otherID, Key: prefix + "a", Value: "v1"}), ShouldBeNil)
+			So(obj.Put(&tables.Object{OwnerID: otherID, Key: prefix + "b", Value: "v1"}), ShouldBeNil)
+
+			listing, err := obj.List("", prefix, "/", "", 0)
+			So(err, ShouldBeNil)
+			So(listing.Objects, ShouldHaveLength, 3)
+			So(listing.Objects[0].Key, ShouldEqual, prefix+"a")
+			So(listing.Objects[1].Key, ShouldEqual, prefix+"a")
+			So(listing.Objects[2].Key, ShouldEqual, prefix+"b")
+
+			Convey("Should not end a page between the owners of a key", func() {
+				listing, err := obj.List("", prefix, "/", "", 2)
+				So(err, ShouldBeNil)
+				So(listing.Objects, ShouldHaveLength, 2)
+				So(listing.NextCursor, ShouldEqual, prefix+"a")
+				listing, err = obj.List("", prefix, "/", listing.NextCursor, 2)
+				So(err, ShouldBeNil)
+				So(listing.Objects, ShouldHaveLength, 1)
+				So(listing.Objects[0].Key, ShouldEqual, prefix+"b")
+			})
+		})
+	})
+}
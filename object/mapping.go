@@ -0,0 +1,446 @@
+package object
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ellcrys/patchain"
+	"github.com/ellcrys/patchain/cockroach/tables"
+	"github.com/fatih/structs"
+	"github.com/pkg/errors"
+)
+
+// Mapping field types
+const (
+	FieldString = "string"
+	FieldInt    = "int"
+	FieldFloat  = "float"
+	FieldBool   = "bool"
+)
+
+var (
+	// fieldTypes lists the supported mapping field types
+	fieldTypes = []string{FieldString, FieldInt, FieldFloat, FieldBool}
+
+	// refColumns lists the columns a mapping can name
+	refColumns = []string{"ref1", "ref2", "ref3", "ref4", "ref5", "ref6", "ref7", "ref8", "ref9", "ref10"}
+
+	// fieldNameRe matches a valid mapping field name
+	fieldNameRe = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+	// maxRefLength is the maximum length of a ref column value
+	maxRefLength = 64
+
+	// ErrNoMapping indicates that a mapping does not exist
+	ErrNoMapping = fmt.Errorf("mapping not found")
+)
+
+// MappingField describes a named ref column
+type MappingField struct {
+	Column   string `json:"-"`
+	Name     string `json:"name"`
+	Type     string `json:"type,omitempty"`
+	Required bool   `json:"required,omitempty"`
+}
+
+// Mapping gives ref columns friendly names and types. It is defined as a JSON
+// object whose keys are ref columns and whose values are either a field name
+// or an object describing the field:
+//
+//	{ "ref1": "order_id", "ref2": { "name": "amount", "type": "int", "required": true } }
+//
+// Fields are of type string by default. Values are stored as strings in the ref
+// columns, so range queries on int and float fields compare them as strings.
+// Ordering by an int or float field casts the values to numbers.
+type Mapping struct {
+	fields map[string]*MappingField
+	names  map[string]*MappingField
+}
+
+// ParseMapping parses and validates a mapping
+func ParseMapping(mappingJSON string) (*Mapping, error) {
+
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(mappingJSON), &raw); err != nil {
+		return nil, fmt.Errorf("malformed mapping")
+	}
+
+	m := &Mapping{fields: make(map[string]*MappingField), names: make(map[string]*MappingField)}
+	for column, def := range raw {
+
+		column = strings.ToLower(column)
+		if !inStrings(refColumns, column) {
+			return nil, fmt.Errorf("%s: only ref1 to ref10 can be mapped", column)
+		}
+
+		field := &MappingField{}
+		if err := json.Unmarshal(def, &field.Name); err != nil {
+			if err := json.Unmarshal(def, field); err != nil {
+				return nil, fmt.Errorf("%s: malformed field", column)
+			}
+		}
+		field.Column = column
+
+		if field.Type == "" {
+			field.Type = FieldString
+		}
+		if !inStrings(fieldTypes, field.Type) {
+			return nil, fmt.Errorf("%s: unknown type %s", column, field.Type)
+		}
+
+		if !fieldNameRe.MatchString(field.Name) {
+			return nil, fmt.Errorf("%s: invalid field name", column)
+		}
+		if inStrings(objectFields(), field.Name) || m.names[field.Name] != nil {
+			return nil, fmt.Errorf("%s: field name %s is already used", column, field.Name)
+		}
+
+		m.fields[column] = field
+		m.names[field.Name] = field
+	}
+
+	if len(m.fields) == 0 {
+		return nil, fmt.Errorf("mapping has no field")
+	}
+
+	return m, nil
+}
+
+// objectFields returns the json names of the fields of an object.
+// Mapped field names must not shadow them in query and results.
+func objectFields() (fields []string) {
+	for _, f := range structs.New(tables.Object{}).Fields() {
+		if name := strings.Split(f.Tag("json"), ",")[0]; name != "-" {
+			fields = append(fields, name)
+		}
+	}
+	return
+}
+
+// inStrings checks whether a string is in a slice
+func inStrings(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Fields returns the fields of the mapping ordered by column
+func (m *Mapping) Fields() []*MappingField {
+	var fields []*MappingField
+	for _, f := range m.fields {
+		fields = append(fields, f)
+	}
+	sort.Slice(fields, func(i, j int) bool {
+		return refIndex(fields[i].Column) < refIndex(fields[j].Column)
+	})
+	return fields
+}
+
+// refIndex returns the number of a ref column
+func refIndex(column string) int {
+	n, _ := strconv.Atoi(strings.TrimPrefix(column, "ref"))
+	return n
+}
+
+// refValue returns the value of a ref column of an object
+func refValue(obj *tables.Object, column string) string {
+	return structs.New(obj).Field("Ref" + strings.TrimPrefix(column, "ref")).Value().(string)
+}
+
+// checkValue checks that a value is valid for the type of a field
+func (f *MappingField) checkValue(v string) error {
+	var err error
+	switch f.Type {
+	case FieldInt:
+		_, err = strconv.ParseInt(v, 10, 64)
+	case FieldFloat:
+		_, err = strconv.ParseFloat(v, 64)
+	case FieldBool:
+		_, err = strconv.ParseBool(v)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: not a valid %s", f.Name, f.Type)
+	}
+	return nil
+}
+
+// decodeValue converts the value of a field to its type
+func (f *MappingField) decodeValue(v string) interface{} {
+	switch f.Type {
+	case FieldInt:
+		n, _ := strconv.ParseInt(v, 10, 64)
+		return n
+	case FieldFloat:
+		n, _ := strconv.ParseFloat(v, 64)
+		return n
+	case FieldBool:
+		b, _ := strconv.ParseBool(v)
+		return b
+	}
+	return v
+}
+
+// Validate checks that the ref columns of an object are valid for the mapping
+func (m *Mapping) Validate(obj *tables.Object) error {
+	for _, f := range m.Fields() {
+		v := refValue(obj, f.Column)
+		if v == "" {
+			if f.Required {
+				return fmt.Errorf("%s: is required", f.Name)
+			}
+			continue
+		}
+		if len(v) > maxRefLength {
+			return fmt.Errorf("%s: must not be longer than %d characters", f.Name, maxRefLength)
+		}
+		if err := f.checkValue(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ToMap returns the fields of an object with mapped ref columns replaced by
+// their field names and values converted to the type of their field
+func (m *Mapping) ToMap(obj *tables.Object) map[string]interface{} {
+
+	bs, _ := json.Marshal(obj)
+	var named map[string]interface{}
+	json.Unmarshal(bs, &named)
+
+	for _, f := range m.fields {
+		delete(named, f.Column)
+		if v := refValue(obj, f.Column); v != "" {
+			named[f.Name] = f.decodeValue(v)
+		}
+	}
+
+	return named
+}
+
+// translateValue converts the values compared to a mapped field to
+// strings so they can be compared to the values of the ref column
+func translateValue(v interface{}) interface{} {
+	switch val := v.(type) {
+	case json.Number:
+		return val.String()
+	case bool:
+		return strconv.FormatBool(val)
+	case []interface{}:
+		for i := range val {
+			val[i] = translateValue(val[i])
+		}
+	case map[string]interface{}:
+		for k := range val {
+			val[k] = translateValue(val[k])
+		}
+	}
+	return v
+}
+
+// translate replaces field names in a decoded JSQ query by their ref column
+func (m *Mapping) translate(v interface{}) interface{} {
+	switch val := v.(type) {
+	case []interface{}:
+		for i := range val {
+			val[i] = m.translate(val[i])
+		}
+	case map[string]interface{}:
+		translated := make(map[string]interface{}, len(val))
+		for k, fv := range val {
+			if f := m.names[k]; f != nil {
+				translated[f.Column] = translateValue(fv)
+			} else {
+				translated[k] = m.translate(fv)
+			}
+		}
+		return translated
+	}
+	return v
+}
+
+// TranslateQuery translates a JSQ query that uses the field names
+// of the mapping into a query on the ref columns
+func (m *Mapping) TranslateQuery(jsqQuery string) (string, error) {
+	dec := json.NewDecoder(bytes.NewReader([]byte(jsqQuery)))
+	dec.UseNumber()
+	var q map[string]interface{}
+	if err := dec.Decode(&q); err != nil {
+		return "", fmt.Errorf("malformed query")
+	}
+	bs, err := json.Marshal(m.translate(q))
+	if err != nil {
+		return "", err
+	}
+	return string(bs), nil
+}
+
+// TranslateOrderBy translates an order by clause that uses a field name of the mapping
+func (m *Mapping) TranslateOrderBy(orderBy string) string {
+	parts := strings.Fields(orderBy)
+	if len(parts) > 0 {
+		if f := m.names[strings.ToLower(parts[0])]; f != nil {
+			parts[0] = f.Column
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// numericRe matches the values of int and float fields that can be ordered numerically
+const numericRe = `^[-+]?([0-9]+[.]?[0-9]*|[.][0-9]+)([eE][-+]?[0-9]+)?$`
+
+// castOrderBy makes a translated order by clause on an int or float field
+// order by the numeric value of its ref column instead of the string value.
+// Values that are not numbers are ordered as NULL.
+func (m *Mapping) castOrderBy(orderBy string) string {
+	parts := strings.Fields(orderBy)
+	if len(parts) == 0 {
+		return orderBy
+	}
+	if f := m.fields[parts[0]]; f != nil && (f.Type == FieldInt || f.Type == FieldFloat) {
+		parts[0] = fmt.Sprintf("(CASE WHEN %s ~ '%s' THEN %s::DECIMAL END)", f.Column, numericRe, f.Column)
+	}
+	return strings.Join(parts, " ")
+}
+
+// MappingOptionName is the name of the MappingOption
+var MappingOptionName = "mapping"
+
+// MappingOption causes Put to validate objects against a mapping of their owner
+type MappingOption struct {
+	Name string
+}
+
+// GetName returns the option's name
+func (t *MappingOption) GetName() string {
+	return MappingOptionName
+}
+
+// GetValue returns the name of the mapping
+func (t *MappingOption) GetValue() interface{} {
+	return t.Name
+}
+
+// WithMapping creates an option that causes Put to validate
+// the objects against a mapping of their owner
+func WithMapping(name string) *MappingOption {
+	return &MappingOption{Name: name}
+}
+
+// getMappingName returns the name of the mapping included in the options
+func getMappingName(options []patchain.Option) string {
+	for _, option := range options {
+		if option.GetName() == MappingOptionName {
+			return option.(*MappingOption).Name
+		}
+	}
+	return ""
+}
+
+// DefineMapping validates a mapping and adds it to a partition of its owner.
+// A mapping is redefined by defining it again.
+func (o *Object) DefineMapping(ownerID, name, mappingJSON string, options ...patchain.Option) (*tables.Object, error) {
+	if _, err := ParseMapping(mappingJSON); err != nil {
+		return nil, err
+	}
+	mappingObj := MakeMappingObject(ownerID, name, mappingJSON)
+	if err := o.Put(mappingObj, options...); err != nil {
+		return nil, errors.Wrap(err, "failed to define mapping")
+	}
+	return mappingObj, nil
+}
+
+// GetMapping returns the latest definition of a mapping of an owner
+func (o *Object) GetMapping(ownerID, name string, options ...patchain.Option) (*Mapping, error) {
+	mappingObj, err := o.GetLast(&tables.Object{OwnerID: ownerID, Key: MakeMappingKey(name)}, options...)
+	if err != nil {
+		if err == patchain.ErrNotFound {
+			return nil, ErrNoMapping
+		}
+		return nil, errors.Wrap(err, "failed to get mapping")
+	}
+	return ParseMapping(mappingObj.Value)
+}
+
+// MappingError indicates that an object does not match a mapping
+type MappingError struct {
+
+	// Index is the position of the object among the objects validated
+	Index int
+
+	Err error
+}
+
+// Error returns the error message
+func (e *MappingError) Error() string {
+	return fmt.Sprintf("object %d: %s", e.Index, e.Err)
+}
+
+// validateMapping validates objects against a mapping of their owner
+func (o *Object) validateMapping(ownerID, name string, objects []*tables.Object, options []patchain.Option) error {
+	m, err := o.GetMapping(ownerID, name, options...)
+	if err != nil {
+		return err
+	}
+	for i, obj := range objects {
+		if err := m.Validate(obj); err != nil {
+			return &MappingError{Index: i, Err: err}
+		}
+	}
+	return nil
+}
+
+// AllMapped returns the objects of an owner that match a JSQ query using the
+// field names of a mapping. Objects are returned as maps in which mapped ref
+// columns are replaced by their field names. orderBy can use a field name;
+// int and float fields are ordered by their numeric value.
+func (o *Object) AllMapped(ownerID, mappingName, jsqQuery, orderBy string, limit int, options ...patchain.Option) ([]map[string]interface{}, error) {
+
+	m, err := o.GetMapping(ownerID, mappingName, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	translated, err := m.TranslateQuery(jsqQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	q, err := o.ParseQuery(translated)
+	if err != nil {
+		return nil, err
+	}
+
+	if q.QueryParams.Expr.Expr == "" {
+		q.QueryParams.Expr.Expr = "owner_id = ?"
+	} else {
+		q.QueryParams.Expr.Expr = fmt.Sprintf("(%s) AND owner_id = ?", q.QueryParams.Expr.Expr)
+	}
+	q.QueryParams.Expr.Args = append(q.QueryParams.Expr.Args, ownerID)
+	q.QueryParams.Limit = limit
+	if orderBy != "" {
+		if q.QueryParams.OrderBy, err = o.ParseOrderBy(m.TranslateOrderBy(orderBy)); err != nil {
+			return nil, err
+		}
+		q.QueryParams.OrderBy = m.castOrderBy(q.QueryParams.OrderBy)
+	}
+
+	objs, err := o.All(q, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	named := make([]map[string]interface{}, len(objs))
+	for i, obj := range objs {
+		named[i] = m.ToMap(obj)
+	}
+	return named, nil
+}
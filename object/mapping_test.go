@@ -0,0 +1,220 @@
+package object
+
+import (
+	"testing"
+
+	"github.com/ellcrys/patchain/cockroach"
+	"github.com/ellcrys/patchain/cockroach/tables"
+	"github.com/ellcrys/util"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+var testMapping = `{"ref1":"order_id","ref2":{"name":"amount","type":"int","required":true},"ref3":{"name":"paid","type":"bool"}}`
+
+func TestMapping(t *testing.T) {
+	Convey("Mapping", t, func() {
+		m, err := ParseMapping(testMapping)
+		So(err, ShouldBeNil)
+
+		Convey(".ParseMapping", func() {
+			Convey("Should parse the fields in column order", func() {
+				fields := m.Fields()
+				So(fields, ShouldHaveLength, 3)
+				So(fields[0], ShouldResemble, &MappingField{Column: "ref1", Name: "order_id", Type: FieldString})
+				So(fields[1], ShouldResemble, &MappingField{Column: "ref2", Name: "amount", Type: FieldInt, Required: true})
+			})
+
+			Convey("Should return error if mapping is invalid", func() {
+				cases := map[string]string{
+					`[]`:                                  "malformed mapping",
+					`{}`:                                  "mapping has no field",
+					`{"key":"name"}`:                      "key: only ref1 to ref10 can be mapped",
+					`{"ref1":1}`:                          "ref1: malformed field",
+					`{"ref1":{"name":"a","type":"date"}}`: "ref1: unknown type date",
+					`{"ref1":"Order-ID"}`:                 "ref1: invalid field name",
+					`{"ref1":"owner_id"}`:                 "ref1: field name owner_id is already used",
+				}
+				for mapping, msg := range cases {
+					_, err := ParseMapping(mapping)
+					So(err, ShouldNotBeNil)
+					So(err.Error(), ShouldEqual, msg)
+				}
+			})
+
+			Convey("Should return error if a field name is used twice", func() {
+				_, err := ParseMapping(`{"ref1":"a","ref2":"a"}`)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEndWith, "field name a is already used")
+			})
+		})
+
+		Convey(".Validate", func() {
+			Convey("Should return nil if the object is valid", func() {
+				So(m.Validate(&tables.Object{Ref1: "o1", Ref2: "10", Ref3: "true"}), ShouldBeNil)
+			})
+
+			Convey("Should return error if a required field is missing", func() {
+				err := m.Validate(&tables.Object{Ref1: "o1"})
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "amount: is required")
+			})
+
+			Convey("Should return error if a value does not match the field type", func() {
+				err := m.Validate(&tables.Object{Ref2: "ten"})
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "amount: not a valid int")
+			})
+		})
+
+		Convey(".TranslateQuery", func() {
+			Convey("Should replace field names by ref columns", func() {
+				q, err := m.TranslateQuery(`{"$or":[{"order_id":"o1"},{"amount":{"$gte":10}}],"paid":true,"key":"k"}`)
+				So(err, ShouldBeNil)
+				So(q, ShouldEqual, `{"$or":[{"ref1":"o1"},{"ref2":{"$gte":"10"}}],"key":"k","ref3":"true"}`)
+			})
+
+			Convey("Should return error if query is malformed", func() {
+				_, err := m.TranslateQuery(`{`)
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey(".TranslateOrderBy", func() {
+			So(m.TranslateOrderBy("amount desc"), ShouldEqual, "ref2 desc")
+			So(m.TranslateOrderBy("timestamp"), ShouldEqual, "timestamp")
+		})
+
+		Convey(".castOrderBy", func() {
+			So(m.castOrderBy("ref2 desc"), ShouldEqual, "(CASE WHEN ref2 ~ '"+numericRe+"' THEN ref2::DECIMAL END) desc")
+			So(m.castOrderBy("ref1"), ShouldEqual, "ref1")
+			So(m.castOrderBy("timestamp asc"), ShouldEqual, "timestamp asc")
+		})
+
+		Convey(".ToMap", func() {
+			named := m.ToMap(&tables.Object{Key: "k", Ref1: "o1", Ref2: "10", Ref3: "true", Ref4: "x"})
+			So(named["key"], ShouldEqual, "k")
+			So(named["order_id"], ShouldEqual, "o1")
+			So(named["amount"], ShouldEqual, int64(10))
+			So(named["paid"], ShouldEqual, true)
+			So(named["ref4"], ShouldEqual, "x")
+			So(named, ShouldNotContainKey, "ref1")
+		})
+	})
+}
+
+func TestObjectMapping(t *testing.T) {
+
+	if err := createDb(t); err != nil {
+		t.Fatalf("failed to create test database. %s", err)
+	}
+	defer dropDB(t)
+
+	cdb := cockroach.NewDB()
+	cdb.ConnectionString = conStrWithDB
+	cdb.NoLogging()
+	if err := cdb.Connect(10, 5); err != nil {
+		t.Fatalf("failed to connect to database. %s", err)
+	}
+
+	if err := cdb.CreateTables(); err != nil {
+		t.Fatalf("failed to create tables. %s", err)
+	}
+
+	obj := NewObject(cdb)
+
+	Convey("Object mappings", t, func() {
+		ownerID := util.RandString(10)
+		_, err := obj.CreatePartitions(1, ownerID, ownerID)
+		So(err, ShouldBeNil)
+
+		Convey(".DefineMapping", func() {
+			Convey("Should return error if mapping is invalid", func() {
+				_, err := obj.DefineMapping(ownerID, "orders", `{"key":"a"}`)
+				So(err, ShouldNotBeNil)
+			})
+
+			Convey("Should use the latest definition", func() {
+				_, err := obj.DefineMapping(ownerID, "orders", `{"ref1":"a"}`)
+				So(err, ShouldBeNil)
+				_, err = obj.DefineMapping(ownerID, "orders", testMapping)
+				So(err, ShouldBeNil)
+				m, err := obj.GetMapping(ownerID, "orders")
+				So(err, ShouldBeNil)
+				So(m.Fields(), ShouldHaveLength, 3)
+			})
+		})
+
+		Convey(".GetMapping", func() {
+			Convey("Should return ErrNoMapping if mapping does not exist", func() {
+				_, err := obj.GetMapping(ownerID, "unknown")
+				So(err, ShouldEqual, ErrNoMapping)
+			})
+		})
+
+		Convey("With a mapping", func() {
+			_, err := obj.DefineMapping(ownerID, "orders", testMapping)
+			So(err, ShouldBeNil)
+
+			Convey(".Put", func() {
+				Convey("Should validate objects against the mapping", func() {
+					err := obj.Put(&tables.Object{OwnerID: ownerID, Key: "order", Ref1: "o1"}, WithMapping("orders"))
+					So(err, ShouldNotBeNil)
+					So(err.Error(), ShouldContainSubstring, "object 0: amount: is required")
+				})
+
+				Convey("Should return error if the mapping does not exist", func() {
+					err := obj.Put(&tables.Object{OwnerID: ownerID, Key: "order"}, WithMapping("unknown"))
+					So(err, ShouldNotBeNil)
+					So(err.Error(), ShouldContainSubstring, ErrNoMapping.Error())
+				})
+			})
+
+			Convey(".AllMapped", func() {
+				So(obj.Put([]*tables.Object{
+					{OwnerID: ownerID, Key: "order", Ref1: "o1", Ref2: "5", Ref3: "true"},
+					{OwnerID: ownerID, Key: "order", Ref1: "o2", Ref2: "7", Ref3: "false"},
+					{OwnerID: ownerID, Key: "order", Ref1: "o3", Ref2: "9", Ref3: "true"},
+				}, WithMapping("orders")), ShouldBeNil)
+
+				Convey("Should query using field names and return named results", func() {
+					results, err := obj.AllMapped(ownerID, "orders", `{"paid":true}`, "amount desc", 0)
+					So(err, ShouldBeNil)
+					So(results, ShouldHaveLength, 2)
+					So(results[0]["order_id"], ShouldEqual, "o3")
+					So(results[0]["amount"], ShouldEqual, int64(9))
+					So(results[1]["order_id"], ShouldEqual, "o1")
+				})
+
+				Convey("Should order int fields by their numeric value", func() {
+					So(obj.Put(&tables.Object{OwnerID: ownerID, Key: "order", Ref1: "o4", Ref2: "10", Ref3: "true"}, WithMapping("orders")), ShouldBeNil)
+					results, err := obj.AllMapped(ownerID, "orders", `{"paid":true}`, "amount desc", 0)
+					So(err, ShouldBeNil)
+					So(results, ShouldHaveLength, 3)
+					So(results[0]["order_id"], ShouldEqual, "o4")
+					So(results[1]["order_id"], ShouldEqual, "o3")
+				})
+
+				Convey("Should return all objects of the owner if the query is empty", func() {
+					results, err := obj.AllMapped(ownerID, "orders", `{}`, "", 0)
+					So(err, ShouldBeNil)
+					So(len(results), ShouldBeGreaterThanOrEqualTo, 3)
+				})
+
+				Convey("Should only return objects of the owner", func() {
+					otherID := util.RandString(10)
+					_, err := obj.CreatePartitions(1, otherID, otherID)
+					So(err, ShouldBeNil)
+					So(obj.Put(&tables.Object{OwnerID: otherID, Key: "order", Ref1: "o1"}), ShouldBeNil)
+					results, err := obj.AllMapped(ownerID, "orders", `{"order_id":"o1"}`, "", 0)
+					So(err, ShouldBeNil)
+					So(results, ShouldHaveLength, 1)
+				})
+
+				Convey("Should return error if order by field is invalid", func() {
+					_, err := obj.AllMapped(ownerID, "orders", `{"paid":true}`, "unknown", 0)
+					So(err, ShouldNotBeNil)
+				})
+			})
+		})
+	})
+}
@@ -2,21 +2,44 @@ package object
 
 import (
 	"fmt"
+	"regexp"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/ellcrys/cocoon/core/common"
 	"github.com/ellcrys/patchain"
+	"github.com/ellcrys/patchain/blob"
 	"github.com/ellcrys/patchain/cockroach/tables"
+	"github.com/ellcrys/patchain/jsonschema"
 	"github.com/ellcrys/util"
 	"github.com/jinzhu/copier"
 	"github.com/ncodes/redo"
 	"github.com/pkg/errors"
 )
 
+var (
+	// ErrNoPartition indicates that the owner of an object has no partition
+	ErrNoPartition = fmt.Errorf("owner has no partition")
+
+	// ErrNoActivePartition indicates that all the partitions of the owner of an
+	// object are being sealed, are sealed or have been archived
+	ErrNoActivePartition = fmt.Errorf("owner has no active partition")
+)
+
 // Object defines a structure for handling objects
 type Object struct {
-	db patchain.DB
+	db                patchain.DB
+	rolloverPolicy    *RolloverPolicy
+	compressionPolicy *CompressionPolicy
+	blobStore         blob.Store
+	blobThreshold     int
+	retryListeners    []RetryListener
+	quotaMtx          sync.RWMutex
+	defaultQuota      *Quota
+	ownerQuotas       map[string]*Quota
+	stateTable        bool
+	schemaMtx         sync.Mutex
+	schemas           map[string]*jsonschema.Schema
 }
 
 // NewObject creates a new object handler
@@ -26,43 +49,160 @@ func NewObject(db patchain.DB) *Object {
 
 // Create creates an object to represent anything or resource
 func (o *Object) Create(obj *tables.Object) error {
-	return o.db.Create(obj.Init().ComputeHash())
+	_, err := o.store(o.db, obj.Init().ComputeHash(), nil)
+	return err
 }
 
-// CreateOnce creates the object only if no other object shares the same key
-func (o *Object) CreateOnce(obj *tables.Object) error {
-	var existing tables.Object
-	err := o.db.GetLast(&tables.Object{Key: obj.Key}, &existing)
+// CreateOnce creates the object only if no other object shares the same key.
+// The key is reserved in the once key table within the same transaction as the
+// object, so concurrent callers cannot both create it. It returns true if the
+// object was created or false if an object with the key already existed, in which
+// case obj is populated with the existing object. The value of an object
+// with an owner is validated against the schemas of the owner. If an acting
+// identity is set, it must be allowed to write for the owner of the object
+// and to read the existing object. If the key is reserved by a concurrent
+// caller while a transaction passed with UseDBOption is in use, that transaction
+// is rolled back (the database aborts it) and must not be used afterwards;
+// false is returned with the object of the other caller.
+func (o *Object) CreateOnce(obj *tables.Object, options ...patchain.Option) (bool, error) {
+
+	actorID := getActorID(options)
+	dbTx, dbOptions, finish := o.getDBOptions(options)
+
+	var created bool
+	err := o.db.TransactWithDB(dbTx, finish, func(dbTx patchain.DB, commit patchain.CommitFunc, rollback patchain.RollbackFunc) error {
+
+		if actorID != "" {
+			if err := o.authorizeWrite(obj.OwnerID, actorID, []*tables.Object{obj}, dbOptions); err != nil {
+				return err
+			}
+		}
+
+		existing, err := o.getLast(&tables.Object{Key: obj.Key}, dbOptions)
+		if err != nil && err != patchain.ErrNotFound {
+			return errors.Wrap(err, "failed to get existing object")
+		}
+		if existing != nil {
+			if err := o.authorizeRead(existing, actorID, dbOptions); err != nil {
+				return err
+			}
+			copier.Copy(obj, existing)
+			return nil
+		}
+
+		if err := o.validateValues(obj.OwnerID, 0, []*tables.Object{obj}, dbOptions); err != nil {
+			return err
+		}
+
+		obj.Init().ComputeHash()
+		onceKey := &tables.OnceKey{ObjectID: obj.ID, Key: obj.Key, Timestamp: obj.Timestamp}
+		if err := o.db.Create(onceKey, dbOptions...); err != nil {
+			return err
+		}
+
+		stored, err := o.store(o.db, obj, dbOptions)
+		if err != nil {
+			return errors.Wrap(err, "failed to create object")
+		}
+
+		if err := o.updateState([]*tables.Object{stored}, dbOptions); err != nil {
+			return err
+		}
+
+		created = true
+		return nil
+	})
 	if err != nil {
-		if common.CompareErr(err, patchain.ErrNotFound) == 0 {
-			err = o.Create(obj)
+
+		// another caller reserved the key before us, return what it created.
+		// The database aborts a transaction on a constraint violation, so a
+		// transaction passed by the caller is rolled back before reading.
+		if o.IsOnceKeyConflict(err) {
+			if !finish {
+				if err := dbTx.Rollback(); err != nil {
+					return false, errors.Wrap(err, "failed to rollback")
+				}
+			}
+			existing, err := o.getLast(&tables.Object{Key: obj.Key}, nil)
+			if err != nil {
+				return false, errors.Wrap(err, "failed to get existing object")
+			}
+			if err := o.authorizeRead(existing, actorID, nil); err != nil {
+				return false, err
+			}
+			copier.Copy(obj, existing)
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	return created, nil
+}
+
+// IsOnceKeyConflict checks whether an error was caused by an attempt
+// to reserve a once key that has already been reserved
+func (o *Object) IsOnceKeyConflict(err error) bool {
+	return strings.Contains(err.Error(), `violates unique constraint "idx_once_key"`)
+}
+
+// getDBOptions returns the transaction, the options to pass to database
+// operations and whether the transaction should be finished by the caller.
+// A new transaction is started if no UseDBOption is included in the options.
+// The actor option is not included as access is checked by the caller.
+func (o *Object) getDBOptions(options []patchain.Option) (patchain.DB, []patchain.Option, bool) {
+	options = withoutActor(options)
+	var dbTx patchain.DB
+	finish := true
+	for _, ops := range options {
+		if ops.GetName() == patchain.UseDBOptionName {
+			dbOpt := ops.(*patchain.UseDBOption)
+			dbTx = dbOpt.GetValue().(patchain.DB)
+			finish = dbOpt.Finish
 		}
-		return err
 	}
-	copier.Copy(obj, existing)
-	return nil
+	if dbTx == nil {
+		dbTx = o.db.Begin()
+		return dbTx, append(options, &patchain.UseDBOption{DB: dbTx}), finish
+	}
+	return dbTx, options, finish
 }
 
 // CreatePartitions creates partitions. Every partition is chained to the
 // one before it by sharing the hash of the previous partition as the new
-// partition's prev hash value.
+// partition's prev hash value. If an acting identity is set (see ActingAs),
+// it must be the owner or have been granted PermWrite by the owner.
+// A QuotaError is returned if the quota of the owner would be exceeded.
 func (o *Object) CreatePartitions(n int64, ownerID, creatorID string, options ...patchain.Option) ([]*tables.Object, error) {
+	return o.createPartitions(n, ownerID, creatorID, true, options)
+}
 
-	// process options
-	dbTx := o.db.Begin()
-	dbOptions := []patchain.Option{&patchain.UseDBOption{DB: dbTx}}
-	finish := true
-	if len(options) > 0 {
-		dbOptions = options
-		for _, ops := range options {
-			if ops.GetName() == patchain.UseDBOptionName {
-				dbOpt := ops.(*patchain.UseDBOption)
-				dbTx = dbOpt.GetValue().(patchain.DB)
-				finish = dbOpt.Finish
-			}
+// createPartitions creates partitions. The partitions are added to the usage
+// of the owner but the quota of the owner is only enforced if enforceQuota is true.
+func (o *Object) createPartitions(n int64, ownerID, creatorID string, enforceQuota bool, options []patchain.Option) ([]*tables.Object, error) {
+
+	actorID := getActorID(options)
+	options = withoutActor(options)
+
+	// check the acting identity before a transaction is started
+	// so that a denied call does not leave it open
+	if actorID != "" {
+		if creatorID == "" {
+			creatorID = actorID
+		} else if creatorID != actorID {
+			return nil, ErrCreatorMismatch
+		}
+		allowed, err := o.HasPermission(ownerID, actorID, PermWrite, options...)
+		if err != nil {
+			return nil, err
+		} else if !allowed {
+			return nil, ErrPermissionDenied
 		}
 	}
 
+	// process options
+	dbTx, dbOptions, finish := o.getDBOptions(options)
+
 	// Process starts a transaction to create the partition(s). It can be called multiple times when
 	// a retry error is returned or a unique constraint error occurs on the prev_hash field
 	var process = func() ([]*tables.Object, error) {
@@ -80,6 +220,10 @@ func (o *Object) CreatePartitions(n int64, ownerID, creatorID string, options ..
 
 		return partitions, o.db.TransactWithDB(dbTx, finish, func(dbTx patchain.DB, commit patchain.CommitFunc, rollback patchain.RollbackFunc) error {
 
+			if err := o.chargeQuota(ownerID, &tables.OwnerUsage{Partitions: n}, enforceQuota, dbOptions); err != nil {
+				return err
+			}
+
 			// get the last partition
 			lastPartition, err := o.GetLast(&tables.Object{QueryParams: patchain.KeyStartsWith(PartitionPrefix)}, dbOptions...)
 			if err != nil {
@@ -134,14 +278,33 @@ func (o *Object) CreatePartitions(n int64, ownerID, creatorID string, options ..
 	return partitions, errors.Wrap(err, "failed to create partition(s)")
 }
 
+// RetryListener is called when an operation performed on behalf of
+// an owner fails with an error that requires the operation to be retried
+type RetryListener func(ownerID string, err error)
+
+// AddRetryListener registers a function to be called every time
+// an operation is retried by Retry, MustPut or MustCreatePartitions
+func (o *Object) AddRetryListener(l RetryListener) {
+	o.retryListeners = append(o.retryListeners, l)
+}
+
 // Retry runs an operation if it fails dues to a retry or prev_hash contention error
 func (o *Object) Retry(cb func(stop func()) error) error {
+	return o.retry("", cb)
+}
+
+// retry is like Retry but notifies the retry listeners of the
+// owner on whose behalf the operation is being performed.
+func (o *Object) retry(ownerID string, cb func(stop func()) error) error {
 	var err error
 	c := redo.NewDefaultBackoffConfig()
 	c.MaxElapsedTime = 10 * time.Minute
 	err = redo.NewRedo().BackOff(c, func(stop func()) error {
 		err = cb(stop)
 		if err != nil && o.RequiresRetry(err) {
+			for _, l := range o.retryListeners {
+				l(ownerID, err)
+			}
 			return err
 		}
 		stop()
@@ -158,7 +321,7 @@ func (o *Object) Retry(cb func(stop func()) error) error {
 func (o *Object) MustCreatePartitions(n int64, ownerID, creatorID string, options ...patchain.Option) ([]*tables.Object, error) {
 	var partitions []*tables.Object
 	var err error
-	err = o.Retry(func(stop func()) error {
+	err = o.retry(ownerID, func(stop func()) error {
 		partitions, err = o.CreatePartitions(n, ownerID, creatorID, options...)
 		if err != nil {
 			return err
@@ -170,20 +333,92 @@ func (o *Object) MustCreatePartitions(n int64, ownerID, creatorID string, option
 
 // GetLast gets the latest version of an object.
 // It does this by enforcing a descending order of the insert timestamp of the object.
+// Ref-only objects are excluded unless IncludeRefOnly is passed or the query sets
+// RefOnly. If an acting identity is set, protected objects it cannot read are excluded.
 func (o *Object) GetLast(q patchain.Query, options ...patchain.Option) (*tables.Object, error) {
+	q, err := o.restrictQuery(q, options)
+	if err != nil {
+		return nil, err
+	}
+	return o.getLast(q, options)
+}
+
+// getLast is like GetLast but returns ref-only and protected objects
+// regardless of the options. It is used to read chains.
+func (o *Object) getLast(q patchain.Query, options []patchain.Option) (*tables.Object, error) {
 	var obj tables.Object
 	err := o.db.GetLast(q, &obj, options...)
 	if err != nil {
 		return nil, err
 	}
+	if err := o.restoreValue(&obj); err != nil {
+		return nil, err
+	}
 	return &obj, nil
 }
 
-// All fetches all the objects matching a query
+// ParseQuery parses a JSQ query and returns a query object whose
+// query parameter expression is set to the parsed query.
+// See http://github.com/ncodes/jsq
+func (o *Object) ParseQuery(jsqQuery string) (*tables.Object, error) {
+	q := o.db.NewQuery()
+	if err := q.Parse(jsqQuery); err != nil {
+		return nil, errors.Wrap(err, "failed to parse query")
+	}
+	sql, args, err := q.ToSQL()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse query")
+	}
+	return &tables.Object{QueryParams: patchain.QueryParams{Expr: patchain.Expr{Expr: sql, Args: args}}}, nil
+}
+
+// orderByRe matches an order by clause on a single field
+var orderByRe = regexp.MustCompile(`^([a-z0-9_]+)(?: (asc|desc))?$`)
+
+// ParseOrderBy validates an order by clause received from an untrusted source.
+// Only a single queryable field and an optional direction are accepted.
+func (o *Object) ParseOrderBy(orderBy string) (string, error) {
+	m := orderByRe.FindStringSubmatch(strings.ToLower(strings.TrimSpace(orderBy)))
+	if m == nil || !util.InStringSlice(o.db.GetValidObjectFields(), m[1]) {
+		return "", fmt.Errorf("invalid order by clause")
+	}
+	return m[0], nil
+}
+
+// All fetches all the objects matching a query. Ref-only objects are
+// excluded unless IncludeRefOnly is passed or the query sets RefOnly. If an
+// acting identity is set, protected objects it cannot read are excluded.
 func (o *Object) All(q patchain.Query, options ...patchain.Option) ([]*tables.Object, error) {
+	q, err := o.restrictQuery(q, options)
+	if err != nil {
+		return nil, err
+	}
+	return o.all(q, options)
+}
+
+// Count counts the objects matching a query. Like All, it excludes ref-only
+// objects and protected objects the acting identity cannot read.
+func (o *Object) Count(q patchain.Query, out interface{}, options ...patchain.Option) error {
+	q, err := o.restrictQuery(q, options)
+	if err != nil {
+		return err
+	}
+	return o.db.Count(q, out, options...)
+}
+
+// all is like All but returns ref-only and protected objects
+// regardless of the options. It is used to read chains.
+func (o *Object) all(q patchain.Query, options []patchain.Option) ([]*tables.Object, error) {
 	var objs []*tables.Object
-	err := o.db.GetAll(q, &objs, options...)
-	return objs, err
+	if err := o.db.GetAll(q, &objs, options...); err != nil {
+		return nil, err
+	}
+	for _, obj := range objs {
+		if err := o.restoreValue(obj); err != nil {
+			return nil, err
+		}
+	}
+	return objs, nil
 }
 
 // given a slice of partitions, it randomly chooses a partition if
@@ -201,9 +436,47 @@ func (o *Object) selectPartition(partitions []*tables.Object) *tables.Object {
 
 // RequiresRetry checks whether a transaction error
 // indicates or requires a retry. This method can detect cockroach db
-// restart, retry error and prev hash contention
+// restart, retry error, prev hash contention and concurrent creation of the usage of an owner
 func (o *Object) RequiresRetry(err error) bool {
-	return strings.Contains(err.Error(), "restart transaction") || strings.Contains(err.Error(), "retry transaction") || strings.Contains(err.Error(), `violates unique constraint "idx_prev_hash"`)
+	return strings.Contains(err.Error(), "restart transaction") || strings.Contains(err.Error(), "retry transaction") || o.IsPrevHashConflict(err) || o.IsUsageConflict(err)
+}
+
+// IsInvalidObject checks whether an error was caused by an object that cannot be
+// stored as given: its key is reserved, it is ref-only but has a value, its codec or
+// compression algorithm is unknown, or it does not match its schema or mapping
+func IsInvalidObject(err error) bool {
+	switch cause := errors.Cause(err).(type) {
+	case *jsonschema.ValidationError, *MappingError:
+		return true
+	default:
+		return cause == ErrReservedKey || cause == ErrRefOnlyValue || cause == ErrUnknownCodec || cause == ErrUnknownCompression
+	}
+}
+
+// IsPrevHashConflict checks whether an error was caused by an attempt to
+// chain an object to an object that has already been chained to
+func (o *Object) IsPrevHashConflict(err error) bool {
+	return strings.Contains(err.Error(), `violates unique constraint "idx_prev_hash"`)
+}
+
+// IsUsageConflict checks whether an error was caused by an attempt to create the
+// usage of an owner that was created by a concurrent write
+func (o *Object) IsUsageConflict(err error) bool {
+	return strings.Contains(err.Error(), "duplicate key value (owner_id)=")
+}
+
+// getOwnerID returns the owner id of the first of the objects
+// passed to Put. Returns an empty string if there is none.
+func getOwnerID(objs interface{}) string {
+	switch o := objs.(type) {
+	case []*tables.Object:
+		if len(o) > 0 {
+			return o[0].OwnerID
+		}
+	case *tables.Object:
+		return o.OwnerID
+	}
+	return ""
 }
 
 // MustPut is the same as Put but it will retry the operation if it
@@ -214,14 +487,49 @@ func (o *Object) RequiresRetry(err error) bool {
 // fails, it will not be retried
 func (o *Object) MustPut(objs interface{}, options ...patchain.Option) error {
 	var err error
-	err = o.Retry(func(stop func()) error {
+	err = o.retry(getOwnerID(objs), func(stop func()) error {
 		return o.Put(objs, options...)
 	})
 	return err
 }
 
+// appendObjects chains the objects to the last object of a partition and
+// creates them. The peer hash of the last object is updated to bind it to the
+// first of the new objects. It returns the copies of the objects that were stored.
+func (o *Object) appendObjects(dbTx patchain.DB, lastObj *tables.Object, objects []*tables.Object, options []patchain.Option) ([]*tables.Object, error) {
+
+	// assign hash of last object as the PrevHash value
+	// of the first object, chain the  objects and create them
+	objects[0].PrevHash = lastObj.Hash
+	MakeChain(objects...)
+	var stored []*tables.Object
+	for _, obj := range objects {
+		storedObj, err := o.store(dbTx, obj, options)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to add object to partition")
+		}
+		stored = append(stored, storedObj)
+	}
+
+	// update peer hash of last object
+	lastObj.ComputePeerHash(objects[0].Hash)
+	if err := dbTx.UpdatePeerHash(lastObj, lastObj.PeerHash, options...); err != nil {
+		return nil, errors.Wrap(err, "failed to update last object peer hash")
+	}
+
+	return stored, nil
+}
+
 // Put adds an object into a randomly selected partition belonging to
-// the owner of the object. If object has no owner, error is returned
+// the owner of the object. If object has no owner, error is returned.
+// Outbox messages passed using WithOutbox are written in the same transaction.
+// If WithMapping is passed, the objects are validated against the mapping.
+// Values are validated against the schemas of the owner (see DefineSchema).
+// If an acting identity is set (see ActingAs), it must be the owner or have
+// been granted PermWrite by the owner, and becomes the creator of the objects.
+// An object cannot have the same key as the latest version of a protected object
+// unless WithOverride is passed. Ref-only objects must not have a value.
+// A QuotaError is returned if the quota of the owner would be exceeded.
 func (o *Object) Put(objs interface{}, options ...patchain.Option) error {
 
 	var objects []*tables.Object
@@ -246,22 +554,16 @@ func (o *Object) Put(objs interface{}, options ...patchain.Option) error {
 			return fmt.Errorf("object %d: object does not have an owner", i)
 		} else if o.OwnerID != ownerID {
 			return fmt.Errorf("object %d: has a different owner", i)
+		} else if isReservedKey(o.Key) {
+			return errors.Wrapf(ErrReservedKey, "object %d", i)
 		}
 	}
 
 	// process options
-	dbTx := o.db.Begin()
-	dbOptions := []patchain.Option{&patchain.UseDBOption{DB: dbTx}}
-	finish := true
-	if len(options) > 0 {
-		dbOptions = options
-		for _, ops := range options {
-			if ops.GetName() == patchain.UseDBOptionName {
-				dbTx = ops.(*patchain.UseDBOption).GetValue().(patchain.DB)
-				finish = ops.(*patchain.UseDBOption).Finish
-			}
-		}
-	}
+	actorID := getActorID(options)
+	dbTx, dbOptions, finish := o.getDBOptions(options)
+	outboxMsgs := getOutboxMessages(options)
+	mappingName := getMappingName(options)
 
 	// define function to perform put operation. May be repeated if the following conditions occur:
 	// - Error indicating a restart or retry the transaction
@@ -270,25 +572,71 @@ func (o *Object) Put(objs interface{}, options ...patchain.Option) error {
 
 		return o.db.TransactWithDB(dbTx, finish, func(dbTx patchain.DB, commit patchain.CommitFunc, rollback patchain.RollbackFunc) error {
 
+			// check that the acting identity can write for the owner
+			if actorID != "" {
+				if err := o.authorizeWrite(ownerID, actorID, objects, dbOptions); err != nil {
+					return err
+				}
+			}
+
+			// validate the objects against the mapping of the owner
+			if mappingName != "" {
+				if err := o.validateMapping(ownerID, mappingName, objects, dbOptions); err != nil {
+					return err
+				}
+			}
+
+			// validate the values against the schemas of the owner
+			if err := o.validateValues(ownerID, 0, objects, dbOptions); err != nil {
+				return err
+			}
+
+			// check the protected and ref-only flags. Override records
+			// are added after the objects that supersede protected objects.
+			overrides, err := o.checkFlags(ownerID, objects, options, dbOptions)
+			if err != nil {
+				return err
+			}
+			toAppend := append(append([]*tables.Object{}, objects...), overrides...)
+
+			// add the objects to the usage of the owner
+			consumed, err := o.usageOf(toAppend)
+			if err != nil {
+				return err
+			}
+			if err := o.chargeQuota(ownerID, consumed, true, dbOptions); err != nil {
+				return err
+			}
+
 			// get the partitions belonging to the owner of the object
 			partitions, err := o.All(&tables.Object{OwnerID: ownerID, QueryParams: patchain.KeyStartsWith(PartitionPrefix)}, dbOptions...)
 			if err != nil {
 				return errors.Wrap(err, "failed to get owner's partition")
 			}
 
+			if len(partitions) == 0 {
+				return ErrNoPartition
+			}
+
+			// exclude partitions that are being sealed or have been sealed
+			partitions, err = o.activePartitions(ownerID, partitions, dbOptions)
+			if err != nil {
+				return errors.Wrap(err, "failed to get owner's active partition")
+			}
+
 			// select a random partition
 			var selectedPartition = o.selectPartition(partitions)
 			if selectedPartition == nil {
-				return fmt.Errorf("owner has no partition")
+				return ErrNoActivePartition
 			}
 
 			// assign selected partition to the objects
-			for _, o := range objects {
+			for _, o := range toAppend {
 				o.PartitionID = selectedPartition.ID
 			}
 
 			// get the last object of the selected partition
-			lastObj, err := o.GetLast(&tables.Object{PartitionID: selectedPartition.ID}, dbOptions...)
+			lastObj, err := o.getLast(&tables.Object{PartitionID: selectedPartition.ID}, dbOptions)
 			if err != nil {
 				// no object in this partition! This means no genesis pair/object, return error
 				if err == patchain.ErrNotFound {
@@ -297,20 +645,31 @@ func (o *Object) Put(objs interface{}, options ...patchain.Option) error {
 				return err
 			}
 
-			// assign hash of last object as the PrevHash value
-			// of the first object, chain the  objects and create them
-			objects[0].PrevHash = lastObj.Hash
-			MakeChain(objects...)
-			for _, o := range objects {
-				if err := dbTx.Create(o, options...); err != nil {
-					return errors.Wrap(err, "failed to add object to partition")
-				}
+			// the partition may have been sealed after we fetched the owner's partitions
+			if getPartitionState(lastObj) != PartitionActive {
+				return ErrPartitionSealed
 			}
 
-			// update peer hash of last object
-			lastObj.ComputePeerHash(objects[0].Hash)
-			if err := dbTx.UpdatePeerHash(lastObj, lastObj.PeerHash, options...); err != nil {
-				return errors.Wrap(err, "failed to update last object peer hash")
+			stored, err := o.appendObjects(dbTx, lastObj, toAppend, options)
+			if err != nil {
+				return err
+			}
+
+			// record the objects as the current state of their keys
+			if err := o.updateState(stored, dbOptions); err != nil {
+				return err
+			}
+
+			// write the outbox messages so they are only relayed if the objects are added
+			if err := addOutboxMessages(dbTx, outboxMsgs, objects, dbOptions); err != nil {
+				return errors.Wrap(err, "failed to add outbox messages")
+			}
+
+			// roll over the partition if it has outgrown the rollover policy
+			if o.rolloverPolicy != nil {
+				if err := o.rollover(dbTx, selectedPartition, dbOptions); err != nil {
+					return errors.Wrap(err, "failed to roll over partition")
+				}
 			}
 
 			return nil
@@ -4,6 +4,8 @@ import (
 	"database/sql"
 	"fmt"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 
 	"github.com/ellcrys/gorm"
@@ -118,17 +120,20 @@ func TestObject(t *testing.T) {
 				So(o.ID, ShouldBeEmpty)
 				So(o.Timestamp, ShouldEqual, 0)
 				So(o.Hash, ShouldBeEmpty)
-				err := obj.CreateOnce(o)
+				created, err := obj.CreateOnce(o)
 				So(err, ShouldBeNil)
+				So(created, ShouldBeTrue)
 				So(o.ID, ShouldNotBeEmpty)
 				So(o.Timestamp, ShouldNotBeEmpty)
 				So(o.Hash, ShouldNotBeEmpty)
 
 				Convey("Should not create duplicate key object and also return no error", func() {
-					o.ID = util.UUID4()
-					o.PrevHash = util.UUID4()
-					err := obj.CreateOnce(o)
+					o2 := &tables.Object{Key: o.Key, Value: "some_value_2", PrevHash: util.UUID4()}
+					created, err := obj.CreateOnce(o2)
 					So(err, ShouldBeNil)
+					So(created, ShouldBeFalse)
+					So(o2.ID, ShouldEqual, o.ID)
+					So(o2.Value, ShouldEqual, o.Value)
 					count := int64(0)
 					err = obj.db.Count(&tables.Object{Key: o.Key}, &count)
 					So(err, ShouldBeNil)
@@ -136,11 +141,50 @@ func TestObject(t *testing.T) {
 				})
 			})
 
+			Convey("Should create the object only once when called concurrently", func() {
+				var wg sync.WaitGroup
+				var numCreated int32
+				for i := 0; i < 5; i++ {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						o := &tables.Object{Key: "concurrent_key", Value: "some_value", PrevHash: util.UUID4()}
+						if created, err := obj.CreateOnce(o); err == nil && created {
+							atomic.AddInt32(&numCreated, 1)
+						}
+					}()
+				}
+				wg.Wait()
+				So(numCreated, ShouldEqual, 1)
+				count := int64(0)
+				err := obj.db.Count(&tables.Object{Key: "concurrent_key"}, &count)
+				So(err, ShouldBeNil)
+				So(count, ShouldEqual, 1)
+			})
+
+			Convey("Should successfully create an object using an external db object", func() {
+				dbOp := patchain.UseDBOption{
+					DB:     cdb.NewDB().Begin(),
+					Finish: true,
+				}
+				o := &tables.Object{Key: "some_key", Value: "some_value", PrevHash: util.UUID4()}
+				created, err := obj.CreateOnce(o, &dbOp)
+				So(err, ShouldBeNil)
+				So(created, ShouldBeTrue)
+			})
+
 			Reset(func() {
-				clearTable(cdb.GetConn().(*gorm.DB), "objects")
+				clearTable(cdb.GetConn().(*gorm.DB), "objects", "once_keys")
 			})
 		})
 
+		Convey(".IsOnceKeyConflict", func() {
+			err := fmt.Errorf(`pq: duplicate key value (key)=('stuff') violates unique constraint "idx_once_key"`)
+			So(obj.IsOnceKeyConflict(err), ShouldEqual, true)
+			err = fmt.Errorf(`pq: duplicate key value (prev_hash)=('stuff') violates unique constraint "idx_prev_hash"`)
+			So(obj.IsOnceKeyConflict(err), ShouldEqual, false)
+		})
+
 		Convey(".RequiresRetry", func() {
 			err := fmt.Errorf(`pq: duplicate key value (prev_hash)=('stuff') violates unique constraint "idx_prev_hash"`)
 			So(obj.RequiresRetry(err), ShouldEqual, true)
@@ -148,6 +192,22 @@ func TestObject(t *testing.T) {
 			So(obj.RequiresRetry(err), ShouldEqual, true)
 			err = fmt.Errorf(`pq: some text restart transaction`)
 			So(obj.RequiresRetry(err), ShouldEqual, true)
+			err = fmt.Errorf(`failed to create usage: pq: duplicate key value (owner_id)=('stuff') violates unique constraint "primary"`)
+			So(obj.RequiresRetry(err), ShouldEqual, true)
+		})
+
+		Convey(".IsUsageConflict", func() {
+			err := fmt.Errorf(`pq: duplicate key value (owner_id)=('stuff') violates unique constraint "primary"`)
+			So(obj.IsUsageConflict(err), ShouldEqual, true)
+			err = fmt.Errorf(`pq: duplicate key value (owner_id,key)=('stuff','key') violates unique constraint "primary"`)
+			So(obj.IsUsageConflict(err), ShouldEqual, false)
+		})
+
+		Convey(".IsPrevHashConflict", func() {
+			err := fmt.Errorf(`pq: duplicate key value (prev_hash)=('stuff') violates unique constraint "idx_prev_hash"`)
+			So(obj.IsPrevHashConflict(err), ShouldEqual, true)
+			err = fmt.Errorf(`pq: some text retry transaction`)
+			So(obj.IsPrevHashConflict(err), ShouldEqual, false)
 		})
 
 		Convey(".CreatePartitions", func() {
@@ -304,6 +364,53 @@ func TestObject(t *testing.T) {
 			})
 		})
 
+		Convey(".ParseQuery", func() {
+			Convey("Should return error if query is invalid", func() {
+				_, err := obj.ParseQuery(`{ "unknown_field": "value" }`)
+				So(err, ShouldNotBeNil)
+			})
+
+			Convey("Should return a query object with the parsed expression", func() {
+				q, err := obj.ParseQuery(`{ "key": "some_key" }`)
+				So(err, ShouldBeNil)
+				So(q.QueryParams.Expr.Expr, ShouldNotBeEmpty)
+				So(q.QueryParams.Expr.Args, ShouldResemble, []interface{}{"some_key"})
+			})
+
+			Convey("Should fetch objects using the parsed query", func() {
+				o := &tables.Object{Key: "some_key", Value: "some_value", PrevHash: util.UUID4()}
+				err := obj.Create(o)
+				So(err, ShouldBeNil)
+				q, err := obj.ParseQuery(`{ "key": "some_key" }`)
+				So(err, ShouldBeNil)
+				objs, err := obj.All(q)
+				So(err, ShouldBeNil)
+				So(objs, ShouldHaveLength, 1)
+			})
+
+			Reset(func() {
+				clearTable(cdb.GetConn().(*gorm.DB), "objects")
+			})
+		})
+
+		Convey(".ParseOrderBy", func() {
+			Convey("Should accept a queryable field and an optional direction", func() {
+				orderBy, err := obj.ParseOrderBy(" Timestamp DESC ")
+				So(err, ShouldBeNil)
+				So(orderBy, ShouldEqual, "timestamp desc")
+				orderBy, err = obj.ParseOrderBy("key")
+				So(err, ShouldBeNil)
+				So(orderBy, ShouldEqual, "key")
+			})
+
+			Convey("Should reject unknown fields and anything else", func() {
+				for _, orderBy := range []string{"unknown_field", "key sideways", "key; DROP TABLE objects", "key asc, value desc"} {
+					_, err := obj.ParseOrderBy(orderBy)
+					So(err, ShouldNotBeNil)
+				}
+			})
+		})
+
 		Convey(".selectPartition", func() {
 			Convey("Should return nil if no partition is passed", func() {
 				selected := obj.selectPartition(nil)
@@ -332,8 +439,9 @@ func TestObject(t *testing.T) {
 		Convey(".MustPut", func() {
 			Convey("Should successfully put an object", func() {
 				ownerID := util.RandString(10)
-				identity := MakeIdentityObject(ownerID, ownerID, "email@email.com", "some_pass", true)
-				err := obj.Create(identity)
+				identity, err := MakeIdentityObject(ownerID, ownerID, "email@email.com", "some_pass", true)
+				So(err, ShouldBeNil)
+				err = obj.Create(identity)
 				So(err, ShouldBeNil)
 
 				_, err = obj.CreatePartitions(1, ownerID, ownerID)
@@ -399,8 +507,9 @@ func TestObject(t *testing.T) {
 
 			Convey("Should successfully add initial chained objects", func() {
 				ownerID := util.RandString(10)
-				identity := MakeIdentityObject(ownerID, ownerID, "email@email.com", "some_pass", true)
-				err := obj.Create(identity)
+				identity, err := MakeIdentityObject(ownerID, ownerID, "email@email.com", "some_pass", true)
+				So(err, ShouldBeNil)
+				err = obj.Create(identity)
 				So(err, ShouldBeNil)
 
 				partitions, err := obj.CreatePartitions(1, ownerID, ownerID)
@@ -417,8 +526,9 @@ func TestObject(t *testing.T) {
 				Convey("Should return error if partition has no genesis object", func() {
 
 					ownerID := util.RandString(10)
-					identity := MakeIdentityObject(ownerID, ownerID, "email@email.com", "some_pass", true)
-					err := obj.Create(identity)
+					identity, err := MakeIdentityObject(ownerID, ownerID, "email@email.com", "some_pass", true)
+					So(err, ShouldBeNil)
+					err = obj.Create(identity)
 					So(err, ShouldBeNil)
 
 					err = cdb.Create(MakePartitionObject("a_partition", ownerID, ownerID))
@@ -477,8 +587,9 @@ func TestObject(t *testing.T) {
 
 			Convey("Should successfully add objects with an external db connection", func() {
 				ownerID := util.RandString(10)
-				identity := MakeIdentityObject(ownerID, ownerID, "email@email.com", "some_pass", true)
-				err := obj.Create(identity)
+				identity, err := MakeIdentityObject(ownerID, ownerID, "email@email.com", "some_pass", true)
+				So(err, ShouldBeNil)
+				err = obj.Create(identity)
 				So(err, ShouldBeNil)
 
 				partitions, err := obj.CreatePartitions(1, ownerID, ownerID)
@@ -1,12 +1,14 @@
 package object
 
 import (
+	"encoding/json"
 	"fmt"
 
 	"strings"
 
 	"github.com/ellcrys/patchain/cockroach/tables"
 	"github.com/ellcrys/util"
+	"github.com/pkg/errors"
 )
 
 var (
@@ -18,6 +20,18 @@ var (
 
 	// MappingPrefix is the prefix of an object mappings
 	MappingPrefix = "$mapping/"
+
+	// SchemaPrefix is the prefix of a schema of object values
+	SchemaPrefix = "$schema/"
+
+	// GrantPrefix is the prefix of a grant of access to an owner's objects
+	GrantPrefix = "$grant/"
+
+	// OverridePrefix is the prefix of the record of an override of a protected object
+	OverridePrefix = "$override/"
+
+	// TombstonePrefix is the prefix of the record of the deletion of a key
+	TombstonePrefix = "$tombstone/"
 )
 
 // MakeIdentityKey creates an identity key
@@ -35,6 +49,26 @@ func MakeMappingKey(name string) string {
 	return fmt.Sprintf("%s%s", MappingPrefix, name)
 }
 
+// MakeSchemaKey creates the key of the schema of a key prefix
+func MakeSchemaKey(keyPrefix string) string {
+	return fmt.Sprintf("%s%s", SchemaPrefix, keyPrefix)
+}
+
+// MakeGrantKey creates the key of the grants of an identity
+func MakeGrantKey(granteeID string) string {
+	return fmt.Sprintf("%s%s", GrantPrefix, granteeID)
+}
+
+// MakeOverrideKey creates the key of the record of an override of a protected object
+func MakeOverrideKey(objectID string) string {
+	return fmt.Sprintf("%s%s", OverridePrefix, objectID)
+}
+
+// MakeTombstoneKey creates the key of the record of the deletion of a key
+func MakeTombstoneKey(key string) string {
+	return fmt.Sprintf("%s%s", TombstonePrefix, key)
+}
+
 // MakePartitionObject creates an object that describes a partition
 func MakePartitionObject(name, ownerID, creatorID string) *tables.Object {
 	po := tables.Object{
@@ -55,15 +89,99 @@ func SplitKey(key string) (string, string, error) {
 	return split[0], split[1], nil
 }
 
-// MakeIdentityObject creates an object that describes an identity
-func MakeIdentityObject(ownerID, creatorID, email, password string, protected bool) *tables.Object {
+// IdentityInfo is the content of an identity object
+type IdentityInfo struct {
+	Email        string `json:"email"`
+	PasswordHash string `json:"password_hash,omitempty"`
+
+	// ClientSecretHash is the hash of the client secret of a developer identity.
+	// The client id is stored in the Ref1 field of the identity object.
+	ClientSecretHash string `json:"client_secret_hash,omitempty"`
+
+	// CredentialsRevoked is set when the client credentials have been revoked
+	CredentialsRevoked bool `json:"credentials_revoked,omitempty"`
+}
+
+// GrantInfo describes the content of a grant object
+type GrantInfo struct {
+
+	// Permissions lists the permissions granted (see PermRead and PermWrite)
+	Permissions []string `json:"permissions"`
+
+	// Revoked is set when the grant has been revoked
+	Revoked bool `json:"revoked,omitempty"`
+}
+
+// Has checks whether the grant is active and includes a permission
+func (g *GrantInfo) Has(permission string) bool {
+	if g.Revoked {
+		return false
+	}
+	for _, p := range g.Permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// makeIdentityValue encodes the content of an identity object. The
+// password is hashed using HashPassword. No hash is stored if it is empty.
+func makeIdentityValue(email, password string) (string, error) {
+	info := IdentityInfo{Email: email}
+	if password != "" {
+		hash, err := HashPassword(password)
+		if err != nil {
+			return "", errors.Wrap(err, "failed to hash password")
+		}
+		info.PasswordHash = hash
+	}
+	bs, _ := json.Marshal(info)
+	return string(bs), nil
+}
+
+// GetIdentityInfo decodes the content of an identity object
+func GetIdentityInfo(identity *tables.Object) (*IdentityInfo, error) {
+	if !strings.HasPrefix(identity.Key, IdentityPrefix) {
+		return nil, fmt.Errorf("not an identity object")
+	}
+	var info IdentityInfo
+	if err := json.Unmarshal([]byte(identity.Value), &info); err != nil {
+		return nil, errors.Wrap(err, "malformed identity object")
+	}
+	return &info, nil
+}
+
+// SetIdentityPassword replaces the password hash stored in an identity object.
+// The hash of the object must be recomputed afterwards.
+func SetIdentityPassword(identity *tables.Object, password string) error {
+	info, err := GetIdentityInfo(identity)
+	if err != nil {
+		return err
+	}
+	if info.PasswordHash, err = HashPassword(password); err != nil {
+		return errors.Wrap(err, "failed to hash password")
+	}
+	bs, _ := json.Marshal(info)
+	identity.Value = string(bs)
+	return nil
+}
+
+// MakeIdentityObject creates an object that describes an identity.
+// The hash of the password is stored in the value of the object.
+func MakeIdentityObject(ownerID, creatorID, email, password string, protected bool) (*tables.Object, error) {
+	value, err := makeIdentityValue(email, password)
+	if err != nil {
+		return nil, err
+	}
 	po := tables.Object{
 		OwnerID:   ownerID,
 		CreatorID: creatorID,
 		Key:       MakeIdentityKey(email),
+		Value:     value,
 		Protected: protected,
 	}
-	return po.Init()
+	return po.Init(), nil
 }
 
 // MakeMappingObject creates a mapping object
@@ -77,20 +195,130 @@ func MakeMappingObject(ownerID, name, mappingJSON string) *tables.Object {
 	return po.Init()
 }
 
-// MakeDeveloperIdentityObject creates an identity with developer related data like
-// client id and client secret
-func MakeDeveloperIdentityObject(ownerID, creatorID, email, password string, protected bool) *tables.Object {
+// MakeSchemaObject creates a schema object for the values of
+// the objects whose key starts with keyPrefix
+func MakeSchemaObject(ownerID, keyPrefix, schemaJSON string) *tables.Object {
+	po := tables.Object{
+		OwnerID:   ownerID,
+		CreatorID: ownerID,
+		Key:       MakeSchemaKey(keyPrefix),
+		Value:     schemaJSON,
+	}
+	return po.Init()
+}
+
+// MakeGrantObject creates an object that grants permissions on the
+// objects of an owner to an identity
+func MakeGrantObject(ownerID, granteeID string, grant *GrantInfo) *tables.Object {
+	value, _ := json.Marshal(grant)
+	po := tables.Object{
+		OwnerID:   ownerID,
+		CreatorID: ownerID,
+		Key:       MakeGrantKey(granteeID),
+		Value:     string(value),
+	}
+	return po.Init()
+}
+
+// MakeOverrideObject creates an object that records that a protected
+// object was superseded. It holds the hash of the protected object.
+func MakeOverrideObject(ownerID, creatorID string, protected *tables.Object) *tables.Object {
 	po := tables.Object{
 		OwnerID:   ownerID,
 		CreatorID: creatorID,
-		Key:       MakeIdentityKey(email),
-		Protected: protected,
-		Ref1:      util.RandString(util.RandNum(26, 36)), // client id
-		Ref2:      util.RandString(util.RandNum(26, 36)), // client secret
+		Key:       MakeOverrideKey(protected.ID),
+		Value:     protected.Hash,
+	}
+	return po.Init()
+}
+
+// MakeTombstoneObject creates an object that records the deletion of a key.
+// Its first reference is the id of the latest object of the key when it was deleted.
+func MakeTombstoneObject(ownerID, creatorID, key, deletedID string) *tables.Object {
+	po := tables.Object{
+		OwnerID:   ownerID,
+		CreatorID: creatorID,
+		Key:       MakeTombstoneKey(key),
+		Ref1:      deletedID,
 	}
 	return po.Init()
 }
 
+// GetGrantInfo decodes the value of a grant object
+func GetGrantInfo(grantObj *tables.Object) (*GrantInfo, error) {
+	if !strings.HasPrefix(grantObj.Key, GrantPrefix) {
+		return nil, fmt.Errorf("not a grant object")
+	}
+	var grant GrantInfo
+	if err := json.Unmarshal([]byte(grantObj.Value), &grant); err != nil {
+		return nil, errors.Wrap(err, "malformed grant")
+	}
+	return &grant, nil
+}
+
+// SetClientCredentials generates a client secret for an identity and stores
+// its hash. A new client id is generated if clientID is empty. The client id
+// is stored in Ref1. The secret is returned and must be given to the developer
+// as it cannot be recovered. The hash of the object must be recomputed afterwards.
+func SetClientCredentials(identity *tables.Object, clientID string) (string, error) {
+
+	info, err := GetIdentityInfo(identity)
+	if err != nil {
+		return "", err
+	}
+
+	if clientID == "" {
+		if clientID, err = RandomToken(16); err != nil {
+			return "", errors.Wrap(err, "failed to generate client id")
+		}
+	}
+
+	secret, err := RandomToken(32)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to generate client secret")
+	}
+
+	if info.ClientSecretHash, err = HashPassword(secret); err != nil {
+		return "", errors.Wrap(err, "failed to hash client secret")
+	}
+	info.CredentialsRevoked = false
+
+	bs, _ := json.Marshal(info)
+	identity.Value = string(bs)
+	identity.Ref1 = clientID
+	return secret, nil
+}
+
+// RevokeClientCredentials removes the hash of the client secret of an identity
+// and records that its credentials have been revoked. The client id is kept so
+// that the revocation can be traced. The hash of the object must be recomputed afterwards.
+func RevokeClientCredentials(identity *tables.Object) error {
+	info, err := GetIdentityInfo(identity)
+	if err != nil {
+		return err
+	}
+	info.ClientSecretHash = ""
+	info.CredentialsRevoked = true
+	bs, _ := json.Marshal(info)
+	identity.Value = string(bs)
+	return nil
+}
+
+// MakeDeveloperIdentityObject creates an identity with client credentials. The
+// client id is stored in Ref1 and only the hash of the client secret is stored.
+// The client secret is returned and cannot be recovered from the identity.
+func MakeDeveloperIdentityObject(ownerID, creatorID, email, password string, protected bool) (*tables.Object, string, error) {
+	po, err := MakeIdentityObject(ownerID, creatorID, email, password, protected)
+	if err != nil {
+		return nil, "", err
+	}
+	secret, err := SetClientCredentials(po, "")
+	if err != nil {
+		return nil, "", errors.Wrap(err, "failed to create client credentials")
+	}
+	return po, secret, nil
+}
+
 // MakeChain takes objects and chains them together. Each object referencing the
 // hash of the previous on their PrevHash field and each preceding object
 // calculates its PeerHash which is the hash of its own hash and the hash of the object after
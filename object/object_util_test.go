@@ -1,6 +1,7 @@
 package object
 
 import (
+	"fmt"
 	"testing"
 
 	"github.com/ellcrys/util"
@@ -46,12 +47,53 @@ func TestObjectUtil(t *testing.T) {
 		})
 
 		Convey(".MakeIdentityObject", func() {
-			obj := MakeIdentityObject("owner_id", "creator_id", "lana@gmail.com", "some_password", true)
+			obj, err := MakeIdentityObject("owner_id", "creator_id", "lana@gmail.com", "some_password", true)
+			So(err, ShouldBeNil)
 			So(obj.CreatorID, ShouldEqual, "creator_id")
 			So(obj.OwnerID, ShouldEqual, "owner_id")
 			So(obj.Key, ShouldEqual, MakeIdentityKey("lana@gmail.com"))
 			So(obj.ID, ShouldNotBeEmpty)
 			So(obj.Timestamp, ShouldNotBeEmpty)
+
+			Convey("Should store the hash of the password", func() {
+				info, err := GetIdentityInfo(obj)
+				So(err, ShouldBeNil)
+				So(info.Email, ShouldEqual, "lana@gmail.com")
+				So(obj.Value, ShouldNotContainSubstring, "some_password")
+				ok, err := CheckPassword(info.PasswordHash, "some_password")
+				So(err, ShouldBeNil)
+				So(ok, ShouldBeTrue)
+			})
+
+			Convey("Should replace the hash of the password", func() {
+				So(SetIdentityPassword(obj, "new_password"), ShouldBeNil)
+				info, err := GetIdentityInfo(obj)
+				So(err, ShouldBeNil)
+				ok, err := CheckPassword(info.PasswordHash, "new_password")
+				So(err, ShouldBeNil)
+				So(ok, ShouldBeTrue)
+			})
+		})
+
+		Convey(".MakeDeveloperIdentityObject", func() {
+			obj, secret, err := MakeDeveloperIdentityObject("owner_id", "creator_id", "lana@gmail.com", "some_password", true)
+			So(err, ShouldBeNil)
+			So(obj.Ref1, ShouldNotBeEmpty)
+			So(obj.Ref2, ShouldBeEmpty)
+			So(obj.Value, ShouldNotContainSubstring, secret)
+			info, err := GetIdentityInfo(obj)
+			So(err, ShouldBeNil)
+			ok, err := CheckPassword(info.ClientSecretHash, secret)
+			So(err, ShouldBeNil)
+			So(ok, ShouldBeTrue)
+
+			Convey("Should record the revocation of the credentials", func() {
+				So(RevokeClientCredentials(obj), ShouldBeNil)
+				info, err := GetIdentityInfo(obj)
+				So(err, ShouldBeNil)
+				So(info.ClientSecretHash, ShouldBeEmpty)
+				So(info.CredentialsRevoked, ShouldBeTrue)
+			})
 		})
 
 		Convey(".MakeMappingObject", func() {
@@ -63,12 +105,28 @@ func TestObjectUtil(t *testing.T) {
 			So(obj.Timestamp, ShouldNotBeEmpty)
 		})
 
+		Convey(".MakeGrantObject", func() {
+			obj := MakeGrantObject("owner_id", "grantee_id", &GrantInfo{Permissions: []string{PermRead}})
+			So(obj.CreatorID, ShouldEqual, "owner_id")
+			So(obj.Key, ShouldEqual, MakeGrantKey("grantee_id"))
+			grant, err := GetGrantInfo(obj)
+			So(err, ShouldBeNil)
+			So(grant.Has(PermRead), ShouldBeTrue)
+			So(grant.Has(PermWrite), ShouldBeFalse)
+
+			Convey("A revoked grant has no permission", func() {
+				grant.Revoked = true
+				So(grant.Has(PermRead), ShouldBeFalse)
+			})
+		})
+
 		Convey(".MakeChain", func() {
 			Convey("Should successfully chain multiple objects", func() {
-				objs := []*tables.Object{
-					MakeIdentityObject("owner_id", "creator_id", "lana@gmail.com", "some_password", true),
-					MakeIdentityObject("owner_id_2", "creator_id_2", "lana_2@gmail.com", "some_password_2", true),
-					MakeIdentityObject("owner_id_3", "creator_id_3", "lana_3@gmail.com", "some_password_3", false),
+				var objs []*tables.Object
+				for i, protected := range []bool{true, true, false} {
+					obj, err := MakeIdentityObject(fmt.Sprintf("owner_id_%d", i), fmt.Sprintf("creator_id_%d", i), fmt.Sprintf("lana_%d@gmail.com", i), "some_password", protected)
+					So(err, ShouldBeNil)
+					objs = append(objs, obj)
 				}
 
 				Convey("All objects with a preceding object must reference the hash of the previous object", func() {
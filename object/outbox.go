@@ -0,0 +1,56 @@
+package object
+
+import (
+	"github.com/ellcrys/patchain"
+	"github.com/ellcrys/patchain/cockroach/tables"
+)
+
+// OutboxOptionName is the name of the OutboxOption
+var OutboxOptionName = "outbox"
+
+// OutboxOption contains outbox messages to write in the
+// same transaction as the objects passed to Put
+type OutboxOption struct {
+	Messages []*tables.OutboxMessage
+}
+
+// GetName returns the option's name
+func (t *OutboxOption) GetName() string {
+	return OutboxOptionName
+}
+
+// GetValue returns the outbox messages
+func (t *OutboxOption) GetValue() interface{} {
+	return t.Messages
+}
+
+// WithOutbox creates an option that causes Put to write
+// the messages in the transaction that adds the objects
+func WithOutbox(msgs ...*tables.OutboxMessage) *OutboxOption {
+	return &OutboxOption{Messages: msgs}
+}
+
+// getOutboxMessages returns the outbox messages included in the options
+func getOutboxMessages(options []patchain.Option) []*tables.OutboxMessage {
+	var msgs []*tables.OutboxMessage
+	for _, option := range options {
+		if option.GetName() == OutboxOptionName {
+			msgs = append(msgs, option.(*OutboxOption).Messages...)
+		}
+	}
+	return msgs
+}
+
+// addOutboxMessages writes outbox messages in a transaction. Messages
+// without an object id are linked to the first of the objects.
+func addOutboxMessages(dbTx patchain.DB, msgs []*tables.OutboxMessage, objects []*tables.Object, options []patchain.Option) error {
+	for _, msg := range msgs {
+		if msg.ObjectID == "" && len(objects) > 0 {
+			msg.ObjectID = objects[0].ID
+		}
+		if err := dbTx.Create(msg.Init(), options...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package object
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	// PasswordHashCost is the bcrypt cost used when hashing new passwords
+	PasswordHashCost = bcrypt.DefaultCost
+
+	// ErrMalformedPasswordHash indicates that an encoded password hash cannot be parsed
+	ErrMalformedPasswordHash = fmt.Errorf("malformed password hash")
+)
+
+// RandomToken returns n random bytes encoded in unpadded URL-safe base64
+func RandomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// HashPassword hashes a password with bcrypt and a random salt. Passwords
+// longer than 72 bytes are rejected.
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), PasswordHashCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// CheckPassword checks whether a password matches a hash created by HashPassword
+func CheckPassword(encodedHash, password string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(encodedHash), []byte(password))
+	switch err {
+	case nil:
+		return true, nil
+	case bcrypt.ErrMismatchedHashAndPassword:
+		return false, nil
+	default:
+		return false, ErrMalformedPasswordHash
+	}
+}
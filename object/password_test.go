@@ -0,0 +1,53 @@
+package object
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPassword(t *testing.T) {
+	Convey("Password", t, func() {
+
+		Convey(".HashPassword", func() {
+			Convey("Should use a different salt for every hash", func() {
+				hash, err := HashPassword("some_password")
+				So(err, ShouldBeNil)
+				So(hash, ShouldStartWith, "$2a$")
+				hash2, err := HashPassword("some_password")
+				So(err, ShouldBeNil)
+				So(hash, ShouldNotEqual, hash2)
+			})
+
+			Convey("Should return error if the password is longer than 72 bytes", func() {
+				_, err := HashPassword(strings.Repeat("a", 73))
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey(".CheckPassword", func() {
+			hash, err := HashPassword("some_password")
+			So(err, ShouldBeNil)
+
+			Convey("Should return true if the password matches", func() {
+				ok, err := CheckPassword(hash, "some_password")
+				So(err, ShouldBeNil)
+				So(ok, ShouldBeTrue)
+			})
+
+			Convey("Should return false if the password does not match", func() {
+				ok, err := CheckPassword(hash, "other_password")
+				So(err, ShouldBeNil)
+				So(ok, ShouldBeFalse)
+			})
+
+			Convey("Should return error if the hash is malformed", func() {
+				_, err := CheckPassword("md5$abc", "some_password")
+				So(err, ShouldEqual, ErrMalformedPasswordHash)
+				_, err = CheckPassword("$2a$xx$c2FsdA", "some_password")
+				So(err, ShouldEqual, ErrMalformedPasswordHash)
+			})
+		})
+	})
+}
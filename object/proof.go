@@ -0,0 +1,87 @@
+package object
+
+import (
+	"fmt"
+
+	"github.com/ellcrys/patchain"
+	"github.com/ellcrys/patchain/cockroach/tables"
+	"github.com/pkg/errors"
+)
+
+// Proof proves that an object is part of a partition. It holds the partition
+// and the objects of the partition from the genesis object up to the proven
+// object. The object after the proven object is included if there is one so
+// that the peer hash of the proven object can also be checked.
+type Proof struct {
+	Partition *tables.Object   `json:"partition"`
+	Objects   []*tables.Object `json:"objects"`
+
+	// Index is the position of the proven object in Objects
+	Index int `json:"index"`
+}
+
+// Object returns the proven object
+func (p *Proof) Object() *tables.Object {
+	return p.Objects[p.Index]
+}
+
+// GetProof returns a proof that an object is part of its partition.
+// Objects of archived partitions must be restored before they can be proven.
+// If an identity is acting, ErrPermissionDenied is returned unless it can
+// read the proven object and the protected objects of the chain.
+func (o *Object) GetProof(objectID string, options ...patchain.Option) (*Proof, error) {
+
+	actorID := getActorID(options)
+	options = withoutActor(options)
+
+	obj, err := o.getLast(&tables.Object{ID: objectID}, options)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := o.authorizeRead(obj, actorID, options); err != nil {
+		return nil, err
+	}
+
+	if obj.PartitionID == "" {
+		return nil, fmt.Errorf("object does not belong to a partition")
+	}
+
+	partition, err := o.GetLast(&tables.Object{ID: obj.PartitionID, QueryParams: patchain.KeyStartsWith(PartitionPrefix)}, options...)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get partition")
+	}
+
+	objs, err := o.all(&tables.Object{QueryParams: patchain.QueryParams{
+		Expr:    patchain.Expr{Expr: "partition_id = ? AND timestamp <= ?", Args: []interface{}{partition.ID, obj.Timestamp}},
+		OrderBy: "timestamp asc",
+	}}, options)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get partition objects")
+	}
+
+	next, err := o.all(&tables.Object{QueryParams: patchain.QueryParams{
+		Expr:    patchain.Expr{Expr: "partition_id = ? AND timestamp > ?", Args: []interface{}{partition.ID, obj.Timestamp}},
+		OrderBy: "timestamp asc",
+		Limit:   1,
+	}}, options)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get next object")
+	}
+
+	objs = append(objs, next...)
+	if err := o.authorizeReadAll(objs, actorID, options); err != nil {
+		return nil, err
+	}
+
+	return &Proof{Partition: partition, Objects: objs, Index: len(objs) - 1 - len(next)}, nil
+}
+
+// VerifyProof checks that the objects of a proof form a valid chain
+// from the partition's genesis pair to the proven object
+func VerifyProof(p *Proof) error {
+	if p.Partition == nil || p.Index < 0 || p.Index >= len(p.Objects) || len(p.Objects)-p.Index > 2 {
+		return fmt.Errorf("malformed proof")
+	}
+	return VerifyPartition(p.Partition, p.Objects)
+}
@@ -0,0 +1,96 @@
+package object
+
+import (
+	"testing"
+
+	"github.com/ellcrys/patchain/cockroach"
+	"github.com/ellcrys/patchain/cockroach/tables"
+	"github.com/ellcrys/util"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestVerifyProof(t *testing.T) {
+	Convey("VerifyProof", t, func() {
+		partition, objs := makeTestPartition(3)
+
+		Convey("Should verify a valid proof", func() {
+			So(VerifyProof(&Proof{Partition: partition, Objects: objs[:4], Index: 2}), ShouldBeNil)
+			So(VerifyProof(&Proof{Partition: partition, Objects: objs, Index: 4}), ShouldBeNil)
+		})
+
+		Convey("Should return error if proof is malformed", func() {
+			So(VerifyProof(&Proof{Partition: partition, Objects: objs, Index: 5}), ShouldNotBeNil)
+			So(VerifyProof(&Proof{Partition: partition, Objects: objs, Index: 1}).Error(), ShouldEqual, "malformed proof")
+		})
+
+		Convey("Should return error if an object was altered", func() {
+			objs[1].Value = "altered"
+			So(VerifyProof(&Proof{Partition: partition, Objects: objs[:4], Index: 2}), ShouldNotBeNil)
+		})
+
+		Convey("Should return error if objects do not start with the genesis pair", func() {
+			So(VerifyProof(&Proof{Partition: partition, Objects: objs[1:4], Index: 1}), ShouldNotBeNil)
+		})
+	})
+}
+
+func TestGetProof(t *testing.T) {
+
+	if err := createDb(t); err != nil {
+		t.Fatalf("failed to create test database. %s", err)
+	}
+	defer dropDB(t)
+
+	cdb := cockroach.NewDB()
+	cdb.ConnectionString = conStrWithDB
+	cdb.NoLogging()
+	if err := cdb.Connect(10, 5); err != nil {
+		t.Fatalf("failed to connect to database. %s", err)
+	}
+
+	if err := cdb.CreateTables(); err != nil {
+		t.Fatalf("failed to create tables. %s", err)
+	}
+
+	obj := NewObject(cdb)
+
+	Convey(".GetProof", t, func() {
+		ownerID := util.RandString(10)
+		partitions, err := obj.CreatePartitions(1, ownerID, ownerID)
+		So(err, ShouldBeNil)
+		objs := []*tables.Object{{Key: "key_1", OwnerID: ownerID}, {Key: "key_2", OwnerID: ownerID}, {Key: "key_3", OwnerID: ownerID}}
+		So(obj.Put(objs), ShouldBeNil)
+
+		Convey("Should return a verifiable proof of an object", func() {
+			proof, err := obj.GetProof(objs[1].ID)
+			So(err, ShouldBeNil)
+			So(proof.Partition.ID, ShouldEqual, partitions[0].ID)
+			So(proof.Object().ID, ShouldEqual, objs[1].ID)
+			So(proof.Objects, ShouldHaveLength, 5)
+			So(VerifyProof(proof), ShouldBeNil)
+		})
+
+		Convey("Should return a proof of the most recent object", func() {
+			proof, err := obj.GetProof(objs[2].ID)
+			So(err, ShouldBeNil)
+			So(proof.Index, ShouldEqual, len(proof.Objects)-1)
+			So(VerifyProof(proof), ShouldBeNil)
+		})
+
+		Convey("Should return ErrPermissionDenied if the acting identity cannot read the object", func() {
+			protected := &tables.Object{Key: "key_4", OwnerID: ownerID, Protected: true}
+			So(obj.Put([]*tables.Object{protected}), ShouldBeNil)
+			_, err := obj.GetProof(protected.ID, ActingAs(util.RandString(10)))
+			So(err, ShouldEqual, ErrPermissionDenied)
+			proof, err := obj.GetProof(protected.ID, ActingAs(ownerID))
+			So(err, ShouldBeNil)
+			So(proof.Object().ID, ShouldEqual, protected.ID)
+		})
+
+		Convey("Should return error if object does not belong to a partition", func() {
+			_, err := obj.GetProof(partitions[0].ID)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "object does not belong to a partition")
+		})
+	})
+}
@@ -0,0 +1,273 @@
+package object
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ellcrys/patchain"
+	"github.com/ellcrys/patchain/cockroach/tables"
+	"github.com/pkg/errors"
+)
+
+// Names of the limits of a quota
+const (
+	// LimitObjects is the limit on the number of objects of an owner
+	LimitObjects = "objects"
+
+	// LimitValueBytes is the limit on the total size of the values of the objects of an owner
+	LimitValueBytes = "value_bytes"
+
+	// LimitPartitions is the limit on the number of partitions of an owner
+	LimitPartitions = "partitions"
+
+	// LimitWritesPerSecond is the limit on the number of writes per second of an owner
+	LimitWritesPerSecond = "writes_per_second"
+)
+
+// Quota limits the resources an owner can consume. A zero value disables a limit.
+type Quota struct {
+
+	// MaxObjects is the maximum number of objects that can be put for an owner
+	MaxObjects int64
+
+	// MaxValueBytes is the maximum total size of the values of the objects of an
+	// owner. Values count for the size they are stored with, after compression.
+	MaxValueBytes int64
+
+	// MaxPartitions is the maximum number of partitions that can be created for an owner
+	MaxPartitions int64
+
+	// WritesPerSecond is the maximum number of calls to Put and
+	// CreatePartitions an owner can make within a second
+	WritesPerSecond int64
+}
+
+// QuotaError indicates that a write would exceed a limit of the quota of an owner
+type QuotaError struct {
+	OwnerID string
+
+	// Limit is the name of the exceeded limit (e.g LimitObjects)
+	Limit string
+
+	// Max is the value of the limit
+	Max int64
+
+	// Requested is the usage the write would have resulted in
+	Requested int64
+}
+
+// Error returns the error message
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("quota exceeded: %s of owner (%s) would be %d, max is %d", e.Limit, e.OwnerID, e.Requested, e.Max)
+}
+
+// IsQuotaError checks whether an error was caused by an exceeded quota
+func IsQuotaError(err error) bool {
+	_, ok := errors.Cause(err).(*QuotaError)
+	return ok
+}
+
+// SetQuota sets the quota of owners that have no quota of their own (see SetOwnerQuota).
+// Usage is only tracked for owners with a quota; an empty Quota tracks usage without
+// enforcing limits. Pass nil to remove the default quota.
+func (o *Object) SetQuota(quota *Quota) {
+	o.quotaMtx.Lock()
+	defer o.quotaMtx.Unlock()
+	o.defaultQuota = quota
+}
+
+// SetOwnerQuota sets the quota of an owner. Pass nil to remove it.
+func (o *Object) SetOwnerQuota(ownerID string, quota *Quota) {
+	o.quotaMtx.Lock()
+	defer o.quotaMtx.Unlock()
+	if quota == nil {
+		delete(o.ownerQuotas, ownerID)
+		return
+	}
+	if o.ownerQuotas == nil {
+		o.ownerQuotas = make(map[string]*Quota)
+	}
+	o.ownerQuotas[ownerID] = quota
+}
+
+// GetQuota returns the quota of an owner or nil if it has none
+func (o *Object) GetQuota(ownerID string) *Quota {
+	o.quotaMtx.RLock()
+	defer o.quotaMtx.RUnlock()
+	if quota, ok := o.ownerQuotas[ownerID]; ok {
+		return quota
+	}
+	return o.defaultQuota
+}
+
+// GetUsage returns the resources consumed by an owner. Usage starts being tracked
+// at the first write of an owner with a quota and includes the objects and partitions
+// stored at that time. It never decreases: deleting a key adds a tombstone and
+// archiving a partition does not release the objects it held. A zero usage is
+// returned if it is not tracked.
+func (o *Object) GetUsage(ownerID string, options ...patchain.Option) (*tables.OwnerUsage, error) {
+	var usage tables.OwnerUsage
+	if err := o.db.GetLast(&tables.OwnerUsage{OwnerID: ownerID}, &usage, withoutActor(options)...); err != nil {
+		if err == patchain.ErrNotFound {
+			return &tables.OwnerUsage{OwnerID: ownerID}, nil
+		}
+		return nil, errors.Wrap(err, "failed to get usage")
+	}
+	return &usage, nil
+}
+
+// chargeQuota adds the resources consumed by a write to the usage of an owner.
+// If enforce is true, the write is counted against the rate limit and a
+// QuotaError is returned if any limit is exceeded. The usage is updated in
+// the transaction included in the options so it is rolled back with the write.
+func (o *Object) chargeQuota(ownerID string, consumed *tables.OwnerUsage, enforce bool, options []patchain.Option) error {
+
+	quota := o.GetQuota(ownerID)
+	if quota == nil {
+		return nil
+	}
+
+	var usage tables.OwnerUsage
+	exists := true
+	if err := o.db.GetLast(&tables.OwnerUsage{OwnerID: ownerID}, &usage, options...); err != nil {
+		if err != patchain.ErrNotFound {
+			return errors.Wrap(err, "failed to get usage")
+		}
+		seeded, err := o.seedUsage(ownerID, options)
+		if err != nil {
+			return err
+		}
+		usage = *seeded
+		exists = false
+	}
+
+	now := time.Now()
+	usage.Objects += consumed.Objects
+	usage.ValueBytes += consumed.ValueBytes
+	usage.Partitions += consumed.Partitions
+	usage.Timestamp = now.UnixNano()
+
+	if enforce {
+
+		// writes are counted within one second windows
+		if usage.WindowStart != now.Unix() {
+			usage.WindowStart = now.Unix()
+			usage.WindowWrites = 0
+		}
+		usage.WindowWrites++
+
+		for _, limit := range []struct {
+			name           string
+			max, requested int64
+			consumed       bool
+		}{
+			{LimitWritesPerSecond, quota.WritesPerSecond, usage.WindowWrites, true},
+			{LimitObjects, quota.MaxObjects, usage.Objects, consumed.Objects > 0},
+			{LimitValueBytes, quota.MaxValueBytes, usage.ValueBytes, consumed.ValueBytes > 0},
+			{LimitPartitions, quota.MaxPartitions, usage.Partitions, consumed.Partitions > 0},
+		} {
+			if limit.consumed && limit.max > 0 && limit.requested > limit.max {
+				return &QuotaError{OwnerID: ownerID, Limit: limit.name, Max: limit.max, Requested: limit.requested}
+			}
+		}
+	}
+
+	// a concurrent first write may create the usage before us; the
+	// duplicate key error it causes is retried (see IsUsageConflict)
+	if !exists {
+		if err := o.db.Create(&usage, options...); err != nil {
+			return errors.Wrap(err, "failed to create usage")
+		}
+		return nil
+	}
+
+	if err := o.db.Update(&tables.OwnerUsage{OwnerID: ownerID}, map[string]interface{}{
+		"objects":       usage.Objects,
+		"value_bytes":   usage.ValueBytes,
+		"partitions":    usage.Partitions,
+		"window_start":  usage.WindowStart,
+		"window_writes": usage.WindowWrites,
+		"timestamp":     usage.Timestamp,
+	}, options...); err != nil {
+		return errors.Wrap(err, "failed to update usage")
+	}
+
+	return nil
+}
+
+// seedUsage returns the resources consumed by the objects and partitions an owner
+// has before its usage is tracked. Objects added by this package when partitions
+// are created, sealed or archived are not counted, as for tracked owners. Values
+// count for their stored size, as in storedSize.
+func (o *Object) seedUsage(ownerID string, options []patchain.Option) (*tables.OwnerUsage, error) {
+
+	partitionKey := PartitionPrefix + "%"
+	usage := &tables.OwnerUsage{OwnerID: ownerID}
+	if err := o.db.Aggregate(&tables.Object{QueryParams: patchain.QueryParams{Expr: patchain.Expr{
+		Expr: "owner_id = ? AND key NOT LIKE ? AND key NOT IN (?)",
+		Args: []interface{}{ownerID, "$genesis/%", []string{SealingKey, SealKey, ArchiveKey}},
+	}}}, patchain.Expr{
+		Expr: "CAST(COALESCE(SUM(CASE WHEN key LIKE ? THEN 0 ELSE 1 END), 0) AS INT) AS objects, " +
+			"CAST(COALESCE(SUM(CASE WHEN key LIKE ? THEN 0 ELSE OCTET_LENGTH(value) + blob_size END), 0) AS INT) AS value_bytes, " +
+			"CAST(COALESCE(SUM(CASE WHEN key LIKE ? THEN 1 ELSE 0 END), 0) AS INT) AS partitions",
+		Args: []interface{}{partitionKey, partitionKey, partitionKey},
+	}, usage, options...); err != nil {
+		return nil, errors.Wrap(err, "failed to get existing usage")
+	}
+
+	return usage, nil
+}
+
+// storedSize returns the size the value of an object is stored with: the size
+// of the compressed value if it is compressed. An offloaded value counts for
+// the size of its blob, which is the same.
+func (o *Object) storedSize(obj *tables.Object) (int64, error) {
+	stored, err := o.toStored(obj)
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(stored.Value)), nil
+}
+
+// usageOf returns the resources consumed by objects
+func (o *Object) usageOf(objects []*tables.Object) (*tables.OwnerUsage, error) {
+	usage := &tables.OwnerUsage{Objects: int64(len(objects))}
+	for _, obj := range objects {
+		size, err := o.storedSize(obj)
+		if err != nil {
+			return nil, err
+		}
+		usage.ValueBytes += size
+	}
+	return usage, nil
+}
+
+// usageByOwner returns the resources consumed by objects of one or more owners
+// and the owners in the order they first appear. As in seedUsage, objects added
+// by this package when partitions are created, sealed or archived are not counted.
+func (o *Object) usageByOwner(objects []*tables.Object) ([]string, map[string]*tables.OwnerUsage, error) {
+	var ownerIDs []string
+	usages := make(map[string]*tables.OwnerUsage)
+	for _, obj := range objects {
+		usage, ok := usages[obj.OwnerID]
+		if !ok {
+			usage = &tables.OwnerUsage{OwnerID: obj.OwnerID}
+			usages[obj.OwnerID] = usage
+			ownerIDs = append(ownerIDs, obj.OwnerID)
+		}
+		switch {
+		case strings.HasPrefix(obj.Key, PartitionPrefix):
+			usage.Partitions++
+		case strings.HasPrefix(obj.Key, "$genesis/"), isReservedKey(obj.Key):
+		default:
+			size, err := o.storedSize(obj)
+			if err != nil {
+				return nil, nil, err
+			}
+			usage.Objects++
+			usage.ValueBytes += size
+		}
+	}
+	return ownerIDs, usages, nil
+}
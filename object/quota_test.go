@@ -0,0 +1,168 @@
+package object
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ellcrys/patchain/cockroach"
+	"github.com/ellcrys/patchain/cockroach/tables"
+	"github.com/ellcrys/util"
+	"github.com/pkg/errors"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestQuota(t *testing.T) {
+
+	if err := createDb(t); err != nil {
+		t.Fatalf("failed to create test database. %s", err)
+	}
+	defer dropDB(t)
+
+	cdb := cockroach.NewDB()
+	cdb.ConnectionString = conStrWithDB
+	cdb.NoLogging()
+	if err := cdb.Connect(10, 5); err != nil {
+		t.Fatalf("failed to connect to database. %s", err)
+	}
+
+	if err := cdb.CreateTables(); err != nil {
+		t.Fatalf("failed to create tables. %s", err)
+	}
+
+	obj := NewObject(cdb)
+
+	Convey("Quota", t, func() {
+		ownerID := util.RandString(10)
+
+		Convey(".GetQuota", func() {
+			Convey("Should return the quota of the owner or the default quota", func() {
+				So(obj.GetQuota(ownerID), ShouldBeNil)
+				obj.SetQuota(&Quota{MaxObjects: 1})
+				So(obj.GetQuota(ownerID).MaxObjects, ShouldEqual, 1)
+				obj.SetOwnerQuota(ownerID, &Quota{MaxObjects: 2})
+				So(obj.GetQuota(ownerID).MaxObjects, ShouldEqual, 2)
+				obj.SetOwnerQuota(ownerID, nil)
+				So(obj.GetQuota(ownerID).MaxObjects, ShouldEqual, 1)
+				obj.SetQuota(nil)
+			})
+		})
+
+		Convey(".GetUsage", func() {
+			Convey("Should return a zero usage if the usage of the owner is not tracked", func() {
+				_, err := obj.CreatePartitions(1, ownerID, ownerID)
+				So(err, ShouldBeNil)
+				usage, err := obj.GetUsage(ownerID)
+				So(err, ShouldBeNil)
+				So(usage.OwnerID, ShouldEqual, ownerID)
+				So(usage.Partitions, ShouldEqual, 0)
+			})
+
+			Convey("Should track the usage of an owner with a quota", func() {
+				obj.SetOwnerQuota(ownerID, &Quota{})
+				defer obj.SetOwnerQuota(ownerID, nil)
+				_, err := obj.CreatePartitions(2, ownerID, ownerID)
+				So(err, ShouldBeNil)
+				So(obj.Put([]*tables.Object{
+					{OwnerID: ownerID, Key: "a", Value: "abc"},
+					{OwnerID: ownerID, Key: "b", Value: "de"},
+				}), ShouldBeNil)
+
+				usage, err := obj.GetUsage(ownerID)
+				So(err, ShouldBeNil)
+				So(usage.Partitions, ShouldEqual, 2)
+				So(usage.Objects, ShouldEqual, 2)
+				So(usage.ValueBytes, ShouldEqual, 5)
+			})
+
+			Convey("Should include the objects stored before the usage was tracked", func() {
+				_, err := obj.CreatePartitions(1, ownerID, ownerID)
+				So(err, ShouldBeNil)
+				So(obj.Put(&tables.Object{OwnerID: ownerID, Key: "a", Value: "abc"}), ShouldBeNil)
+
+				obj.SetOwnerQuota(ownerID, &Quota{})
+				defer obj.SetOwnerQuota(ownerID, nil)
+				So(obj.Put(&tables.Object{OwnerID: ownerID, Key: "b", Value: "de"}), ShouldBeNil)
+
+				usage, err := obj.GetUsage(ownerID)
+				So(err, ShouldBeNil)
+				So(usage.Partitions, ShouldEqual, 1)
+				So(usage.Objects, ShouldEqual, 2)
+				So(usage.ValueBytes, ShouldEqual, 5)
+			})
+
+			Convey("Should count values for their stored size", func() {
+				So(obj.SetCompressionPolicy(&CompressionPolicy{Algorithm: CompressionGzip, Threshold: 1}), ShouldBeNil)
+				defer obj.SetCompressionPolicy(nil)
+				_, err := obj.CreatePartitions(1, ownerID, ownerID)
+				So(err, ShouldBeNil)
+				So(obj.Put(&tables.Object{OwnerID: ownerID, Key: "a", Value: strings.Repeat("a", 1000)}), ShouldBeNil)
+
+				obj.SetOwnerQuota(ownerID, &Quota{})
+				defer obj.SetOwnerQuota(ownerID, nil)
+				So(obj.Put(&tables.Object{OwnerID: ownerID, Key: "b", Value: strings.Repeat("b", 1000)}), ShouldBeNil)
+
+				usage, err := obj.GetUsage(ownerID)
+				So(err, ShouldBeNil)
+				So(usage.Objects, ShouldEqual, 2)
+				So(usage.ValueBytes, ShouldBeLessThan, 2000)
+				seeded, err := obj.seedUsage(ownerID, nil)
+				So(err, ShouldBeNil)
+				So(usage.ValueBytes, ShouldEqual, seeded.ValueBytes)
+			})
+		})
+
+		Convey(".Put", func() {
+			_, err := obj.CreatePartitions(1, ownerID, ownerID)
+			So(err, ShouldBeNil)
+
+			Convey("Should return error if the maximum number of objects would be exceeded", func() {
+				obj.SetOwnerQuota(ownerID, &Quota{MaxObjects: 2})
+				defer obj.SetOwnerQuota(ownerID, nil)
+				So(obj.Put(&tables.Object{OwnerID: ownerID, Key: "a"}), ShouldBeNil)
+				err := obj.Put([]*tables.Object{{OwnerID: ownerID, Key: "b"}, {OwnerID: ownerID, Key: "c"}})
+				So(IsQuotaError(err), ShouldBeTrue)
+				qErr := errors.Cause(err).(*QuotaError)
+				So(qErr.Limit, ShouldEqual, LimitObjects)
+				So(qErr.Max, ShouldEqual, 2)
+				So(qErr.Requested, ShouldEqual, 3)
+
+				Convey("The usage of a rejected write must not be counted", func() {
+					usage, err := obj.GetUsage(ownerID)
+					So(err, ShouldBeNil)
+					So(usage.Objects, ShouldEqual, 1)
+				})
+			})
+
+			Convey("Should return error if the maximum size of values would be exceeded", func() {
+				obj.SetOwnerQuota(ownerID, &Quota{MaxValueBytes: 4})
+				defer obj.SetOwnerQuota(ownerID, nil)
+				err := obj.Put(&tables.Object{OwnerID: ownerID, Key: "a", Value: "abcde"})
+				So(IsQuotaError(err), ShouldBeTrue)
+				So(errors.Cause(err).(*QuotaError).Limit, ShouldEqual, LimitValueBytes)
+			})
+
+			Convey("Should return error if the rate limit would be exceeded", func() {
+				obj.SetOwnerQuota(ownerID, &Quota{WritesPerSecond: 1})
+				defer obj.SetOwnerQuota(ownerID, nil)
+				var err error
+				for i := 0; i < 3 && err == nil; i++ {
+					err = obj.Put(&tables.Object{OwnerID: ownerID, Key: "a"})
+				}
+				So(IsQuotaError(err), ShouldBeTrue)
+				So(errors.Cause(err).(*QuotaError).Limit, ShouldEqual, LimitWritesPerSecond)
+			})
+		})
+
+		Convey(".CreatePartitions", func() {
+			Convey("Should return error if the maximum number of partitions would be exceeded", func() {
+				obj.SetOwnerQuota(ownerID, &Quota{MaxPartitions: 2})
+				defer obj.SetOwnerQuota(ownerID, nil)
+				_, err := obj.CreatePartitions(2, ownerID, ownerID)
+				So(err, ShouldBeNil)
+				_, err = obj.CreatePartitions(1, ownerID, ownerID)
+				So(IsQuotaError(err), ShouldBeTrue)
+				So(errors.Cause(err).(*QuotaError).Limit, ShouldEqual, LimitPartitions)
+			})
+		})
+	})
+}
@@ -0,0 +1,254 @@
+package object
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ellcrys/patchain"
+	"github.com/ellcrys/patchain/cockroach/tables"
+	logging "github.com/op/go-logging"
+	"github.com/pkg/errors"
+)
+
+// ScalerConfig configures a partition scaler
+type ScalerConfig struct {
+
+	// Interval is how often contention is evaluated. Defaults to 1 minute.
+	Interval time.Duration
+
+	// ConflictThreshold is the number of prev hash conflicts an owner
+	// must reach within an interval to be given new partitions
+	ConflictThreshold int64
+
+	// RetryThreshold is the number of transaction retries (excluding prev hash conflicts)
+	// an owner must reach within an interval to be given new partitions
+	RetryThreshold int64
+
+	// MinPartitions is the minimum number of active partitions an owner is scaled to
+	MinPartitions int64
+
+	// MaxPartitions is the maximum number of active partitions an owner can be scaled to.
+	// A zero value means there is no limit.
+	MaxPartitions int64
+
+	// Step is the number of partitions added when a threshold is crossed. Defaults to 1.
+	Step int64
+
+	// Cooldown is the minimum duration between two scaling operations for the same owner
+	Cooldown time.Duration
+
+	// DryRun causes the scaler to only log its recommendations
+	DryRun bool
+}
+
+// contention holds the number of contention events observed for an owner
+type contention struct {
+	conflicts int64
+	retries   int64
+}
+
+// PartitionScaler adds partitions to owners whose writes are frequently retried
+// because of prev hash conflicts or transaction retries. It observes retries
+// through the retry listener of the object handler it is created with.
+type PartitionScaler struct {
+	sync.Mutex
+	o          *Object
+	cfg        ScalerConfig
+	log        *logging.Logger
+	contention map[string]*contention
+	lastScaled map[string]time.Time
+	stop       chan struct{}
+}
+
+// NewPartitionScaler creates a partition scaler and registers it
+// to observe the retries of the object handler
+func NewPartitionScaler(o *Object, cfg ScalerConfig) *PartitionScaler {
+	if cfg.Interval <= 0 {
+		cfg.Interval = time.Minute
+	}
+	if cfg.Step <= 0 {
+		cfg.Step = 1
+	}
+	s := &PartitionScaler{
+		o:          o,
+		cfg:        cfg,
+		contention: make(map[string]*contention),
+		lastScaled: make(map[string]time.Time),
+	}
+	s.log, _ = logging.GetLogger("patchain/scaler")
+	o.AddRetryListener(s.Observe)
+	return s
+}
+
+// Observe records a contention event for an owner
+func (s *PartitionScaler) Observe(ownerID string, err error) {
+	if ownerID == "" {
+		return
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	c := s.contention[ownerID]
+	if c == nil {
+		c = &contention{}
+		s.contention[ownerID] = c
+	}
+
+	if s.o.IsPrevHashConflict(err) {
+		c.conflicts++
+	} else {
+		c.retries++
+	}
+}
+
+// Start starts evaluating the observed contention at every interval.
+// It does not block.
+func (s *PartitionScaler) Start() {
+	s.stop = make(chan struct{})
+	go func(stop chan struct{}) {
+		ticker := time.NewTicker(s.cfg.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.Evaluate()
+			case <-stop:
+				return
+			}
+		}
+	}(s.stop)
+}
+
+// Stop stops the scaler
+func (s *PartitionScaler) Stop() {
+	if s.stop != nil {
+		close(s.stop)
+		s.stop = nil
+	}
+}
+
+// Evaluate checks the contention observed since the last evaluation and adds
+// partitions to owners that have crossed a threshold and are not cooling down.
+// Observed owners with fewer active partitions than MinPartitions are scaled
+// up to it whether or not a threshold was crossed.
+func (s *PartitionScaler) Evaluate() {
+
+	s.Lock()
+	observed := s.contention
+	s.contention = make(map[string]*contention)
+	s.Unlock()
+
+	for ownerID, c := range observed {
+		crossed := s.thresholdCrossed(c)
+		if crossed && s.coolingDown(ownerID) {
+			s.log.Debugf("owner %s: contention threshold crossed but still cooling down", ownerID)
+			crossed = false
+		}
+		if !crossed && s.cfg.MinPartitions <= 0 {
+			continue
+		}
+
+		if err := s.scale(ownerID, c, crossed); err != nil {
+			s.log.Errorf("owner %s: %s", ownerID, err)
+		}
+	}
+}
+
+// coolingDown checks whether an owner was scaled less than the cooldown ago
+func (s *PartitionScaler) coolingDown(ownerID string) bool {
+	s.Lock()
+	defer s.Unlock()
+	lastScaled, ok := s.lastScaled[ownerID]
+	return ok && time.Since(lastScaled) < s.cfg.Cooldown
+}
+
+// setScaled records that an owner has just been scaled
+func (s *PartitionScaler) setScaled(ownerID string) {
+	s.Lock()
+	defer s.Unlock()
+	s.lastScaled[ownerID] = time.Now()
+}
+
+// thresholdCrossed checks whether the contention has crossed any of the thresholds
+func (s *PartitionScaler) thresholdCrossed(c *contention) bool {
+	if s.cfg.ConflictThreshold > 0 && c.conflicts >= s.cfg.ConflictThreshold {
+		return true
+	}
+	if s.cfg.RetryThreshold > 0 && c.retries >= s.cfg.RetryThreshold {
+		return true
+	}
+	return false
+}
+
+// scale adds partitions to an owner within the configured limits. If no
+// threshold was crossed, partitions are only added to reach the min partitions.
+func (s *PartitionScaler) scale(ownerID string, c *contention, crossed bool) error {
+
+	partitions, err := s.o.All(&tables.Object{OwnerID: ownerID, QueryParams: patchain.KeyStartsWith(PartitionPrefix)})
+	if err != nil {
+		return errors.Wrap(err, "failed to get owner's partitions")
+	} else if len(partitions) == 0 {
+		return nil
+	}
+
+	active, err := s.o.activePartitions(ownerID, partitions, nil)
+	if err != nil {
+		return errors.Wrap(err, "failed to get owner's active partitions")
+	}
+
+	reason := fmt.Sprintf("contention threshold crossed (conflicts: %d, retries: %d)", c.conflicts, c.retries)
+	n := s.numPartitionsToAdd(int64(len(active)))
+	if !crossed {
+		reason = fmt.Sprintf("below min partitions (active: %d)", len(active))
+		n = s.numPartitionsToMin(int64(len(active)))
+		if n <= 0 {
+			return nil
+		}
+	}
+
+	if n <= 0 {
+		s.log.Infof("owner %s: %s but max partitions reached", ownerID, reason)
+		return nil
+	}
+
+	if s.cfg.DryRun {
+		s.log.Infof("owner %s: %s. Recommend adding %d partition(s)", ownerID, reason, n)
+		s.setScaled(ownerID)
+		return nil
+	}
+
+	// new partitions share the creator of the owner's existing partitions
+	creatorID := partitions[0].CreatorID
+	if _, err := s.o.MustCreatePartitions(n, ownerID, creatorID); err != nil {
+		return errors.Wrap(err, "failed to add partitions")
+	}
+
+	s.setScaled(ownerID)
+	s.log.Infof("owner %s: %s. Added %d partition(s)", ownerID, reason, n)
+	return nil
+}
+
+// numPartitionsToAdd returns the number of partitions to add to
+// an owner with the given number of active partitions.
+func (s *PartitionScaler) numPartitionsToAdd(numActive int64) int64 {
+	n := s.cfg.Step
+	if numActive+n < s.cfg.MinPartitions {
+		n = s.cfg.MinPartitions - numActive
+	}
+	if s.cfg.MaxPartitions > 0 && numActive+n > s.cfg.MaxPartitions {
+		n = s.cfg.MaxPartitions - numActive
+	}
+	return n
+}
+
+// numPartitionsToMin returns the number of partitions an owner with the
+// given number of active partitions needs to reach the min partitions.
+func (s *PartitionScaler) numPartitionsToMin(numActive int64) int64 {
+	n := s.cfg.MinPartitions - numActive
+	if s.cfg.MaxPartitions > 0 && numActive+n > s.cfg.MaxPartitions {
+		n = s.cfg.MaxPartitions - numActive
+	}
+	return n
+}
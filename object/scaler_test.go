@@ -0,0 +1,149 @@
+package object
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ellcrys/gorm"
+	"github.com/ellcrys/patchain"
+	"github.com/ellcrys/patchain/cockroach"
+	"github.com/ellcrys/patchain/cockroach/tables"
+	"github.com/ellcrys/util"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+var prevHashConflictErr = fmt.Errorf(`pq: duplicate key value (prev_hash)=('stuff') violates unique constraint "idx_prev_hash"`)
+var retryErr = fmt.Errorf(`pq: some text retry transaction`)
+
+func TestPartitionScaler(t *testing.T) {
+
+	if err := createDb(t); err != nil {
+		t.Fatalf("failed to create test database. %s", err)
+	}
+	defer dropDB(t)
+
+	cdb := cockroach.NewDB()
+	cdb.ConnectionString = conStrWithDB
+	cdb.NoLogging()
+	if err := cdb.Connect(10, 5); err != nil {
+		t.Fatalf("failed to connect to database. %s", err)
+	}
+
+	if err := cdb.CreateTables(); err != nil {
+		t.Fatalf("failed to create tables. %s", err)
+	}
+
+	countPartitions := func(ownerID string) int {
+		partitions, err := NewObject(cdb).All(&tables.Object{OwnerID: ownerID, QueryParams: patchain.KeyStartsWith(PartitionPrefix)})
+		So(err, ShouldBeNil)
+		return len(partitions)
+	}
+
+	Convey("PartitionScaler", t, func() {
+
+		Convey(".Observe", func() {
+			s := NewPartitionScaler(NewObject(cdb), ScalerConfig{})
+
+			Convey("Should count prev hash conflicts and retries separately", func() {
+				s.Observe("owner_id", prevHashConflictErr)
+				s.Observe("owner_id", prevHashConflictErr)
+				s.Observe("owner_id", retryErr)
+				So(s.contention["owner_id"], ShouldResemble, &contention{conflicts: 2, retries: 1})
+			})
+
+			Convey("Should ignore events with no owner", func() {
+				s.Observe("", retryErr)
+				So(s.contention, ShouldBeEmpty)
+			})
+
+			Convey("Should observe retries of the object handler", func() {
+				obj := NewObject(cdb)
+				s := NewPartitionScaler(obj, ScalerConfig{})
+				for _, l := range obj.retryListeners {
+					l("owner_id", retryErr)
+				}
+				So(s.contention["owner_id"], ShouldResemble, &contention{retries: 1})
+			})
+		})
+
+		Convey(".numPartitionsToAdd", func() {
+			Convey("Should add the configured step", func() {
+				s := NewPartitionScaler(NewObject(cdb), ScalerConfig{Step: 2})
+				So(s.numPartitionsToAdd(1), ShouldEqual, 2)
+			})
+
+			Convey("Should add enough partitions to reach the min partitions", func() {
+				s := NewPartitionScaler(NewObject(cdb), ScalerConfig{MinPartitions: 5})
+				So(s.numPartitionsToAdd(1), ShouldEqual, 4)
+			})
+
+			Convey("Should not exceed the max partitions", func() {
+				s := NewPartitionScaler(NewObject(cdb), ScalerConfig{Step: 5, MaxPartitions: 3})
+				So(s.numPartitionsToAdd(1), ShouldEqual, 2)
+				So(s.numPartitionsToAdd(3), ShouldEqual, 0)
+			})
+		})
+
+		Convey(".numPartitionsToMin", func() {
+			Convey("Should add enough partitions to reach the min partitions within the max partitions", func() {
+				s := NewPartitionScaler(NewObject(cdb), ScalerConfig{MinPartitions: 5, MaxPartitions: 3})
+				So(s.numPartitionsToMin(1), ShouldEqual, 2)
+				So(s.numPartitionsToMin(3), ShouldEqual, 0)
+			})
+		})
+
+		Convey(".Evaluate", func() {
+			ownerID := util.RandString(10)
+			_, err := NewObject(cdb).CreatePartitions(1, ownerID, ownerID)
+			So(err, ShouldBeNil)
+
+			Convey("Should add partitions when a threshold is crossed", func() {
+				s := NewPartitionScaler(NewObject(cdb), ScalerConfig{ConflictThreshold: 2})
+				s.Observe(ownerID, prevHashConflictErr)
+				s.Observe(ownerID, prevHashConflictErr)
+				s.Evaluate()
+				So(countPartitions(ownerID), ShouldEqual, 2)
+
+				Convey("Should reset observed contention after evaluation", func() {
+					So(s.contention, ShouldBeEmpty)
+				})
+			})
+
+			Convey("Should not add partitions when no threshold is crossed", func() {
+				s := NewPartitionScaler(NewObject(cdb), ScalerConfig{ConflictThreshold: 2, RetryThreshold: 2})
+				s.Observe(ownerID, prevHashConflictErr)
+				s.Observe(ownerID, retryErr)
+				s.Evaluate()
+				So(countPartitions(ownerID), ShouldEqual, 1)
+			})
+
+			Convey("Should not add partitions while cooling down", func() {
+				s := NewPartitionScaler(NewObject(cdb), ScalerConfig{RetryThreshold: 1, Cooldown: time.Hour})
+				s.Observe(ownerID, retryErr)
+				s.Evaluate()
+				s.Observe(ownerID, retryErr)
+				s.Evaluate()
+				So(countPartitions(ownerID), ShouldEqual, 2)
+			})
+
+			Convey("Should add partitions to reach the min partitions when no threshold is crossed", func() {
+				s := NewPartitionScaler(NewObject(cdb), ScalerConfig{ConflictThreshold: 2, MinPartitions: 3})
+				s.Observe(ownerID, retryErr)
+				s.Evaluate()
+				So(countPartitions(ownerID), ShouldEqual, 3)
+			})
+
+			Convey("Should only log recommendation in dry-run mode", func() {
+				s := NewPartitionScaler(NewObject(cdb), ScalerConfig{RetryThreshold: 1, DryRun: true})
+				s.Observe(ownerID, retryErr)
+				s.Evaluate()
+				So(countPartitions(ownerID), ShouldEqual, 1)
+			})
+
+			Reset(func() {
+				clearTable(cdb.GetConn().(*gorm.DB), "objects")
+			})
+		})
+	})
+}
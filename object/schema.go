@@ -0,0 +1,217 @@
+package object
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ellcrys/patchain"
+	"github.com/ellcrys/patchain/cockroach/tables"
+	"github.com/ellcrys/patchain/jsonschema"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrInvalidSchemaPrefix indicates that a schema was defined for a reserved key prefix
+	ErrInvalidSchemaPrefix = fmt.Errorf("key prefix must not start with $")
+
+	// ErrNoSchema indicates that no schema is defined for a key prefix
+	ErrNoSchema = fmt.Errorf("schema not found")
+)
+
+// ValueSchema is a version of the schema of the values of the objects
+// whose key starts with a prefix. Every version is an object that refers
+// to the previous version in Ref1 and holds its version number in Ref2.
+type ValueSchema struct {
+	Object    *tables.Object
+	KeyPrefix string
+	Version   int
+	Schema    *jsonschema.Schema
+}
+
+// newValueSchema compiles the schema held by a schema object
+func (o *Object) newValueSchema(schemaObj *tables.Object) (*ValueSchema, error) {
+	s, err := o.compileSchema(schemaObj)
+	if err != nil {
+		return nil, err
+	}
+	version, _ := strconv.Atoi(schemaObj.Ref2)
+	return &ValueSchema{
+		Object:    schemaObj,
+		KeyPrefix: strings.TrimPrefix(schemaObj.Key, SchemaPrefix),
+		Version:   version,
+		Schema:    s,
+	}, nil
+}
+
+// DefineSchema adds a new version of the JSON Schema that the values of an owner's
+// objects must match if their key starts with keyPrefix. When several schemas
+// apply to a key, the one with the longest prefix is used. Keys starting
+// with $ are reserved and never validated. If an identity is acting, it must be
+// the owner.
+func (o *Object) DefineSchema(ownerID, keyPrefix, schemaJSON string, options ...patchain.Option) (*tables.Object, error) {
+
+	if strings.HasPrefix(keyPrefix, "$") {
+		return nil, ErrInvalidSchemaPrefix
+	}
+
+	if _, err := jsonschema.Compile(schemaJSON); err != nil {
+		return nil, err
+	}
+
+	schemaObj := MakeSchemaObject(ownerID, keyPrefix, schemaJSON)
+	if actorID := getActorID(options); actorID != "" {
+		schemaObj.CreatorID = actorID
+	}
+
+	dbTx, dbOptions, finish := o.getDBOptions(options)
+	err := o.db.TransactWithDB(dbTx, finish, func(dbTx patchain.DB, commit patchain.CommitFunc, rollback patchain.RollbackFunc) error {
+
+		// chain the new version to the current version
+		version := 1
+		current, err := o.GetLast(&tables.Object{OwnerID: ownerID, Key: schemaObj.Key}, dbOptions...)
+		if err != nil && err != patchain.ErrNotFound {
+			return errors.Wrap(err, "failed to get current schema")
+		}
+		if current != nil {
+			schemaObj.Ref1 = current.ID
+			n, _ := strconv.Atoi(current.Ref2)
+			version = n + 1
+		}
+		schemaObj.Ref2 = strconv.Itoa(version)
+
+		return o.Put(schemaObj, append(append([]patchain.Option{}, options...), &patchain.UseDBOption{DB: dbTx})...)
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to define schema")
+	}
+
+	return schemaObj, nil
+}
+
+// GetSchema returns the current version of the schema of a key prefix
+func (o *Object) GetSchema(ownerID, keyPrefix string, options ...patchain.Option) (*ValueSchema, error) {
+	schemaObj, err := o.GetLast(&tables.Object{OwnerID: ownerID, Key: MakeSchemaKey(keyPrefix)}, options...)
+	if err != nil {
+		if err == patchain.ErrNotFound {
+			return nil, ErrNoSchema
+		}
+		return nil, errors.Wrap(err, "failed to get schema")
+	}
+	return o.newValueSchema(schemaObj)
+}
+
+// maxCachedSchemas is the number of compiled schemas kept in memory
+const maxCachedSchemas = 1000
+
+// compileSchema returns the compiled schema of a schema object. Schema objects
+// are never modified, so compiled schemas are cached by object id.
+func (o *Object) compileSchema(schemaObj *tables.Object) (*jsonschema.Schema, error) {
+
+	o.schemaMtx.Lock()
+	s := o.schemas[schemaObj.ID]
+	o.schemaMtx.Unlock()
+	if s != nil {
+		return s, nil
+	}
+
+	s, err := jsonschema.Compile(schemaObj.Value)
+	if err != nil {
+		return nil, err
+	}
+
+	o.schemaMtx.Lock()
+	defer o.schemaMtx.Unlock()
+	if o.schemas == nil || len(o.schemas) >= maxCachedSchemas {
+		o.schemas = make(map[string]*jsonschema.Schema)
+	}
+	o.schemas[schemaObj.ID] = s
+	return s, nil
+}
+
+// loadSchemas returns the latest schema object of every key prefix of an
+// owner. If at is not zero, only schemas defined at or before it are considered.
+// Versions that are not the latest of their key prefix are not read.
+func (o *Object) loadSchemas(ownerID string, at int64, options []patchain.Option) (map[string]*tables.Object, error) {
+
+	latest := "SELECT key, MAX(timestamp) FROM objects WHERE owner_id = ? AND key LIKE ?"
+	args := []interface{}{ownerID, ownerID, SchemaPrefix + "%"}
+	if at > 0 {
+		latest += " AND timestamp <= ?"
+		args = append(args, at)
+	}
+	q := &tables.Object{QueryParams: patchain.QueryParams{
+		Expr: patchain.Expr{Expr: fmt.Sprintf("owner_id = ? AND (key, timestamp) IN (%s GROUP BY key)", latest), Args: args},
+	}}
+
+	schemaObjs, err := o.All(q, options...)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get schemas")
+	}
+
+	schemas := make(map[string]*tables.Object)
+	for _, schemaObj := range schemaObjs {
+		schemas[strings.TrimPrefix(schemaObj.Key, SchemaPrefix)] = schemaObj
+	}
+	return schemas, nil
+}
+
+// schemaFor returns the schema object with the longest prefix of a key
+func schemaFor(schemas map[string]*tables.Object, key string) *tables.Object {
+	var found *tables.Object
+	longest := -1
+	for prefix, schemaObj := range schemas {
+		if strings.HasPrefix(key, prefix) && len(prefix) > longest {
+			found, longest = schemaObj, len(prefix)
+		}
+	}
+	return found
+}
+
+// validateValues validates the values of objects against the schemas of their
+// owner. If at is not zero, the schemas in force at that time are used.
+// A *jsonschema.ValidationError is returned if a value does not match.
+// Values under a schema must be JSON (no codec or JSONCodec).
+func (o *Object) validateValues(ownerID string, at int64, objects []*tables.Object, options []patchain.Option) error {
+
+	if ownerID == "" {
+		return nil
+	}
+
+	schemas, err := o.loadSchemas(ownerID, at, options)
+	if err != nil || len(schemas) == 0 {
+		return err
+	}
+
+	for i, obj := range objects {
+		if strings.HasPrefix(obj.Key, "$") {
+			continue
+		}
+		schemaObj := schemaFor(schemas, obj.Key)
+		if schemaObj == nil {
+			continue
+		}
+		s, err := o.compileSchema(schemaObj)
+		if err != nil {
+			return errors.Wrapf(err, "schema %s", schemaObj.Key)
+		}
+		if obj.Codec != "" && obj.Codec != tables.CodecJSON {
+			return fmt.Errorf("object %d: values encoded by the %s codec cannot be validated against schema %s", i, obj.Codec, schemaObj.Key)
+		}
+		if err := s.Validate(obj.Value); err != nil {
+			return errors.Wrapf(err, "object %d", i)
+		}
+	}
+
+	return nil
+}
+
+// Revalidate validates the value of a stored object against the schema that
+// was in force when the object was created. It returns nil if no schema applied.
+func (o *Object) Revalidate(obj *tables.Object, options ...patchain.Option) error {
+	err := o.validateValues(obj.OwnerID, obj.Timestamp, []*tables.Object{obj}, options)
+	if verr, ok := errors.Cause(err).(*jsonschema.ValidationError); ok {
+		return verr
+	}
+	return err
+}
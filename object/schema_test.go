@@ -0,0 +1,156 @@
+package object
+
+import (
+	"testing"
+
+	"github.com/ellcrys/patchain/cockroach"
+	"github.com/ellcrys/patchain/cockroach/tables"
+	"github.com/ellcrys/patchain/jsonschema"
+	"github.com/ellcrys/util"
+	"github.com/pkg/errors"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSchema(t *testing.T) {
+
+	if err := createDb(t); err != nil {
+		t.Fatalf("failed to create test database. %s", err)
+	}
+	defer dropDB(t)
+
+	cdb := cockroach.NewDB()
+	cdb.ConnectionString = conStrWithDB
+	cdb.NoLogging()
+	if err := cdb.Connect(10, 5); err != nil {
+		t.Fatalf("failed to connect to database. %s", err)
+	}
+
+	if err := cdb.CreateTables(); err != nil {
+		t.Fatalf("failed to create tables. %s", err)
+	}
+
+	obj := NewObject(cdb)
+
+	Convey("Schemas", t, func() {
+		ownerID := util.RandString(10)
+		_, err := obj.CreatePartitions(1, ownerID, ownerID)
+		So(err, ShouldBeNil)
+
+		Convey(".DefineSchema", func() {
+			Convey("Should return error if key prefix is reserved", func() {
+				_, err := obj.DefineSchema(ownerID, "$identity/", `{}`)
+				So(err, ShouldEqual, ErrInvalidSchemaPrefix)
+			})
+
+			Convey("Should return error if schema is invalid", func() {
+				_, err := obj.DefineSchema(ownerID, "orders/", `{"type":"date"}`)
+				So(err, ShouldNotBeNil)
+			})
+
+			Convey("Should return ErrPermissionDenied if the acting identity is not the owner", func() {
+				granteeID := util.RandString(10)
+				_, err := obj.Grant(ownerID, granteeID, []string{PermWrite})
+				So(err, ShouldBeNil)
+				_, err = obj.DefineSchema(ownerID, "orders/", `{"type":"object"}`, ActingAs(granteeID))
+				So(errors.Cause(err), ShouldEqual, ErrPermissionDenied)
+				_, err = obj.GetSchema(ownerID, "orders/")
+				So(err, ShouldEqual, ErrNoSchema)
+			})
+
+			Convey("Should chain schema versions", func() {
+				v1, err := obj.DefineSchema(ownerID, "orders/", `{"type":"object"}`)
+				So(err, ShouldBeNil)
+				So(v1.Ref1, ShouldBeEmpty)
+				So(v1.Ref2, ShouldEqual, "1")
+
+				v2, err := obj.DefineSchema(ownerID, "orders/", `{"type":"object","required":["id"]}`)
+				So(err, ShouldBeNil)
+				So(v2.Ref1, ShouldEqual, v1.ID)
+				So(v2.Ref2, ShouldEqual, "2")
+
+				current, err := obj.GetSchema(ownerID, "orders/")
+				So(err, ShouldBeNil)
+				So(current.Version, ShouldEqual, 2)
+				So(current.KeyPrefix, ShouldEqual, "orders/")
+			})
+		})
+
+		Convey(".GetSchema", func() {
+			Convey("Should cache the compiled schema of a version", func() {
+				_, err := obj.DefineSchema(ownerID, "invoices/", `{"type":"object"}`)
+				So(err, ShouldBeNil)
+				s1, err := obj.GetSchema(ownerID, "invoices/")
+				So(err, ShouldBeNil)
+				s2, err := obj.GetSchema(ownerID, "invoices/")
+				So(err, ShouldBeNil)
+				So(s1.Schema, ShouldPointTo, s2.Schema)
+			})
+
+			Convey("Should return ErrNoSchema if no schema is defined", func() {
+				_, err := obj.GetSchema(ownerID, "unknown/")
+				So(err, ShouldEqual, ErrNoSchema)
+			})
+		})
+
+		Convey("With schemas", func() {
+			_, err := obj.DefineSchema(ownerID, "orders/", `{"type":"object","required":["id"]}`)
+			So(err, ShouldBeNil)
+			_, err = obj.DefineSchema(ownerID, "orders/archived/", `{"type":"null"}`)
+			So(err, ShouldBeNil)
+
+			Convey(".Put", func() {
+				Convey("Should add objects whose value matches the schema", func() {
+					So(obj.Put(&tables.Object{OwnerID: ownerID, Key: "orders/1", Value: `{"id":1}`}), ShouldBeNil)
+				})
+
+				Convey("Should not validate keys without schema", func() {
+					So(obj.Put(&tables.Object{OwnerID: ownerID, Key: "notes/1", Value: "text"}), ShouldBeNil)
+				})
+
+				Convey("Should return field-level errors if a value does not match", func() {
+					err := obj.Put([]*tables.Object{
+						{OwnerID: ownerID, Key: "orders/1", Value: `{"id":1}`},
+						{OwnerID: ownerID, Key: "orders/2", Value: `{}`},
+					})
+					So(err, ShouldNotBeNil)
+					So(err.Error(), ShouldContainSubstring, "object 1: value does not match schema")
+					verr, ok := errors.Cause(err).(*jsonschema.ValidationError)
+					So(ok, ShouldBeTrue)
+					So(verr.Errors, ShouldResemble, []jsonschema.FieldError{{Message: "missing properties: 'id'"}})
+				})
+
+				Convey("Should use the schema with the longest matching prefix", func() {
+					So(obj.Put(&tables.Object{OwnerID: ownerID, Key: "orders/archived/1", Value: `null`}), ShouldBeNil)
+					err := obj.Put(&tables.Object{OwnerID: ownerID, Key: "orders/archived/2", Value: `{"id":1}`})
+					So(err, ShouldNotBeNil)
+				})
+			})
+
+			Convey(".CreateOnce", func() {
+				Convey("Should return error if the value does not match", func() {
+					_, err := obj.CreateOnce(&tables.Object{OwnerID: ownerID, Key: "orders/" + util.RandString(5), Value: `[]`})
+					So(err, ShouldNotBeNil)
+					_, ok := errors.Cause(err).(*jsonschema.ValidationError)
+					So(ok, ShouldBeTrue)
+				})
+			})
+
+			Convey(".Revalidate", func() {
+				Convey("Should use the schema in force when the object was created", func() {
+					order := &tables.Object{OwnerID: ownerID, Key: "orders/1", Value: `{"id":1}`}
+					So(obj.Put(order), ShouldBeNil)
+
+					_, err := obj.DefineSchema(ownerID, "orders/", `{"type":"object","required":["id","total"]}`)
+					So(err, ShouldBeNil)
+					So(obj.Revalidate(order), ShouldBeNil)
+
+					newOrder := &tables.Object{OwnerID: ownerID, Key: "orders/1", Value: `{"id":1}`}
+					newOrder.Init()
+					err = obj.Revalidate(newOrder)
+					So(err, ShouldNotBeNil)
+					So(err.(*jsonschema.ValidationError).Errors, ShouldResemble, []jsonschema.FieldError{{Message: "missing properties: 'total'"}})
+				})
+			})
+		})
+	})
+}
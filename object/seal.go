@@ -0,0 +1,290 @@
+package object
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/ellcrys/patchain"
+	"github.com/ellcrys/patchain/cockroach/tables"
+	"github.com/pkg/errors"
+)
+
+var (
+	// SealingKey is the key of the object that marks a partition as being sealed
+	SealingKey = "$sealing"
+
+	// SealKey is the key of the final object of a sealed partition
+	SealKey = "$seal"
+
+	// ErrPartitionSealed indicates that a partition no longer accepts new objects
+	ErrPartitionSealed = fmt.Errorf("partition is sealed")
+
+	// ErrReservedKey indicates an attempt to put an object whose key marks the state of a partition
+	ErrReservedKey = fmt.Errorf("key is reserved")
+)
+
+// isReservedKey checks whether a key marks the state of a partition.
+// Objects with such keys are only added by this package.
+func isReservedKey(key string) bool {
+	return key == SealingKey || key == SealKey || key == ArchiveKey
+}
+
+// Partition states
+const (
+	// PartitionActive describes a partition that accepts new objects
+	PartitionActive = "active"
+
+	// PartitionSealing describes a partition that no longer accepts
+	// new objects but has not been sealed
+	PartitionSealing = "sealing"
+
+	// PartitionSealed describes a partition that has been sealed
+	PartitionSealed = "sealed"
+)
+
+// SealInfo describes the content of a seal object. It commits
+// to the state of the partition at the point it was sealed.
+type SealInfo struct {
+	Length   int64  `json:"length"`
+	HeadHash string `json:"head_hash"`
+}
+
+// RolloverPolicy defines the conditions that cause a partition to be sealed
+// and replaced by new partitions. A zero value disables a condition.
+type RolloverPolicy struct {
+
+	// MaxObjects is the maximum number of objects a partition can hold
+	MaxObjects int64
+
+	// MaxAge is the maximum duration a partition can accept new objects
+	MaxAge time.Duration
+
+	// NumPartitions is the number of partitions to create when a partition
+	// is sealed. Defaults to 1.
+	NumPartitions int64
+}
+
+// ShouldRollover checks whether a partition with the given number of
+// objects and creation time (in nanoseconds) has exceeded the policy
+func (p *RolloverPolicy) ShouldRollover(numObjects, createdAt int64) bool {
+	if p.MaxObjects > 0 && numObjects >= p.MaxObjects {
+		return true
+	}
+	if p.MaxAge > 0 && time.Now().UnixNano()-createdAt >= p.MaxAge.Nanoseconds() {
+		return true
+	}
+	return false
+}
+
+// SetRolloverPolicy sets the policy used to automatically seal a partition and
+// create new partitions for its owner. Pass nil to disable automatic rollover.
+func (o *Object) SetRolloverPolicy(policy *RolloverPolicy) {
+	o.rolloverPolicy = policy
+}
+
+// getPartitionState determines the state of a partition from its last object
+func getPartitionState(lastObj *tables.Object) string {
+	switch lastObj.Key {
+	case SealingKey:
+		return PartitionSealing
+	case SealKey:
+		return PartitionSealed
+	case ArchiveKey:
+		return PartitionArchived
+	default:
+		return PartitionActive
+	}
+}
+
+// GetPartitionState returns the state of a partition
+func (o *Object) GetPartitionState(partitionID string, options ...patchain.Option) (string, error) {
+	lastObj, err := o.getLast(&tables.Object{PartitionID: partitionID}, options)
+	if err != nil {
+		if err == patchain.ErrNotFound {
+			return "", fmt.Errorf("no genesis object in the partition")
+		}
+		return "", err
+	}
+	return getPartitionState(lastObj), nil
+}
+
+// activePartitions returns the partitions of an owner that are not being
+// sealed, sealed or archived. The state of a partition is determined from
+// its last object, as by GetPartitionState.
+func (o *Object) activePartitions(ownerID string, partitions []*tables.Object, options []patchain.Option) ([]*tables.Object, error) {
+
+	if len(partitions) == 0 {
+		return partitions, nil
+	}
+
+	var ids []string
+	for _, p := range partitions {
+		ids = append(ids, p.ID)
+	}
+
+	var lastObjs []*tables.Object
+	if err := o.db.GetAll(&tables.Object{QueryParams: patchain.QueryParams{
+		Expr: patchain.Expr{
+			Expr: "(partition_id, timestamp) IN (SELECT partition_id, MAX(timestamp) FROM objects WHERE owner_id = ? AND partition_id IN (?) GROUP BY partition_id)",
+			Args: []interface{}{ownerID, ids},
+		},
+	}}, &lastObjs, withoutActor(options)...); err != nil {
+		return nil, err
+	}
+
+	var inactive = make(map[string]bool)
+	for _, lastObj := range lastObjs {
+		if getPartitionState(lastObj) != PartitionActive {
+			inactive[lastObj.PartitionID] = true
+		}
+	}
+
+	var active []*tables.Object
+	for _, p := range partitions {
+		if !inactive[p.ID] {
+			active = append(active, p)
+		}
+	}
+
+	return active, nil
+}
+
+// BeginSealPartition marks a partition as sealing by adding a sealing object
+// to it. A partition in this state will no longer accept new objects. If an identity
+// is acting, it must be allowed to write for the owner of the partition.
+func (o *Object) BeginSealPartition(partitionID string, options ...patchain.Option) error {
+	dbTx, dbOptions, finish := o.getDBOptions(options)
+	err := o.db.TransactWithDB(dbTx, finish, func(dbTx patchain.DB, commit patchain.CommitFunc, rollback patchain.RollbackFunc) error {
+		partition, err := o.GetLast(&tables.Object{ID: partitionID, QueryParams: patchain.KeyStartsWith(PartitionPrefix)}, dbOptions...)
+		if err != nil {
+			return errors.Wrap(err, "failed to get partition")
+		}
+		if err := o.authorizeOwners([]string{partition.OwnerID}, getActorID(options), dbOptions); err != nil {
+			return err
+		}
+		_, err = o.beginSeal(dbTx, partition, dbOptions)
+		return err
+	})
+	return errors.Wrap(err, "failed to begin partition seal")
+}
+
+// beginSeal adds a sealing object to a partition if it is still active.
+// It returns the last object of the partition.
+func (o *Object) beginSeal(dbTx patchain.DB, partition *tables.Object, options []patchain.Option) (*tables.Object, error) {
+
+	lastObj, err := o.getLast(&tables.Object{PartitionID: partition.ID}, options)
+	if err != nil {
+		if err == patchain.ErrNotFound {
+			return nil, fmt.Errorf("no genesis object in the partition")
+		}
+		return nil, err
+	}
+
+	switch getPartitionState(lastObj) {
+	case PartitionSealed, PartitionArchived:
+		return nil, ErrPartitionSealed
+	case PartitionSealing:
+		return lastObj, nil
+	}
+
+	sealingObj := &tables.Object{
+		OwnerID:     partition.OwnerID,
+		CreatorID:   partition.CreatorID,
+		PartitionID: partition.ID,
+		Key:         SealingKey,
+	}
+	if _, err := o.appendObjects(dbTx, lastObj, []*tables.Object{sealingObj}, options); err != nil {
+		return nil, err
+	}
+
+	return sealingObj, nil
+}
+
+// SealPartition seals a partition. The partition is marked as sealing if it is still
+// active and a seal object that commits to the number of objects in the partition and
+// the hash of the partition's head is added as the final object of the partition.
+// If an identity is acting, it must be allowed to write for the owner of the partition.
+func (o *Object) SealPartition(partitionID string, options ...patchain.Option) (*tables.Object, error) {
+	var sealObj *tables.Object
+	dbTx, dbOptions, finish := o.getDBOptions(options)
+	err := o.db.TransactWithDB(dbTx, finish, func(dbTx patchain.DB, commit patchain.CommitFunc, rollback patchain.RollbackFunc) error {
+		partition, err := o.GetLast(&tables.Object{ID: partitionID, QueryParams: patchain.KeyStartsWith(PartitionPrefix)}, dbOptions...)
+		if err != nil {
+			return errors.Wrap(err, "failed to get partition")
+		}
+		if err := o.authorizeOwners([]string{partition.OwnerID}, getActorID(options), dbOptions); err != nil {
+			return err
+		}
+		sealObj, err = o.seal(dbTx, partition, dbOptions)
+		return err
+	})
+	return sealObj, errors.Wrap(err, "failed to seal partition")
+}
+
+// seal adds the seal object to a partition
+func (o *Object) seal(dbTx patchain.DB, partition *tables.Object, options []patchain.Option) (*tables.Object, error) {
+
+	lastObj, err := o.beginSeal(dbTx, partition, options)
+	if err != nil {
+		return nil, err
+	}
+
+	var length int64
+	if err := o.db.Count(&tables.Object{PartitionID: partition.ID}, &length, options...); err != nil {
+		return nil, errors.Wrap(err, "failed to count partition objects")
+	}
+
+	sealInfo, _ := json.Marshal(SealInfo{Length: length, HeadHash: lastObj.Hash})
+	sealObj := &tables.Object{
+		OwnerID:     partition.OwnerID,
+		CreatorID:   partition.CreatorID,
+		PartitionID: partition.ID,
+		Key:         SealKey,
+		Value:       string(sealInfo),
+	}
+	if _, err := o.appendObjects(dbTx, lastObj, []*tables.Object{sealObj}, options); err != nil {
+		return nil, err
+	}
+
+	return sealObj, nil
+}
+
+// GetSealInfo decodes the seal information stored in a seal object
+func GetSealInfo(sealObj *tables.Object) (*SealInfo, error) {
+	if sealObj.Key != SealKey {
+		return nil, fmt.Errorf("not a seal object")
+	}
+	var info SealInfo
+	if err := json.Unmarshal([]byte(sealObj.Value), &info); err != nil {
+		return nil, errors.Wrap(err, "malformed seal object")
+	}
+	return &info, nil
+}
+
+// rollover seals a partition and creates new partitions for its
+// owner if the partition has exceeded the rollover policy.
+func (o *Object) rollover(dbTx patchain.DB, partition *tables.Object, options []patchain.Option) error {
+
+	var numObjects int64
+	if err := o.db.Count(&tables.Object{PartitionID: partition.ID}, &numObjects, options...); err != nil {
+		return errors.Wrap(err, "failed to count partition objects")
+	}
+
+	if !o.rolloverPolicy.ShouldRollover(numObjects, partition.Timestamp) {
+		return nil
+	}
+
+	if _, err := o.seal(dbTx, partition, options); err != nil {
+		return err
+	}
+
+	n := o.rolloverPolicy.NumPartitions
+	if n <= 0 {
+		n = 1
+	}
+
+	// partitions replacing a sealed partition are not limited by the quota of the owner
+	_, err := o.createPartitions(n, partition.OwnerID, partition.CreatorID, false, []patchain.Option{&patchain.UseDBOption{DB: dbTx, Finish: false}})
+	return err
+}
@@ -0,0 +1,178 @@
+package object
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ellcrys/gorm"
+	"github.com/ellcrys/patchain"
+	"github.com/ellcrys/patchain/cockroach"
+	"github.com/ellcrys/patchain/cockroach/tables"
+	"github.com/ellcrys/util"
+	"github.com/pkg/errors"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSeal(t *testing.T) {
+
+	if err := createDb(t); err != nil {
+		t.Fatalf("failed to create test database. %s", err)
+	}
+	defer dropDB(t)
+
+	cdb := cockroach.NewDB()
+	cdb.ConnectionString = conStrWithDB
+	cdb.NoLogging()
+	if err := cdb.Connect(10, 5); err != nil {
+		t.Fatalf("failed to connect to database. %s", err)
+	}
+
+	if err := cdb.CreateTables(); err != nil {
+		t.Fatalf("failed to create tables. %s", err)
+	}
+
+	obj := NewObject(cdb)
+
+	Convey("Seal", t, func() {
+
+		Convey("RolloverPolicy.ShouldRollover", func() {
+			Convey("Should return false if policy has no condition", func() {
+				p := &RolloverPolicy{}
+				So(p.ShouldRollover(1000, 0), ShouldBeFalse)
+			})
+
+			Convey("Should return true if number of objects reaches the max objects", func() {
+				p := &RolloverPolicy{MaxObjects: 10}
+				So(p.ShouldRollover(9, time.Now().UnixNano()), ShouldBeFalse)
+				So(p.ShouldRollover(10, time.Now().UnixNano()), ShouldBeTrue)
+			})
+
+			Convey("Should return true if partition is older than the max age", func() {
+				p := &RolloverPolicy{MaxAge: time.Hour}
+				So(p.ShouldRollover(1, time.Now().UnixNano()), ShouldBeFalse)
+				So(p.ShouldRollover(1, time.Now().Add(-2*time.Hour).UnixNano()), ShouldBeTrue)
+			})
+		})
+
+		Convey(".GetSealInfo", func() {
+			Convey("Should return error if object is not a seal object", func() {
+				_, err := GetSealInfo(&tables.Object{Key: "some_key"})
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "not a seal object")
+			})
+
+			Convey("Should decode seal info", func() {
+				info, err := GetSealInfo(&tables.Object{Key: SealKey, Value: `{"length": 4, "head_hash": "abc"}`})
+				So(err, ShouldBeNil)
+				So(info, ShouldResemble, &SealInfo{Length: 4, HeadHash: "abc"})
+			})
+		})
+
+		Convey(".SealPartition", func() {
+			ownerID := util.RandString(10)
+			partitions, err := obj.CreatePartitions(1, ownerID, ownerID)
+			So(err, ShouldBeNil)
+			err = obj.Put([]*tables.Object{{Key: "key_1", OwnerID: ownerID}})
+			So(err, ShouldBeNil)
+
+			Convey("Should return ErrPermissionDenied if the acting identity cannot write for the owner", func() {
+				err := obj.BeginSealPartition(partitions[0].ID, ActingAs(util.RandString(10)))
+				So(errors.Cause(err), ShouldEqual, ErrPermissionDenied)
+				_, err = obj.SealPartition(partitions[0].ID, ActingAs(util.RandString(10)))
+				So(errors.Cause(err), ShouldEqual, ErrPermissionDenied)
+				state, err := obj.GetPartitionState(partitions[0].ID)
+				So(err, ShouldBeNil)
+				So(state, ShouldEqual, PartitionActive)
+			})
+
+			Convey("Should not accept objects with a key that marks the state of a partition", func() {
+				for _, key := range []string{SealingKey, SealKey, ArchiveKey} {
+					err := obj.Put(&tables.Object{Key: key, OwnerID: ownerID})
+					So(errors.Cause(err), ShouldEqual, ErrReservedKey)
+				}
+				state, err := obj.GetPartitionState(partitions[0].ID)
+				So(err, ShouldBeNil)
+				So(state, ShouldEqual, PartitionActive)
+			})
+
+			Convey("Should begin sealing a partition", func() {
+				err := obj.BeginSealPartition(partitions[0].ID)
+				So(err, ShouldBeNil)
+				state, err := obj.GetPartitionState(partitions[0].ID)
+				So(err, ShouldBeNil)
+				So(state, ShouldEqual, PartitionSealing)
+
+				Convey("Should not accept new objects", func() {
+					err = obj.Put(&tables.Object{Key: "key_2", OwnerID: ownerID})
+					So(err, ShouldNotBeNil)
+					So(err.Error(), ShouldEqual, "failed to put object(s): owner has no active partition")
+				})
+			})
+
+			Convey("Should seal a partition", func() {
+				sealObj, err := obj.SealPartition(partitions[0].ID)
+				So(err, ShouldBeNil)
+				state, err := obj.GetPartitionState(partitions[0].ID)
+				So(err, ShouldBeNil)
+				So(state, ShouldEqual, PartitionSealed)
+
+				Convey("Seal object must commit to the partition's length and head hash", func() {
+					var all []*tables.Object
+					err := cdb.GetAll(&tables.Object{PartitionID: partitions[0].ID, QueryParams: patchain.QueryParams{OrderBy: "timestamp asc"}}, &all)
+					So(err, ShouldBeNil)
+					So(all, ShouldHaveLength, 5)
+					So(all[3].Key, ShouldEqual, SealingKey)
+					So(all[4].Key, ShouldEqual, SealKey)
+					info, err := GetSealInfo(sealObj)
+					So(err, ShouldBeNil)
+					So(info.Length, ShouldEqual, 4)
+					So(info.HeadHash, ShouldEqual, all[3].Hash)
+					So(sealObj.PrevHash, ShouldEqual, all[3].Hash)
+				})
+
+				Convey("Should return error if partition is already sealed", func() {
+					_, err := obj.SealPartition(partitions[0].ID)
+					So(err, ShouldNotBeNil)
+					So(err.Error(), ShouldEqual, "failed to seal partition: partition is sealed")
+				})
+			})
+
+			Reset(func() {
+				clearTable(cdb.GetConn().(*gorm.DB), "objects")
+			})
+		})
+
+		Convey("Automatic rollover", func() {
+			ownerID := util.RandString(10)
+			partitions, err := obj.CreatePartitions(1, ownerID, ownerID)
+			So(err, ShouldBeNil)
+
+			obj.SetRolloverPolicy(&RolloverPolicy{MaxObjects: 4})
+			defer obj.SetRolloverPolicy(nil)
+
+			Convey("Should seal a partition and create a new one when the partition is full", func() {
+				err = obj.Put([]*tables.Object{{Key: "key_1", OwnerID: ownerID}, {Key: "key_2", OwnerID: ownerID}})
+				So(err, ShouldBeNil)
+
+				state, err := obj.GetPartitionState(partitions[0].ID)
+				So(err, ShouldBeNil)
+				So(state, ShouldEqual, PartitionSealed)
+
+				ownerPartitions, err := obj.All(&tables.Object{OwnerID: ownerID, QueryParams: patchain.KeyStartsWith(PartitionPrefix)})
+				So(err, ShouldBeNil)
+				So(ownerPartitions, ShouldHaveLength, 2)
+
+				Convey("New objects must be added to the new partition", func() {
+					o := &tables.Object{Key: "key_3", OwnerID: ownerID}
+					err = obj.Put(o)
+					So(err, ShouldBeNil)
+					So(o.PartitionID, ShouldNotEqual, partitions[0].ID)
+				})
+			})
+
+			Reset(func() {
+				clearTable(cdb.GetConn().(*gorm.DB), "objects")
+			})
+		})
+	})
+}
@@ -0,0 +1,336 @@
+package object
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ellcrys/patchain"
+	"github.com/ellcrys/patchain/cockroach/tables"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrKeyTooLong indicates that a key is too long for its tombstone key to fit the key column
+	ErrKeyTooLong = fmt.Errorf("key is too long to be deleted")
+
+	// ErrInternalKey indicates an attempt to delete a key managed by this package
+	ErrInternalKey = fmt.Errorf("keys starting with $ cannot be deleted")
+)
+
+// maxKeyLen is the size of the key column
+const maxKeyLen = 64
+
+// StateDrift describes a key whose state does not match the state rebuilt from the chain
+type StateDrift struct {
+	Key string `json:"key"`
+
+	// Expected is the state rebuilt from the chain or nil if the key should have no state
+	Expected *tables.ObjectState `json:"expected"`
+
+	// Actual is the state in the state table or nil if the key has no state
+	Actual *tables.ObjectState `json:"actual"`
+}
+
+// SetStateTable enables or disables the maintenance of the state table. When
+// enabled, the latest object of every key is recorded in the state table in
+// the transaction of Put, CreateOnce, Import and RestorePartition.
+func (o *Object) SetStateTable(enabled bool) {
+	o.stateTable = enabled
+}
+
+// stateKey returns the key whose current state is set by an object with a key.
+// Tombstones set the state of the key they delete. It returns false for the
+// other keys starting with $.
+func stateKey(key string) (string, bool) {
+	if strings.HasPrefix(key, TombstonePrefix) {
+		return strings.TrimPrefix(key, TombstonePrefix), true
+	}
+	return key, !strings.HasPrefix(key, "$")
+}
+
+// makeState returns the state set by an object. The value of a stored object
+// that was compressed or offloaded to the blob store is not copied.
+func makeState(obj *tables.Object) *tables.ObjectState {
+	key, _ := stateKey(obj.Key)
+	state := &tables.ObjectState{
+		OwnerID:   obj.OwnerID,
+		Key:       key,
+		ObjectID:  obj.ID,
+		Hash:      obj.Hash,
+		Protected: obj.Protected,
+		Tombstone: strings.HasPrefix(obj.Key, TombstonePrefix),
+		Timestamp: obj.Timestamp,
+	}
+	if state.Tombstone {
+		return state
+	}
+	if obj.Compression != "" || obj.BlobDigest != "" {
+		state.External = true
+	} else {
+		state.Value = obj.Value
+	}
+	return state
+}
+
+// latestStates returns the states set by the most recent objects of the keys
+// of objects, indexed by owner and key. Ref-only objects and objects without
+// an owner do not set a state. Of two objects with the same timestamp, the
+// last one wins.
+func latestStates(objs []*tables.Object) map[string]*tables.ObjectState {
+	states := make(map[string]*tables.ObjectState)
+	for _, obj := range objs {
+		if _, ok := stateKey(obj.Key); !ok || obj.RefOnly || obj.OwnerID == "" {
+			continue
+		}
+		state := makeState(obj)
+		id := state.OwnerID + "/" + state.Key
+		if cur := states[id]; cur == nil || cur.Timestamp <= state.Timestamp {
+			states[id] = state
+		}
+	}
+	return states
+}
+
+// stateColumns returns the columns of a state to update
+func stateColumns(state *tables.ObjectState) map[string]interface{} {
+	return map[string]interface{}{
+		"object_id": state.ObjectID,
+		"hash":      state.Hash,
+		"value":     state.Value,
+		"external":  state.External,
+		"protected": state.Protected,
+		"tombstone": state.Tombstone,
+		"timestamp": state.Timestamp,
+	}
+}
+
+// sameState checks whether two states are equal
+func sameState(a, b *tables.ObjectState) bool {
+	return a.OwnerID == b.OwnerID &&
+		a.Key == b.Key &&
+		a.ObjectID == b.ObjectID &&
+		a.Hash == b.Hash &&
+		a.Value == b.Value &&
+		a.External == b.External &&
+		a.Protected == b.Protected &&
+		a.Tombstone == b.Tombstone &&
+		a.Timestamp == b.Timestamp
+}
+
+// updateState records the objects that are more recent than the current
+// state of their key in the state table. It does nothing if the state table
+// is disabled. The options must include the transaction the objects are written in.
+func (o *Object) updateState(objs []*tables.Object, options []patchain.Option) error {
+
+	if !o.stateTable {
+		return nil
+	}
+
+	states := latestStates(objs)
+	ids := make([]string, 0, len(states))
+	for id := range states {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		state := states[id]
+
+		var cur tables.ObjectState
+		err := o.db.GetLast(&tables.ObjectState{OwnerID: state.OwnerID, Key: state.Key}, &cur, options...)
+		if err != nil {
+			if err != patchain.ErrNotFound {
+				return errors.Wrap(err, "failed to get state")
+			}
+			if err := o.db.Create(state, options...); err != nil {
+				return errors.Wrap(err, "failed to create state")
+			}
+			continue
+		}
+
+		// an older object (e.g of a restored partition) does not replace the state
+		if cur.Timestamp > state.Timestamp {
+			continue
+		}
+
+		if err := o.db.Update(&tables.ObjectState{OwnerID: state.OwnerID, Key: state.Key}, stateColumns(state), options...); err != nil {
+			return errors.Wrap(err, "failed to update state")
+		}
+	}
+
+	return nil
+}
+
+// GetState returns the state of a key of an owner from the state table. The
+// state of a deleted key has Tombstone set. A value not copied to the state
+// table is read from the object. If an acting identity is set, it must be
+// allowed to read the object when it is protected.
+func (o *Object) GetState(ownerID, key string, options ...patchain.Option) (*tables.ObjectState, error) {
+
+	actorID := getActorID(options)
+	options = withoutActor(options)
+
+	var state tables.ObjectState
+	if err := o.db.GetLast(&tables.ObjectState{OwnerID: ownerID, Key: key}, &state, options...); err != nil {
+		return nil, err
+	}
+
+	if err := o.authorizeRead(&tables.Object{OwnerID: ownerID, Protected: state.Protected}, actorID, options); err != nil {
+		return nil, err
+	}
+
+	if state.External {
+		obj, err := o.getLast(&tables.Object{ID: state.ObjectID}, options)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to get object of state")
+		}
+		state.Value = obj.Value
+	}
+
+	return &state, nil
+}
+
+// Delete deletes a key of an owner by putting a tombstone object whose key is
+// the tombstone key of the key (see MakeTombstoneKey). The versions of the key
+// are kept. Deleting the latest object of a key that is protected requires
+// WithOverride. It returns patchain.ErrNotFound if the key has no object or
+// has already been deleted.
+func (o *Object) Delete(ownerID, key string, options ...patchain.Option) (*tables.Object, error) {
+
+	if strings.HasPrefix(key, "$") {
+		return nil, ErrInternalKey
+	}
+
+	tombstoneKey := MakeTombstoneKey(key)
+	if len(tombstoneKey) > maxKeyLen {
+		return nil, ErrKeyTooLong
+	}
+
+	var tombstone *tables.Object
+	dbTx, dbOptions, finish := o.getDBOptions(options)
+	err := o.db.TransactWithDB(dbTx, finish, func(dbTx patchain.DB, commit patchain.CommitFunc, rollback patchain.RollbackFunc) error {
+
+		current, err := o.getLast(&tables.Object{QueryParams: patchain.QueryParams{
+			Expr: patchain.Expr{Expr: "owner_id = ? AND key IN (?) AND ref_only = ?", Args: []interface{}{ownerID, []string{key, tombstoneKey}, false}},
+		}}, dbOptions)
+		if err != nil {
+			return err
+		}
+		if current.Key == tombstoneKey {
+			return patchain.ErrNotFound
+		}
+
+		tombstone = MakeTombstoneObject(ownerID, "", key, current.ID)
+		return o.Put(tombstone, append(append([]patchain.Option{}, options...), &patchain.UseDBOption{DB: dbTx})...)
+	})
+	if err != nil {
+		if err == patchain.ErrNotFound {
+			return nil, err
+		}
+		return nil, errors.Wrap(err, "failed to delete key")
+	}
+
+	return tombstone, nil
+}
+
+// CheckState rebuilds the states of the keys of an owner from the objects of
+// the owner and returns the keys whose state in the state table differs.
+// Objects of archived partitions are not part of the rebuilt states, so a
+// state that refers to an object that is no longer stored, created before a
+// partition of the owner was archived, is assumed to refer to an archived object.
+// Such states are kept unless a more recent object of the key is stored.
+func (o *Object) CheckState(ownerID string, options ...patchain.Option) ([]StateDrift, error) {
+
+	options = withoutActor(options)
+
+	// values are not restored as the state of compressed and offloaded values has none
+	var objs []*tables.Object
+	if err := o.db.GetAll(&tables.Object{OwnerID: ownerID, QueryParams: patchain.QueryParams{OrderBy: "timestamp asc"}}, &objs, options...); err != nil {
+		return nil, errors.Wrap(err, "failed to get objects")
+	}
+
+	expected := make(map[string]*tables.ObjectState)
+	for _, state := range latestStates(objs) {
+		expected[state.Key] = state
+	}
+
+	stored := make(map[string]bool)
+	var lastArchived int64
+	for _, obj := range objs {
+		stored[obj.ID] = true
+		if obj.Key == ArchiveKey && obj.Timestamp > lastArchived {
+			lastArchived = obj.Timestamp
+		}
+	}
+
+	var states []*tables.ObjectState
+	if err := o.db.GetAll(&tables.ObjectState{OwnerID: ownerID}, &states, options...); err != nil {
+		return nil, errors.Wrap(err, "failed to get states")
+	}
+
+	actual := make(map[string]*tables.ObjectState)
+	var keys []string
+	for _, state := range states {
+		actual[state.Key] = state
+		keys = append(keys, state.Key)
+	}
+	for key := range expected {
+		if actual[key] == nil {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var drifts []StateDrift
+	for _, key := range keys {
+		e, a := expected[key], actual[key]
+		if e != nil && a != nil && sameState(e, a) {
+			continue
+		}
+		if a != nil && !stored[a.ObjectID] && a.Timestamp < lastArchived && (e == nil || e.Timestamp <= a.Timestamp) {
+			continue
+		}
+		drifts = append(drifts, StateDrift{Key: key, Expected: e, Actual: a})
+	}
+
+	return drifts, nil
+}
+
+// RebuildState checks the states of the keys of an owner (see CheckState) and
+// replaces the states that drifted with the states rebuilt from the chain.
+// It returns the drifts that were repaired.
+func (o *Object) RebuildState(ownerID string, options ...patchain.Option) ([]StateDrift, error) {
+
+	var drifts []StateDrift
+	dbTx, dbOptions, finish := o.getDBOptions(options)
+	err := o.db.TransactWithDB(dbTx, finish, func(dbTx patchain.DB, commit patchain.CommitFunc, rollback patchain.RollbackFunc) error {
+
+		var err error
+		if drifts, err = o.CheckState(ownerID, dbOptions...); err != nil {
+			return err
+		}
+
+		for _, drift := range drifts {
+			q := &tables.ObjectState{OwnerID: ownerID, Key: drift.Key}
+			switch {
+			case drift.Expected == nil:
+				err = o.db.Delete(q, dbOptions...)
+			case drift.Actual == nil:
+				err = o.db.Create(drift.Expected, dbOptions...)
+			default:
+				err = o.db.Update(q, stateColumns(drift.Expected), dbOptions...)
+			}
+			if err != nil {
+				return errors.Wrapf(err, "failed to repair state of key (%s)", drift.Key)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to rebuild state")
+	}
+
+	return drifts, nil
+}
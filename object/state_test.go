@@ -0,0 +1,216 @@
+package object
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/ellcrys/patchain"
+	"github.com/ellcrys/patchain/blob"
+	"github.com/ellcrys/patchain/cockroach"
+	"github.com/ellcrys/patchain/cockroach/tables"
+	"github.com/ellcrys/util"
+	"github.com/pkg/errors"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestState(t *testing.T) {
+	Convey("State", t, func() {
+
+		Convey(".stateKey", func() {
+			Convey("Should map tombstones to the key they delete and exclude other internal keys", func() {
+				key, ok := stateKey("a")
+				So(ok, ShouldBeTrue)
+				So(key, ShouldEqual, "a")
+				key, ok = stateKey(MakeTombstoneKey("a"))
+				So(ok, ShouldBeTrue)
+				So(key, ShouldEqual, "a")
+				_, ok = stateKey(MakeGrantKey("a"))
+				So(ok, ShouldBeFalse)
+			})
+		})
+
+		Convey(".latestStates", func() {
+			Convey("Should keep the most recent object of every key of every owner", func() {
+				states := latestStates([]*tables.Object{
+					{ID: "1", OwnerID: "owner_1", Key: "a", Value: "v1", Timestamp: 1},
+					{ID: "2", OwnerID: "owner_1", Key: "a", Value: "v2", Timestamp: 3},
+					{ID: "3", OwnerID: "owner_1", Key: "a", Value: "v0", Timestamp: 2},
+					{ID: "4", OwnerID: "owner_2", Key: "a", Value: "v1", Timestamp: 1},
+					{ID: "5", OwnerID: "owner_1", Key: "b", Ref1: "ref", RefOnly: true, Timestamp: 1},
+					{ID: "6", OwnerID: "owner_1", Key: MakeGrantKey("b"), Timestamp: 1},
+				})
+				So(states, ShouldHaveLength, 2)
+				So(states["owner_1/a"].ObjectID, ShouldEqual, "2")
+				So(states["owner_1/a"].Value, ShouldEqual, "v2")
+				So(states["owner_2/a"].ObjectID, ShouldEqual, "4")
+			})
+
+			Convey("Should record tombstones without a value", func() {
+				states := latestStates([]*tables.Object{
+					{ID: "1", OwnerID: "owner_1", Key: "a", Value: "v1", Timestamp: 1},
+					MakeTombstoneObject("owner_1", "owner_1", "a", "1"),
+				})
+				So(states["owner_1/a"].Tombstone, ShouldBeTrue)
+				So(states["owner_1/a"].Value, ShouldBeEmpty)
+			})
+
+			Convey("Should not copy the value of compressed or offloaded objects", func() {
+				states := latestStates([]*tables.Object{
+					{ID: "1", OwnerID: "owner_1", Key: "a", Value: "v1", Compression: CompressionGzip},
+					{ID: "2", OwnerID: "owner_1", Key: "b", BlobDigest: "digest", BlobSize: 10},
+				})
+				So(states["owner_1/a"].External, ShouldBeTrue)
+				So(states["owner_1/a"].Value, ShouldBeEmpty)
+				So(states["owner_1/b"].External, ShouldBeTrue)
+			})
+		})
+
+		Convey(".Delete", func() {
+			o := NewObject(nil)
+
+			Convey("Should return error if the key is internal", func() {
+				_, err := o.Delete("owner_id", MakeGrantKey("a"))
+				So(err, ShouldEqual, ErrInternalKey)
+			})
+
+			Convey("Should return error if the tombstone key does not fit the key column", func() {
+				_, err := o.Delete("owner_id", strings.Repeat("a", 60))
+				So(err, ShouldEqual, ErrKeyTooLong)
+			})
+		})
+	})
+}
+
+func TestObjectState(t *testing.T) {
+
+	if err := createDb(t); err != nil {
+		t.Fatalf("failed to create test database. %s", err)
+	}
+	defer dropDB(t)
+
+	cdb := cockroach.NewDB()
+	cdb.ConnectionString = conStrWithDB
+	cdb.NoLogging()
+	if err := cdb.Connect(10, 5); err != nil {
+		t.Fatalf("failed to connect to database. %s", err)
+	}
+
+	if err := cdb.CreateTables(); err != nil {
+		t.Fatalf("failed to create tables. %s", err)
+	}
+
+	obj := NewObject(cdb)
+	obj.SetStateTable(true)
+
+	Convey("Object state", t, func() {
+		ownerID := util.RandString(10)
+		_, err := obj.CreatePartitions(2, ownerID, ownerID)
+		So(err, ShouldBeNil)
+
+		Convey("Should record the latest object of a key", func() {
+			So(obj.Put(&tables.Object{OwnerID: ownerID, Key: "a", Value: "v1"}), ShouldBeNil)
+			latest := &tables.Object{OwnerID: ownerID, Key: "a", Value: "v2"}
+			So(obj.Put(latest), ShouldBeNil)
+
+			state, err := obj.GetState(ownerID, "a")
+			So(err, ShouldBeNil)
+			So(state.ObjectID, ShouldEqual, latest.ID)
+			So(state.Hash, ShouldEqual, latest.Hash)
+			So(state.Value, ShouldEqual, "v2")
+			So(state.Tombstone, ShouldBeFalse)
+
+			drifts, err := obj.CheckState(ownerID)
+			So(err, ShouldBeNil)
+			So(drifts, ShouldBeEmpty)
+		})
+
+		Convey("Should return patchain.ErrNotFound if a key has no state", func() {
+			_, err := obj.GetState(ownerID, "unknown")
+			So(err, ShouldEqual, patchain.ErrNotFound)
+		})
+
+		Convey("Should record deleted keys as tombstones", func() {
+			current := &tables.Object{OwnerID: ownerID, Key: "b", Value: "v1"}
+			So(obj.Put(current), ShouldBeNil)
+			tombstone, err := obj.Delete(ownerID, "b")
+			So(err, ShouldBeNil)
+			So(tombstone.Ref1, ShouldEqual, current.ID)
+
+			state, err := obj.GetState(ownerID, "b")
+			So(err, ShouldBeNil)
+			So(state.Tombstone, ShouldBeTrue)
+			So(state.ObjectID, ShouldEqual, tombstone.ID)
+
+			_, err = obj.Delete(ownerID, "b")
+			So(err, ShouldEqual, patchain.ErrNotFound)
+
+			drifts, err := obj.CheckState(ownerID)
+			So(err, ShouldBeNil)
+			So(drifts, ShouldBeEmpty)
+		})
+
+		Convey("Should require an override to delete a protected key", func() {
+			So(obj.Put(&tables.Object{OwnerID: ownerID, Key: "c", Value: "v1", Protected: true}), ShouldBeNil)
+			_, err := obj.Delete(ownerID, "c")
+			So(errors.Cause(err), ShouldEqual, ErrProtected)
+			_, err = obj.Delete(ownerID, "c", WithOverride())
+			So(err, ShouldBeNil)
+		})
+
+		Convey("Should read values that were not copied from the object", func() {
+			obj.SetBlobStore(blob.NewMemoryStore(), 10)
+			defer obj.SetBlobStore(nil, 0)
+			value := strings.Repeat("abcdefgh", 10)
+			So(obj.Put(&tables.Object{OwnerID: ownerID, Key: "d", Value: value}), ShouldBeNil)
+
+			state, err := obj.GetState(ownerID, "d")
+			So(err, ShouldBeNil)
+			So(state.External, ShouldBeTrue)
+			So(state.Value, ShouldEqual, value)
+		})
+
+		Convey("Should keep the states of objects of archived partitions", func() {
+			ownerID := util.RandString(10)
+			_, err := obj.CreatePartitions(1, ownerID, ownerID)
+			So(err, ShouldBeNil)
+			archived := &tables.Object{OwnerID: ownerID, Key: "g", Value: "v1"}
+			So(obj.Put(archived), ShouldBeNil)
+			_, err = obj.SealPartition(archived.PartitionID)
+			So(err, ShouldBeNil)
+			var buf bytes.Buffer
+			_, err = obj.ArchivePartition(archived.PartitionID, &buf)
+			So(err, ShouldBeNil)
+
+			drifts, err := obj.CheckState(ownerID)
+			So(err, ShouldBeNil)
+			So(drifts, ShouldBeEmpty)
+			state, err := obj.GetState(ownerID, "g")
+			So(err, ShouldBeNil)
+			So(state.ObjectID, ShouldEqual, archived.ID)
+		})
+
+		Convey("Should detect and repair drift", func() {
+			latest := &tables.Object{OwnerID: ownerID, Key: "e", Value: "v1"}
+			So(obj.Put(latest), ShouldBeNil)
+			So(cdb.Update(&tables.ObjectState{OwnerID: ownerID, Key: "e"}, map[string]interface{}{"value": "stale"}), ShouldBeNil)
+			So(cdb.Create(&tables.ObjectState{OwnerID: ownerID, Key: "f", ObjectID: "unknown"}), ShouldBeNil)
+
+			drifts, err := obj.CheckState(ownerID)
+			So(err, ShouldBeNil)
+			So(drifts, ShouldHaveLength, 2)
+			So(drifts[0].Key, ShouldEqual, "e")
+			So(drifts[0].Expected.Value, ShouldEqual, "v1")
+			So(drifts[0].Actual.Value, ShouldEqual, "stale")
+			So(drifts[1].Key, ShouldEqual, "f")
+			So(drifts[1].Expected, ShouldBeNil)
+
+			repaired, err := obj.RebuildState(ownerID)
+			So(err, ShouldBeNil)
+			So(repaired, ShouldHaveLength, 2)
+			drifts, err = obj.CheckState(ownerID)
+			So(err, ShouldBeNil)
+			So(drifts, ShouldBeEmpty)
+		})
+	})
+}
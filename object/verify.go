@@ -0,0 +1,93 @@
+package object
+
+import (
+	"fmt"
+
+	"github.com/ellcrys/patchain/cockroach/tables"
+	"github.com/ellcrys/util"
+)
+
+// VerifyObjectHash checks that the hash of an object matches its content
+func VerifyObjectHash(obj *tables.Object) error {
+	computed := *obj
+	if computed.ComputeHash().Hash != obj.Hash {
+		return fmt.Errorf("hash mismatch")
+	}
+	return nil
+}
+
+// VerifyChain checks that the objects are correctly chained. The hash of every
+// object must match its content, every object must reference the hash of the
+// object before it and every object with an object after it must have a valid
+// peer hash. Objects must be ordered from the oldest to the most recent.
+func VerifyChain(objs []*tables.Object) error {
+	for i, obj := range objs {
+		if err := VerifyObjectHash(obj); err != nil {
+			return fmt.Errorf("object %d (%s): %s", i, obj.ID, err)
+		}
+		if i == 0 {
+			continue
+		}
+		prevObj := objs[i-1]
+		if obj.PrevHash != prevObj.Hash {
+			return fmt.Errorf("object %d (%s): prev hash does not match the hash of the previous object", i, obj.ID)
+		}
+		peer := *prevObj
+		if peer.ComputePeerHash(obj.Hash).PeerHash != prevObj.PeerHash {
+			return fmt.Errorf("object %d (%s): invalid peer hash", i-1, prevObj.ID)
+		}
+	}
+	return nil
+}
+
+// VerifyPartition checks the integrity of a partition and its objects. The objects
+// must begin with the genesis pair of the partition and must be ordered from the
+// oldest to the most recent.
+func VerifyPartition(partition *tables.Object, objs []*tables.Object) error {
+
+	if err := VerifyObjectHash(partition); err != nil {
+		return fmt.Errorf("partition (%s): %s", partition.ID, err)
+	}
+
+	if len(objs) < 2 {
+		return fmt.Errorf("partition (%s): genesis pair not found", partition.ID)
+	}
+
+	if objs[0].Key != "$genesis/1" || objs[1].Key != "$genesis/2" {
+		return fmt.Errorf("partition (%s): partition must begin with the genesis pair", partition.ID)
+	}
+
+	if objs[0].PrevHash != util.Sha256(PartitionPrefix+partition.Hash) {
+		return fmt.Errorf("partition (%s): genesis object is not chained to the partition", partition.ID)
+	}
+
+	for i, obj := range objs {
+		if obj.PartitionID != partition.ID {
+			return fmt.Errorf("object %d (%s): does not belong to the partition", i, obj.ID)
+		}
+	}
+
+	return VerifyChain(objs)
+}
+
+// MerkleRoot computes the merkle root of a list of hashes. Each level is built by
+// hashing the concatenation of pairs of nodes. The last node of a level with
+// an odd number of nodes is paired with itself.
+func MerkleRoot(hashes []string) string {
+	if len(hashes) == 0 {
+		return ""
+	}
+	level := hashes
+	for len(level) > 1 {
+		var next []string
+		for i := 0; i < len(level); i += 2 {
+			if i+1 < len(level) {
+				next = append(next, util.Sha256(level[i]+level[i+1]))
+			} else {
+				next = append(next, util.Sha256(level[i]+level[i]))
+			}
+		}
+		level = next
+	}
+	return level[0]
+}
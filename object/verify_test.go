@@ -0,0 +1,117 @@
+package object
+
+import (
+	"testing"
+
+	"github.com/ellcrys/patchain/cockroach/tables"
+	"github.com/ellcrys/util"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// makeTestPartition creates a partition and a valid chain of
+// objects that begins with the partition's genesis pair
+func makeTestPartition(numObjs int) (*tables.Object, []*tables.Object) {
+	partition := MakePartitionObject(util.UUID4(), "owner_id", "creator_id")
+	MakeChain(partition)
+	objs := MakeGenesisPair("owner_id", "creator_id", partition.ID, partition.Hash)
+	for i := 0; i < numObjs; i++ {
+		last := objs[len(objs)-1]
+		obj := &tables.Object{OwnerID: "owner_id", PartitionID: partition.ID, Key: util.RandString(5), Value: util.RandString(5), PrevHash: last.Hash}
+		MakeChain(obj)
+		last.ComputePeerHash(obj.Hash)
+		objs = append(objs, obj)
+	}
+	return partition, objs
+}
+
+func TestVerify(t *testing.T) {
+	Convey("Verify", t, func() {
+		Convey(".VerifyObjectHash", func() {
+			Convey("Should return nil if hash matches the object", func() {
+				obj := MakePartitionObject("partition_a", "owner_id", "creator_id").ComputeHash()
+				So(VerifyObjectHash(obj), ShouldBeNil)
+			})
+
+			Convey("Should return error if object was altered", func() {
+				obj := MakePartitionObject("partition_a", "owner_id", "creator_id").ComputeHash()
+				obj.Value = "altered"
+				err := VerifyObjectHash(obj)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "hash mismatch")
+			})
+		})
+
+		Convey(".VerifyChain", func() {
+			_, objs := makeTestPartition(3)
+
+			Convey("Should return nil if objects are correctly chained", func() {
+				So(VerifyChain(objs), ShouldBeNil)
+			})
+
+			Convey("Should return error if an object was altered", func() {
+				objs[3].Value = "altered"
+				err := VerifyChain(objs)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "object 3 ("+objs[3].ID+"): hash mismatch")
+			})
+
+			Convey("Should return error if an object was removed", func() {
+				objs = append(objs[:3], objs[4:]...)
+				err := VerifyChain(objs)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "object 3 ("+objs[3].ID+"): prev hash does not match the hash of the previous object")
+			})
+
+			Convey("Should return error if a peer hash is invalid", func() {
+				objs[2].PeerHash = "invalid"
+				err := VerifyChain(objs)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "object 2 ("+objs[2].ID+"): invalid peer hash")
+			})
+		})
+
+		Convey(".VerifyPartition", func() {
+			partition, objs := makeTestPartition(2)
+
+			Convey("Should return nil if partition is valid", func() {
+				So(VerifyPartition(partition, objs), ShouldBeNil)
+			})
+
+			Convey("Should return error if partition has no genesis pair", func() {
+				err := VerifyPartition(partition, objs[:1])
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "partition ("+partition.ID+"): genesis pair not found")
+			})
+
+			Convey("Should return error if genesis object is not chained to the partition", func() {
+				otherPartition, _ := makeTestPartition(0)
+				_, otherObjs := makeTestPartition(0)
+				err := VerifyPartition(otherPartition, otherObjs)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "partition ("+otherPartition.ID+"): genesis object is not chained to the partition")
+			})
+
+			Convey("Should return error if partition was altered", func() {
+				partition.OwnerID = "another_owner"
+				err := VerifyPartition(partition, objs)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "partition ("+partition.ID+"): hash mismatch")
+			})
+		})
+
+		Convey(".MerkleRoot", func() {
+			Convey("Should return empty string if there are no hashes", func() {
+				So(MerkleRoot(nil), ShouldBeEmpty)
+			})
+
+			Convey("Should return the only hash if one hash is passed", func() {
+				So(MerkleRoot([]string{"a"}), ShouldEqual, "a")
+			})
+
+			Convey("Should compute merkle root", func() {
+				So(MerkleRoot([]string{"a", "b"}), ShouldEqual, util.Sha256("ab"))
+				So(MerkleRoot([]string{"a", "b", "c"}), ShouldEqual, util.Sha256(util.Sha256("ab")+util.Sha256("cc")))
+			})
+		})
+	})
+}
@@ -0,0 +1,165 @@
+// Package outbox relays messages written to the outbox table to an external
+// system. Messages are written in the transaction of a database operation,
+// either by passing object.WithOutbox to Put or by calling Add with a
+// UseDBOption, so they only exist if the operation commits. A relay then sends
+// them to a sink and marks them as sent. Delivery is at-least-once: a message
+// may be sent again if the relay stops after sending it but before marking it.
+package outbox
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ellcrys/patchain"
+	"github.com/ellcrys/patchain/cockroach/tables"
+	logging "github.com/op/go-logging"
+	"github.com/pkg/errors"
+)
+
+// NewMessage creates a message
+func NewMessage(topic, payload string) *tables.OutboxMessage {
+	return (&tables.OutboxMessage{Topic: topic, Payload: payload}).Init()
+}
+
+// Add writes messages to the outbox. Pass a UseDBOption to write
+// them in the transaction of other operations.
+func Add(db patchain.DB, msgs []*tables.OutboxMessage, options ...patchain.Option) error {
+	for _, msg := range msgs {
+		if err := db.Create(msg.Init(), options...); err != nil {
+			return errors.Wrap(err, "failed to add message")
+		}
+	}
+	return nil
+}
+
+// RelayConfig configures a relay
+type RelayConfig struct {
+
+	// PollInterval is how often pending messages are polled. Defaults to 1 second.
+	PollInterval time.Duration
+
+	// BatchSize is the maximum number of messages sent per poll. Defaults to 100.
+	BatchSize int
+
+	// MaxAttempts is the number of failed attempts after which a
+	// message is abandoned. A zero value means there is no limit.
+	MaxAttempts int
+
+	// InitialBackoff is the delay before the first retry of a failed message.
+	// The delay doubles after every attempt. Defaults to 1 second.
+	InitialBackoff time.Duration
+
+	// MaxBackoff is the maximum delay between two attempts. Defaults to 10 minutes.
+	MaxBackoff time.Duration
+}
+
+// Relay sends the pending messages of the outbox to a sink
+type Relay struct {
+	db   patchain.DB
+	sink Sink
+	cfg  RelayConfig
+	log  *logging.Logger
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewRelay creates a relay
+func NewRelay(db patchain.DB, sink Sink, cfg RelayConfig) *Relay {
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = time.Second
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 100
+	}
+	if cfg.InitialBackoff <= 0 {
+		cfg.InitialBackoff = time.Second
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = 10 * time.Minute
+	}
+	r := &Relay{db: db, sink: sink, cfg: cfg}
+	r.log, _ = logging.GetLogger("patchain/outbox")
+	return r
+}
+
+// Start starts relaying pending messages at every poll interval. It does not block.
+func (r *Relay) Start() {
+	r.stop = make(chan struct{})
+	r.wg.Add(1)
+	go func(stop chan struct{}) {
+		defer r.wg.Done()
+		ticker := time.NewTicker(r.cfg.PollInterval)
+		defer ticker.Stop()
+		for {
+			if _, err := r.RelayPending(); err != nil {
+				r.log.Errorf("failed to relay messages: %s", err)
+			}
+			select {
+			case <-ticker.C:
+			case <-stop:
+				return
+			}
+		}
+	}(r.stop)
+}
+
+// Stop stops the relay and waits for it to finish
+func (r *Relay) Stop() {
+	if r.stop != nil {
+		close(r.stop)
+		r.stop = nil
+		r.wg.Wait()
+	}
+}
+
+// backoff returns the delay before the next attempt of a
+// message that has failed the given number of times
+func (r *Relay) backoff(attempts int) time.Duration {
+	delay := r.cfg.InitialBackoff
+	for i := 1; i < attempts && delay < r.cfg.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > r.cfg.MaxBackoff {
+		delay = r.cfg.MaxBackoff
+	}
+	return delay
+}
+
+// RelayPending sends a batch of pending messages that are due, oldest
+// first, and returns the number of messages accepted by the sink
+func (r *Relay) RelayPending() (int, error) {
+
+	expr := patchain.Expr{Expr: "sent_at = 0 AND next_attempt_at <= ?", Args: []interface{}{time.Now().UnixNano()}}
+	if r.cfg.MaxAttempts > 0 {
+		expr.Expr += " AND attempts < ?"
+		expr.Args = append(expr.Args, r.cfg.MaxAttempts)
+	}
+
+	var msgs []*tables.OutboxMessage
+	if err := r.db.GetAll(&tables.OutboxMessage{QueryParams: patchain.QueryParams{
+		Expr:    expr,
+		OrderBy: "timestamp asc",
+		Limit:   r.cfg.BatchSize,
+	}}, &msgs); err != nil {
+		return 0, errors.Wrap(err, "failed to get pending messages")
+	}
+
+	var sent int
+	for _, msg := range msgs {
+		now := time.Now()
+		values := map[string]interface{}{"attempts": msg.Attempts + 1}
+		if err := r.sink.Send(msg); err != nil {
+			values["next_attempt_at"] = now.Add(r.backoff(msg.Attempts + 1)).UnixNano()
+			values["last_error"] = err.Error()
+		} else {
+			values["sent_at"] = now.UnixNano()
+			values["last_error"] = ""
+			sent++
+		}
+		if err := r.db.Update(&tables.OutboxMessage{ID: msg.ID}, values); err != nil {
+			return sent, errors.Wrap(err, "failed to update message")
+		}
+	}
+
+	return sent, nil
+}
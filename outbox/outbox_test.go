@@ -0,0 +1,221 @@
+package outbox
+
+import (
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ellcrys/patchain"
+	"github.com/ellcrys/patchain/cockroach"
+	"github.com/ellcrys/patchain/cockroach/tables"
+	"github.com/ellcrys/patchain/object"
+	"github.com/ellcrys/util"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+var testDB *sql.DB
+
+var dbName = "test_" + strings.ToLower(util.RandString(5))
+var conStr = "postgresql://root@localhost:26257?sslmode=disable"
+var conStrWithDB = "postgresql://root@localhost:26257/" + dbName + "?sslmode=disable"
+
+func init() {
+	var err error
+	testDB, err = sql.Open("postgres", conStr)
+	if err != nil {
+		panic(fmt.Errorf("failed to connect to database: %s", err))
+	}
+}
+
+func createDb(t *testing.T) error {
+	_, err := testDB.Query(fmt.Sprintf("CREATE DATABASE %s;", dbName))
+	return err
+}
+
+func dropDB(t *testing.T) error {
+	_, err := testDB.Query(fmt.Sprintf("DROP DATABASE %s;", dbName))
+	return err
+}
+
+// failingSink fails the first n messages of a topic
+type failingSink struct {
+	topic    string
+	failures int
+	sent     []*tables.OutboxMessage
+}
+
+func (s *failingSink) Send(msg *tables.OutboxMessage) error {
+	if msg.Topic == s.topic && s.failures > 0 {
+		s.failures--
+		return fmt.Errorf("sink failed")
+	}
+	s.sent = append(s.sent, msg)
+	return nil
+}
+
+func TestSinks(t *testing.T) {
+	Convey("Sinks", t, func() {
+		msg := NewMessage("orders", `{"id":1}`)
+
+		Convey("ChanSink", func() {
+			Convey("Should fail if the channel is full", func() {
+				s := NewChanSink(1)
+				So(s.Send(msg), ShouldBeNil)
+				So(s.Send(msg), ShouldNotBeNil)
+				So(<-s.C, ShouldEqual, msg)
+			})
+		})
+
+		Convey("FileSink", func() {
+			Convey("Should append messages as JSON lines", func() {
+				dir, err := ioutil.TempDir("", "outbox")
+				So(err, ShouldBeNil)
+				defer os.RemoveAll(dir)
+				path := filepath.Join(dir, "outbox.jsonl")
+
+				s, err := NewFileSink(path)
+				So(err, ShouldBeNil)
+				So(s.Send(msg), ShouldBeNil)
+				So(s.Send(msg), ShouldBeNil)
+				So(s.Close(), ShouldBeNil)
+
+				bs, err := ioutil.ReadFile(path)
+				So(err, ShouldBeNil)
+				lines := strings.Split(strings.TrimSpace(string(bs)), "\n")
+				So(lines, ShouldHaveLength, 2)
+				So(lines[0], ShouldContainSubstring, `"topic":"orders"`)
+			})
+		})
+
+		Convey("HTTPSink", func() {
+			var received []string
+			status := http.StatusOK
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				received = append(received, r.Header.Get("X-Outbox-Message-Id"))
+				w.WriteHeader(status)
+			}))
+			defer server.Close()
+			s := NewHTTPSink(server.URL, time.Second)
+
+			Convey("Should post the message", func() {
+				So(s.Send(msg), ShouldBeNil)
+				So(received, ShouldResemble, []string{msg.ID})
+			})
+
+			Convey("Should fail if the endpoint does not return a 2xx response", func() {
+				status = http.StatusServiceUnavailable
+				err := s.Send(msg)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "endpoint returned 503")
+			})
+		})
+	})
+}
+
+func TestRelay(t *testing.T) {
+
+	if err := createDb(t); err != nil {
+		t.Fatalf("failed to create test database. %s", err)
+	}
+	defer dropDB(t)
+
+	cdb := cockroach.NewDB()
+	cdb.ConnectionString = conStrWithDB
+	cdb.NoLogging()
+	if err := cdb.Connect(10, 5); err != nil {
+		t.Fatalf("failed to connect to database. %s", err)
+	}
+
+	if err := cdb.CreateTables(); err != nil {
+		t.Fatalf("failed to create tables. %s", err)
+	}
+
+	obj := object.NewObject(cdb)
+
+	Convey("Outbox", t, func() {
+		ownerID := util.RandString(10)
+		topic := util.RandString(10)
+
+		countMessages := func() int64 {
+			var count int64
+			So(cdb.Count(&tables.OutboxMessage{Topic: topic}, &count), ShouldBeNil)
+			return count
+		}
+
+		Convey("Should write messages in the transaction of Put", func() {
+			_, err := obj.CreatePartitions(1, ownerID, ownerID)
+			So(err, ShouldBeNil)
+			o := &tables.Object{OwnerID: ownerID, Key: "orders/1"}
+			msg := NewMessage(topic, "created")
+			So(obj.Put(o, object.WithOutbox(msg)), ShouldBeNil)
+			So(countMessages(), ShouldEqual, 1)
+
+			var stored tables.OutboxMessage
+			So(cdb.GetLast(&tables.OutboxMessage{ID: msg.ID}, &stored), ShouldBeNil)
+			So(stored.ObjectID, ShouldEqual, o.ID)
+		})
+
+		Convey("Should not write messages if Put fails", func() {
+			err := obj.Put(&tables.Object{OwnerID: ownerID, Key: "orders/1"}, object.WithOutbox(NewMessage(topic, "created")))
+			So(err, ShouldNotBeNil)
+			So(countMessages(), ShouldEqual, 0)
+		})
+
+		Convey("Should not write messages if an external transaction is rolled back", func() {
+			dbTx := cdb.Begin()
+			So(Add(cdb, []*tables.OutboxMessage{NewMessage(topic, "a")}, &patchain.UseDBOption{DB: dbTx}), ShouldBeNil)
+			So(dbTx.Rollback(), ShouldBeNil)
+			So(countMessages(), ShouldEqual, 0)
+		})
+
+		Convey("Should relay messages and mark them as sent", func() {
+			So(Add(cdb, []*tables.OutboxMessage{NewMessage(topic, "a"), NewMessage(topic, "b")}), ShouldBeNil)
+			sink := NewChanSink(10)
+			r := NewRelay(cdb, sink, RelayConfig{})
+			_, err := r.RelayPending()
+			So(err, ShouldBeNil)
+
+			var payloads []string
+			for len(sink.C) > 0 {
+				if msg := <-sink.C; msg.Topic == topic {
+					payloads = append(payloads, msg.Payload)
+				}
+			}
+			So(payloads, ShouldResemble, []string{"a", "b"})
+
+			var pending int64
+			So(cdb.Count(&tables.OutboxMessage{Topic: topic, QueryParams: patchain.QueryParams{
+				Expr: patchain.Expr{Expr: "topic = ? AND sent_at = 0", Args: []interface{}{topic}},
+			}}, &pending), ShouldBeNil)
+			So(pending, ShouldEqual, 0)
+		})
+
+		Convey("Should retry messages the sink failed to send", func() {
+			msg := NewMessage(topic, "a")
+			So(Add(cdb, []*tables.OutboxMessage{msg}), ShouldBeNil)
+			sink := &failingSink{topic: topic, failures: 1}
+			r := NewRelay(cdb, sink, RelayConfig{PollInterval: 20 * time.Millisecond, InitialBackoff: 10 * time.Millisecond})
+			r.Start()
+			defer r.Stop()
+
+			deadline := time.Now().Add(5 * time.Second)
+			var stored tables.OutboxMessage
+			for time.Now().Before(deadline) {
+				So(cdb.GetLast(&tables.OutboxMessage{ID: msg.ID}, &stored), ShouldBeNil)
+				if stored.SentAt != 0 {
+					break
+				}
+				time.Sleep(20 * time.Millisecond)
+			}
+			So(stored.SentAt, ShouldNotEqual, 0)
+			So(stored.Attempts, ShouldEqual, 2)
+		})
+	})
+}
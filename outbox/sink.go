@@ -0,0 +1,117 @@
+package outbox
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ellcrys/patchain/cockroach/tables"
+)
+
+// Sink receives the messages relayed from the outbox. A message is marked as
+// sent once Send returns nil. Send is called from a single goroutine.
+type Sink interface {
+	Send(msg *tables.OutboxMessage) error
+}
+
+// ChanSink sends messages to a buffered channel
+type ChanSink struct {
+	C chan *tables.OutboxMessage
+}
+
+// NewChanSink creates a channel sink with the given buffer size
+func NewChanSink(size int) *ChanSink {
+	return &ChanSink{C: make(chan *tables.OutboxMessage, size)}
+}
+
+// Send sends a message to the channel. It fails if the
+// channel is full so that the message is retried later.
+func (s *ChanSink) Send(msg *tables.OutboxMessage) error {
+	select {
+	case s.C <- msg:
+		return nil
+	default:
+		return fmt.Errorf("channel is full")
+	}
+}
+
+// FileSink appends messages as JSON lines to a file
+type FileSink struct {
+	sync.Mutex
+	f *os.File
+}
+
+// NewFileSink creates a file sink. The file is created if it does not exist.
+func NewFileSink(path string) (*FileSink, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &FileSink{f: f}, nil
+}
+
+// Send appends a message to the file and flushes it to disk
+func (s *FileSink) Send(msg *tables.OutboxMessage) error {
+	s.Lock()
+	defer s.Unlock()
+	bs, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	if _, err := s.f.Write(append(bs, '\n')); err != nil {
+		return err
+	}
+	return s.f.Sync()
+}
+
+// Close closes the file
+func (s *FileSink) Close() error {
+	return s.f.Close()
+}
+
+// HTTPSink posts messages as JSON to a URL. The message is
+// sent if the endpoint returns a 2xx response.
+type HTTPSink struct {
+	URL    string
+	Client *http.Client
+}
+
+// NewHTTPSink creates an HTTP sink
+func NewHTTPSink(url string, timeout time.Duration) *HTTPSink {
+	return &HTTPSink{URL: url, Client: &http.Client{Timeout: timeout}}
+}
+
+// Send posts a message to the URL
+func (s *HTTPSink) Send(msg *tables.OutboxMessage) error {
+
+	bs, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", s.URL, bytes.NewReader(bs))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Outbox-Topic", msg.Topic)
+	req.Header.Set("X-Outbox-Message-Id", msg.ID)
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("endpoint returned %d", resp.StatusCode)
+	}
+	return nil
+}
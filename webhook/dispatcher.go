@@ -0,0 +1,412 @@
+package webhook
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ellcrys/patchain"
+	"github.com/ellcrys/patchain/cockroach/tables"
+	"github.com/ellcrys/patchain/object"
+	logging "github.com/op/go-logging"
+	"github.com/pkg/errors"
+)
+
+// Config configures a dispatcher
+type Config struct {
+
+	// Name identifies the change feed cursor of the dispatcher. Dispatchers
+	// sharing a name resume from the same cursor. Defaults to "webhooks".
+	Name string
+
+	// PollInterval is how often the change feed and pending deliveries
+	// are polled. Defaults to 1 second.
+	PollInterval time.Duration
+
+	// BatchSize is the maximum number of deliveries sent per poll. Defaults to 100.
+	BatchSize int
+
+	// MaxAttempts is the number of failed attempts after which a
+	// delivery is abandoned. Defaults to 10.
+	MaxAttempts int
+
+	// InitialBackoff is the delay before the first retry of a failed delivery.
+	// The delay doubles after every attempt. Defaults to 1 second.
+	InitialBackoff time.Duration
+
+	// MaxBackoff is the maximum delay between two attempts. Defaults to 1 hour.
+	MaxBackoff time.Duration
+
+	// Timeout is the timeout of every request. Defaults to 10 seconds.
+	Timeout time.Duration
+
+	// DisableNotifications forces the change feed to poll even if
+	// the database supports change notifications
+	DisableNotifications bool
+}
+
+// Dispatcher turns the objects added to the chain into events and
+// delivers them to the registered endpoints
+type Dispatcher struct {
+	db         patchain.DB
+	o          *object.Object
+	cfg        Config
+	log        *logging.Logger
+	httpClient *http.Client
+	partitions map[string]*tables.Object
+	stop       chan struct{}
+	wg         sync.WaitGroup
+}
+
+// NewDispatcher creates a dispatcher. The object handler must use the same database.
+func NewDispatcher(db patchain.DB, o *object.Object, cfg Config) *Dispatcher {
+	if cfg.Name == "" {
+		cfg.Name = "webhooks"
+	}
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = time.Second
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 100
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = 10
+	}
+	if cfg.InitialBackoff <= 0 {
+		cfg.InitialBackoff = time.Second
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = time.Hour
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+	d := &Dispatcher{
+		db:         db,
+		o:          o,
+		cfg:        cfg,
+		httpClient: &http.Client{Timeout: cfg.Timeout},
+		partitions: make(map[string]*tables.Object),
+	}
+	d.log, _ = logging.GetLogger("patchain/webhook")
+	return d
+}
+
+// Start starts reading the change feed and delivering events. It does not block.
+// When the dispatcher starts for the first time, the feed starts at the head of
+// the existing partitions.
+func (d *Dispatcher) Start() {
+	d.stop = make(chan struct{})
+	d.wg.Add(2)
+	go d.runFeed(d.stop)
+	go d.runDeliveries(d.stop)
+}
+
+// Stop stops the dispatcher and waits for it to finish
+func (d *Dispatcher) Stop() {
+	if d.stop != nil {
+		close(d.stop)
+		d.stop = nil
+		d.wg.Wait()
+	}
+}
+
+// wait waits for the poll interval. It returns false if the dispatcher was stopped.
+func (d *Dispatcher) wait(stop chan struct{}) bool {
+	select {
+	case <-stop:
+		return false
+	case <-time.After(d.cfg.PollInterval):
+		return true
+	}
+}
+
+// runFeed reads the change feed from the saved cursor and restarts
+// it whenever it stops because an event could not be queued
+func (d *Dispatcher) runFeed(stop chan struct{}) {
+	defer d.wg.Done()
+	for {
+		if err := d.readFeed(stop); err != nil {
+			d.log.Errorf("change feed stopped: %s", err)
+		}
+		if !d.wait(stop) {
+			return
+		}
+	}
+}
+
+// readFeed runs the change feed until the dispatcher is stopped
+// or an event cannot be queued
+func (d *Dispatcher) readFeed(stop chan struct{}) error {
+
+	cursor, err := d.loadCursor()
+	if err != nil {
+		return err
+	}
+
+	feed, err := d.o.NewFeed(object.FeedConfig{
+		Cursor:               cursor,
+		StartAtEnd:           cursor == nil,
+		PollInterval:         d.cfg.PollInterval,
+		DisableNotifications: d.cfg.DisableNotifications,
+	})
+	if err != nil {
+		return err
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-feed.Ready():
+			// save the starting position so that objects added while
+			// the dispatcher is stopped are not skipped on restart
+			if cursor == nil {
+				if err := d.createCursor(feed.Cursor()); err != nil {
+					d.log.Errorf("failed to save initial cursor: %s", err)
+				}
+			}
+		case <-done:
+			return
+		case <-stop:
+		}
+		select {
+		case <-stop:
+			feed.Stop()
+		case <-done:
+		}
+	}()
+
+	return feed.Run(func(ev *object.FeedEvent) error {
+		return d.handleEvent(feed, ev)
+	})
+}
+
+// loadCursor loads the saved cursor of the dispatcher's feed.
+// It returns nil if no cursor has been saved.
+func (d *Dispatcher) loadCursor() (*object.FeedCursor, error) {
+	var saved tables.FeedCursor
+	if err := d.db.GetLast(&tables.FeedCursor{Name: d.cfg.Name}, &saved); err != nil {
+		if err == patchain.ErrNotFound {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "failed to load cursor")
+	}
+	return object.DecodeFeedCursor(saved.Cursor)
+}
+
+// saveCursor saves the cursor of the dispatcher's feed
+func (d *Dispatcher) saveCursor(cursor *object.FeedCursor, options ...patchain.Option) error {
+	if err := d.db.Delete(&tables.FeedCursor{Name: d.cfg.Name}, options...); err != nil {
+		return errors.Wrap(err, "failed to delete cursor")
+	}
+	saved := &tables.FeedCursor{Name: d.cfg.Name, Cursor: cursor.Encode(), Timestamp: time.Now().UnixNano()}
+	if err := d.db.Create(saved, options...); err != nil {
+		return errors.Wrap(err, "failed to save cursor")
+	}
+	return nil
+}
+
+// createCursor saves the cursor of the dispatcher's feed
+// unless a cursor has already been saved
+func (d *Dispatcher) createCursor(cursor *object.FeedCursor) error {
+	var count int64
+	if err := d.db.Count(&tables.FeedCursor{Name: d.cfg.Name}, &count); err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+	return d.db.Create(&tables.FeedCursor{Name: d.cfg.Name, Cursor: cursor.Encode(), Timestamp: time.Now().UnixNano()})
+}
+
+// getPartition returns a partition
+func (d *Dispatcher) getPartition(partitionID string) (*tables.Object, error) {
+	if p := d.partitions[partitionID]; p != nil {
+		return p, nil
+	}
+	p, err := d.o.GetLast(&tables.Object{ID: partitionID, QueryParams: patchain.KeyStartsWith(object.PartitionPrefix)})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get partition")
+	}
+	d.partitions[partitionID] = p
+	return p, nil
+}
+
+// toPayloads returns the payloads of the events caused by an object added to the chain
+func (d *Dispatcher) toPayloads(ev *object.FeedEvent) ([]*Payload, error) {
+
+	partition, err := d.getPartition(ev.PartitionID)
+	if err != nil {
+		return nil, err
+	}
+
+	obj := ev.Object
+	newPayload := func(event string, o *tables.Object) *Payload {
+		return &Payload{
+			Event:       event,
+			OwnerID:     obj.OwnerID,
+			PartitionID: ev.PartitionID,
+			Object:      o,
+			Proof: &ProofInfo{
+				PartitionID:   partition.ID,
+				PartitionHash: partition.Hash,
+				Position:      ev.Position,
+				PrevHash:      obj.PrevHash,
+				Hash:          obj.Hash,
+			},
+		}
+	}
+
+	if err := object.VerifyObjectHash(obj); err != nil {
+		p := newPayload(EventVerificationFailed, obj)
+		p.Error = err.Error()
+		return []*Payload{p}, nil
+	}
+
+	switch {
+	case ev.Position == 0:
+		return []*Payload{newPayload(EventPartitionCreated, partition)}, nil
+	case obj.Key == object.SealKey:
+		return []*Payload{newPayload(EventPartitionSealed, obj)}, nil
+	case strings.HasPrefix(obj.Key, "$genesis/") || obj.Key == object.SealingKey || obj.Key == object.ArchiveKey:
+		return nil, nil
+	default:
+		return []*Payload{newPayload(EventObjectCreated, obj)}, nil
+	}
+}
+
+// handleEvent queues the events caused by an object and saves the
+// position of the feed after the object in a single transaction
+func (d *Dispatcher) handleEvent(feed *object.Feed, ev *object.FeedEvent) error {
+
+	payloads, err := d.toPayloads(ev)
+	if err != nil {
+		return err
+	}
+
+	return d.db.Transact(func(dbTx patchain.DB, commit patchain.CommitFunc, rollback patchain.RollbackFunc) error {
+		dbOptions := []patchain.Option{&patchain.UseDBOption{DB: dbTx}}
+		for _, p := range payloads {
+			if err := d.Enqueue(p, dbOptions...); err != nil {
+				return err
+			}
+		}
+		return d.saveCursor(feed.CursorAfter(ev), dbOptions...)
+	})
+}
+
+// runDeliveries sends the pending deliveries at every poll interval
+func (d *Dispatcher) runDeliveries(stop chan struct{}) {
+	defer d.wg.Done()
+	for {
+		if _, err := d.DeliverPending(); err != nil {
+			d.log.Errorf("failed to deliver events: %s", err)
+		}
+		if !d.wait(stop) {
+			return
+		}
+	}
+}
+
+// backoff returns the delay before the next attempt of a
+// delivery that has failed the given number of times
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	delay := d.cfg.InitialBackoff
+	for i := 1; i < attempts && delay < d.cfg.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > d.cfg.MaxBackoff {
+		delay = d.cfg.MaxBackoff
+	}
+	return delay
+}
+
+// DeliverPending sends a batch of deliveries that are due and
+// returns the number of deliveries acknowledged by their endpoint
+func (d *Dispatcher) DeliverPending() (int, error) {
+
+	var deliveries []*tables.WebhookDelivery
+	if err := d.db.GetAll(&tables.WebhookDelivery{QueryParams: patchain.QueryParams{
+		Expr:    patchain.Expr{Expr: "delivered_at = 0 AND attempts < ? AND next_attempt_at <= ?", Args: []interface{}{d.cfg.MaxAttempts, time.Now().UnixNano()}},
+		OrderBy: "next_attempt_at asc",
+		Limit:   d.cfg.BatchSize,
+	}}, &deliveries); err != nil {
+		return 0, errors.Wrap(err, "failed to get pending deliveries")
+	}
+
+	hooks := make(map[string]*tables.Webhook)
+	var delivered int
+	for _, delivery := range deliveries {
+
+		hook := hooks[delivery.WebhookID]
+		if hook == nil {
+			hook = &tables.Webhook{}
+			if err := d.db.GetLast(&tables.Webhook{ID: delivery.WebhookID}, hook); err != nil {
+				if err != patchain.ErrNotFound {
+					return delivered, errors.Wrap(err, "failed to get webhook")
+				}
+				hook = nil
+			}
+			hooks[delivery.WebhookID] = hook
+		}
+
+		var sendErr error
+		if hook == nil {
+			sendErr = fmt.Errorf("webhook not found")
+		} else {
+			sendErr = d.send(hook, delivery)
+		}
+
+		now := time.Now()
+		values := map[string]interface{}{"attempts": delivery.Attempts + 1}
+		if sendErr == nil {
+			values["delivered_at"] = now.UnixNano()
+			values["last_error"] = ""
+			delivered++
+		} else {
+			values["next_attempt_at"] = now.Add(d.backoff(delivery.Attempts + 1)).UnixNano()
+			values["last_error"] = sendErr.Error()
+			if hook == nil {
+				values["attempts"] = d.cfg.MaxAttempts
+			}
+		}
+
+		if err := d.db.Update(&tables.WebhookDelivery{ID: delivery.ID}, values); err != nil {
+			return delivered, errors.Wrap(err, "failed to update delivery")
+		}
+	}
+
+	return delivered, nil
+}
+
+// send posts a delivery to its endpoint
+func (d *Dispatcher) send(hook *tables.Webhook, delivery *tables.WebhookDelivery) error {
+
+	body := []byte(delivery.Payload)
+	req, err := http.NewRequest("POST", hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Patchain-Event", delivery.Event)
+	req.Header.Set("X-Patchain-Delivery", delivery.ID)
+	req.Header.Set(SignatureHeader, Sign(hook.Secret, body))
+
+	resp, err := d.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("endpoint returned %d", resp.StatusCode)
+	}
+	return nil
+}
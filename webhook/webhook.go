@@ -0,0 +1,220 @@
+// Package webhook delivers chain events to registered HTTP endpoints. Events are
+// written to a delivery table (an outbox) in the same transaction that advances
+// the dispatcher's change feed cursor, so events of committed objects are not
+// lost if the process stops. Deliveries are retried with exponential backoff
+// until the endpoint acknowledges them with a 2xx response. Delivery is
+// at-least-once: endpoints must be prepared to receive an event more than once.
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ellcrys/patchain"
+	"github.com/ellcrys/patchain/cockroach/tables"
+	"github.com/ellcrys/util"
+	"github.com/pkg/errors"
+)
+
+// Event types
+const (
+	// EventObjectCreated is sent when an object is added to a partition
+	EventObjectCreated = "object.created"
+
+	// EventPartitionCreated is sent when the genesis pair of a partition is added
+	EventPartitionCreated = "partition.created"
+
+	// EventPartitionSealed is sent when the seal of a partition is added
+	EventPartitionSealed = "partition.sealed"
+
+	// EventVerificationFailed is sent when an object fails verification
+	EventVerificationFailed = "verification.failed"
+)
+
+// EventTypes lists the event types an endpoint can subscribe to
+var EventTypes = []string{EventObjectCreated, EventPartitionCreated, EventPartitionSealed, EventVerificationFailed}
+
+// SignatureHeader is the header holding the signature of a payload
+var SignatureHeader = "X-Patchain-Signature"
+
+// ProofInfo locates an object in the chain of its partition. A full
+// proof of the object can be fetched with object.GetProof.
+type ProofInfo struct {
+	PartitionID   string `json:"partition_id"`
+	PartitionHash string `json:"partition_hash"`
+
+	// Position is the position of the object in its partition.
+	// The first genesis object is at position 0.
+	Position int64  `json:"position"`
+	PrevHash string `json:"prev_hash"`
+	Hash     string `json:"hash"`
+}
+
+// Payload is the JSON body sent to endpoints
+type Payload struct {
+	Event       string         `json:"event"`
+	OwnerID     string         `json:"owner_id,omitempty"`
+	PartitionID string         `json:"partition_id,omitempty"`
+	Object      *tables.Object `json:"object,omitempty"`
+	Proof       *ProofInfo     `json:"proof,omitempty"`
+
+	// Error describes why an object failed verification
+	Error     string `json:"error,omitempty"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// Sign returns the signature of a payload. It is the hex encoded
+// HMAC-SHA256 of the body prefixed with "sha256=".
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifySignature checks the signature of a payload received by an endpoint
+func VerifySignature(secret string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, body)), []byte(signature))
+}
+
+// newSecret creates a random signing secret
+func newSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Register registers an endpoint. A signing secret is generated if the
+// webhook does not have one. Only events of the webhook's owner are sent
+// to it or events of all owners if it has no owner.
+func (d *Dispatcher) Register(hook *tables.Webhook) error {
+
+	u, err := url.Parse(hook.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid url")
+	}
+
+	for _, event := range splitEvents(hook.Events) {
+		if !util.InStringSlice(EventTypes, event) {
+			return fmt.Errorf("unknown event type: %s", event)
+		}
+	}
+
+	if hook.Secret == "" {
+		if hook.Secret, err = newSecret(); err != nil {
+			return errors.Wrap(err, "failed to create secret")
+		}
+	}
+
+	if hook.ID == "" {
+		hook.ID = util.UUID4()
+	}
+	hook.Timestamp = time.Now().UnixNano()
+
+	return d.db.Create(hook)
+}
+
+// Unregister removes an endpoint and its pending deliveries
+func (d *Dispatcher) Unregister(webhookID string) error {
+	if webhookID == "" {
+		return fmt.Errorf("webhook id is required")
+	}
+	return d.db.Transact(func(dbTx patchain.DB, commit patchain.CommitFunc, rollback patchain.RollbackFunc) error {
+		dbOptions := []patchain.Option{&patchain.UseDBOption{DB: dbTx}}
+		if err := d.db.Delete(&tables.WebhookDelivery{WebhookID: webhookID}, dbOptions...); err != nil {
+			return errors.Wrap(err, "failed to delete deliveries")
+		}
+		if err := d.db.Delete(&tables.Webhook{ID: webhookID}, dbOptions...); err != nil {
+			return errors.Wrap(err, "failed to delete webhook")
+		}
+		return nil
+	})
+}
+
+// List returns the endpoints registered for an owner
+func (d *Dispatcher) List(ownerID string) ([]*tables.Webhook, error) {
+	var hooks []*tables.Webhook
+	err := d.db.GetAll(&tables.Webhook{OwnerID: ownerID, QueryParams: patchain.QueryParams{OrderBy: "timestamp asc"}}, &hooks)
+	return hooks, err
+}
+
+// splitEvents splits a comma separated list of event types
+func splitEvents(events string) []string {
+	var types []string
+	for _, event := range strings.Split(events, ",") {
+		if event = strings.TrimSpace(event); event != "" {
+			types = append(types, event)
+		}
+	}
+	return types
+}
+
+// matches checks whether a payload should be sent to an endpoint
+func matches(hook *tables.Webhook, p *Payload) bool {
+
+	if hook.OwnerID != "" && hook.OwnerID != p.OwnerID {
+		return false
+	}
+
+	if events := splitEvents(hook.Events); len(events) > 0 && !util.InStringSlice(events, p.Event) {
+		return false
+	}
+
+	if hook.KeyPrefix != "" && (p.Event == EventObjectCreated || p.Event == EventVerificationFailed) {
+		return p.Object != nil && strings.HasPrefix(p.Object.Key, hook.KeyPrefix)
+	}
+
+	return true
+}
+
+// Enqueue queues a payload for delivery to every matching endpoint. Pass a
+// UseDBOption to queue the deliveries in the transaction of an operation.
+func (d *Dispatcher) Enqueue(p *Payload, options ...patchain.Option) error {
+
+	if p.Timestamp == 0 {
+		p.Timestamp = time.Now().UnixNano()
+	}
+
+	var hooks []*tables.Webhook
+	if err := d.db.GetAll(&tables.Webhook{QueryParams: patchain.QueryParams{
+		Expr: patchain.Expr{Expr: "owner_id = ? OR owner_id = ''", Args: []interface{}{p.OwnerID}},
+	}}, &hooks, options...); err != nil {
+		return errors.Wrap(err, "failed to get webhooks")
+	}
+
+	var payload []byte
+	for _, hook := range hooks {
+		if !matches(hook, p) {
+			continue
+		}
+
+		if payload == nil {
+			var err error
+			if payload, err = json.Marshal(p); err != nil {
+				return errors.Wrap(err, "failed to encode payload")
+			}
+		}
+
+		delivery := &tables.WebhookDelivery{
+			ID:            util.UUID4(),
+			WebhookID:     hook.ID,
+			Event:         p.Event,
+			Payload:       string(payload),
+			NextAttemptAt: p.Timestamp,
+			Timestamp:     p.Timestamp,
+		}
+		if err := d.db.Create(delivery, options...); err != nil {
+			return errors.Wrap(err, "failed to queue delivery")
+		}
+	}
+
+	return nil
+}
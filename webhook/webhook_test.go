@@ -0,0 +1,292 @@
+package webhook
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ellcrys/patchain/cockroach"
+	"github.com/ellcrys/patchain/cockroach/tables"
+	"github.com/ellcrys/patchain/object"
+	"github.com/ellcrys/util"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+var testDB *sql.DB
+
+var dbName = "test_" + strings.ToLower(util.RandString(5))
+var conStr = "postgresql://root@localhost:26257?sslmode=disable"
+var conStrWithDB = "postgresql://root@localhost:26257/" + dbName + "?sslmode=disable"
+
+func init() {
+	var err error
+	testDB, err = sql.Open("postgres", conStr)
+	if err != nil {
+		panic(fmt.Errorf("failed to connect to database: %s", err))
+	}
+}
+
+func createDb(t *testing.T) error {
+	_, err := testDB.Query(fmt.Sprintf("CREATE DATABASE %s;", dbName))
+	return err
+}
+
+func dropDB(t *testing.T) error {
+	_, err := testDB.Query(fmt.Sprintf("DROP DATABASE %s;", dbName))
+	return err
+}
+
+// endpoint records the payloads it receives. It fails the
+// first n requests if failures is set to n.
+type endpoint struct {
+	sync.Mutex
+	secret   string
+	failures int
+	payloads []*Payload
+	invalid  int
+}
+
+func (e *endpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e.Lock()
+	defer e.Unlock()
+	body, _ := ioutil.ReadAll(r.Body)
+	if !VerifySignature(e.secret, body, r.Header.Get(SignatureHeader)) {
+		e.invalid++
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if e.failures > 0 {
+		e.failures--
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	var p Payload
+	json.Unmarshal(body, &p)
+	e.payloads = append(e.payloads, &p)
+}
+
+// events returns the event types of the received payloads
+func (e *endpoint) events() []string {
+	e.Lock()
+	defer e.Unlock()
+	var events []string
+	for _, p := range e.payloads {
+		events = append(events, p.Event)
+	}
+	return events
+}
+
+// waitFor waits until the endpoint has received n payloads or the timeout expires
+func (e *endpoint) waitFor(n int, timeout time.Duration) []*Payload {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		e.Lock()
+		if len(e.payloads) >= n {
+			payloads := e.payloads
+			e.Unlock()
+			return payloads
+		}
+		e.Unlock()
+		time.Sleep(20 * time.Millisecond)
+	}
+	e.Lock()
+	defer e.Unlock()
+	return e.payloads
+}
+
+func TestSignature(t *testing.T) {
+	Convey("Signature", t, func() {
+		body := []byte(`{"event":"object.created"}`)
+		sig := Sign("secret", body)
+		So(sig, ShouldStartWith, "sha256=")
+		So(VerifySignature("secret", body, sig), ShouldBeTrue)
+		So(VerifySignature("other_secret", body, sig), ShouldBeFalse)
+		So(VerifySignature("secret", []byte(`{"event":"partition.sealed"}`), sig), ShouldBeFalse)
+	})
+}
+
+func TestMatches(t *testing.T) {
+	Convey(".matches", t, func() {
+		p := &Payload{Event: EventObjectCreated, OwnerID: "owner_a", Object: &tables.Object{Key: "orders/1"}}
+
+		Convey("Should match webhooks of the owner or without owner", func() {
+			So(matches(&tables.Webhook{}, p), ShouldBeTrue)
+			So(matches(&tables.Webhook{OwnerID: "owner_a"}, p), ShouldBeTrue)
+			So(matches(&tables.Webhook{OwnerID: "owner_b"}, p), ShouldBeFalse)
+		})
+
+		Convey("Should only match the subscribed events", func() {
+			So(matches(&tables.Webhook{Events: "partition.sealed, object.created"}, p), ShouldBeTrue)
+			So(matches(&tables.Webhook{Events: "partition.sealed"}, p), ShouldBeFalse)
+		})
+
+		Convey("Should only apply the key prefix to object events", func() {
+			So(matches(&tables.Webhook{KeyPrefix: "orders/"}, p), ShouldBeTrue)
+			So(matches(&tables.Webhook{KeyPrefix: "users/"}, p), ShouldBeFalse)
+			So(matches(&tables.Webhook{KeyPrefix: "users/"}, &Payload{Event: EventPartitionSealed}), ShouldBeTrue)
+		})
+	})
+}
+
+func TestBackoff(t *testing.T) {
+	Convey(".backoff", t, func() {
+		d := NewDispatcher(cockroach.NewDB(), nil, Config{InitialBackoff: time.Second, MaxBackoff: 5 * time.Second})
+		So(d.backoff(1), ShouldEqual, time.Second)
+		So(d.backoff(2), ShouldEqual, 2*time.Second)
+		So(d.backoff(3), ShouldEqual, 4*time.Second)
+		So(d.backoff(4), ShouldEqual, 5*time.Second)
+		So(d.backoff(100), ShouldEqual, 5*time.Second)
+	})
+}
+
+func TestDispatcher(t *testing.T) {
+
+	if err := createDb(t); err != nil {
+		t.Fatalf("failed to create test database. %s", err)
+	}
+	defer dropDB(t)
+
+	cdb := cockroach.NewDB()
+	cdb.ConnectionString = conStrWithDB
+	cdb.NoLogging()
+	if err := cdb.Connect(10, 5); err != nil {
+		t.Fatalf("failed to connect to database. %s", err)
+	}
+
+	if err := cdb.CreateTables(); err != nil {
+		t.Fatalf("failed to create tables. %s", err)
+	}
+
+	obj := object.NewObject(cdb)
+
+	Convey("Dispatcher", t, func() {
+		ownerID := util.RandString(10)
+		ep := &endpoint{}
+		server := httptest.NewServer(ep)
+		defer server.Close()
+
+		d := NewDispatcher(cdb, obj, Config{
+			Name:                 util.RandString(10),
+			PollInterval:         50 * time.Millisecond,
+			InitialBackoff:       10 * time.Millisecond,
+			DisableNotifications: true,
+		})
+
+		Convey(".Register", func() {
+			Convey("Should reject an invalid url", func() {
+				err := d.Register(&tables.Webhook{URL: "ftp://example.com"})
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "invalid url")
+			})
+
+			Convey("Should reject an unknown event type", func() {
+				err := d.Register(&tables.Webhook{URL: server.URL, Events: "object.deleted"})
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "unknown event type: object.deleted")
+			})
+
+			Convey("Should generate a secret and list the webhook", func() {
+				hook := &tables.Webhook{URL: server.URL, OwnerID: ownerID}
+				So(d.Register(hook), ShouldBeNil)
+				So(hook.Secret, ShouldHaveLength, 64)
+				hooks, err := d.List(ownerID)
+				So(err, ShouldBeNil)
+				So(hooks, ShouldHaveLength, 1)
+				So(hooks[0].ID, ShouldEqual, hook.ID)
+
+				Convey("Should unregister the webhook", func() {
+					So(d.Unregister(hook.ID), ShouldBeNil)
+					hooks, err := d.List(ownerID)
+					So(err, ShouldBeNil)
+					So(hooks, ShouldBeEmpty)
+				})
+
+				Convey("Should reject an empty webhook id", func() {
+					err := d.Unregister("")
+					So(err, ShouldNotBeNil)
+					So(err.Error(), ShouldEqual, "webhook id is required")
+				})
+			})
+		})
+
+		Convey("Should deliver events of the owner's partitions and objects", func() {
+			hook := &tables.Webhook{URL: server.URL, OwnerID: ownerID}
+			So(d.Register(hook), ShouldBeNil)
+			ep.secret = hook.Secret
+
+			d.Start()
+			defer d.Stop()
+			time.Sleep(200 * time.Millisecond)
+
+			partitions, err := obj.CreatePartitions(1, ownerID, ownerID)
+			So(err, ShouldBeNil)
+			o := &tables.Object{OwnerID: ownerID, Key: "orders/1", Value: "value"}
+			So(obj.Put(o), ShouldBeNil)
+			_, err = obj.SealPartition(partitions[0].ID)
+			So(err, ShouldBeNil)
+
+			payloads := ep.waitFor(3, 5*time.Second)
+			So(ep.events(), ShouldResemble, []string{EventPartitionCreated, EventObjectCreated, EventPartitionSealed})
+			So(payloads[0].Object.ID, ShouldEqual, partitions[0].ID)
+			So(payloads[1].Object.ID, ShouldEqual, o.ID)
+			So(payloads[1].Proof.PartitionHash, ShouldEqual, partitions[0].Hash)
+			So(payloads[1].Proof.Position, ShouldEqual, 2)
+			So(payloads[1].Proof.PrevHash, ShouldEqual, o.PrevHash)
+			So(ep.invalid, ShouldEqual, 0)
+		})
+
+		Convey("Should retry failed deliveries", func() {
+			hook := &tables.Webhook{URL: server.URL, OwnerID: ownerID, Events: EventObjectCreated}
+			So(d.Register(hook), ShouldBeNil)
+			ep.secret = hook.Secret
+			ep.failures = 2
+
+			So(d.Enqueue(&Payload{Event: EventObjectCreated, OwnerID: ownerID, Object: &tables.Object{Key: "a"}}), ShouldBeNil)
+			So(d.Enqueue(&Payload{Event: EventPartitionSealed, OwnerID: ownerID}), ShouldBeNil)
+
+			var delivered int
+			for i := 0; i < 3; i++ {
+				time.Sleep(50 * time.Millisecond)
+				n, err := d.DeliverPending()
+				So(err, ShouldBeNil)
+				delivered += n
+			}
+			So(delivered, ShouldEqual, 1)
+			So(ep.events(), ShouldResemble, []string{EventObjectCreated})
+
+			var deliveries []*tables.WebhookDelivery
+			So(cdb.GetAll(&tables.WebhookDelivery{WebhookID: hook.ID}, &deliveries), ShouldBeNil)
+			So(deliveries, ShouldHaveLength, 1)
+			So(deliveries[0].Attempts, ShouldEqual, 3)
+			So(deliveries[0].DeliveredAt, ShouldNotEqual, 0)
+		})
+
+		Convey("Should resume from the saved cursor after a restart", func() {
+			hook := &tables.Webhook{URL: server.URL, OwnerID: ownerID, Events: EventObjectCreated}
+			So(d.Register(hook), ShouldBeNil)
+			ep.secret = hook.Secret
+			_, err := obj.CreatePartitions(1, ownerID, ownerID)
+			So(err, ShouldBeNil)
+
+			d.Start()
+			time.Sleep(200 * time.Millisecond)
+			d.Stop()
+
+			o := &tables.Object{OwnerID: ownerID, Key: "orders/2"}
+			So(obj.Put(o), ShouldBeNil)
+
+			d.Start()
+			defer d.Stop()
+			payloads := ep.waitFor(1, 5*time.Second)
+			So(payloads, ShouldHaveLength, 1)
+			So(payloads[0].Object.ID, ShouldEqual, o.ID)
+		})
+	})
+}